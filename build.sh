@@ -5,3 +5,9 @@ CGO_ENABLED=0 go build ./
 
 cd $dir/pop
 CGO_ENABLED=0 go build ./
+
+cd $dir/pushpop
+CGO_ENABLED=0 go build ./
+
+cd $dir/pushpopd
+CGO_ENABLED=0 go build ./
@@ -6,9 +6,14 @@ pkg_dir=pushpop_0.0-$pkg_rev
 
 echo $pkg_dir
 mkdir -p $pkg_dir/usr/local/bin
+mkdir -p $pkg_dir/usr/share/applications
 cp -r DEBIAN $pkg_dir/
 sed -i "s/Version: 0.0-2/Version: 0.0-$pkg_rev/g" $pkg_dir/DEBIAN/control
 cp -v push/push $pkg_dir/usr/local/bin/
 cp -v pop/pop $pkg_dir/usr/local/bin/
+cp -v pushpop/pushpop $pkg_dir/usr/local/bin/
+cp -v pushpopd/pushpopd $pkg_dir/usr/local/bin/
+cp -v share/pushpop-share $pkg_dir/usr/local/bin/
+cp -v share/pushpop-share.desktop $pkg_dir/usr/share/applications/
 dpkg-deb --build $pkg_dir
 rm -r $pkg_dir
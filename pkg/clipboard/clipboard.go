@@ -0,0 +1,130 @@
+// Package clipboard reads and writes the system clipboard by shelling out to
+// whichever platform tool is available, or with the OSC 52 escape sequence
+// of terminals.
+package clipboard
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+type tool struct {
+	name string
+	args []string
+}
+
+func pasteTools() []tool {
+	switch runtime.GOOS {
+	case "darwin":
+		return []tool{{"pbpaste", nil}}
+	case "windows":
+		return []tool{{"powershell.exe", []string{"-NoProfile", "-Command", "Get-Clipboard -Raw"}}}
+	}
+	tools := []tool{
+		{"xclip", []string{"-selection", "clipboard", "-o"}},
+		{"xsel", []string{"--clipboard", "--output"}},
+	}
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		tools = append([]tool{{"wl-paste", []string{"--no-newline"}}}, tools...)
+	}
+	return tools
+}
+
+func copyTools() []tool {
+	switch runtime.GOOS {
+	case "darwin":
+		return []tool{{"pbcopy", nil}}
+	case "windows":
+		return []tool{{"clip.exe", nil}}
+	}
+	tools := []tool{
+		{"xclip", []string{"-selection", "clipboard", "-i"}},
+		{"xsel", []string{"--clipboard", "--input"}},
+	}
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		tools = append([]tool{{"wl-copy", nil}}, tools...)
+	}
+	return tools
+}
+
+func find(tools []tool) (tool, error) {
+	for _, t := range tools {
+		if _, err := exec.LookPath(t.name); err == nil {
+			return t, nil
+		}
+	}
+	return tool{}, fmt.Errorf("No clipboard tool found (tried %d)", len(tools))
+}
+
+// Read returns the current clipboard contents.
+func Read() ([]byte, error) {
+	t, err := find(pasteTools())
+	if err != nil {
+		return nil, err
+	}
+	out, err := exec.Command(t.name, t.args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", t.name, err)
+	}
+	return out, nil
+}
+
+// Write replaces the clipboard contents with data.
+func Write(data []byte) error {
+	t, err := find(copyTools())
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(t.name, t.args...)
+	cmd.Stdin = bytes.NewReader(data)
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("%s: %v", t.name, err)
+	}
+	return nil
+}
+
+// OverSSH tells whether the process runs in an SSH session, where the
+// clipboard tools, if any, are those of the remote machine.
+func OverSSH() bool {
+	return os.Getenv("SSH_TTY") != "" || os.Getenv("SSH_CONNECTION") != ""
+}
+
+// Copy puts data on the clipboard of the user: with the clipboard tools,
+// unless over SSH without X forwarding, and otherwise by writing to the
+// terminal term the OSC 52 escape sequence, which asks the terminal on the
+// user's side to do it. It tells whether OSC 52 was used, in which case
+// terminals not allowing it quietly ignore the request.
+func Copy(data []byte, term io.Writer) (osc52 bool, err error) {
+	if !OverSSH() || os.Getenv("DISPLAY") != "" {
+		err = Write(data)
+		if err == nil || term == nil {
+			return false, err
+		}
+	}
+	if term == nil {
+		return false, fmt.Errorf("No terminal to copy over SSH with")
+	}
+	return true, OSC52(term, data)
+}
+
+// OSC52 writes to w the OSC 52 escape sequence setting the clipboard to
+// data. Inside tmux or screen, the sequence is wrapped for them to pass it
+// on to the terminal.
+func OSC52(w io.Writer, data []byte) error {
+	seq := "\x1b]52;c;" + base64.StdEncoding.EncodeToString(data) + "\a"
+	switch {
+	case os.Getenv("TMUX") != "":
+		seq = "\x1bPtmux;" + strings.ReplaceAll(seq, "\x1b", "\x1b\x1b") + "\x1b\\"
+	case strings.HasPrefix(os.Getenv("TERM"), "screen"):
+		seq = "\x1bP" + seq + "\x1b\\"
+	}
+	_, err := io.WriteString(w, seq)
+	return err
+}
@@ -0,0 +1,162 @@
+// Package config loads ~/.config/pushpop/config, which provides defaults for
+// command line flags, optionally grouped into named profiles:
+//
+//	# Applies to every invocation.
+//	hash = sha256
+//
+//	[work]
+//	pop.dir = ~/Work/Inbox
+//	interface = eth0
+//
+// Keys are flag names. A "push." or "pop." prefix restricts a key to one
+// program; unprefixed keys apply to whichever program has such a flag.
+// Flags given on the command line always win.
+package config
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ProfileEnv selects a profile when -profile is not given.
+const ProfileEnv = "PUSHPOP_PROFILE"
+
+// Dir returns the pushpop configuration directory.
+func Dir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pushpop"), nil
+}
+
+// Path returns the path of the configuration file.
+func Path() (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "config"), nil
+}
+
+type entry struct {
+	key, value string
+	line       int
+}
+
+// sections maps a profile name, "" for the global section, to its entries.
+type sections map[string][]entry
+
+func load(path string) (sections, error) {
+	secs := sections{}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return secs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if _, ok := secs[section]; !ok {
+				secs[section] = nil
+			}
+			continue
+		}
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, n)
+		}
+		key := strings.TrimSpace(line[:eq])
+		value := strings.TrimSpace(line[eq+1:])
+		secs[section] = append(secs[section], entry{key, value, n})
+	}
+	return secs, scanner.Err()
+}
+
+// Profile returns the profile to use: the given one, or $PUSHPOP_PROFILE.
+func Profile(profile string) string {
+	if profile != "" {
+		return profile
+	}
+	return os.Getenv(ProfileEnv)
+}
+
+// Apply sets every flag of fs that was not given on the command line from
+// the global section of the configuration file, then from the section of
+// profile. program is "push" or "pop".
+func Apply(fs *flag.FlagSet, program, profile string) error {
+	path, err := Path()
+	if err != nil {
+		return err
+	}
+	secs, err := load(path)
+	if err != nil {
+		return err
+	}
+	profile = Profile(profile)
+	if _, ok := secs[profile]; profile != "" && !ok {
+		return fmt.Errorf("Profile %q not found in %s", profile, path)
+	}
+
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	names := []string{""}
+	if profile != "" {
+		names = append(names, profile)
+	}
+	for _, section := range names {
+		for _, e := range secs[section] {
+			name := e.key
+			explicit := false
+			if i := strings.Index(name, "."); i >= 0 {
+				if name[:i] != program {
+					continue
+				}
+				name, explicit = name[i+1:], true
+			}
+			if name == "profile" || given[name] {
+				continue
+			}
+			if fs.Lookup(name) == nil {
+				if explicit {
+					return fmt.Errorf("%s:%d: %s has no -%s flag", path, e.line, program, name)
+				}
+				continue
+			}
+			err := fs.Set(name, expand(e.value))
+			if err != nil {
+				return fmt.Errorf("%s:%d: %v", path, e.line, err)
+			}
+		}
+	}
+	return nil
+}
+
+// expand replaces a leading ~ with the home directory.
+func expand(value string) string {
+	if value != "~" && !strings.HasPrefix(value, "~/") {
+		return value
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return value
+	}
+	return filepath.Join(home, value[1:])
+}
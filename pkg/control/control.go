@@ -0,0 +1,171 @@
+// Package control locates the local control API of a running push. The API
+// is plain HTTP over a unix socket only reachable by the current user.
+package control
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// BaseURL prefixes the paths of the control API. The host is ignored since
+// requests always go to the socket.
+const BaseURL = "http://push"
+
+// Dir returns the per-user directory holding the control socket, creating
+// it if needed. Without XDG_RUNTIME_DIR it is in the shared temporary
+// directory, where another user could have made it first: it is refused
+// unless it is a directory of the current user, not a symbolic link, that
+// only they can open.
+func Dir() (string, error) {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir != "" {
+		dir = filepath.Join(dir, "pushpop")
+		err := os.MkdirAll(dir, 0700)
+		if err != nil {
+			return "", err
+		}
+		return dir, nil
+	}
+	dir = filepath.Join(os.TempDir(), fmt.Sprintf("pushpop-%d", os.Getuid()))
+	err := os.Mkdir(dir, 0700)
+	if err != nil && !os.IsExist(err) {
+		return "", err
+	}
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return "", err
+	}
+	if !fi.IsDir() || fi.Mode().Perm() != 0700 || !owned(fi) {
+		return "", fmt.Errorf("%s is not a private directory of the current user", dir)
+	}
+	return dir, nil
+}
+
+// SocketPath returns the path of the control socket.
+func SocketPath() (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "push.sock"), nil
+}
+
+// Listen binds the control socket. A socket left behind by a push that died
+// is replaced, but one still answering is not: the caller gets an error.
+func Listen() (net.Listener, error) {
+	path, err := SocketPath()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("Another push already serves %s", path)
+	}
+	os.Remove(path)
+	return net.Listen("unix", path)
+}
+
+// Client returns an HTTP client whose requests go to the control socket.
+func Client() (*http.Client, error) {
+	path, err := SocketPath()
+	if err != nil {
+		return nil, err
+	}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// revokedDir returns the directory of the kill-switch files, creating it if
+// needed.
+func revokedDir() (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "revoked")
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// Revoke creates the kill-switch file of the share with the given id. Every
+// push watches for the files of its shares, so this reaches the pushes that
+// do not own the control socket too. The push that stops the share removes
+// the file.
+func Revoke(id string) error {
+	dir, err := revokedDir()
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(filepath.Join(dir, filepath.Base(id)))
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Revoked reports whether the kill-switch file of the share with the given
+// id exists.
+func Revoked(id string) bool {
+	dir, err := revokedDir()
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(filepath.Join(dir, filepath.Base(id)))
+	return err == nil
+}
+
+// Unrevoke removes the kill-switch file of the share with the given id.
+func Unrevoke(id string) error {
+	dir, err := revokedDir()
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filepath.Join(dir, filepath.Base(id)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// NextGeneration returns the generation of a new share called name: one
+// more than the last share of that name started by this user, so that
+// receivers can tell the newest apart from older pushes still running.
+func NextGeneration(name string) (int, error) {
+	dir, err := Dir()
+	if err != nil {
+		return 0, err
+	}
+	dir = filepath.Join(dir, "generations")
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return 0, err
+	}
+	path := filepath.Join(dir, url.PathEscape(name))
+	gen := 0
+	data, err := os.ReadFile(path)
+	if err == nil {
+		gen, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+	}
+	gen++
+	err = os.WriteFile(path, []byte(strconv.Itoa(gen)+"\n"), 0600)
+	if err != nil {
+		return 0, err
+	}
+	return gen, nil
+}
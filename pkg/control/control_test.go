@@ -0,0 +1,40 @@
+package control
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirFallback(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	t.Setenv("TMPDIR", tmp)
+	dir, err := Dir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Lstat(dir)
+	if err != nil || !fi.IsDir() || fi.Mode().Perm() != 0700 {
+		t.Fatalf("Dir() = %s: %v, %v", dir, fi.Mode(), err)
+	}
+
+	err = os.Chmod(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Dir(); err == nil {
+		t.Error("Dir() accepted a directory others can open")
+	}
+
+	os.Remove(dir)
+	target := filepath.Join(tmp, "elsewhere")
+	os.Mkdir(target, 0700)
+	err = os.Symlink(target, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Dir(); err == nil {
+		t.Error("Dir() accepted a symbolic link")
+	}
+}
@@ -0,0 +1,175 @@
+// Package debugbundle collects what it takes to reproduce a problem, the
+// logs, timings and what the peers told each other, into a single tar.gz
+// to attach to a bug report. Everything is sanitized on the way in: user
+// and host names and the home directory are replaced by placeholders.
+package debugbundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/version"
+)
+
+// Bundle accumulates the files of a debug bundle.
+type Bundle struct {
+	program  string
+	start    time.Time
+	replacer *strings.Replacer
+
+	mu    sync.Mutex
+	files map[string]*bytes.Buffer
+}
+
+// New returns an empty bundle for program.
+func New(program string) *Bundle {
+	return &Bundle{
+		program:  program,
+		start:    time.Now(),
+		replacer: sanitizer(),
+		files:    map[string]*bytes.Buffer{},
+	}
+}
+
+// sanitizer replaces what identifies the user in the bundle.
+func sanitizer() *strings.Replacer {
+	var pairs []string
+	// Longest first, so that the home directory goes before the user name
+	// it usually contains.
+	if home, err := os.UserHomeDir(); err == nil && home != "" && home != "/" {
+		pairs = append(pairs, home, "$HOME")
+	}
+	// Shorter names would mangle unrelated words.
+	if u, err := user.Current(); err == nil && len(u.Username) >= 3 {
+		pairs = append(pairs, u.Username, "$USER")
+	}
+	if host, err := os.Hostname(); err == nil && len(host) >= 3 {
+		pairs = append(pairs, host, "$HOST")
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// Sanitize returns s with the user's identifying strings replaced.
+func (b *Bundle) Sanitize(s string) string {
+	return b.replacer.Replace(s)
+}
+
+// Printf appends a sanitized line to the file called name.
+func (b *Bundle) Printf(name, format string, v ...interface{}) {
+	line := b.Sanitize(fmt.Sprintf(format, v...))
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	f, ok := b.files[name]
+	if !ok {
+		f = &bytes.Buffer{}
+		b.files[name] = f
+	}
+	f.WriteString(line)
+	if !strings.HasSuffix(line, "\n") {
+		f.WriteByte('\n')
+	}
+}
+
+// Writer returns a writer appending to the file called name, such as a log.
+func (b *Bundle) Writer(name string) *Writer {
+	return &Writer{b, name}
+}
+
+// Writer appends what is written to it to a file of a bundle.
+type Writer struct {
+	b    *Bundle
+	name string
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.b.Printf(w.name, "%s", p)
+	return len(p), nil
+}
+
+// environment describes the system the bundle comes from.
+func (b *Bundle) environment() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "program: %s\n", version.UserAgent(b.program))
+	fmt.Fprintf(&buf, "go: %s\n", runtime.Version())
+	fmt.Fprintf(&buf, "args: %q\n", os.Args[1:])
+	fmt.Fprintf(&buf, "started: %s\n", b.start.Format(time.RFC3339Nano))
+	fmt.Fprintf(&buf, "duration: %v\n", time.Since(b.start))
+	for _, key := range []string{"PUSHPOP_PROFILE", "XDG_RUNTIME_DIR", "XDG_CONFIG_HOME", "XDG_DATA_HOME", "TMPDIR", "WAYLAND_DISPLAY", "DISPLAY"} {
+		if v, ok := os.LookupEnv(key); ok {
+			fmt.Fprintf(&buf, "env %s=%s\n", key, v)
+		}
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		fmt.Fprintf(&buf, "interfaces: %v\n", err)
+	}
+	for _, iface := range ifaces {
+		addrs, _ := iface.Addrs()
+		var list []string
+		for _, a := range addrs {
+			list = append(list, a.String())
+		}
+		fmt.Fprintf(&buf, "interface %s mtu=%d flags=%v addrs=%s\n", iface.Name, iface.MTU, iface.Flags, strings.Join(list, ","))
+	}
+	return b.Sanitize(buf.String())
+}
+
+// Write saves the bundle as a tar.gz at path.
+func (b *Bundle) Write(path string) error {
+	b.mu.Lock()
+	files := map[string][]byte{"environment.txt": []byte(b.environment())}
+	for name, f := range b.files {
+		files[name] = append([]byte(nil), f.Bytes()...)
+	}
+	b.mu.Unlock()
+
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	dir := fmt.Sprintf("pushpop-%s-debug", b.program)
+	for _, name := range names {
+		data := files[name]
+		err = tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     dir + "/" + name,
+			Mode:     0644,
+			Size:     int64(len(data)),
+			ModTime:  time.Now(),
+		})
+		if err == nil {
+			_, err = tw.Write(data)
+		}
+		if err != nil {
+			out.Close()
+			return err
+		}
+	}
+	err = tw.Close()
+	if err == nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,40 @@
+// Package debugserver serves the Go profiling endpoints of net/http/pprof
+// on an address of its own, for performance investigations on the machines
+// of users without a custom build. Besides the profiles, the trace endpoint
+// captures a runtime/trace of the given number of seconds:
+//
+//	go tool pprof http://127.0.0.1:6060/debug/pprof/profile?seconds=30
+//	curl -o trace.out 'http://127.0.0.1:6060/debug/pprof/trace?seconds=5'
+package debugserver
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+)
+
+// Start serves the profiling endpoints on addr, such as 127.0.0.1:6060,
+// until the program exits, logging where with logger, unless nil. Anyone
+// who can reach addr can profile the program, so addresses other than
+// loopback ones get a warning.
+func Start(addr string, logger *slog.Logger) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if ip := ln.Addr().(*net.TCPAddr).IP; !ip.IsLoopback() && logger != nil {
+		logger.Warn("The debug endpoints are reachable from other machines", "addr", ln.Addr().String())
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	if logger != nil {
+		logger.Info("Serving the debug endpoints", "url", "http://"+ln.Addr().String()+"/debug/pprof/")
+	}
+	go http.Serve(ln, mux)
+	return nil
+}
@@ -0,0 +1,300 @@
+// Package delta lets a receiver that holds an older version of a file
+// download only the parts that changed, the way zsync does. The sender
+// publishes the checksums of every block of its file: a weak rolling
+// checksum, cheap to slide over the receiver's copy one byte at a time, and
+// a BLAKE3 checksum confirming the weak matches. The receiver looks for the
+// blocks in its copy, reuses the ones it finds wherever they moved, and
+// downloads the others with ranges.
+package delta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/zeebo/blake3"
+)
+
+// Block sizes, picked by BlockSize.
+const (
+	MinBlockSize = 4 << 10
+	MaxBlockSize = 1 << 20
+)
+
+// strongSize is how many bytes of the BLAKE3 checksum of a block are kept,
+// enough for the weak checksum to only be confirmed by the right block.
+const strongSize = 16
+
+// magic starts encoded Blocks.
+const magic = "pushpop-blocks-1\n"
+
+// headerSize is the size of encoded Blocks without their checksums.
+const headerSize = len(magic) + 8 + 4
+
+// blockEntrySize is the size of the checksums of a block once encoded.
+const blockEntrySize = 4 + strongSize
+
+// Sum holds the checksums of a block. The last block of a file is padded
+// with zeros to the block size.
+type Sum struct {
+	Weak   uint32
+	Strong [strongSize]byte
+}
+
+// Blocks describes a file as the checksums of its blocks.
+type Blocks struct {
+	Size      int64
+	BlockSize int
+	Sums      []Sum
+}
+
+// BlockSize returns the block size for a file of size bytes: about its
+// square root, so that the checksums and the blocks that changed weigh
+// about the same, as a power of two between MinBlockSize and MaxBlockSize.
+func BlockSize(size int64) int {
+	bs := MinBlockSize
+	for bs < MaxBlockSize && float64(bs) < math.Sqrt(float64(size)) {
+		bs *= 2
+	}
+	return bs
+}
+
+// Compute returns the blocks of the size bytes read from r.
+func Compute(r io.Reader, size int64) (Blocks, error) {
+	b := Blocks{Size: size, BlockSize: BlockSize(size)}
+	buf := make([]byte, b.BlockSize)
+	for off := int64(0); off < size; off += int64(b.BlockSize) {
+		n := b.BlockSize
+		if size-off < int64(n) {
+			n = int(size - off)
+			for i := n; i < len(buf); i++ {
+				buf[i] = 0
+			}
+		}
+		_, err := io.ReadFull(r, buf[:n])
+		if err != nil {
+			return Blocks{}, err
+		}
+		b.Sums = append(b.Sums, Sum{Weak: weak(buf), Strong: strong(buf)})
+	}
+	return b, nil
+}
+
+// Len returns the length of block i, shorter than the block size for the
+// last block.
+func (b Blocks) Len(i int) int64 {
+	start := int64(i) * int64(b.BlockSize)
+	if b.Size-start < int64(b.BlockSize) {
+		return b.Size - start
+	}
+	return int64(b.BlockSize)
+}
+
+// Check reports whether data, block i of a file, matches its checksums.
+func (b Blocks) Check(i int, data []byte) bool {
+	if i < 0 || i >= len(b.Sums) || int64(len(data)) != b.Len(i) {
+		return false
+	}
+	block := data
+	if len(block) < b.BlockSize {
+		block = make([]byte, b.BlockSize)
+		copy(block, data)
+	}
+	return weak(block) == b.Sums[i].Weak && strong(block) == b.Sums[i].Strong
+}
+
+// Encode returns b as served by senders.
+func (b Blocks) Encode() []byte {
+	data := make([]byte, headerSize, headerSize+len(b.Sums)*blockEntrySize)
+	copy(data, magic)
+	binary.BigEndian.PutUint64(data[len(magic):], uint64(b.Size))
+	binary.BigEndian.PutUint32(data[len(magic)+8:], uint32(b.BlockSize))
+	var entry [blockEntrySize]byte
+	for _, s := range b.Sums {
+		binary.BigEndian.PutUint32(entry[:], s.Weak)
+		copy(entry[4:], s.Strong[:])
+		data = append(data, entry[:]...)
+	}
+	return data
+}
+
+// ErrInvalid is returned by Decode for data that are not encoded Blocks.
+var ErrInvalid = errors.New("Invalid block checksums")
+
+// Decode parses Blocks encoded by Encode.
+func Decode(data []byte) (Blocks, error) {
+	if len(data) < headerSize || !bytes.HasPrefix(data, []byte(magic)) {
+		return Blocks{}, ErrInvalid
+	}
+	b := Blocks{
+		Size:      int64(binary.BigEndian.Uint64(data[len(magic):])),
+		BlockSize: int(binary.BigEndian.Uint32(data[len(magic)+8:])),
+	}
+	if b.Size < 0 || b.BlockSize < MinBlockSize || b.BlockSize > MaxBlockSize {
+		return Blocks{}, ErrInvalid
+	}
+	count := (b.Size + int64(b.BlockSize) - 1) / int64(b.BlockSize)
+	data = data[headerSize:]
+	if int64(len(data)) != count*blockEntrySize {
+		return Blocks{}, fmt.Errorf("%w: expected %d blocks, got %d bytes", ErrInvalid, count, len(data))
+	}
+	b.Sums = make([]Sum, count)
+	for i := range b.Sums {
+		entry := data[i*blockEntrySize:]
+		b.Sums[i].Weak = binary.BigEndian.Uint32(entry)
+		copy(b.Sums[i].Strong[:], entry[4:blockEntrySize])
+	}
+	return b, nil
+}
+
+// Match looks for the blocks of b in the size bytes of local, at any offset,
+// and returns where each block was found, or -1 for the blocks to download.
+func Match(local io.ReaderAt, size int64, b Blocks) ([]int64, error) {
+	found := make([]int64, len(b.Sums))
+	byWeak := map[uint32][]int{}
+	for i, s := range b.Sums {
+		found[i] = -1
+		byWeak[s.Weak] = append(byWeak[s.Weak], i)
+	}
+	if size == 0 || len(b.Sums) == 0 {
+		return found, nil
+	}
+
+	bs := b.BlockSize
+	w := &window{r: local, size: size, bs: bs}
+	err := w.fill(0)
+	if err != nil {
+		return nil, err
+	}
+	var r rolling
+	r.init(w.block())
+	missing := len(b.Sums)
+	for missing > 0 {
+		matched := false
+		if candidates := byWeak[r.sum()]; candidates != nil {
+			s := strong(w.block())
+			for _, i := range candidates {
+				if found[i] < 0 && b.Sums[i].Strong == s {
+					found[i] = w.pos
+					missing--
+					matched = true
+				}
+			}
+		}
+		// Like rsync, the search goes on past a block once it matched.
+		step := 1
+		if matched {
+			step = bs
+		}
+		if w.pos+int64(step) >= size {
+			break
+		}
+		if matched {
+			err = w.fill(w.pos + int64(step))
+			if err != nil {
+				return nil, err
+			}
+			r.init(w.block())
+			continue
+		}
+		out := w.buf[w.off]
+		err = w.fill(w.pos + 1)
+		if err != nil {
+			return nil, err
+		}
+		block := w.block()
+		r.roll(out, block[bs-1])
+	}
+	return found, nil
+}
+
+// window slides over a file a block at a time, reading ahead, and reads
+// zeros past its end as the last block is padded.
+type window struct {
+	r    io.ReaderAt
+	size int64
+	bs   int
+	// buf holds the file from start on; pos is the start of the window, at
+	// off in buf.
+	buf   []byte
+	start int64
+	pos   int64
+	off   int
+}
+
+// readAhead is how much of the file a window reads at once, in blocks.
+const readAhead = 64
+
+// fill moves the window to pos, reading more of the file when needed.
+func (w *window) fill(pos int64) error {
+	w.pos = pos
+	w.off = int(pos - w.start)
+	if w.buf != nil && w.off >= 0 && w.off+w.bs <= len(w.buf) {
+		return nil
+	}
+	n := readAhead * w.bs
+	if w.buf == nil {
+		w.buf = make([]byte, 0, n)
+	}
+	w.buf = w.buf[:n]
+	w.start, w.off = pos, 0
+	for i := range w.buf {
+		w.buf[i] = 0
+	}
+	if pos < w.size {
+		read, err := w.r.ReadAt(w.buf, pos)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if read < n && pos+int64(read) < w.size {
+			return io.ErrUnexpectedEOF
+		}
+	}
+	return nil
+}
+
+// block returns the bytes in the window.
+func (w *window) block() []byte {
+	return w.buf[w.off : w.off+w.bs]
+}
+
+// rolling is the weak checksum of rsync, which slides over data a byte at
+// a time.
+type rolling struct {
+	a, b uint32
+	n    uint32
+}
+
+func (r *rolling) init(block []byte) {
+	r.a, r.b, r.n = 0, 0, uint32(len(block))
+	for i, c := range block {
+		r.a += uint32(c)
+		r.b += uint32(len(block)-i) * uint32(c)
+	}
+}
+
+// roll slides the window by a byte, out leaving it and in entering it.
+func (r *rolling) roll(out, in byte) {
+	r.a += uint32(in) - uint32(out)
+	r.b += r.a - r.n*uint32(out)
+}
+
+func (r *rolling) sum() uint32 {
+	return r.a&0xffff | r.b<<16
+}
+
+func weak(block []byte) uint32 {
+	var r rolling
+	r.init(block)
+	return r.sum()
+}
+
+func strong(block []byte) [strongSize]byte {
+	sum := blake3.Sum256(block)
+	var s [strongSize]byte
+	copy(s[:], sum[:])
+	return s
+}
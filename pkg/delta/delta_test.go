@@ -0,0 +1,149 @@
+package delta
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// random returns n pseudo-random bytes, the same for the same seed.
+func random(seed int64, n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(b)
+	return b
+}
+
+func compute(t *testing.T, data []byte) Blocks {
+	t.Helper()
+	b, err := Compute(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestBlockSize(t *testing.T) {
+	for _, tt := range []struct {
+		size int64
+		want int
+	}{
+		{0, MinBlockSize},
+		{1, MinBlockSize},
+		{16 << 20, MinBlockSize},
+		{16<<20 + 1, 2 * MinBlockSize},
+		{1 << 30, 32 << 10},
+		{1 << 40, MaxBlockSize},
+		{1 << 50, MaxBlockSize},
+	} {
+		if got := BlockSize(tt.size); got != tt.want {
+			t.Errorf("BlockSize(%d) = %d, want %d", tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestRolling(t *testing.T) {
+	data := random(1, 3*MinBlockSize)
+	var r rolling
+	r.init(data[:MinBlockSize])
+	for i := 1; i <= 2*MinBlockSize; i++ {
+		r.roll(data[i-1], data[i+MinBlockSize-1])
+		if want := weak(data[i : i+MinBlockSize]); r.sum() != want {
+			t.Fatalf("rolled to %d: got %08x, want %08x", i, r.sum(), want)
+		}
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	for _, size := range []int{0, 1, MinBlockSize, MinBlockSize + 1, 5*MinBlockSize - 3} {
+		b := compute(t, random(int64(size), size))
+		got, err := Decode(b.Encode())
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		if got.Size != b.Size || got.BlockSize != b.BlockSize || len(got.Sums) != len(b.Sums) || (len(b.Sums) > 0 && !reflect.DeepEqual(got.Sums, b.Sums)) {
+			t.Errorf("%d bytes: decoded %+v, want %+v", size, got, b)
+		}
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	valid := compute(t, random(2, 3*MinBlockSize)).Encode()
+	for _, tt := range []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"wrong magic", append([]byte("pushpop-blocks-2\n"), valid[len(magic):]...)},
+		{"header only", valid[:headerSize]},
+		{"truncated", valid[:len(valid)-1]},
+		{"trailing bytes", append(append([]byte(nil), valid...), 0)},
+		{"small blocks", func() []byte {
+			d := append([]byte(nil), valid...)
+			d[len(magic)+8+2] = 0
+			return d
+		}()},
+		{"negative size", func() []byte {
+			d := append([]byte(nil), valid...)
+			d[len(magic)] = 0x80
+			return d
+		}()},
+	} {
+		if _, err := Decode(tt.data); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: got %v, want ErrInvalid", tt.name, err)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	data := random(3, 2*MinBlockSize+100)
+	b := compute(t, data)
+	for _, tt := range []struct {
+		name  string
+		i     int
+		block []byte
+		want  bool
+	}{
+		{"first", 0, data[:MinBlockSize], true},
+		{"last, short", 2, data[2*MinBlockSize:], true},
+		{"wrong block", 1, data[:MinBlockSize], false},
+		{"padded last", 2, append(append([]byte(nil), data[2*MinBlockSize:]...), 0), false},
+		{"out of range", 3, data[:100], false},
+		{"negative", -1, data[:MinBlockSize], false},
+	} {
+		if got := b.Check(tt.i, tt.block); got != tt.want {
+			t.Errorf("%s: Check = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	const bs = MinBlockSize
+	remote := random(4, 8*bs+123)
+	changed := append([]byte(nil), remote...)
+	changed[3*bs+7] ^= 0xff
+	for _, tt := range []struct {
+		name  string
+		local []byte
+		want  []int64
+	}{
+		{"same", remote, []int64{0, bs, 2 * bs, 3 * bs, 4 * bs, 5 * bs, 6 * bs, 7 * bs, 8 * bs}},
+		{"one byte changed", changed, []int64{0, bs, 2 * bs, -1, 4 * bs, 5 * bs, 6 * bs, 7 * bs, 8 * bs}},
+		{"bytes inserted", append(append(append([]byte(nil), remote[:2*bs]...), "inserted"...), remote[2*bs:]...),
+			[]int64{0, bs, 2*bs + 8, 3*bs + 8, 4*bs + 8, 5*bs + 8, 6*bs + 8, 7*bs + 8, 8*bs + 8}},
+		{"bytes removed", append(append([]byte(nil), remote[:bs]...), remote[bs+10:]...),
+			[]int64{0, -1, 2*bs - 10, 3*bs - 10, 4*bs - 10, 5*bs - 10, 6*bs - 10, 7*bs - 10, 8*bs - 10}},
+		{"truncated", remote[:4*bs], []int64{0, bs, 2 * bs, 3 * bs, -1, -1, -1, -1, -1}},
+		{"empty", nil, []int64{-1, -1, -1, -1, -1, -1, -1, -1, -1}},
+		{"unrelated", random(5, 8*bs+123), []int64{-1, -1, -1, -1, -1, -1, -1, -1, -1}},
+	} {
+		got, err := Match(bytes.NewReader(tt.local), int64(len(tt.local)), compute(t, remote))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+)
+
+// Backend is a way of announcing and finding endpoints on the network.
+type Backend interface {
+	// Browse returns the endpoints announced through the backend as they
+	// are found, until ctx is done. The channel is then closed.
+	Browse(ctx context.Context) (<-chan *zeroconf.ServiceEntry, error)
+	// Announce announces an endpoint as instance on port with the TXT
+	// record text, until ctx is done or the announcement is shut down. A
+	// non-nil ip is the only address announced.
+	Announce(ctx context.Context, instance string, ip net.IP, port int, text []string) (Announcement, error)
+}
+
+// Announcement is an endpoint being announced.
+type Announcement interface {
+	// SetText replaces the TXT record of the endpoint.
+	SetText(text []string)
+	// Shutdown stops announcing the endpoint.
+	Shutdown()
+}
+
+// The backends endpoints are announced and browsed with.
+var (
+	// MDNS is multicast DNS, with zeroconf.
+	MDNS Backend = mdns{}
+	// Broadcast is UDP broadcast, for networks that filter multicast.
+	Broadcast Backend = broadcast{}
+)
+
+// Options are which backends endpoints are browsed and announced with.
+// The zero Options use DefaultBackends.
+type Options struct {
+	// Backends lists the backends by preference. BrowseNetwork only tries
+	// the next one when the previous ones found nothing for FallbackAfter,
+	// while Announce announces through all of them.
+	Backends []Backend
+}
+
+// DefaultBackends returns the backends of the zero Options: mDNS, then
+// broadcast.
+func DefaultBackends() []Backend {
+	return []Backend{MDNS, Broadcast}
+}
+
+func (o Options) backends() []Backend {
+	if len(o.Backends) == 0 {
+		return DefaultBackends()
+	}
+	return o.Backends
+}
+
+// FallbackAfter is how long BrowseNetwork waits for a backend to find an
+// endpoint before trying the next one as well.
+const FallbackAfter = 2 * time.Second
+
+type mdns struct{}
+
+func (mdns) Browse(ctx context.Context) (<-chan *zeroconf.ServiceEntry, error) {
+	return resolve(ctx, func(r *zeroconf.Resolver, ctx context.Context, raw chan *zeroconf.ServiceEntry) error {
+		return r.Browse(ctx, Service, Domain, raw)
+	})
+}
+
+// lookup returns the Browse of backend, only keeping the endpoints
+// announced as instance.
+func lookup(backend Backend, instance string) browseFunc {
+	return func(ctx context.Context) (<-chan *zeroconf.ServiceEntry, error) {
+		entries, err := backend.Browse(ctx)
+		if err != nil {
+			return nil, err
+		}
+		out := make(chan *zeroconf.ServiceEntry)
+		go func() {
+			defer close(out)
+			for e := range entries {
+				if e.Instance != instance && Unescape(e.Instance) != instance {
+					continue
+				}
+				select {
+				case out <- e:
+				case <-ctx.Done():
+				}
+			}
+		}()
+		return out, nil
+	}
+}
+
+func (mdns) Announce(ctx context.Context, instance string, ip net.IP, port int, text []string) (Announcement, error) {
+	return RegisterAt(ctx, instance, ip, port, text)
+}
+
+// Announce announces an endpoint through every backend, like RegisterAt.
+// It fails when the first backend does, the others being best effort.
+func Announce(ctx context.Context, instance string, ip net.IP, port int, text []string) (Announcement, error) {
+	return Options{}.Announce(ctx, instance, ip, port, text)
+}
+
+// Announce is the package's Announce with the backends of o.
+func (o Options) Announce(ctx context.Context, instance string, ip net.IP, port int, text []string) (Announcement, error) {
+	var all announcements
+	for i, b := range o.backends() {
+		a, err := b.Announce(ctx, instance, ip, port, text)
+		if err != nil && i == 0 {
+			return nil, err
+		}
+		if err == nil {
+			all = append(all, a)
+		}
+	}
+	return all, nil
+}
+
+// announcements announces an endpoint through several backends.
+type announcements []Announcement
+
+func (all announcements) SetText(text []string) {
+	for _, a := range all {
+		a.SetText(text)
+	}
+}
+
+func (all announcements) Shutdown() {
+	for _, a := range all {
+		a.Shutdown()
+	}
+}
+
+// browseFunc browses with a backend, see Backend.
+type browseFunc func(ctx context.Context) (<-chan *zeroconf.ServiceEntry, error)
+
+// browseFuncs returns the Browse methods of backends.
+func browseFuncs(backends []Backend) []browseFunc {
+	var fs []browseFunc
+	for _, b := range backends {
+		fs = append(fs, b.Browse)
+	}
+	return fs
+}
+
+// fallback browses with browses one after the other, adding the next one
+// whenever nothing was found for FallbackAfter, and merges what they find.
+func fallback(ctx context.Context, browses []browseFunc) (<-chan *zeroconf.ServiceEntry, error) {
+	first, err := browses[0](ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan *zeroconf.ServiceEntry)
+	found := make(chan *zeroconf.ServiceEntry)
+	go func() {
+		defer close(out)
+		open := 1
+		next := 1
+		relay := func(entries <-chan *zeroconf.ServiceEntry) {
+			for e := range entries {
+				found <- e
+			}
+			found <- nil
+		}
+		go relay(first)
+		timer := time.NewTimer(FallbackAfter)
+		defer timer.Stop()
+		seen := false
+		for open > 0 {
+			select {
+			case e := <-found:
+				if e == nil {
+					open--
+					continue
+				}
+				seen = true
+				select {
+				case out <- e:
+				case <-ctx.Done():
+				}
+			case <-timer.C:
+				if seen || next == len(browses) || ctx.Err() != nil {
+					continue
+				}
+				entries, err := browses[next](ctx)
+				next++
+				if err == nil {
+					open++
+					go relay(entries)
+				}
+				timer.Reset(FallbackAfter)
+			}
+		}
+	}()
+	return out, nil
+}
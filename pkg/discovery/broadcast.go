@@ -0,0 +1,202 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+)
+
+// BroadcastPort is the UDP port endpoints are broadcast to.
+const BroadcastPort = 41800
+
+// broadcastInterval is how often an endpoint is broadcast.
+const broadcastInterval = 2 * time.Second
+
+// broadcastMagic starts every broadcast datagram, followed by the Record
+// of the endpoint in JSON. The address of the endpoint is the one the
+// datagram came from.
+const broadcastMagic = "pushpop-broadcast-1\n"
+
+// maxDatagram bounds the datagrams read.
+const maxDatagram = 8 << 10
+
+// broadcast announces endpoints by broadcasting them to BroadcastPort on
+// every IPv4 network, every broadcastInterval, and finds them by listening
+// on that port. Only one program at a time can browse on a machine, which
+// pushpopd is when it runs.
+type broadcast struct{}
+
+func (broadcast) Browse(ctx context.Context) (<-chan *zeroconf.ServiceEntry, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: BroadcastPort})
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	out := make(chan *zeroconf.ServiceEntry)
+	go func() {
+		defer close(out)
+		// Endpoints are broadcast again and again, but only sent on when
+		// they are new or changed.
+		seen := map[string]string{}
+		buf := make([]byte, maxDatagram)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			data := buf[:n]
+			if !bytes.HasPrefix(data, []byte(broadcastMagic)) {
+				continue
+			}
+			var r Record
+			if json.Unmarshal(data[len(broadcastMagic):], &r) != nil || r.Instance == "" {
+				continue
+			}
+			r.AddrIPv4 = []net.IP{from.IP}
+			r.AddrIPv6 = nil
+			key := r.Key() + "\x00" + from.IP.String()
+			text := strings.Join(r.Text, "\x00")
+			if t, ok := seen[key]; ok && t == text {
+				continue
+			}
+			seen[key] = text
+			e := r.Entry()
+			e.Instance = escape(r.Instance)
+			select {
+			case out <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (broadcast) Announce(ctx context.Context, instance string, ip net.IP, port int, text []string) (Announcement, error) {
+	var local *net.UDPAddr
+	if ip != nil && !ip.IsUnspecified() {
+		if ip.To4() == nil {
+			// IPv6 has no broadcast.
+			return nil, net.UnknownNetworkError("udp6 broadcast")
+		}
+		local = &net.UDPAddr{IP: ip}
+	}
+	conn, err := net.ListenUDP("udp4", local)
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	a := &broadcaster{conn: conn, ip: ip, done: make(chan struct{}),
+		record: Record{Instance: instance, HostName: host, Port: port, Text: text}}
+	go a.run(ctx)
+	return a, nil
+}
+
+// broadcaster broadcasts an endpoint until shut down.
+type broadcaster struct {
+	conn *net.UDPConn
+	// ip, when set, is the only address broadcast from.
+	ip   net.IP
+	once sync.Once
+	done chan struct{}
+
+	mu     sync.Mutex
+	record Record
+}
+
+func (a *broadcaster) run(ctx context.Context) {
+	defer a.conn.Close()
+	ticker := time.NewTicker(broadcastInterval)
+	defer ticker.Stop()
+	for {
+		a.send()
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		case <-a.done:
+			return
+		}
+	}
+}
+
+// send broadcasts the endpoint once on every network.
+func (a *broadcaster) send() {
+	a.mu.Lock()
+	data, err := json.Marshal(a.record)
+	a.mu.Unlock()
+	if err != nil {
+		return
+	}
+	data = append([]byte(broadcastMagic), data...)
+	for _, addr := range broadcastAddrs(a.ip) {
+		a.conn.WriteToUDP(data, &net.UDPAddr{IP: addr, Port: BroadcastPort})
+	}
+}
+
+func (a *broadcaster) SetText(text []string) {
+	a.mu.Lock()
+	a.record.Text = text
+	a.mu.Unlock()
+}
+
+func (a *broadcaster) Shutdown() {
+	a.once.Do(func() { close(a.done) })
+}
+
+// broadcastAddrs returns the broadcast addresses of the IPv4 networks of
+// the machine, or of the one of ip when set, and the limited broadcast
+// address, which reaches at least the loopback.
+func broadcastAddrs(ip net.IP) []net.IP {
+	addrs := []net.IP{net.IPv4bcast}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return addrs
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagBroadcast == 0 {
+			continue
+		}
+		ifaddrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, ifaddr := range ifaddrs {
+			ipnet, ok := ifaddr.(*net.IPNet)
+			if !ok || ipnet.IP.To4() == nil || (ip != nil && !ipnet.IP.Equal(ip)) {
+				continue
+			}
+			bcast := make(net.IP, net.IPv4len)
+			for i, b := range ipnet.IP.To4() {
+				bcast[i] = b | ^ipnet.Mask[len(ipnet.Mask)-net.IPv4len+i]
+			}
+			addrs = append(addrs, bcast)
+		}
+	}
+	return addrs
+}
+
+// escape escapes instance the way mDNS entries are, see Unescape.
+func escape(instance string) string {
+	var b strings.Builder
+	for i := 0; i < len(instance); i++ {
+		switch c := instance[i]; c {
+		case ' ', '\'', '@', ';', '(', ')', '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,170 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+	"github.com/yifu/pushpop/pkg/control"
+)
+
+// EntriesPath is where pushpopd serves the entries it knows, as a JSON
+// array of Record, the most recently seen first.
+const EntriesPath = "/entries"
+
+// daemonURL prefixes the paths of pushpopd. The host is ignored since
+// requests always go to the socket.
+const daemonURL = "http://pushpopd"
+
+// pollInterval is how often Browse asks pushpopd for new entries.
+const pollInterval = 500 * time.Millisecond
+
+// Record is an entry as pushpopd hands it over. zeroconf does not encode
+// the addresses of its entries.
+type Record struct {
+	Instance string   `json:"instance"`
+	HostName string   `json:"hostname"`
+	Port     int      `json:"port"`
+	Text     []string `json:"text"`
+	AddrIPv4 []net.IP `json:"ipv4,omitempty"`
+	AddrIPv6 []net.IP `json:"ipv6,omitempty"`
+	// Seen is when the entry was last announced or answered a query.
+	Seen time.Time `json:"seen"`
+}
+
+// NewRecord returns the record of e, seen now.
+func NewRecord(e *zeroconf.ServiceEntry) Record {
+	return Record{e.Instance, e.HostName, e.Port, e.Text, e.AddrIPv4, e.AddrIPv6, time.Now()}
+}
+
+// Entry returns the entry r describes.
+func (r Record) Entry() *zeroconf.ServiceEntry {
+	e := zeroconf.NewServiceEntry(r.Instance, Service, Domain)
+	e.HostName = r.HostName
+	e.Port = r.Port
+	e.Text = r.Text
+	e.AddrIPv4 = r.AddrIPv4
+	e.AddrIPv6 = r.AddrIPv6
+	return e
+}
+
+// Key tells entries apart.
+func (r Record) Key() string {
+	return fmt.Sprintf("%s\x00%s\x00%d", r.Instance, r.HostName, r.Port)
+}
+
+// SocketPath returns the path of the socket pushpopd listens on.
+func SocketPath() (string, error) {
+	dir, err := control.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pushpopd.sock"), nil
+}
+
+// Listen binds the socket of pushpopd. A socket left behind by a daemon
+// that died is replaced, but one still answering is not.
+func Listen() (net.Listener, error) {
+	path, err := SocketPath()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("Another pushpopd already serves %s", path)
+	}
+	os.Remove(path)
+	return net.Listen("unix", path)
+}
+
+// daemonClient returns an HTTP client talking to pushpopd, or nil when it
+// does not run.
+func daemonClient() *http.Client {
+	path, err := SocketPath()
+	if err != nil {
+		return nil
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil
+	}
+	conn.Close()
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}
+	return &http.Client{Transport: transport}
+}
+
+// fetchRecords asks pushpopd for the entries it knows.
+func fetchRecords(ctx context.Context, client *http.Client) ([]Record, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, daemonURL+EntriesPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected status from pushpopd: %s", resp.Status)
+	}
+	var records []Record
+	err = json.NewDecoder(resp.Body).Decode(&records)
+	return records, err
+}
+
+// fromDaemon relays the entries pushpopd knows, then the ones it learns,
+// until ctx is done. It returns nil when pushpopd does not run.
+func fromDaemon(ctx context.Context, match func(Record) bool) <-chan *zeroconf.ServiceEntry {
+	client := daemonClient()
+	if client == nil {
+		return nil
+	}
+	records, err := fetchRecords(ctx, client)
+	if err != nil {
+		return nil
+	}
+
+	out := make(chan *zeroconf.ServiceEntry)
+	go func() {
+		defer close(out)
+		defer client.CloseIdleConnections()
+		seen := map[string]bool{}
+		tick := time.NewTicker(pollInterval)
+		defer tick.Stop()
+		for {
+			for _, r := range records {
+				if seen[r.Key()] || !match(r) {
+					continue
+				}
+				seen[r.Key()] = true
+				select {
+				case out <- r.Entry():
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-tick.C:
+			case <-ctx.Done():
+				return
+			}
+			next, err := fetchRecords(ctx, client)
+			if err == nil {
+				records = next
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,201 @@
+// Package discovery browses for and announces pushpop endpoints over mDNS,
+// and over UDP broadcast on networks that filter multicast, see Backend.
+// Browsing goes through pushpopd, which keeps the entries of the network at
+// hand, when it runs.
+//
+// It wraps zeroconf so that cancelling a context always stops the
+// goroutines involved and closes the channel handed to the caller. zeroconf
+// alone blocks forever sending an entry nobody reads, so a caller that
+// stops reading before cancelling leaks its resolver.
+//
+// Listing the shares of the network takes a context to stop browsing, see
+// the example.
+//
+// The TXT record keys of an entry are listed in package transfer. This
+// package is part of the stable API of pushpop, see package transfer.
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/grandcat/zeroconf"
+)
+
+// The mDNS service pushpop endpoints are announced as.
+const (
+	Service = "_pushpop._tcp"
+	Domain  = "local."
+)
+
+// Browse returns the endpoints announced on the network, as they are
+// found, until ctx is done. The channel is then closed. They come from
+// pushpopd when it runs.
+func Browse(ctx context.Context) (<-chan *zeroconf.ServiceEntry, error) {
+	return Options{}.Browse(ctx)
+}
+
+// BrowseNetwork is Browse, always querying the network, with the backends
+// one after the other.
+func BrowseNetwork(ctx context.Context) (<-chan *zeroconf.ServiceEntry, error) {
+	return Options{}.BrowseNetwork(ctx)
+}
+
+// Lookup returns the endpoint announced as instance, like Browse.
+func Lookup(ctx context.Context, instance string) (<-chan *zeroconf.ServiceEntry, error) {
+	return Options{}.Lookup(ctx, instance)
+}
+
+// Browse is the package's Browse with the backends of o.
+func (o Options) Browse(ctx context.Context) (<-chan *zeroconf.ServiceEntry, error) {
+	if entries := fromDaemon(ctx, func(Record) bool { return true }); entries != nil {
+		return entries, nil
+	}
+	return o.BrowseNetwork(ctx)
+}
+
+// BrowseNetwork is the package's BrowseNetwork with the backends of o.
+func (o Options) BrowseNetwork(ctx context.Context) (<-chan *zeroconf.ServiceEntry, error) {
+	return fallback(ctx, browseFuncs(o.backends()))
+}
+
+// Lookup is the package's Lookup with the backends of o.
+func (o Options) Lookup(ctx context.Context, instance string) (<-chan *zeroconf.ServiceEntry, error) {
+	if entries := fromDaemon(ctx, func(r Record) bool { return r.Instance == instance }); entries != nil {
+		return entries, nil
+	}
+	var browses []browseFunc
+	for _, b := range o.backends() {
+		if b != MDNS {
+			browses = append(browses, lookup(b, instance))
+			continue
+		}
+		// mDNS queries the instance alone rather than filtering a browse.
+		browses = append(browses, func(ctx context.Context) (<-chan *zeroconf.ServiceEntry, error) {
+			return resolve(ctx, func(r *zeroconf.Resolver, ctx context.Context, raw chan *zeroconf.ServiceEntry) error {
+				return r.Lookup(ctx, instance, Service, Domain, raw)
+			})
+		})
+	}
+	return fallback(ctx, browses)
+}
+
+// resolve runs a query started by start and relays its entries until ctx
+// is done.
+func resolve(ctx context.Context, start func(*zeroconf.Resolver, context.Context, chan *zeroconf.ServiceEntry) error) (<-chan *zeroconf.ServiceEntry, error) {
+	r, err := zeroconf.NewResolver(nil)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	raw := make(chan *zeroconf.ServiceEntry)
+	err = start(r, ctx, raw)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	out := make(chan *zeroconf.ServiceEntry)
+	go func() {
+		defer close(out)
+		// zeroconf closes raw once it noticed ctx is done; until then it
+		// must never be left blocked on a send.
+		defer func() {
+			cancel()
+			for range raw {
+			}
+		}()
+		for {
+			select {
+			case e, ok := <-raw:
+				if !ok {
+					return
+				}
+				select {
+				case out <- e:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// Register announces an endpoint as instance on port with the TXT record
+// text, until ctx is done or the returned server is shut down.
+func Register(ctx context.Context, instance string, port int, text []string) (*zeroconf.Server, error) {
+	server, err := zeroconf.Register(instance, Service, Domain, port, text, nil)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		server.Shutdown()
+	}()
+	return server, nil
+}
+
+// RegisterAt is Register, announcing ip as the only address of the
+// endpoint, and only on the interface that has it. A nil or unspecified ip
+// announces every address, like Register.
+func RegisterAt(ctx context.Context, instance string, ip net.IP, port int, text []string) (*zeroconf.Server, error) {
+	if ip == nil || ip.IsUnspecified() {
+		return Register(ctx, instance, port, text)
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	iface, err := interfaceOf(ip)
+	if err != nil {
+		return nil, err
+	}
+	server, err := zeroconf.RegisterProxy(instance, Service, Domain, port, host, []string{ip.String()}, text, []net.Interface{iface})
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		server.Shutdown()
+	}()
+	return server, nil
+}
+
+// interfaceOf returns the network interface that has ip.
+func interfaceOf(ip net.IP) (net.Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return net.Interface{}, err
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+				return iface, nil
+			}
+		}
+	}
+	return net.Interface{}, fmt.Errorf("No network interface has the address %s", ip)
+}
+
+// Unescape returns the instance name of a browsed entry as announced,
+// without the backslashes escaping its spaces, dots and brackets.
+func Unescape(instance string) string {
+	var b strings.Builder
+	for i := 0; i < len(instance); i++ {
+		if instance[i] == '\\' && i+1 < len(instance) {
+			i++
+		}
+		b.WriteByte(instance[i])
+	}
+	return b.String()
+}
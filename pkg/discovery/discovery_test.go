@@ -0,0 +1,75 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+)
+
+// settle waits for the number of goroutines to come back to at most n,
+// and returns it.
+func settle(n int) int {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		got := runtime.NumGoroutine()
+		if got <= n || time.Now().After(deadline) {
+			return got
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// TestBrowseLeak cancels browses once they found the endpoint announced by
+// the test, and checks that they leave no goroutine behind.
+func TestBrowseLeak(t *testing.T) {
+	// Without pushpopd, browses query the network.
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	instance := fmt.Sprintf("discovery test %d", os.Getpid())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a, err := Announce(ctx, instance, nil, 9, []string{"user=test"})
+	if err != nil {
+		t.Skip("Unable to announce: ", err)
+	}
+	defer a.Shutdown()
+
+	for _, tt := range []struct {
+		name   string
+		browse func(context.Context) (<-chan *zeroconf.ServiceEntry, error)
+	}{
+		{"Browse", Browse},
+		{"Lookup", func(ctx context.Context) (<-chan *zeroconf.ServiceEntry, error) { return Lookup(ctx, instance) }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			before := runtime.NumGoroutine()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			entries, err := tt.browse(ctx)
+			if err != nil {
+				cancel()
+				t.Fatal(err)
+			}
+			found := false
+			for e := range entries {
+				if e.Instance == instance || Unescape(e.Instance) == instance {
+					found = true
+					break
+				}
+			}
+			// The channel is left undrained, as callers stopping at the
+			// first entry do.
+			cancel()
+			if !found {
+				t.Fatal("the announced endpoint was not found")
+			}
+			if after := settle(before); after > before {
+				buf := make([]byte, 1<<16)
+				t.Errorf("%d goroutines before, %d after:\n%s", before, after, buf[:runtime.Stack(buf, true)])
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package discovery_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/discovery"
+)
+
+// Listing the shares of the network for 3 seconds.
+func Example() {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	entries, err := discovery.Browse(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for entry := range entries {
+		fmt.Println(discovery.Unescape(entry.Instance), entry.Port, entry.Text)
+	}
+}
+
+// Browsing with UDP broadcast only, on a network where multicast is
+// filtered.
+func ExampleOptions() {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	entries, err := discovery.Options{Backends: []discovery.Backend{discovery.Broadcast}}.BrowseNetwork(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for entry := range entries {
+		fmt.Println(discovery.Unescape(entry.Instance), entry.Port, entry.Text)
+	}
+}
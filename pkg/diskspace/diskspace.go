@@ -0,0 +1,9 @@
+// Package diskspace tells how much room is left on a filesystem, so that a
+// download that cannot fit is refused before it starts rather than failing
+// near its end.
+package diskspace
+
+import "errors"
+
+// ErrUnknown is returned by Free on systems where it cannot tell.
+var ErrUnknown = errors.New("Free space unknown on this system")
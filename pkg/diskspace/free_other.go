@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd && !windows
+// +build !linux,!darwin,!freebsd,!windows
+
+package diskspace
+
+// Free returns ErrUnknown.
+func Free(path string) (int64, error) {
+	return 0, ErrUnknown
+}
@@ -0,0 +1,18 @@
+package diskspace
+
+import "golang.org/x/sys/windows"
+
+// Free returns how many bytes the volume holding path has available to the
+// user, quotas included.
+func Free(path string) (int64, error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var avail, total, free uint64
+	err = windows.GetDiskFreeSpaceEx(p, &avail, &total, &free)
+	if err != nil {
+		return 0, err
+	}
+	return int64(avail), nil
+}
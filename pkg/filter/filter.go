@@ -0,0 +1,279 @@
+// Package filter parses and evaluates the small expressions pop -filter
+// selects shares with, such as
+//
+//	size < 1GB && user =~ "^build-"
+//
+// An expression compares fields with values using ==, !=, <, <=, >, >=, =~
+// (matches a regular expression) and !~, and combines comparisons with &&,
+// || and !, grouped by parentheses. Values are numbers, which may carry a
+// size unit (KB, MiB, G...), quoted strings or bare words. Comparisons are
+// numeric when both sides are numbers, and on strings otherwise.
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/yifu/pushpop/pkg/units"
+)
+
+// Filter is a parsed expression.
+type Filter struct {
+	root node
+	src  string
+}
+
+// Lookup returns the value of a field for the item being filtered, or
+// false when the item has none. Fields are only looked up when the
+// expression needs them.
+type Lookup func(field string) (string, bool)
+
+// Parse parses expr, which may only use the given fields.
+func Parse(expr string, fields []string) (*Filter, error) {
+	toks, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks, fields: fields}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("Unexpected %q in filter", p.toks[p.pos].text)
+	}
+	return &Filter{root: root, src: expr}, nil
+}
+
+// Match reports whether the item whose fields lookup returns satisfies f.
+// A comparison with a field the item does not have is false.
+func (f *Filter) Match(lookup Lookup) bool {
+	return f.root.eval(lookup)
+}
+
+func (f *Filter) String() string {
+	return f.src
+}
+
+type node interface {
+	eval(Lookup) bool
+}
+
+type and struct{ l, r node }
+type or struct{ l, r node }
+type not struct{ n node }
+
+func (n and) eval(l Lookup) bool { return n.l.eval(l) && n.r.eval(l) }
+func (n or) eval(l Lookup) bool  { return n.l.eval(l) || n.r.eval(l) }
+func (n not) eval(l Lookup) bool { return !n.n.eval(l) }
+
+// comparison compares a field with a value.
+type comparison struct {
+	field, op, value string
+	// num is value as a number, when it is one.
+	num   float64
+	isNum bool
+	re    *regexp.Regexp
+}
+
+func (c *comparison) eval(lookup Lookup) bool {
+	v, ok := lookup(c.field)
+	if !ok {
+		return false
+	}
+	switch c.op {
+	case "=~":
+		return c.re.MatchString(v)
+	case "!~":
+		return !c.re.MatchString(v)
+	}
+	cmp := strings.Compare(v, c.value)
+	if n, err := units.ParseSize(v); err == nil && c.isNum {
+		switch {
+		case n < c.num:
+			cmp = -1
+		case n > c.num:
+			cmp = 1
+		default:
+			cmp = 0
+		}
+	}
+	switch c.op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+type tokenKind int
+
+const (
+	tokWord tokenKind = iota
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// operators are matched longest first.
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "!", "(", ")"}
+
+func tokenize(s string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '"':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+				b.WriteByte(s[j])
+			}
+			if j == len(s) {
+				return nil, fmt.Errorf("Unterminated string in filter")
+			}
+			toks = append(toks, token{tokString, b.String()})
+			i = j + 1
+		default:
+			op := ""
+			for _, o := range operators {
+				if strings.HasPrefix(s[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op != "" {
+				toks = append(toks, token{tokOp, op})
+				i += len(op)
+				continue
+			}
+			j := i
+			for j < len(s) && !strings.ContainsRune(" \t\n\"&|=!<>()~", rune(s[j])) {
+				j++
+			}
+			if j == i {
+				return nil, fmt.Errorf("Unexpected %q in filter", s[i:i+1])
+			}
+			toks = append(toks, token{tokWord, s[i:j]})
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+type parser struct {
+	toks   []token
+	pos    int
+	fields []string
+}
+
+func (p *parser) peek(op string) bool {
+	return p.pos < len(p.toks) && p.toks[p.pos].kind == tokOp && p.toks[p.pos].text == op
+}
+
+func (p *parser) or() (node, error) {
+	n, err := p.and()
+	for err == nil && p.peek("||") {
+		p.pos++
+		var r node
+		r, err = p.and()
+		n = or{n, r}
+	}
+	return n, err
+}
+
+func (p *parser) and() (node, error) {
+	n, err := p.unary()
+	for err == nil && p.peek("&&") {
+		p.pos++
+		var r node
+		r, err = p.unary()
+		n = and{n, r}
+	}
+	return n, err
+}
+
+func (p *parser) unary() (node, error) {
+	switch {
+	case p.peek("!"):
+		p.pos++
+		n, err := p.unary()
+		return not{n}, err
+	case p.peek("("):
+		p.pos++
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peek(")") {
+			return nil, fmt.Errorf("Missing ) in filter")
+		}
+		p.pos++
+		return n, nil
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (node, error) {
+	if p.pos+3 > len(p.toks) {
+		return nil, fmt.Errorf("Incomplete filter, expected a comparison such as size < 1GB")
+	}
+	field, op, value := p.toks[p.pos], p.toks[p.pos+1], p.toks[p.pos+2]
+	if field.kind != tokWord {
+		return nil, fmt.Errorf("Expected a field in filter, got %q", field.text)
+	}
+	if !p.known(field.text) {
+		return nil, fmt.Errorf("Unknown field %q in filter, expected one of %s", field.text, strings.Join(p.fields, ", "))
+	}
+	switch op.text {
+	case "==", "!=", "<", "<=", ">", ">=", "=~", "!~":
+	default:
+		return nil, fmt.Errorf("Expected a comparison after %s in filter, got %q", field.text, op.text)
+	}
+	if value.kind == tokOp {
+		return nil, fmt.Errorf("Expected a value after %s %s in filter, got %q", field.text, op.text, value.text)
+	}
+	p.pos += 3
+
+	c := &comparison{field: field.text, op: op.text, value: value.text}
+	if op.text == "=~" || op.text == "!~" {
+		re, err := regexp.Compile(value.text)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid regular expression in filter: %v", err)
+		}
+		c.re = re
+	} else if value.kind == tokWord {
+		if n, err := units.ParseSize(value.text); err == nil {
+			c.num, c.isNum = n, true
+		}
+	}
+	return c, nil
+}
+
+func (p *parser) known(field string) bool {
+	for _, f := range p.fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package filter
+
+import "testing"
+
+var fields = []string{"name", "size", "user", "gen"}
+
+// share is the item filtered, without a gen field.
+var share = map[string]string{
+	"name": "report.pdf",
+	"size": "1500000",
+	"user": "build-7",
+}
+
+func lookup(field string) (string, bool) {
+	v, ok := share[field]
+	return v, ok
+}
+
+func TestMatch(t *testing.T) {
+	for _, tt := range []struct {
+		expr string
+		want bool
+	}{
+		{"size < 1GB", true},
+		{"size > 1MB", true},
+		{"size >= 1.5MB", true},
+		{"size <= 1.4MB", false},
+		{"size == 1500000", true},
+		{"size != 1500000", false},
+		{"size < 2MiB", true},
+		{`user =~ "^build-"`, true},
+		{`user !~ "^build-"`, false},
+		{`name == "report.pdf"`, true},
+		{"name == report.pdf", true},
+		{"name != report.pdf", false},
+		{`name < "s"`, true},
+		{`name > "s"`, false},
+		{"!(size < 1GB)", false},
+		{"! size < 1KB", true},
+		{`size < 1KB || user == "build-7"`, true},
+		{`size < 1KB || user == "build-8"`, false},
+		{`(size < 1KB || user == "build-7") && name =~ "\.pdf$"`, true},
+		{`size < 1KB || user == "build-7" && name == x`, false},
+		{"gen == 1", false},
+		{"gen != 1", false},
+		{"!(gen == 1)", true},
+		{`name == "a \"quoted\" name"`, false},
+	} {
+		f, err := Parse(tt.expr, fields)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := f.Match(lookup); got != tt.want {
+			t.Errorf("%q: got %v, want %v", tt.expr, got, tt.want)
+		}
+		if f.String() != tt.expr {
+			t.Errorf("String() = %q, want %q", f.String(), tt.expr)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"size",
+		"size <",
+		"size < 1GB &&",
+		"size < 1GB size > 1MB",
+		"foo == 1",
+		"size 1GB",
+		"size < <",
+		`name == "unterminated`,
+		"(size < 1GB",
+		"size < 1GB)",
+		`user =~ "("`,
+		"== 1",
+		"size < 1GB & user == x",
+	} {
+		if _, err := Parse(expr, fields); err == nil {
+			t.Errorf("Parse(%q) succeeded", expr)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Package gc keeps the state pushpop leaves on disk from growing without
+// bound, by removing old files from each store according to a retention
+// policy.
+package gc
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Store is a set of files pushpop accumulates, such as leftover spool files.
+type Store struct {
+	Name string
+	Dir  string
+	// Pattern is a filepath.Match glob selecting the store's files in Dir.
+	Pattern string
+}
+
+// Policy says what to keep. Zero fields do not limit anything.
+type Policy struct {
+	// MaxAge removes files not modified for longer.
+	MaxAge time.Duration
+	// MaxSize removes the oldest files until the store fits.
+	MaxSize int64
+}
+
+// Removal is a file the policy wants gone.
+type Removal struct {
+	Path   string
+	Size   int64
+	Reason string
+}
+
+type file struct {
+	path  string
+	size  int64
+	mtime time.Time
+}
+
+// Plan lists what enforcing p on s at time now removes, oldest first.
+func Plan(s Store, p Policy, now time.Time) ([]Removal, error) {
+	paths, err := filepath.Glob(filepath.Join(s.Dir, s.Pattern))
+	if err != nil {
+		return nil, err
+	}
+	var files []file
+	var total int64
+	for _, path := range paths {
+		fi, err := os.Lstat(path)
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, file{path, fi.Size(), fi.ModTime()})
+		total += fi.Size()
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].mtime.Before(files[j].mtime)
+	})
+
+	var rs []Removal
+	for _, f := range files {
+		switch {
+		case p.MaxAge > 0 && now.Sub(f.mtime) > p.MaxAge:
+			rs = append(rs, Removal{f.path, f.size, "older than " + p.MaxAge.String()})
+		case p.MaxSize > 0 && total > p.MaxSize:
+			rs = append(rs, Removal{f.path, f.size, "store over size limit"})
+		default:
+			continue
+		}
+		total -= f.size
+	}
+	return rs, nil
+}
+
+// Apply removes the files of rs, stopping at the first failure.
+func Apply(rs []Removal) error {
+	for _, r := range rs {
+		err := os.Remove(r.Path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package hashcache
+
+import "os"
+
+// fileID returns 0 for the device and inode, which os.FileInfo does not
+// give here: the path, size and modification time still key the cache.
+func fileID(fi os.FileInfo) (uint64, uint64) {
+	return 0, 0
+}
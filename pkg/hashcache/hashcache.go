@@ -0,0 +1,229 @@
+// Package hashcache remembers the checksums of files, so that sharing or
+// verifying the same big file again does not hash it again. Checksums are
+// kept in $XDG_CACHE_HOME/pushpop/hashes, one JSON object per line, keyed
+// by the path, size, modification time and inode of the file: any change to
+// these makes the entry stale. With Options.Xattr set, checksums are also
+// kept in an extended attribute of the file itself, which follows it when
+// renamed.
+package hashcache
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/hashing"
+)
+
+// Options are how checksums are cached and computed. The zero Options
+// only use the cache file.
+type Options struct {
+	// Xattr stores checksums in the user.pushpop.<algorithm> extended
+	// attribute of files too, and looks there first, where the system and
+	// file system support it.
+	Xattr bool
+	// Hashing is how checksums missing from the cache are computed.
+	Hashing hashing.Options
+}
+
+// maxEntries is how many lines the cache file holds before it is rewritten
+// with only the latest entry of each file.
+const maxEntries = 1000
+
+// racy is how recently modified a file may be and still be cached: a change
+// made within the resolution of modification times would go unnoticed.
+const racy = 2 * time.Second
+
+type entry struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// ModTime is in nanoseconds since the epoch.
+	ModTime int64 `json:"mtime"`
+	// Device and Inode are 0 where the system has none.
+	Device    uint64 `json:"dev,omitempty"`
+	Inode     uint64 `json:"inode,omitempty"`
+	Algorithm string `json:"algorithm"`
+	Sum       string `json:"sum"`
+}
+
+// describe returns the entry for the file at path as fi describes it, without
+// a checksum.
+func describe(path string, fi os.FileInfo, a hashing.Algorithm) entry {
+	e := entry{Path: path, Size: fi.Size(), ModTime: fi.ModTime().UnixNano(), Algorithm: a.Name()}
+	e.Device, e.Inode = fileID(fi)
+	return e
+}
+
+// same reports whether e and o are the same version of the same file.
+func (e entry) same(o entry) bool {
+	o.Sum = e.Sum
+	return e == o
+}
+
+// Path returns the path of the cache file.
+func Path() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pushpop", "hashes"), nil
+}
+
+// SumFile is hashing.SumFileProgress, returning the cached checksum when
+// there is one, after reporting the whole file to progress at once, and
+// caching the checksum otherwise.
+func SumFile(a hashing.Algorithm, path string, progress func(n int64)) (string, error) {
+	return Options{}.SumFile(a, path, progress)
+}
+
+// SumFile is the package's SumFile with o.
+func (o Options) SumFile(a hashing.Algorithm, path string, progress func(n int64)) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	before, err := os.Stat(abs)
+	if err != nil {
+		return "", err
+	}
+	if sum := o.lookup(describe(abs, before, a)); sum != "" {
+		if progress != nil {
+			progress(before.Size())
+		}
+		return sum, nil
+	}
+	sum, err := o.Hashing.SumFileProgress(a, abs, progress)
+	if err != nil {
+		return "", err
+	}
+	// A file that changed while it was hashed is not cached.
+	after, err := os.Stat(abs)
+	if err != nil {
+		return "", err
+	}
+	e := describe(abs, after, a)
+	if e.same(describe(abs, before, a)) && before.Mode().IsRegular() && time.Since(after.ModTime()) > racy {
+		e.Sum = sum
+		o.store(e)
+	}
+	return sum, nil
+}
+
+// lookup returns the cached checksum of the file e describes, "" when
+// there is none.
+func (o Options) lookup(e entry) string {
+	if o.Xattr {
+		if sum := getXattr(e); sum != "" {
+			return sum
+		}
+	}
+	entries, err := load()
+	if err != nil {
+		return ""
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].same(e) {
+			return entries[i].Sum
+		}
+	}
+	return ""
+}
+
+// store caches e. The cache is only an optimization, and failing to update
+// it is not an error.
+func (o Options) store(e entry) {
+	if o.Xattr {
+		setXattr(e)
+	}
+	fn, err := Path()
+	if err != nil {
+		return
+	}
+	err = os.MkdirAll(filepath.Dir(fn), 0700)
+	if err != nil {
+		return
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	// Like the history, each entry is a single write to a file opened for
+	// appending, so that concurrent pushes and pops do not interleave lines.
+	f, err := os.OpenFile(fn, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return
+	}
+	f.Write(append(line, '\n'))
+	f.Close()
+
+	entries, err := load()
+	if err == nil && len(entries) > maxEntries {
+		compact(fn, entries)
+	}
+}
+
+// load returns the entries of the cache file, oldest first.
+func load() ([]entry, error) {
+	fn, err := Path()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(fn)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []entry
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e entry
+		if json.Unmarshal(sc.Bytes(), &e) != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, sc.Err()
+}
+
+// compact rewrites the cache file with the latest entry of each file and
+// algorithm, dropping those of files that are gone. An entry appended by
+// another process meanwhile may be lost, which only costs hashing again.
+func compact(fn string, entries []entry) {
+	type file struct{ path, algorithm string }
+	seen := map[file]bool{}
+	var keep []entry
+	for i := len(entries) - 1; i >= 0 && len(keep) < maxEntries/2; i-- {
+		e := entries[i]
+		f := file{e.Path, e.Algorithm}
+		if seen[f] {
+			continue
+		}
+		seen[f] = true
+		if _, err := os.Stat(e.Path); err == nil {
+			keep = append(keep, e)
+		}
+	}
+	var data []byte
+	for i := len(keep) - 1; i >= 0; i-- {
+		line, err := json.Marshal(keep[i])
+		if err != nil {
+			return
+		}
+		data = append(append(data, line...), '\n')
+	}
+	tmp := fn + ".tmp"
+	err := os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return
+	}
+	err = os.Rename(tmp, fn)
+	if err != nil {
+		os.Remove(tmp)
+	}
+}
@@ -0,0 +1,27 @@
+package hashcache
+
+import "fmt"
+
+// xattrName is the extended attribute holding the checksum computed with
+// the algorithm of e.
+func xattrName(e entry) string {
+	return "user.pushpop." + e.Algorithm
+}
+
+// xattrValue records the checksum of e along with the size and modification
+// time it is valid for. The attribute stays with the inode, so the path
+// does not matter.
+func xattrValue(e entry) string {
+	return fmt.Sprintf("%d %d %s", e.Size, e.ModTime, e.Sum)
+}
+
+// parseXattr returns the checksum in value, "" unless it is valid for e.
+func parseXattr(e entry, value string) string {
+	var size, mtime int64
+	var sum string
+	_, err := fmt.Sscanf(value, "%d %d %s", &size, &mtime, &sum)
+	if err != nil || size != e.Size || mtime != e.ModTime {
+		return ""
+	}
+	return sum
+}
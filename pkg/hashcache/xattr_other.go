@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package hashcache
+
+func getXattr(e entry) string {
+	return ""
+}
+
+func setXattr(e entry) {}
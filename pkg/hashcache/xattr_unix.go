@@ -0,0 +1,21 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package hashcache
+
+import "golang.org/x/sys/unix"
+
+func getXattr(e entry) string {
+	buf := make([]byte, 256)
+	n, err := unix.Getxattr(e.Path, xattrName(e), buf)
+	if err != nil {
+		return ""
+	}
+	return parseXattr(e, string(buf[:n]))
+}
+
+// setXattr fails quietly on file systems without extended attributes and on
+// files the user may not write to.
+func setXattr(e entry) {
+	unix.Setxattr(e.Path, xattrName(e), []byte(xattrValue(e)), 0)
+}
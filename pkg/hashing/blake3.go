@@ -0,0 +1,289 @@
+package hashing
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"math/bits"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/zeebo/blake3"
+)
+
+// BLAKE3 splits its input into 1 KiB chunks hashed independently, then
+// combines their chaining values in a binary tree whose left subtrees are
+// always complete. A large file is hashed a subtree per worker, each reading
+// its own part of the file, and the workers' results are combined up the
+// tree. The compression function below follows the BLAKE3 specification.
+
+const (
+	chunkLen = 1024
+	blockLen = 64
+
+	flagChunkStart = 1 << 0
+	flagChunkEnd   = 1 << 1
+	flagParent     = 1 << 2
+	flagRoot       = 1 << 3
+)
+
+// parallelMin is the smallest file hashed in parallel, and parallelGrain
+// how much of it a worker reads at once, a power of two chunks.
+const (
+	parallelMin   = 64 << 20
+	parallelGrain = 4 << 20
+)
+
+var (
+	calibrate      sync.Once
+	parallelFaster bool
+)
+
+// worthParallel reports whether hashing on all CPUs with the portable code
+// below beats hashing on one with the SIMD code of zeebo/blake3, which is
+// several times faster per CPU where AVX2 or AVX-512 is available. Both are
+// timed once on a sample.
+func worthParallel() bool {
+	calibrate.Do(func() {
+		procs := runtime.GOMAXPROCS(0)
+		if procs < 2 {
+			return
+		}
+		sample := make([]byte, 1<<20)
+		var simd, portable time.Duration
+		// The first round warms up caches and is not counted.
+		for i := 0; i < 2; i++ {
+			start := time.Now()
+			blake3.Sum256(sample)
+			simd = time.Since(start)
+			start = time.Now()
+			treeCV(sample, 0, true)
+			portable = time.Since(start)
+		}
+		// Workers share memory bandwidth, and do not quite scale.
+		parallelFaster = portable*4 < simd*time.Duration(procs)*3
+	})
+	return parallelFaster
+}
+
+var iv = [8]uint32{
+	0x6A09E667, 0xBB67AE85, 0x3C6EF372, 0xA54FF53A,
+	0x510E527F, 0x9B05688C, 0x1F83D9AB, 0x5BE0CD19,
+}
+
+// schedule lists the message words used by each round, the permutation of
+// the specification applied round after round.
+var schedule = func() (s [7][16]int) {
+	perm := [16]int{2, 6, 3, 10, 7, 0, 4, 13, 1, 11, 12, 5, 9, 14, 15, 8}
+	for i := range s[0] {
+		s[0][i] = i
+	}
+	for r := 1; r < len(s); r++ {
+		for i := range s[r] {
+			s[r][i] = s[r-1][perm[i]]
+		}
+	}
+	return s
+}()
+
+func g(a, b, c, d, x, y uint32) (uint32, uint32, uint32, uint32) {
+	a += b + x
+	d = bits.RotateLeft32(d^a, -16)
+	c += d
+	b = bits.RotateLeft32(b^c, -12)
+	a += b + y
+	d = bits.RotateLeft32(d^a, -8)
+	c += d
+	b = bits.RotateLeft32(b^c, -7)
+	return a, b, c, d
+}
+
+// compress returns the chaining value of block m following cv.
+func compress(cv *[8]uint32, m *[16]uint32, counter uint64, n, flags uint32) [8]uint32 {
+	s0, s1, s2, s3 := cv[0], cv[1], cv[2], cv[3]
+	s4, s5, s6, s7 := cv[4], cv[5], cv[6], cv[7]
+	s8, s9, s10, s11 := iv[0], iv[1], iv[2], iv[3]
+	s12, s13, s14, s15 := uint32(counter), uint32(counter>>32), n, flags
+	for r := range schedule {
+		w := &schedule[r]
+		s0, s4, s8, s12 = g(s0, s4, s8, s12, m[w[0]], m[w[1]])
+		s1, s5, s9, s13 = g(s1, s5, s9, s13, m[w[2]], m[w[3]])
+		s2, s6, s10, s14 = g(s2, s6, s10, s14, m[w[4]], m[w[5]])
+		s3, s7, s11, s15 = g(s3, s7, s11, s15, m[w[6]], m[w[7]])
+		s0, s5, s10, s15 = g(s0, s5, s10, s15, m[w[8]], m[w[9]])
+		s1, s6, s11, s12 = g(s1, s6, s11, s12, m[w[10]], m[w[11]])
+		s2, s7, s8, s13 = g(s2, s7, s8, s13, m[w[12]], m[w[13]])
+		s3, s4, s9, s14 = g(s3, s4, s9, s14, m[w[14]], m[w[15]])
+	}
+	return [8]uint32{s0 ^ s8, s1 ^ s9, s2 ^ s10, s3 ^ s11, s4 ^ s12, s5 ^ s13, s6 ^ s14, s7 ^ s15}
+}
+
+// chunkCV returns the chaining value of chunk index holding data, or the
+// start of the hash when the chunk is the whole input.
+func chunkCV(data []byte, index uint64, root bool) [8]uint32 {
+	cv := iv
+	var m [16]uint32
+	for off := 0; off == 0 || off < len(data); off += blockLen {
+		block := data[off:]
+		if len(block) > blockLen {
+			block = block[:blockLen]
+		}
+		n := len(block)
+		if len(block) < blockLen {
+			var buf [blockLen]byte
+			copy(buf[:], block)
+			block = buf[:]
+		}
+		for i := range m {
+			m[i] = binary.LittleEndian.Uint32(block[4*i:])
+		}
+		var flags uint32
+		if off == 0 {
+			flags |= flagChunkStart
+		}
+		if off+blockLen >= len(data) {
+			flags |= flagChunkEnd
+			if root {
+				flags |= flagRoot
+			}
+		}
+		cv = compress(&cv, &m, index, uint32(n), flags)
+	}
+	return cv
+}
+
+// parentCV combines the chaining values of two subtrees.
+func parentCV(left, right [8]uint32, root bool) [8]uint32 {
+	var m [16]uint32
+	copy(m[:8], left[:])
+	copy(m[8:], right[:])
+	flags := uint32(flagParent)
+	if root {
+		flags |= flagRoot
+	}
+	return compress(&iv, &m, 0, blockLen, flags)
+}
+
+// leftChunks returns how many of n chunks go to the left subtree: the
+// largest power of two below n.
+func leftChunks(n int64) int64 {
+	left := int64(1)
+	for left*2 < n {
+		left *= 2
+	}
+	return left
+}
+
+// treeCV returns the chaining value of the subtree of the chunks in data,
+// the first of which is chunk first.
+func treeCV(data []byte, first uint64, root bool) [8]uint32 {
+	n := (int64(len(data)) + chunkLen - 1) / chunkLen
+	if n <= 1 {
+		return chunkCV(data, first, root)
+	}
+	left := leftChunks(n)
+	l := treeCV(data[:left*chunkLen], first, false)
+	r := treeCV(data[left*chunkLen:], first+uint64(left), false)
+	return parentCV(l, r, root)
+}
+
+// parallelTree hashes size bytes of a file with workers, in the same tree
+// as BLAKE3 hashing them in order.
+type parallelTree struct {
+	r    io.ReaderAt
+	size int64
+	// slots holds a token per idle worker.
+	slots chan struct{}
+	bufs  sync.Pool
+	// progress, when not nil, is called with the bytes hashed.
+	progress func(int64)
+}
+
+// subtree returns the chaining value of the n chunks from chunk first on.
+func (t *parallelTree) subtree(first, n int64, root bool) ([8]uint32, error) {
+	if n*chunkLen <= parallelGrain {
+		return t.leaves(first, n, root)
+	}
+	left := leftChunks(n)
+	var l [8]uint32
+	var lerr error
+	var wg sync.WaitGroup
+	select {
+	case <-t.slots:
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l, lerr = t.subtree(first, left, false)
+			t.slots <- struct{}{}
+		}()
+	default:
+		l, lerr = t.subtree(first, left, false)
+	}
+	r, rerr := t.subtree(first+left, n-left, false)
+	wg.Wait()
+	if lerr != nil {
+		return l, lerr
+	}
+	if rerr != nil {
+		return r, rerr
+	}
+	return parentCV(l, r, root), nil
+}
+
+// leaves reads the n chunks from chunk first on and hashes them.
+func (t *parallelTree) leaves(first, n int64, root bool) ([8]uint32, error) {
+	buf := t.bufs.Get().(*[]byte)
+	defer t.bufs.Put(buf)
+	start := first * chunkLen
+	end := start + n*chunkLen
+	if end > t.size {
+		end = t.size
+	}
+	data := (*buf)[:end-start]
+	_, err := t.r.ReadAt(data, start)
+	if err == io.EOF && len(data) == 0 {
+		err = nil
+	} else if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return [8]uint32{}, err
+	}
+	cv := treeCV(data, uint64(first), root)
+	if t.progress != nil {
+		t.progress(int64(len(data)))
+	}
+	return cv, nil
+}
+
+// sumParallel returns the hex encoded BLAKE3 checksum of the size bytes of
+// r, hashed by as many workers as there are CPUs.
+func sumParallel(r io.ReaderAt, size int64, progress func(int64)) (string, error) {
+	t := &parallelTree{
+		r:        r,
+		size:     size,
+		slots:    make(chan struct{}, runtime.GOMAXPROCS(0)),
+		progress: progress,
+	}
+	t.bufs.New = func() interface{} {
+		buf := make([]byte, parallelGrain)
+		return &buf
+	}
+	for i := 1; i < cap(t.slots); i++ {
+		t.slots <- struct{}{}
+	}
+	chunks := (size + chunkLen - 1) / chunkLen
+	if chunks == 0 {
+		chunks = 1
+	}
+	cv, err := t.subtree(0, chunks, true)
+	if err != nil {
+		return "", err
+	}
+	var sum [32]byte
+	for i, w := range cv {
+		binary.LittleEndian.PutUint32(sum[4*i:], w)
+	}
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,93 @@
+package hashing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"runtime"
+	"sync/atomic"
+	"testing"
+
+	"github.com/zeebo/blake3"
+)
+
+// input returns the input of the official BLAKE3 test vectors: n bytes
+// repeating 0 to 250.
+func input(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i % 251)
+	}
+	return b
+}
+
+// TestBLAKE3Vectors checks the portable code against two of the official
+// test vectors.
+func TestBLAKE3Vectors(t *testing.T) {
+	for _, tt := range []struct {
+		n    int
+		want string
+	}{
+		{0, "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262"},
+		{1, "2d3adedff11b61f14c886e35afa036736dcd87a74d27b5c1510225d0f592e213"},
+	} {
+		got, err := sumParallel(bytes.NewReader(input(tt.n)), int64(tt.n), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%d bytes: got %s, want %s", tt.n, got, tt.want)
+		}
+	}
+}
+
+// TestSumParallel checks sumParallel against zeebo/blake3 around the block,
+// chunk and subtree boundaries, including those between workers.
+func TestSumParallel(t *testing.T) {
+	// Workers only start with several CPUs.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	sizes := []int{0, 1, 63, 64, 65, 1023, 1024, 1025, 2047, 2048, 2049, 3 * chunkLen, 5*chunkLen + 1}
+	for _, n := range []int{parallelGrain, 2 * parallelGrain, 3 * parallelGrain} {
+		sizes = append(sizes, n-chunkLen, n-1, n, n+1, n+chunkLen)
+	}
+	data := input(3*parallelGrain + chunkLen)
+	for _, n := range sizes {
+		// Workers report their progress concurrently.
+		var progress atomic.Int64
+		got, err := sumParallel(bytes.NewReader(data[:n]), int64(n), func(k int64) { progress.Add(k) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := blake3.Sum256(data[:n])
+		if got != hex.EncodeToString(want[:]) {
+			t.Errorf("%d bytes: got %s, want %x", n, got, want)
+		}
+		if progress.Load() != int64(n) {
+			t.Errorf("%d bytes: progress reported %d", n, progress.Load())
+		}
+	}
+}
+
+func TestSumParallelShort(t *testing.T) {
+	_, err := sumParallel(bytes.NewReader(input(100)), 2*chunkLen, nil)
+	if err == nil {
+		t.Error("no error for a reader shorter than its size")
+	}
+}
+
+// BenchmarkSum compares the portable code hashing on every CPU with the
+// SIMD code of zeebo/blake3 on one, which worthParallel picks between.
+func BenchmarkSum(b *testing.B) {
+	data := input(parallelMin)
+	b.Run("parallel", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			sumParallel(bytes.NewReader(data), int64(len(data)), nil)
+		}
+	})
+	b.Run("zeebo", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			blake3.Sum256(data)
+		}
+	})
+}
@@ -0,0 +1,24 @@
+package hashing_test
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/yifu/pushpop/pkg/hashing"
+)
+
+// A receiver looks up the algorithm a sender advertises, under
+// transfer.HashKey, and checks what it got with it.
+func Example() {
+	a, err := hashing.Lookup("sha256")
+	if err != nil {
+		log.Fatal(err)
+	}
+	sum, err := hashing.Sum(a, strings.NewReader("hello, world\n"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(sum)
+	// Output: 853ff93762a06ddbf722c4ebe9ddd66d8f63ddaea97f521c3ecc20da7c976020
+}
@@ -0,0 +1,179 @@
+// Package hashing computes the checksums pop uses to verify downloads. The
+// algorithm is chosen by the sender and advertised to receivers by name,
+// which Lookup turns back into an Algorithm, see the example.
+//
+// This package is part of the stable API of pushpop, see package transfer.
+package hashing
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/zeebo/blake3"
+)
+
+// Algorithm is a checksum algorithm known to pushpop.
+type Algorithm interface {
+	// Name identifies the algorithm in TXT records, headers and URLs.
+	Name() string
+	// New returns a fresh hasher.
+	New() hash.Hash
+}
+
+type algorithm struct {
+	name string
+	new  func() hash.Hash
+}
+
+func (a algorithm) Name() string   { return a.name }
+func (a algorithm) New() hash.Hash { return a.new() }
+
+var (
+	BLAKE3 Algorithm = algorithm{"blake3", func() hash.Hash { return blake3.New() }}
+	SHA256 Algorithm = algorithm{"sha256", sha256.New}
+	SHA512 Algorithm = algorithm{"sha512", sha512.New}
+)
+
+// Default is used by senders that do not pick an algorithm, and assumed for
+// senders that do not advertise one.
+var Default = BLAKE3
+
+var algorithms = map[string]Algorithm{
+	BLAKE3.Name(): BLAKE3,
+	SHA256.Name(): SHA256,
+	SHA512.Name(): SHA512,
+}
+
+// Lookup returns the algorithm called name.
+func Lookup(name string) (Algorithm, error) {
+	a, ok := algorithms[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown hash algorithm %q", name)
+	}
+	return a, nil
+}
+
+// Names lists the known algorithms.
+func Names() []string {
+	var names []string
+	for name := range algorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Hex returns the hex encoded digest of h.
+func Hex(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// DefaultBufferSize is the size of the reads of Sum by default. Files on a
+// disk are read faster in larger pieces than io.Copy's.
+const DefaultBufferSize = 256 << 10
+
+// Options are how checksums are computed. The zero Options are the
+// defaults, those of the Sum functions of the package.
+type Options struct {
+	// BufferSize is the size of the reads of Sum; 0 means
+	// DefaultBufferSize.
+	BufferSize int
+}
+
+// Sum returns the hex encoded checksum of everything read from r.
+func Sum(a Algorithm, r io.Reader) (string, error) {
+	return Options{}.Sum(a, r)
+}
+
+// SumFile returns the hex encoded checksum of the file at path.
+func SumFile(a Algorithm, path string) (string, error) {
+	return Options{}.SumFileProgress(a, path, nil)
+}
+
+// SumFileProgress is SumFile calling progress, when not nil, with the
+// number of bytes hashed each time some more are. Large files are hashed
+// with BLAKE3 on all CPUs where that is faster, and progress may then be
+// called from several goroutines at once.
+func SumFileProgress(a Algorithm, path string, progress func(n int64)) (string, error) {
+	return Options{}.SumFileProgress(a, path, progress)
+}
+
+// Sum is the package's Sum with o.
+func (o Options) Sum(a Algorithm, r io.Reader) (string, error) {
+	size := o.BufferSize
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	h := a.New()
+	// Hiding the WriterTo of files, which would copy with a buffer of its
+	// own.
+	_, err := io.CopyBuffer(h, struct{ io.Reader }{r}, make([]byte, size))
+	if err != nil {
+		return "", err
+	}
+	return Hex(h), nil
+}
+
+// SumFileProgress is the package's SumFileProgress with o.
+func (o Options) SumFileProgress(a Algorithm, path string, progress func(n int64)) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if a.Name() == BLAKE3.Name() {
+		fi, err := f.Stat()
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode().IsRegular() && fi.Size() >= parallelMin && worthParallel() {
+			return sumParallel(f, fi.Size(), progress)
+		}
+	}
+	var r io.Reader = f
+	if progress != nil {
+		r = &progressReader{r: f, progress: progress}
+	}
+	return o.Sum(a, r)
+}
+
+type progressReader struct {
+	r        io.Reader
+	progress func(int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.progress(int64(n))
+	}
+	return n, err
+}
+
+// State returns the internal state of h, so that hashing can carry on in
+// another process. Only some algorithms, such as the SHA-2 family, can save
+// it; ok is false for the others.
+func State(h hash.Hash) (state []byte, ok bool) {
+	m, ok := h.(encoding.BinaryMarshaler)
+	if !ok {
+		return nil, false
+	}
+	state, err := m.MarshalBinary()
+	return state, err == nil
+}
+
+// Restore sets the internal state of h to one returned by State.
+func Restore(h hash.Hash, state []byte) error {
+	u, ok := h.(encoding.BinaryUnmarshaler)
+	if !ok {
+		return fmt.Errorf("Unable to restore the state of this hash")
+	}
+	return u.UnmarshalBinary(state)
+}
@@ -0,0 +1,40 @@
+package hashing
+
+import (
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yifu/pushpop/pkg/units"
+)
+
+// BenchmarkBufferSize compares values of Options.BufferSize hashing a file
+// on the disk, as when verifying it.
+func BenchmarkBufferSize(b *testing.B) {
+	const size = 64 << 20
+	fn := filepath.Join(b.TempDir(), "file")
+	f, err := os.Create(fn)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	_, err = io.CopyN(f, rand.Reader, size)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, n := range []int{16 << 10, 32 << 10, 64 << 10, 128 << 10, 256 << 10, 512 << 10, 1 << 20, 4 << 20} {
+		b.Run(units.Bytes(int64(n)), func(b *testing.B) {
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				f.Seek(0, io.SeekStart)
+				_, err := Options{BufferSize: n}.Sum(Default, f)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+// Package history keeps a log of every transfer push and pop make, one JSON
+// object per line in $XDG_DATA_HOME/pushpop/history.jsonl, so that it is
+// possible to find out later what left the machine and what arrived.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Directions of a transfer.
+const (
+	Send    = "send"
+	Receive = "receive"
+)
+
+// ResultOK is the result of a transfer that completed.
+const ResultOK = "ok"
+
+// Entry is one transfer.
+type Entry struct {
+	// Time is when the transfer started.
+	Time      time.Time `json:"time"`
+	Direction string    `json:"direction"`
+	// User is the peer's user name, when known.
+	User string `json:"user,omitempty"`
+	// Addr is the peer's IP address.
+	Addr string `json:"addr,omitempty"`
+	// Agent is the peer's program, as reported by version.Peer.
+	Agent string `json:"agent,omitempty"`
+	// Name is the announced name of the file, Path where it was read from
+	// or written to.
+	Name string `json:"name"`
+	Path string `json:"path,omitempty"`
+	// Size is the number of bytes sent, or the size of what was received.
+	Size      int64         `json:"size"`
+	Algorithm string        `json:"algorithm,omitempty"`
+	Sum       string        `json:"sum,omitempty"`
+	Duration  time.Duration `json:"duration"`
+	// Result is ResultOK or what went wrong.
+	Result string `json:"result"`
+}
+
+// Dir returns the pushpop data directory, following the XDG base directory
+// specification.
+func Dir() (string, error) {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dir, "pushpop"), nil
+}
+
+// Path returns the path of the history file.
+func Path() (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "history.jsonl"), nil
+}
+
+// Append adds e to the history. Each entry is a single write to a file opened
+// for appending, so concurrent pushes and pops do not interleave lines.
+func Append(e Entry) error {
+	fn, err := Path()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(fn), 0700)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(fn, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Load returns the entries of the history, oldest first. A missing history
+// is empty; lines that do not parse, such as one cut short by a crash, are
+// skipped.
+func Load() ([]Entry, error) {
+	fn, err := Path()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(fn)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e Entry
+		if json.Unmarshal(sc.Bytes(), &e) != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, sc.Err()
+}
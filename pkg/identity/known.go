@@ -0,0 +1,77 @@
+package identity
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/yifu/pushpop/pkg/config"
+)
+
+// KnownFileName is the name of the file, in the configuration directory,
+// pinning the keys of the users pop received from: the first key a user
+// signs with is trusted, as ssh trusts the first key of a host, and
+// another one then stands out. Each line holds a user name and a
+// fingerprint; a user sharing from several machines has a line per key.
+const KnownFileName = "known_senders"
+
+// KnownPath returns the path of the known senders file.
+func KnownPath() (string, error) {
+	dir, err := config.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, KnownFileName), nil
+}
+
+// Known returns the fingerprints pinned for user, none when pop never
+// received anything signed from them.
+func Known(user string) ([]string, error) {
+	path, err := KnownPath()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var fps []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 2 && fields[0] == user {
+			fps = append(fps, fields[1])
+		}
+	}
+	return fps, sc.Err()
+}
+
+// Trust pins fingerprint as a key of user.
+func Trust(user, fingerprint string) error {
+	if user == "" || strings.ContainsAny(user, " \t\n") {
+		return fmt.Errorf("Invalid user name %q", user)
+	}
+	path, err := KnownPath()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%s %s\n", user, fingerprint)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,172 @@
+// Package ignore implements .pushpopignore files, which use the gitignore
+// syntax to keep files out of a directory push.
+package ignore
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// FileName is the ignore file looked up at the root of a pushed directory.
+const FileName = ".pushpopignore"
+
+type rule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// Matcher tells which paths an ignore file excludes. The zero value ignores
+// nothing.
+type Matcher struct {
+	rules []rule
+}
+
+// Parse reads gitignore style patterns from r.
+func Parse(r io.Reader) (*Matcher, error) {
+	m := &Matcher{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var ru rule
+		if strings.HasPrefix(line, "!") {
+			ru.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			ru.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		re, err := regexp.Compile(compile(line))
+		if err != nil {
+			return nil, err
+		}
+		ru.re = re
+		m.rules = append(m.rules, ru)
+	}
+	return m, scanner.Err()
+}
+
+// Load reads the ignore file at path. A missing file ignores nothing.
+func Load(path string) (*Matcher, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return &Matcher{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// compile turns a pattern into a regular expression matching slash separated
+// paths relative to the ignore file. Like git, a pattern with a slash
+// before its end is anchored to that directory; one without matches at any
+// depth.
+func compile(pattern string) string {
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "/**") && i+3 == len(pattern):
+			b.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	if anchored {
+		return "^" + b.String() + "$"
+	}
+	return "^(?:.*/)?" + b.String() + "$"
+}
+
+// Match reports whether the slash separated path rel is ignored. The last
+// matching pattern wins, so a "!" pattern can bring back a path excluded
+// by an earlier one.
+func (m *Matcher) Match(rel string, isDir bool) bool {
+	ignored := false
+	for _, ru := range m.rules {
+		if ru.dirOnly && !isDir {
+			continue
+		}
+		if ru.re.MatchString(rel) {
+			ignored = !ru.negate
+		}
+	}
+	return ignored
+}
+
+// Walk calls fn, in lexical order, for every regular file under root that
+// the ignore file at the root of the tree does not exclude. Ignored
+// directories are not descended into. rel is slash separated.
+func Walk(root string, fn func(path, rel string, fi os.FileInfo) error) error {
+	m, err := Load(filepath.Join(root, FileName))
+	if err != nil {
+		return err
+	}
+	return filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if m.Match(rel, fi.IsDir()) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		return fn(path, rel, fi)
+	})
+}
@@ -0,0 +1,114 @@
+package ignore
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	m, err := Parse(strings.NewReader(`# comment
+*.log
+!keep.log
+build/
+/top.txt
+docs/*.html
+**/cache
+logs/**
+a?c
+[bc]at
+[!x]yz
+\#literal
+\!bang
+trailing   
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		rel   string
+		isDir bool
+		want  bool
+	}{
+		{"a.log", false, true},
+		{"deep/dir/a.log", false, true},
+		{"keep.log", false, false},
+		{"deep/keep.log", false, false},
+		{"build", true, true},
+		{"src/build", true, true},
+		{"build", false, false},
+		{"top.txt", false, true},
+		{"sub/top.txt", false, false},
+		{"docs/index.html", false, true},
+		{"docs/api/index.html", false, false},
+		{"cache", true, true},
+		{"a/b/cache", false, true},
+		{"logs/x", false, true},
+		{"logs/x/y", false, true},
+		{"logs", true, false},
+		{"abc", false, true},
+		{"abbc", false, false},
+		{"a/c", false, false},
+		{"bat", false, true},
+		{"cat", false, true},
+		{"rat", false, false},
+		{"ayz", false, true},
+		{"xyz", false, false},
+		{"#literal", false, true},
+		{"comment", false, false},
+		{"!bang", false, true},
+		{"trailing", false, true},
+		{"main.go", false, false},
+	} {
+		if got := m.Match(tt.rel, tt.isDir); got != tt.want {
+			t.Errorf("Match(%q, %v) = %v, want %v", tt.rel, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestZeroMatcher(t *testing.T) {
+	var m Matcher
+	if m.Match("anything", false) {
+		t.Error("the zero Matcher ignores something")
+	}
+}
+
+func TestLoadMissing(t *testing.T) {
+	m, err := Load(filepath.Join(t.TempDir(), FileName))
+	if err != nil || m.Match("a", false) {
+		t.Errorf("Load of a missing file = %v, %v", m, err)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	root := t.TempDir()
+	for _, fn := range []string{FileName, "a.txt", "a.log", "build/out.o", "src/main.go", "src/build/gen.go", "src/keep.log"} {
+		path := filepath.Join(root, filepath.FromSlash(fn))
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content := ""
+		if fn == FileName {
+			content = "*.log\n!keep.log\nbuild/\n"
+		}
+		err = os.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []string
+	err := Walk(root, func(path, rel string, fi os.FileInfo) error {
+		got = append(got, rel)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{FileName, "a.txt", "src/keep.log", "src/main.go"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Walk found %q, want %q", got, want)
+	}
+}
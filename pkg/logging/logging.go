@@ -0,0 +1,225 @@
+// Package logging sets up the log/slog logger of the pushpop commands from
+// their -verbose, -log-level and -log-file flags. The messages of the log
+// package go through it too, at the info level.
+//
+// On the terminal, records read like those of the log package, the level
+// ahead of the message when other than info and the attributes after it:
+//
+//	2024/05/04 10:12:01 DEBUG Request method=GET path=/ addr=10.0.0.2:51234
+//
+// In the log file, and in the copies added with Tee, they are written by
+// slog's text handler, one key=value record per line.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How to log, set by the -verbose, -log-level and -log-file flags.
+var (
+	// Verbose logs debugging details too, like Level "debug".
+	Verbose bool
+	// Level is the least severe level logged: debug, info, warn or error.
+	Level = "info"
+	// File is a file the log is appended to instead of stderr, when set.
+	File string
+)
+
+// handlers are the outputs of the default logger, Tee adding some.
+var handlers = &outputs{}
+
+// Setup makes the logger the flags describe the default one of slog and of
+// the log package. The returned function closes the log file.
+func Setup() (func(), error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(Level))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid -log-level value %q, expected debug, info, warn or error", Level)
+	}
+	if Verbose && level > slog.LevelDebug {
+		level = slog.LevelDebug
+	}
+	closeFile := func() {}
+	var h slog.Handler
+	if File != "" {
+		f, err := os.OpenFile(File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		closeFile = func() { f.Close() }
+		h = slog.NewTextHandler(f, &slog.HandlerOptions{Level: level})
+	} else {
+		h = &lineHandler{mu: &sync.Mutex{}, w: os.Stderr, level: level}
+	}
+	handlers.add(h)
+	slog.SetDefault(slog.New(&fanout{outs: handlers}))
+	return closeFile, nil
+}
+
+// Error logs msg at the error level and, when the log goes to a file, also
+// prints it on stderr: that is how the command tells why it fails.
+func Error(msg string, args ...interface{}) {
+	slog.Error(msg, args...)
+	if File != "" {
+		fmt.Fprintln(os.Stderr, msg)
+	}
+}
+
+// Tee copies every record of the default logger, whatever its level, to w,
+// as for a debug bundle.
+func Tee(w io.Writer) {
+	handlers.add(slog.NewTextHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+// outputs are handlers records are written to.
+type outputs struct {
+	mu sync.Mutex
+	hs []slog.Handler
+}
+
+func (o *outputs) add(h slog.Handler) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.hs = append(o.hs, h)
+}
+
+func (o *outputs) list() []slog.Handler {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]slog.Handler(nil), o.hs...)
+}
+
+// fanout hands records to every output, including those added after
+// loggers were derived from it with With or WithGroup: these calls are
+// replayed on the outputs as records come.
+type fanout struct {
+	outs *outputs
+	with []func(slog.Handler) slog.Handler
+}
+
+func (f *fanout) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range f.outs.list() {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fanout) Handle(ctx context.Context, r slog.Record) error {
+	var first error
+	for _, h := range f.outs.list() {
+		for _, with := range f.with {
+			h = with(h)
+		}
+		if !h.Enabled(ctx, r.Level) {
+			continue
+		}
+		err := h.Handle(ctx, r.Clone())
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (f *fanout) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return f.derive(func(h slog.Handler) slog.Handler { return h.WithAttrs(attrs) })
+}
+
+func (f *fanout) WithGroup(name string) slog.Handler {
+	return f.derive(func(h slog.Handler) slog.Handler { return h.WithGroup(name) })
+}
+
+func (f *fanout) derive(with func(slog.Handler) slog.Handler) slog.Handler {
+	return &fanout{outs: f.outs, with: append(append([]func(slog.Handler) slog.Handler(nil), f.with...), with)}
+}
+
+// lineHandler writes records the way the log package does, for people
+// rather than programs to read.
+type lineHandler struct {
+	mu    *sync.Mutex
+	w     io.Writer
+	level slog.Leveler
+	// attrs are those of With, formatted, and group the prefix of the keys
+	// of WithGroup.
+	attrs string
+	group string
+}
+
+func (h *lineHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *lineHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	t := r.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	b.WriteString(t.Format("2006/01/02 15:04:05 "))
+	if r.Level != slog.LevelInfo {
+		b.WriteString(r.Level.String() + " ")
+	}
+	b.WriteString(strings.TrimSuffix(r.Message, "\n"))
+	b.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttr(&b, h.group, a)
+		return true
+	})
+	b.WriteByte('\n')
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *lineHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var b strings.Builder
+	for _, a := range attrs {
+		appendAttr(&b, h.group, a)
+	}
+	h2 := *h
+	h2.attrs += b.String()
+	return &h2
+}
+
+func (h *lineHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.group += name + "."
+	return &h2
+}
+
+// appendAttr appends a to b as " key=value", quoting the value when it
+// needs to be.
+func appendAttr(b *strings.Builder, group string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			group += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			appendAttr(b, group, ga)
+		}
+		return
+	}
+	v := a.Value.String()
+	if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+		v = strconv.Quote(v)
+	}
+	b.WriteString(" " + group + a.Key + "=" + v)
+}
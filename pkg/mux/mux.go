@@ -0,0 +1,185 @@
+// Package mux shares a single listener between several protocols, so that
+// push can announce one port whatever the client speaks. Each connection is
+// routed by its first bytes, which are peeked and then replayed to the
+// protocol's server.
+package mux
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// sniffTimeout bounds how long a client may stay silent before its
+// connection is dropped.
+const sniffTimeout = 10 * time.Second
+
+// Matcher reports whether a connection speaks a protocol, peeking at its
+// first bytes in r.
+type Matcher func(r *bufio.Reader) bool
+
+// httpMethods start HTTP/1 requests. HTTP/2 without TLS is not used by any
+// pushpop client.
+var httpMethods = [][]byte{
+	[]byte("GET "), []byte("PUT "), []byte("HEAD "), []byte("POST "),
+	[]byte("PATCH "), []byte("TRACE "), []byte("DELETE "), []byte("OPTIONS "), []byte("CONNECT "),
+}
+
+// HTTP matches plain HTTP/1 requests.
+func HTTP(r *bufio.Reader) bool {
+	return hasPrefix(r, httpMethods...)
+}
+
+// TLS matches a TLS handshake.
+func TLS(r *bufio.Reader) bool {
+	// A handshake record, with a 3.x record version.
+	b, err := r.Peek(2)
+	return err == nil && b[0] == 0x16 && b[1] == 0x03
+}
+
+// Prefix matches connections that start with p.
+func Prefix(p []byte) Matcher {
+	return func(r *bufio.Reader) bool {
+		return hasPrefix(r, p)
+	}
+}
+
+// hasPrefix reports whether r starts with one of prefixes. It peeks one byte
+// at a time, so that it does not wait for more bytes than the client sent
+// to tell.
+func hasPrefix(r *bufio.Reader, prefixes ...[]byte) bool {
+	for n := 1; ; n++ {
+		b, err := r.Peek(n)
+		if err != nil {
+			return false
+		}
+		possible := false
+		for _, p := range prefixes {
+			if len(p) < n || !bytes.Equal(p[:n], b) {
+				continue
+			}
+			if len(p) == n {
+				return true
+			}
+			possible = true
+		}
+		if !possible {
+			return false
+		}
+	}
+}
+
+// Mux dispatches the connections accepted by a listener to the listeners
+// returned by Match.
+type Mux struct {
+	ln     net.Listener
+	routes []route
+	done   chan struct{}
+	once   sync.Once
+}
+
+type route struct {
+	match Matcher
+	l     *listener
+}
+
+// New returns a Mux for ln. Routes must be added with Match before Serve is
+// called.
+func New(ln net.Listener) *Mux {
+	return &Mux{ln: ln, done: make(chan struct{})}
+}
+
+// Match returns a listener accepting the connections matched by match.
+// Matchers are tried in the order they were added; connections nothing
+// matches are closed.
+func (m *Mux) Match(match Matcher) net.Listener {
+	l := &listener{m: m, conns: make(chan net.Conn)}
+	m.routes = append(m.routes, route{match, l})
+	return l
+}
+
+// Serve accepts connections until the listener is closed.
+func (m *Mux) Serve() error {
+	defer m.Close()
+	for {
+		c, err := m.ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		go m.dispatch(c)
+	}
+}
+
+// Close closes the listener and every listener returned by Match.
+func (m *Mux) Close() error {
+	var err error
+	m.once.Do(func() {
+		close(m.done)
+		err = m.ln.Close()
+	})
+	return err
+}
+
+func (m *Mux) dispatch(c net.Conn) {
+	r := bufio.NewReader(c)
+	c.SetReadDeadline(time.Now().Add(sniffTimeout))
+	for _, rt := range m.routes {
+		if !rt.match(r) {
+			continue
+		}
+		c.SetReadDeadline(time.Time{})
+		select {
+		case rt.l.conns <- &conn{c, r}:
+		case <-m.done:
+			c.Close()
+		}
+		return
+	}
+	c.Close()
+}
+
+// conn replays the peeked bytes before reading on from the connection.
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// ReadFrom writes r to the connection with the connection's own ReadFrom,
+// so that a file served over a mux still goes out with sendfile.
+func (c *conn) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(c.Conn, r)
+}
+
+type listener struct {
+	m     *Mux
+	conns chan net.Conn
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.m.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the whole Mux, since its routes cannot outlive it anyway.
+func (l *listener) Close() error {
+	return l.m.Close()
+}
+
+func (l *listener) Addr() net.Addr {
+	return l.m.ln.Addr()
+}
@@ -0,0 +1,253 @@
+// Package netlimit bounds the connections an HTTP server takes from the
+// network: how many are open at once, in all and per address, for how
+// long, and how long their peer may take to send headers, stay idle or
+// take data. A peer that misbehaves, or just goes away, then cannot use up
+// the file descriptors of the server.
+package netlimit
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// MaxHeaderBytes caps the size of the headers of a request, which pushpop
+// clients keep small.
+const MaxHeaderBytes = 64 << 10
+
+// Limits are the limits on the connections of servers and listeners. The
+// connections are counted across every listener of the same Limits, which
+// must not be copied or changed once used. 0 lifts a limit.
+type Limits struct {
+	// MaxConns is how many connections may be open at once. More wait for
+	// one to close before being accepted.
+	MaxConns int
+	// MaxConnsPerIP is how many connections an address may have open at
+	// once. Those beyond are closed at once.
+	MaxConnsPerIP int
+	// ConnTimeout is how long a connection may stay open, whatever it is
+	// doing.
+	ConnTimeout time.Duration
+	// HeaderTimeout is how long the peer may take to send the headers of a
+	// request.
+	HeaderTimeout time.Duration
+	// IdleTimeout is how long a kept-alive connection may stay idle between
+	// requests.
+	IdleTimeout time.Duration
+	// WriteTimeout is how long the peer may take no data. The deadline of
+	// writes is pushed back with each of them, so that downloads taking
+	// hours go on as long as they move.
+	WriteTimeout time.Duration
+	// Logger logs the connections closed for going over a limit; nil
+	// discards them.
+	Logger *slog.Logger
+
+	once sync.Once
+	// slots holds a value per open connection, MaxConns at most.
+	slots chan struct{}
+
+	mu    sync.Mutex
+	perIP map[string]int
+}
+
+// New returns the default limits: 256 connections, 32 per address, 10
+// seconds for the headers, 2 minutes idle or without taking data, and no
+// limit on how long a connection stays open.
+func New() *Limits {
+	return &Limits{
+		MaxConns:      256,
+		MaxConnsPerIP: 32,
+		HeaderTimeout: 10 * time.Second,
+		IdleTimeout:   2 * time.Minute,
+		WriteTimeout:  2 * time.Minute,
+	}
+}
+
+// Server returns a server of handler with the timeouts of l. It should
+// serve a listener from l.Listener, which enforces the others.
+func (l *Limits) Server(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: l.HeaderTimeout,
+		IdleTimeout:       l.IdleTimeout,
+		MaxHeaderBytes:    MaxHeaderBytes,
+	}
+}
+
+// Listener returns ln, accepting connections within l and pushing back
+// their write deadline as they write.
+func (l *Limits) Listener(ln net.Listener) net.Listener {
+	if l.MaxConns <= 0 && l.MaxConnsPerIP <= 0 && l.ConnTimeout <= 0 && l.WriteTimeout <= 0 {
+		return ln
+	}
+	l.once.Do(func() {
+		l.perIP = map[string]int{}
+		if l.MaxConns > 0 {
+			l.slots = make(chan struct{}, l.MaxConns)
+		}
+	})
+	return &listener{Listener: ln, limits: l, done: make(chan struct{})}
+}
+
+func (l *Limits) debug(msg string, args ...any) {
+	if l.Logger != nil {
+		l.Logger.Debug(msg, args...)
+	}
+}
+
+// acquire waits for a connection to be allowed under MaxConns, or for done
+// to be closed, returning false then.
+func (l *Limits) acquire(done <-chan struct{}) bool {
+	if l.slots == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+func (l *Limits) release() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// open counts a connection from ip, unless ip has MaxConnsPerIP open
+// already.
+func (l *Limits) open(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.MaxConnsPerIP > 0 && l.perIP[ip] >= l.MaxConnsPerIP {
+		return false
+	}
+	l.perIP[ip]++
+	return true
+}
+
+func (l *Limits) close(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.perIP[ip]--
+	if l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+}
+
+// listener accepts connections within its limits.
+type listener struct {
+	net.Listener
+	limits    *Limits
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (ln *listener) Accept() (net.Conn, error) {
+	l := ln.limits
+	for {
+		if !l.acquire(ln.done) {
+			return nil, net.ErrClosed
+		}
+		c, err := ln.Listener.Accept()
+		if err != nil {
+			l.release()
+			return nil, err
+		}
+		ip, _, err := net.SplitHostPort(c.RemoteAddr().String())
+		if err != nil {
+			ip = c.RemoteAddr().String()
+		}
+		if !l.open(ip) {
+			l.debug("Too many connections, closing", "addr", c.RemoteAddr(), "limit", l.MaxConnsPerIP)
+			c.Close()
+			l.release()
+			continue
+		}
+		lc := &conn{Conn: c, limits: l, ip: ip}
+		if l.ConnTimeout > 0 {
+			lc.timer = time.AfterFunc(l.ConnTimeout, func() {
+				l.debug("Connection open for too long, closing", "addr", c.RemoteAddr(), "timeout", l.ConnTimeout)
+				c.Close()
+			})
+		}
+		return lc, nil
+	}
+}
+
+func (ln *listener) Close() error {
+	ln.closeOnce.Do(func() { close(ln.done) })
+	return ln.Listener.Close()
+}
+
+// conn is a connection counted under its limits until closed.
+type conn struct {
+	net.Conn
+	limits *Limits
+	ip     string
+	timer  *time.Timer
+	once   sync.Once
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	if c.limits.WriteTimeout > 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(c.limits.WriteTimeout))
+	}
+	return c.Conn.Write(b)
+}
+
+// deadlineChunk is how much ReadFrom sends between pushes of the write
+// deadline.
+const deadlineChunk = 1 << 20
+
+// ReadFrom sends r with the ReadFrom of the connection, so that files still
+// go out with sendfile, pushing back the write deadline every
+// deadlineChunk bytes. A file served with ServeContent comes as an
+// io.LimitedReader, which is cut in chunks itself: wrapping it again would
+// hide the file from sendfile.
+func (c *conn) ReadFrom(r io.Reader) (int64, error) {
+	rf, ok := c.Conn.(io.ReaderFrom)
+	if !ok {
+		return io.Copy(struct{ io.Writer }{c}, r)
+	}
+	timeout := c.limits.WriteTimeout
+	if timeout <= 0 {
+		return rf.ReadFrom(r)
+	}
+	outer, limited := r.(*io.LimitedReader)
+	var total int64
+	for {
+		chunk := &io.LimitedReader{R: r, N: deadlineChunk}
+		if limited {
+			if outer.N <= 0 {
+				return total, nil
+			}
+			chunk.R, chunk.N = outer.R, min(outer.N, deadlineChunk)
+		}
+		c.Conn.SetWriteDeadline(time.Now().Add(timeout))
+		n, err := rf.ReadFrom(chunk)
+		total += n
+		if limited {
+			outer.N -= n
+		}
+		if err != nil || chunk.N > 0 {
+			return total, err
+		}
+	}
+}
+
+func (c *conn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		if c.timer != nil {
+			c.timer.Stop()
+		}
+		c.limits.close(c.ip)
+		c.limits.release()
+	})
+	return err
+}
@@ -0,0 +1,122 @@
+package netlimit
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// serve serves handler under l on a local port and returns its address.
+func serve(t *testing.T, l *Limits, handler http.Handler) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := l.Server(handler)
+	go srv.Serve(l.Listener(ln))
+	t.Cleanup(func() { srv.Close() })
+	return ln.Addr().String()
+}
+
+// closed reports whether the server closed c within a second.
+func closed(c net.Conn) bool {
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := c.Read(make([]byte, 1))
+	return err == io.EOF
+}
+
+func TestMaxConnsPerIP(t *testing.T) {
+	l := &Limits{MaxConnsPerIP: 2}
+	addr := serve(t, l, http.NotFoundHandler())
+	var conns []net.Conn
+	for i := 0; i < 3; i++ {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		conns = append(conns, c)
+	}
+	if !closed(conns[2]) {
+		t.Error("the connection over the limit was kept open")
+	}
+	conns[0].Close()
+	time.Sleep(100 * time.Millisecond)
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+	resp, err := io.ReadAll(c)
+	if err != nil || !bytes.HasPrefix(resp, []byte("HTTP/1.0 404")) {
+		t.Errorf("once one was closed, got %q, %v", resp, err)
+	}
+}
+
+func TestHeaderTimeout(t *testing.T) {
+	l := &Limits{HeaderTimeout: 100 * time.Millisecond}
+	addr := serve(t, l, http.NotFoundHandler())
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("GET / HTTP/1.1\r\n"))
+	if !closed(c) {
+		t.Error("the connection sending no headers was kept open")
+	}
+}
+
+func TestConnTimeout(t *testing.T) {
+	l := &Limits{ConnTimeout: 100 * time.Millisecond}
+	addr := serve(t, l, http.NotFoundHandler())
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if !closed(c) {
+		t.Error("the connection was kept open past ConnTimeout")
+	}
+}
+
+// TestServeFile checks that files served through the write deadline arrive
+// whole, in several chunks.
+func TestServeFile(t *testing.T) {
+	data := bytes.Repeat([]byte("pushpop "), 3*deadlineChunk/8+5)
+	fn := filepath.Join(t.TempDir(), "file")
+	err := os.WriteFile(fn, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := New()
+	addr := serve(t, l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, fn)
+	}))
+	for _, rng := range []string{"", "bytes=10-"} {
+		req, _ := http.NewRequest(http.MethodGet, "http://"+addr+"/", nil)
+		if rng != "" {
+			req.Header.Set("Range", rng)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		want := data
+		if rng != "" {
+			want = data[10:]
+		}
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("Range %q: got %d bytes, want %d: %v", rng, len(got), len(want), err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// Package notify tells about finished transfers with a desktop notification
+// and a webhook, so that long transfers need not be watched.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/history"
+	"github.com/yifu/pushpop/pkg/units"
+)
+
+// Options are how to tell, set by the -notify and -webhook flags. The zero
+// Options tell nothing.
+type Options struct {
+	// Desktop enables desktop notifications.
+	Desktop bool
+	// Webhook is a URL the history entry of each transfer is posted to as
+	// JSON, when set.
+	Webhook string
+	// Logger logs the failures to tell; nil discards them.
+	Logger *slog.Logger
+}
+
+// timeout bounds how long telling about a transfer may take.
+const timeout = 10 * time.Second
+
+// Transfer tells about e, a transfer that ended, as o sets up. Failures to
+// tell are logged with o.Logger.
+func (o Options) Transfer(e history.Entry) {
+	if o.Desktop {
+		title, body := summary(e)
+		err := desktop(title, body)
+		if err != nil && o.Logger != nil {
+			o.Logger.Warn("Unable to show a notification", "err", err)
+		}
+	}
+	if o.Webhook != "" {
+		err := post(o.Webhook, e)
+		if err != nil && o.Logger != nil {
+			o.Logger.Warn("Unable to call the webhook", "url", o.Webhook, "err", err)
+		}
+	}
+}
+
+// summary returns the title and body of the notification about e.
+func summary(e history.Entry) (string, string) {
+	verb := "Received"
+	if e.Direction == history.Send {
+		verb = "Sent"
+	}
+	peer := e.User
+	if peer == "" {
+		peer = e.Addr
+	}
+	if e.Result != history.ResultOK {
+		return fmt.Sprintf("%s %s failed", verb, e.Name), e.Result
+	}
+	body := fmt.Sprintf("%s in %v", units.Bytes(e.Size), e.Duration.Round(time.Second))
+	if peer != "" {
+		body = fmt.Sprintf("%s, %s", peer, body)
+	}
+	return fmt.Sprintf("%s %s", verb, e.Name), body
+}
+
+func desktop(title, body string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %q with title %q", body, "pushpop: "+title)
+		cmd = exec.CommandContext(ctx, "osascript", "-e", script)
+	case "windows":
+		return fmt.Errorf("Desktop notifications are not supported on Windows")
+	default:
+		cmd = exec.CommandContext(ctx, "notify-send", "--app-name=pushpop", title, body)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil && len(out) > 0 {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(out))
+	}
+	return err
+}
+
+func post(url string, e history.Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("Unexpected status: %s", resp.Status)
+	}
+	return nil
+}
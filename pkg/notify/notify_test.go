@@ -0,0 +1,44 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yifu/pushpop/pkg/history"
+)
+
+func TestWebhook(t *testing.T) {
+	got := make(chan history.Entry, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e history.Entry
+		json.NewDecoder(r.Body).Decode(&e)
+		got <- e
+	}))
+	defer srv.Close()
+	Options{Webhook: srv.URL}.Transfer(history.Entry{Name: "f.iso", Result: history.ResultOK})
+	if e := <-got; e.Name != "f.iso" {
+		t.Errorf("The webhook got %+v", e)
+	}
+}
+
+func TestWebhookFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	e := history.Entry{Name: "f.iso", Result: history.ResultOK}
+
+	// Without a logger, the failure goes unsaid.
+	Options{Webhook: srv.URL}.Transfer(e)
+
+	var buf bytes.Buffer
+	Options{Webhook: srv.URL, Logger: slog.New(slog.NewTextHandler(&buf, nil))}.Transfer(e)
+	if !strings.Contains(buf.String(), "Unable to call the webhook") {
+		t.Errorf("The failure was not logged: %q", buf.String())
+	}
+}
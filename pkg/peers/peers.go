@@ -0,0 +1,71 @@
+// Package peers reads ~/.config/pushpop/peers, the senders pop reaches
+// without mDNS, on networks where multicast is blocked:
+//
+//	# user host:port
+//	alice 192.168.1.10:8437
+//	bob   build-server.example.com:8437
+//
+// A user may have several lines, tried in order. push prints the line
+// describing its share; it only stays valid with a fixed push -port.
+package peers
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/yifu/pushpop/pkg/config"
+)
+
+// Path returns the path of the peers file.
+func Path() (string, error) {
+	dir, err := config.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "peers"), nil
+}
+
+// Load returns the addresses of every user in the peers file, as host:port,
+// in the order of the file. A missing file has none.
+func Load() (map[string][]string, error) {
+	path, err := Path()
+	if err != nil {
+		return nil, err
+	}
+	peers := map[string][]string{}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return peers, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected user host:port", path, n)
+		}
+		_, _, err := net.SplitHostPort(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		peers[fields[0]] = append(peers[fields[0]], fields[1])
+	}
+	return peers, scanner.Err()
+}
+
+// Line returns the line of the peers file for user at host and port.
+func Line(user, host string, port int) string {
+	return fmt.Sprintf("%s %s", user, net.JoinHostPort(host, fmt.Sprint(port)))
+}
@@ -0,0 +1,39 @@
+package popcmd
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/grandcat/zeroconf"
+	"github.com/yifu/pushpop/pkg/transfer"
+	"github.com/yifu/pushpop/pkg/units"
+)
+
+// announcedSize returns the size of the file announced by entry, false when
+// its sender does not announce it.
+func announcedSize(entry *zeroconf.ServiceEntry) (int64, bool) {
+	size, err := strconv.ParseInt(txtValue(entry, transfer.SizeKey), 10, 64)
+	if err != nil || size < 0 {
+		return 0, false
+	}
+	return size, true
+}
+
+// describe returns what entry announces as people read it, such as
+// "report.pdf (1.3 GiB) from alice@laptop", without connecting to the
+// sender.
+func describe(entry *zeroconf.ServiceEntry) string {
+	line := fileName(entry)
+	if size, ok := announcedSize(entry); ok {
+		line += " (" + units.Bytes(size) + ")"
+	}
+	user, err := getUserName(entry)
+	if err != nil {
+		return line
+	}
+	line += " from " + user
+	if host := strings.TrimSuffix(strings.TrimSuffix(entry.HostName, "."), ".local"); host != "" {
+		line += "@" + host
+	}
+	return line
+}
@@ -0,0 +1,41 @@
+package popcmd
+
+import (
+	"net/http"
+
+	"github.com/yifu/pushpop/pkg/hashcache"
+	"github.com/yifu/pushpop/pkg/netlimit"
+	"github.com/yifu/pushpop/pkg/prompt"
+	"github.com/yifu/pushpop/pkg/tempfile"
+	"github.com/yifu/pushpop/pkg/transfer"
+	"github.com/yifu/pushpop/pkg/version"
+)
+
+// transport carries the requests of pop to senders, through the proxy of
+// setupProxy and with the certificate pinned by pinCert.
+var transport = http.DefaultTransport.(*http.Transport).Clone()
+
+// client makes the requests of pop to senders, telling them the user pop
+// runs as and, with -code, the code of a private share.
+var client = &transfer.Client{
+	HTTP:      &http.Client{Transport: transport},
+	UserAgent: version.UserAgent("pop"),
+}
+
+// bufferSize is the -buffer-size flag, the size of the buffers downloads
+// are copied and hashed through.
+var bufferSize transfer.BufferSize
+
+// hashes is how files are hashed, set by -hash-xattr and -buffer-size.
+var hashes hashcache.Options
+
+// saving is how downloads are saved, set by -fsync.
+var saving tempfile.Options
+
+// prompts is how pop asks questions, set by -yes.
+var prompts prompt.Options
+
+// limits bounds the connections pop serves, of other receivers with
+// -swarm, of senders with -receive and of browsers with -listen, like
+// those of push.
+var limits = netlimit.New()
@@ -0,0 +1,60 @@
+package popcmd
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/transfer"
+)
+
+// maxSkew is how far the sender's clock may be from ours without a warning.
+var maxSkew = transfer.DefaultMaxSkew
+
+var skewOnce sync.Once
+
+// fetch sends req and checks the sender's clock against ours on the first
+// response. Durations pop reports are measured with the monotonic clock, so
+// skew only matters for the times the sender reports, such as modification
+// times.
+func fetch(req *http.Request) (*http.Response, error) {
+	sent := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		var rec tls.RecordHeaderError
+		if req.URL.Scheme == "https" && (errors.As(err, &rec) || strings.Contains(err.Error(), "HTTP response to HTTPS client")) {
+			// Senders from before push spoke TLS on its port.
+			return nil, fmt.Errorf("The sender does not speak TLS, use an http:// URL: %w", err)
+		}
+		var verify *tls.CertificateVerificationError
+		if errors.As(err, &verify) {
+			return nil, fmt.Errorf("Unable to check the sender's certificate, use the HTTPS URL push prints, which gives its fingerprint: %w", err)
+		}
+		return nil, err
+	}
+	recordExchange(req, resp, time.Since(sent))
+	skewOnce.Do(func() {
+		skew, ok := transfer.Skew(resp, sent)
+		if ok && (skew > maxSkew || skew < -maxSkew) {
+			slog.Warn("The sender's clock is off ours, use -max-skew to tolerate more", "skew", skew.Round(time.Second))
+		}
+	})
+	return resp, nil
+}
+
+// senderTime returns t, a time reported by the sender, unless it is in the
+// future by more than maxSkew, in which case it is replaced by now: a file
+// from the future confuses build tools and backups.
+func senderTime(t time.Time) time.Time {
+	now := time.Now()
+	if t.Sub(now) > maxSkew {
+		slog.Warn("The sender's modification time is in the future, using the current time", "ahead", t.Sub(now).Round(time.Second))
+		return now
+	}
+	return t
+}
@@ -0,0 +1,20 @@
+package popcmd
+
+import (
+	"os"
+	"os/exec"
+)
+
+// Command is the command line running pop, which watches and queues run
+// again for each download, and which resumes a canceled one: the pop
+// binary, or pushpop followed by "pop".
+var Command = []string{os.Args[0]}
+
+// arguments are the arguments Main was given.
+var arguments []string
+
+// command returns the command running another pop with args.
+func command(args ...string) *exec.Cmd {
+	prefix := Command[1:len(Command):len(Command)]
+	return exec.Command(Command[0], append(prefix, args...)...)
+}
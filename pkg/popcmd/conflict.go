@@ -0,0 +1,158 @@
+package popcmd
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/yifu/pushpop/pkg/prompt"
+)
+
+// policyFlag is a boolean flag standing for a value of another flag, like
+// -skip-existing for -on-exists=skip. Setting it sets the other flag, which
+// then counts as given.
+type policyFlag struct {
+	name, value string
+}
+
+func (f policyFlag) String() string {
+	if other := flag.Lookup(f.name); other != nil && other.Value.String() == f.value {
+		return "true"
+	}
+	return "false"
+}
+
+func (f policyFlag) Set(s string) error {
+	on, err := strconv.ParseBool(s)
+	if err != nil || !on {
+		return err
+	}
+	return flag.Set(f.name, f.value)
+}
+
+func (f policyFlag) IsBoolFlag() bool {
+	return true
+}
+
+// resolveExisting decides what to do when fn already exists, according to
+// policy: "overwrite", "skip", "rename", or "ask". It returns the path to
+// save the download to, and false when the download should be skipped.
+func resolveExisting(fn, policy string) (string, bool) {
+	if _, err := os.Stat(fn); err != nil {
+		return fn, true
+	}
+	switch policy {
+	case "overwrite":
+		return fn, true
+	case "skip":
+		return fn, false
+	case "rename":
+		return uniqueName(fn), true
+	case "ask":
+	default:
+		fatalCodef(exitUsage, "Invalid -on-exists value %q", policy)
+	}
+
+	renamed := uniqueName(fn)
+	sel, err := prompts.Choose(fmt.Sprintf("%s already exists.", fn), []string{"Overwrite", "Skip", "Save as " + filepath.Base(renamed)}, 0)
+	if err == prompt.ErrNotAsked {
+		log.Println(fn, "already exists, overwriting (use -on-exists to choose).")
+		return fn, true
+	}
+	if err != nil {
+		fatalPrompt(err)
+	}
+	switch sel {
+	case 1:
+		return fn, false
+	case 2:
+		return renamed, true
+	}
+	return fn, true
+}
+
+// numbered returns fn numbered n, "name (n).ext", as browsers name a
+// download saved next to one of the same name.
+func numbered(fn string, n int) string {
+	dir, name := filepath.Split(fn)
+	ext := filepath.Ext(name)
+	if ext == name {
+		// A dot file, like .profile.
+		ext = ""
+	}
+	base := strings.TrimSuffix(name, ext)
+	if strings.HasSuffix(base, ".tar") {
+		base, ext = strings.TrimSuffix(base, ".tar"), ".tar"+ext
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s (%d)%s", base, n, ext))
+}
+
+// uniqueName returns the first of the numbered names of fn that does not
+// exist. A .part file left by an earlier download to that name is resumed
+// as usual.
+func uniqueName(fn string) string {
+	for n := 1; ; n++ {
+		candidate := numbered(fn, n)
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
+
+// copies returns fn followed, when policy renames files rather than
+// overwriting them, by its numbered names that exist: the files an earlier
+// download of the same name may have been saved to.
+func copies(fn, policy string) []string {
+	fns := []string{fn}
+	if policy != "rename" {
+		return fns
+	}
+	for n := 1; ; n++ {
+		candidate := numbered(fn, n)
+		if _, err := os.Lstat(candidate); err != nil {
+			return fns
+		}
+		fns = append(fns, candidate)
+	}
+}
+
+// resolvePart decides what to do with a leftover .part file,
+// according to policy: "resume", "restart", or "ask". It returns true when
+// the .part file should be discarded.
+func resolvePart(part, policy string) bool {
+	if _, err := os.Stat(part); err != nil {
+		return false
+	}
+	switch policy {
+	case "resume":
+		return false
+	case "restart":
+		return true
+	case "ask":
+	default:
+		fatalCodef(exitUsage, "Invalid -on-part value %q", policy)
+	}
+
+	sel, err := prompts.Choose(fmt.Sprintf("%s is left from an interrupted download.", part), []string{"Resume", "Restart"}, 0)
+	if err == prompt.ErrNotAsked {
+		log.Println("Resuming", part, "(use -on-part to choose).")
+		return false
+	}
+	if err != nil {
+		fatalPrompt(err)
+	}
+	return sel == 1
+}
+
+// fatalPrompt ends pop when a prompt failed, as canceled when the user quit
+// it instead of answering.
+func fatalPrompt(err error) {
+	if err == prompt.ErrAborted {
+		fatalCodef(exitCanceled, "%v", err)
+	}
+	fatal(err)
+}
@@ -0,0 +1,63 @@
+package popcmd
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/debugbundle"
+	"github.com/yifu/pushpop/pkg/logging"
+)
+
+var (
+	// bundle collects the -debug-bundle, nil without it.
+	bundle     *debugbundle.Bundle
+	bundlePath string
+)
+
+// startBundle starts collecting a debug bundle to be saved at path: the log,
+// pop's messages, the time spent in each state and every exchange with the
+// sender.
+func startBundle(path string) {
+	bundle = debugbundle.New("pop")
+	bundlePath = path
+	logging.Tee(bundle.Writer("log.txt"))
+	msg = io.MultiWriter(msg, bundle.Writer("messages.txt"))
+	pipe.observe(func(from, to state, elapsed time.Duration) {
+		bundle.Printf("timings.txt", "%-10v %v", from, elapsed)
+	})
+}
+
+// recordExchange adds a request to the sender and its response to the
+// debug bundle.
+func recordExchange(req *http.Request, resp *http.Response, elapsed time.Duration) {
+	if bundle == nil {
+		return
+	}
+	var keys []string
+	for k := range resp.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var headers strings.Builder
+	for _, k := range keys {
+		headers.WriteString("  " + k + ": " + strings.Join(resp.Header[k], ", ") + "\n")
+	}
+	bundle.Printf("exchanges.txt", "%s %s (Range: %q) -> %s in %v\n%s", req.Method, req.URL, req.Header.Get("Range"), resp.Status, elapsed, headers.String())
+}
+
+// writeBundle saves the debug bundle, if one is being collected.
+func writeBundle() {
+	if bundle == nil {
+		return
+	}
+	err := bundle.Write(bundlePath)
+	if err != nil {
+		log.Println("Unable to save the debug bundle: ", err)
+		return
+	}
+	log.Println("Debug bundle saved to", bundlePath)
+}
@@ -0,0 +1,118 @@
+package popcmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/yifu/pushpop/pkg/delta"
+	"github.com/yifu/pushpop/pkg/transfer"
+	"github.com/yifu/pushpop/pkg/units"
+)
+
+// deltaMin is the smallest file worth downloading as a delta.
+const deltaMin = 1 << 20
+
+// hasOlder reports whether fn holds a file worth patching into the one
+// described by meta rather than downloading it whole.
+func hasOlder(fn string, meta transfer.Meta) bool {
+	fi, err := os.Stat(fn)
+	return err == nil && fi.Mode().IsRegular() && fi.Size() >= deltaMin && meta.Size >= deltaMin
+}
+
+// downloadDelta builds the meta.Size bytes of url into f from the blocks
+// of fn, the older version of the file, that are still in the sender's,
+// downloading only the others, then hashes the result. fn is left as is.
+func downloadDelta(f *os.File, url, fn string, meta transfer.Meta) (string, error) {
+	blocks, err := client.FetchBlocks(url)
+	if err != nil {
+		return "", err
+	}
+	if blocks.Size != meta.Size {
+		return "", fmt.Errorf("The block checksums describe %d bytes, not %d", blocks.Size, meta.Size)
+	}
+	local, err := os.Open(fn)
+	if err != nil {
+		return "", err
+	}
+	defer local.Close()
+	fi, err := local.Stat()
+	if err != nil {
+		return "", err
+	}
+	found, err := delta.Match(local, fi.Size(), blocks)
+	if err != nil {
+		return "", err
+	}
+
+	err = f.Truncate(meta.Size)
+	if err != nil {
+		fatal(err)
+	}
+	var reused, missing int64
+	buf := make([]byte, blocks.BlockSize)
+	for i, at := range found {
+		n := blocks.Len(i)
+		if at < 0 {
+			missing += n
+			continue
+		}
+		// The last block may match the end of fn padded with zeros.
+		read, err := local.ReadAt(buf[:n], at)
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		for j := read; j < int(n); j++ {
+			buf[j] = 0
+		}
+		_, err = f.WriteAt(buf[:n], int64(i)*int64(blocks.BlockSize))
+		if err != nil {
+			return "", err
+		}
+		reused += n
+	}
+	fmt.Fprintf(msg, "Reusing %s of %s, downloading %s.\n", units.Bytes(reused), fn, units.Bytes(missing))
+
+	pipe.enter(stateDownload)
+	emit(event{Event: eventStarted, Name: received.Name, Path: fn, Bytes: reused, Size: meta.Size})
+	prog := startProgress(received.Name, 0, missing)
+	ranges := missingRanges(found, blocks)
+	errs := make(chan error, len(ranges))
+	slots := make(chan struct{}, parallelStreams)
+	for _, rg := range ranges {
+		go func(start, end int64) {
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			errs <- downloadRange(f, url, start, end, prog)
+		}(rg[0], rg[1])
+	}
+	for range ranges {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	prog.finish()
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Downloaded %d ranges.", len(ranges))
+	return hashFile(meta.Algorithm, f.Name())
+}
+
+// missingRanges returns the start and end of the runs of blocks that were
+// not found, each downloaded with a single request.
+func missingRanges(found []int64, blocks delta.Blocks) [][2]int64 {
+	var ranges [][2]int64
+	for i := 0; i < len(found); i++ {
+		if found[i] >= 0 {
+			continue
+		}
+		start := int64(i) * int64(blocks.BlockSize)
+		for i+1 < len(found) && found[i+1] < 0 {
+			i++
+		}
+		ranges = append(ranges, [2]int64{start, int64(i)*int64(blocks.BlockSize) + blocks.Len(i)})
+	}
+	return ranges
+}
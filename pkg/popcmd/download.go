@@ -0,0 +1,323 @@
+package popcmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/hashing"
+	"github.com/yifu/pushpop/pkg/quarantine"
+	"github.com/yifu/pushpop/pkg/tempfile"
+	"github.com/yifu/pushpop/pkg/transfer"
+	"github.com/yifu/pushpop/pkg/units"
+)
+
+// Retries of interrupted downloads.
+const (
+	retryDelay    = time.Second
+	maxRetryDelay = 30 * time.Second
+)
+
+// retries is how many times an interrupted download is retried.
+var retries = 5
+
+// relocate, when set, finds the sender again and returns its new URL.
+var relocate func() (string, error)
+
+// resumeCheckSize is how much of the tail of an existing .part file is
+// compared against the sender before appending to it.
+const resumeCheckSize = 1 << 20
+
+// download fetches url into fn through fn's .part file. When a .part file is
+// already there, its last resumeCheckSize bytes are fetched again and compared
+// with the local copy; the download only continues from the end of the .part
+// file if they match. When fresh is set, any .part file is discarded first.
+// It returns what the sender told about the file and the checksum of what
+// was received, computed on the way with the sender's algorithm.
+//
+// Connection errors are retried up to retries times, waiting twice as long
+// each time and resuming from the .part file. Before each retry the sender
+// is looked up again, in case it came back with another address or port;
+// download also returns the URL it finished with.
+//
+// A new download may use another strategy than a single stream, see
+// pickStrategy. When it fails, download falls back to a stream.
+func download(url, fn string, fresh bool) (transfer.Meta, string, string) {
+	if _, err := os.Stat(tempfile.Part(fn)); fresh || err != nil {
+		if s, meta := pickStrategy(url, received.Name, fn); s != strategyStream {
+			meta, sum, err := downloadWith(s, url, fn, meta)
+			if err == nil {
+				return meta, sum, url
+			}
+			log.Printf("The %s download failed: %v. Downloading the file as is.", s, err)
+			fresh = true
+		}
+	}
+	pause.start(fn)
+	defer pause.stop()
+	delay := retryDelay
+	for attempt := 0; ; attempt++ {
+		meta, sum, err := downloadOnce(url, fn, fresh)
+		if err == nil {
+			return meta, sum, url
+		}
+		if err == errPaused {
+			pause.wait()
+		}
+		if pause.interrupted() == errCanceled {
+			abandon(fn)
+		}
+		if err == errPaused {
+			fmt.Fprintln(msg, "Resuming the download.")
+			emit(event{Event: eventResumed, Name: received.Name})
+			// A pause is not a failed attempt.
+			attempt--
+			fresh = false
+			continue
+		}
+		if attempt >= retries {
+			fatal("Download interrupted, keeping ", tempfile.Part(fn), ": ", err)
+		}
+		log.Printf("Download interrupted: %v. Retrying in %v.", err, delay)
+		time.Sleep(delay)
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+		fresh = false
+		if relocate != nil {
+			moved, err := relocate()
+			if err != nil {
+				log.Println("Unable to find the sender again, retrying the same address: ", err)
+			} else {
+				url = moved
+			}
+		}
+	}
+}
+
+// downloadOnce makes one attempt at download. It returns the errors worth
+// retrying.
+func downloadOnce(url, fn string, fresh bool) (transfer.Meta, string, error) {
+	part := tempfile.Part(fn)
+	slog.Debug("Opening", "path", part)
+	f, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		fatal(err)
+	}
+	defer f.Close()
+	if fresh {
+		err = f.Truncate(0)
+		if err != nil {
+			fatal(err)
+		}
+		removeHashState(part)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		fatal(err)
+	}
+	offset := fi.Size()
+	check := offset
+	if check > resumeCheckSize {
+		check = resumeCheckSize
+	}
+
+	req := newRequest(url)
+	if offset > 0 && resumable(url) {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset-check))
+	}
+	resp, err := fetch(req)
+	if err != nil {
+		return transfer.Meta{}, "", err
+	}
+	defer resp.Body.Close()
+	defer pause.watch(resp.Body)()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if offset > 0 {
+			log.Println("Sender does not support resume, restarting from scratch.")
+		}
+		offset = 0
+	case http.StatusPartialContent:
+		diff, err := matchTail(f, resp.Body, offset-check, check)
+		if err != nil {
+			return transfer.Meta{}, "", fmt.Errorf("Unable to validate %s: %v", part, err)
+		}
+		if diff != nil {
+			log.Println("The end of", part, "does not match the sender, restarting from scratch.")
+			resp.Body.Close()
+			diff.URL = url
+			return restart(f, url, fn, diff)
+		}
+		log.Println("Resuming", part, "at offset", offset)
+	case http.StatusRequestedRangeNotSatisfiable:
+		log.Println(part, "is larger than the sender's file, restarting from scratch.")
+		resp.Body.Close()
+		return restart(f, url, fn, &quarantine.Report{
+			URL:    url,
+			Reason: "larger than the sender's file, " + resp.Header.Get("Content-Range"),
+		})
+	default:
+		fatal("Unexpected status: ", resp.Status)
+	}
+
+	meta, err := senderMeta(url, resp)
+	if err != nil {
+		fatal(err)
+	}
+	if err := checkSpace(fn, meta.Size-offset); meta.Size >= 0 && err != nil {
+		if offset == 0 {
+			// Nothing was downloaded, leave no .part file behind.
+			f.Close()
+			os.Remove(part)
+		}
+		fatalCodef(exitNoSpace, "%v", err)
+	}
+
+	pipe.enter(stateDownload)
+	emit(event{Event: eventStarted, Name: received.Name, Path: fn, Bytes: offset, Size: meta.Size})
+	err = f.Truncate(offset)
+	if err != nil {
+		fatal(err)
+	}
+	if err := preallocate(f, meta.Size); err != nil {
+		if offset == 0 {
+			f.Close()
+			os.Remove(part)
+		}
+		fatalCodef(exitNoSpace, "%v", err)
+	}
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		fatal(err)
+	}
+	h, err := resumeHash(f, part, meta.Algorithm, offset)
+	if err != nil {
+		fatal("Unable to hash ", part, ": ", err)
+	}
+	var body io.Reader = resp.Body
+	if transfer.Following(resp) {
+		// A file still being written may not grow for a while.
+		fmt.Fprintln(msg, "The sender is still writing the file, following it until it is complete.")
+	} else {
+		var stop func()
+		body, stop = guardStall(resp.Body)
+		defer stop()
+	}
+	prog := startProgress(received.Name, offset, meta.Size)
+	n, err := bufferSize.Copy(io.MultiWriter(f, h), prog.reader(body), linkRate)
+	prog.finish()
+	if err != nil {
+		saveHashState(part, meta.Algorithm, h, offset+n)
+		if pause.interrupted() == errCanceled {
+			return transfer.Meta{}, "", errCanceled
+		}
+		if pause.interrupted() == errPaused {
+			at := units.Bytes(offset + n)
+			if meta.Size >= 0 {
+				at += " of " + units.Bytes(meta.Size)
+			}
+			fmt.Fprintf(msg, "Paused at %s, press %c to resume.\n", at, pauseKey)
+			emit(event{Event: eventPaused, Name: received.Name, Bytes: offset + n, Size: meta.Size})
+			return transfer.Meta{}, "", errPaused
+		}
+		return transfer.Meta{}, "", err
+	}
+	removeHashState(part)
+	err = f.Close()
+	if err != nil {
+		fatal(err)
+	}
+	checkSigned(url, part, meta.Algorithm, hashing.Hex(h))
+	pipe.enter(stateRename)
+	err = saving.Finalize(part, fn)
+	if err != nil {
+		fatal(err)
+	}
+	return meta, hashing.Hex(h), nil
+}
+
+// preserve applies the sender's modification time and permissions to fn.
+func preserve(fn string, meta transfer.Meta) {
+	if meta.Mode != 0 {
+		err := os.Chmod(fn, meta.Mode)
+		if err != nil {
+			log.Println("Unable to set permissions: ", err)
+		}
+	}
+	if !meta.Mtime.IsZero() {
+		mtime := senderTime(meta.Mtime)
+		err := os.Chtimes(fn, mtime, mtime)
+		if err != nil {
+			log.Println("Unable to set modification time: ", err)
+		}
+	}
+}
+
+// headMeta asks the sender at url about its file without downloading it.
+func headMeta(url string) (transfer.Meta, error) {
+	req := newRequest(url)
+	req.Method = http.MethodHead
+	resp, err := fetch(req)
+	if err != nil {
+		return transfer.Meta{}, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return transfer.Meta{}, fmt.Errorf("Unexpected status: %s", resp.Status)
+	}
+	return senderMeta(url, resp)
+}
+
+// newRequest returns a GET request for url identifying pop to the sender.
+func newRequest(url string) *http.Request {
+	req, err := client.NewRequest(url)
+	if err != nil {
+		fatal(err)
+	}
+	return req
+}
+
+// restart quarantines the .part file, which failed validation as told by
+// report, and downloads the file again from the start. A .part file that
+// cannot be moved is emptied instead.
+func restart(f *os.File, url, fn string, report *quarantine.Report) (transfer.Meta, string, error) {
+	part := tempfile.Part(fn)
+	f.Close()
+	removeHashState(part)
+	if !quarantineFile(part, *report) {
+		err := os.Truncate(part, 0)
+		if err != nil {
+			fatal(err)
+		}
+	}
+	return downloadOnce(url, fn, false)
+}
+
+// matchTail reads n bytes from r and compares them with the n bytes of f
+// starting at offset. It returns what differs, or nil when they match.
+func matchTail(f *os.File, r io.Reader, offset, n int64) (*quarantine.Report, error) {
+	local := make([]byte, n)
+	_, err := f.ReadAt(local, offset)
+	if err != nil {
+		return nil, err
+	}
+	remote := make([]byte, n)
+	_, err = io.ReadFull(r, remote)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(local, remote) {
+		return nil, nil
+	}
+	diff := diffReport("the end of the .part file does not match the sender", offset, local, remote)
+	return &diff, nil
+}
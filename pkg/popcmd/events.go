@@ -0,0 +1,108 @@
+package popcmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/logging"
+)
+
+// event is a line of pop's -json output.
+type event struct {
+	Event     string    `json:"event"`
+	Time      time.Time `json:"time"`
+	User      string    `json:"user,omitempty"`
+	Addr      string    `json:"addr,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	Bytes     int64     `json:"bytes,omitempty"`
+	Size      int64     `json:"size,omitempty"`
+	Percent   *float64  `json:"percent,omitempty"`
+	Algorithm string    `json:"algorithm,omitempty"`
+	Sum       string    `json:"sum,omitempty"`
+	Message   string    `json:"message,omitempty"`
+}
+
+// Events pop emits.
+const (
+	eventDiscovered = "discovered"
+	eventStarted    = "started"
+	eventProgress   = "progress"
+	eventStalled    = "stalled"
+	eventPaused     = "paused"
+	eventResumed    = "resumed"
+	eventCanceled   = "canceled"
+	eventVerifying  = "verifying"
+	eventDone       = "done"
+	eventSkipped    = "skipped"
+	eventError      = "error"
+)
+
+// progressInterval is the time between two progress events.
+const progressInterval = 250 * time.Millisecond
+
+var (
+	eventsMu sync.Mutex
+	// events receives -json events, nil when they are disabled.
+	events *json.Encoder
+)
+
+// emit writes e to the -json output, if enabled.
+func emit(e event) {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	if events == nil {
+		return
+	}
+	e.Time = time.Now()
+	events.Encode(e)
+}
+
+// Exit statuses of pop besides 0, which scripts may rely on. A file
+// skipped, or already up to date, counts as a success.
+const (
+	// exitFailed is for a failed download, or anything else going wrong.
+	exitFailed = 1
+	// exitUsage is for invalid flags or arguments, as with the flag package.
+	exitUsage = 2
+	// exitMismatch is for a file that does not match the sender's checksum.
+	exitMismatch = 3
+	// exitNoSpace is for a download that does not fit on the disk.
+	exitNoSpace = 4
+	// exitCanceled is for a download canceled with q, Ctrl-C or SIGTERM.
+	exitCanceled = 130
+)
+
+// fatal is log.Fatal, logging at the error level so that no -log-level
+// hides it, and also emitting an error event.
+func fatal(v ...interface{}) {
+	fatalMessage(exitFailed, fmt.Sprint(v...))
+}
+
+// fatalf is log.Fatalf, also emitting an error event.
+func fatalf(format string, v ...interface{}) {
+	fatalMessage(exitFailed, fmt.Sprintf(format, v...))
+}
+
+// fatalCodef is fatalf, exiting with code.
+func fatalCodef(code int, format string, v ...interface{}) {
+	fatalMessage(code, fmt.Sprintf(format, v...))
+}
+
+func fatalMessage(code int, s string) {
+	pause.stop()
+	stopProgress()
+	emit(event{Event: eventError, Message: s})
+	logging.Error(s)
+	if received.Name != "" && received.Result == "" {
+		// A transfer was under way and is not recorded yet.
+		received.Duration = time.Since(received.Time)
+		received.Result = s
+		notifier.Transfer(received)
+	}
+	writeBundle()
+	os.Exit(code)
+}
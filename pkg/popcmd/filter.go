@@ -0,0 +1,64 @@
+package popcmd
+
+import (
+	"log"
+	"strconv"
+
+	"github.com/grandcat/zeroconf"
+	"github.com/yifu/pushpop/pkg/discovery"
+	"github.com/yifu/pushpop/pkg/filter"
+	"github.com/yifu/pushpop/pkg/hashing"
+	"github.com/yifu/pushpop/pkg/transfer"
+)
+
+// filterFields are the fields -filter expressions may use.
+var filterFields = []string{"user", "name", "instance", "gen", "host", "hash", "size"}
+
+// shareFields returns the lookup of the fields of the share announced by
+// entry for -filter. The size of shares that do not announce it is only
+// known from their manifest, fetched when the expression needs it.
+func shareFields(entry *zeroconf.ServiceEntry, iface string) filter.Lookup {
+	return func(field string) (string, bool) {
+		switch field {
+		case "user":
+			user, err := getUserName(entry)
+			return user, err == nil
+		case "name":
+			return fileName(entry), true
+		case "instance":
+			return discovery.Unescape(entry.Instance), true
+		case "gen":
+			return strconv.Itoa(generation(entry)), true
+		case "host":
+			return entry.HostName, true
+		case "hash":
+			return txtValue(entry, "hash"), true
+		case "size":
+			return shareSize(entry, iface)
+		}
+		return "", false
+	}
+}
+
+// shareSize returns the size of the file shared by entry, as announced, or
+// from its manifest for senders that do not announce it.
+func shareSize(entry *zeroconf.ServiceEntry, iface string) (string, bool) {
+	if size, ok := announcedSize(entry); ok {
+		return strconv.FormatInt(size, 10), true
+	}
+	sum := txtValue(entry, transfer.ManifestKey)
+	alg, err := hashing.Lookup(txtValue(entry, "hash"))
+	if sum == "" || err != nil {
+		return "", false
+	}
+	url, _, err := entryURL(entry, iface)
+	if err != nil {
+		return "", false
+	}
+	m, err := client.FetchManifest(url, sum, alg)
+	if err != nil {
+		log.Println("Unable to fetch the manifest for -filter: ", err)
+		return "", false
+	}
+	return strconv.FormatInt(m.Size, 10), true
+}
@@ -0,0 +1,60 @@
+package popcmd
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+	"github.com/yifu/pushpop/pkg/discovery"
+	"github.com/yifu/pushpop/pkg/transfer"
+)
+
+// settleTime is how long pop waits, after finding a share, for a newer
+// generation of the same file.
+const settleTime = 500 * time.Millisecond
+
+// fileName returns the name of the file announced by entry.
+func fileName(entry *zeroconf.ServiceEntry) string {
+	if name := txtValue(entry, transfer.NameKey); name != "" {
+		return name
+	}
+	return discovery.Unescape(entry.Instance)
+}
+
+// generation returns the generation of the share announced by entry, 0 for
+// senders that do not count them.
+func generation(entry *zeroconf.ServiceEntry) int {
+	gen, err := strconv.Atoi(txtValue(entry, transfer.GenerationKey))
+	if err != nil {
+		return 0
+	}
+	return gen
+}
+
+// newest returns the newest generation of the file announced by first,
+// among the entries of results matching match within settleTime. Shares
+// without a generation are returned at once.
+func newest(first *zeroconf.ServiceEntry, results <-chan *zeroconf.ServiceEntry, match func(*zeroconf.ServiceEntry) bool) *zeroconf.ServiceEntry {
+	if generation(first) == 0 {
+		return first
+	}
+	best := first
+	deadline := time.After(settleTime)
+	for {
+		select {
+		case entry, ok := <-results:
+			if !ok {
+				return best
+			}
+			if match(entry) && fileName(entry) == fileName(best) && generation(entry) > generation(best) {
+				best = entry
+			}
+		case <-deadline:
+			if best != first {
+				log.Printf("Picked generation %d of %s.", generation(best), fileName(best))
+			}
+			return best
+		}
+	}
+}
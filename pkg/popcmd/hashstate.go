@@ -0,0 +1,75 @@
+package popcmd
+
+import (
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+
+	"github.com/yifu/pushpop/pkg/hashing"
+)
+
+// hashStatePath returns where the hasher state of the .part file part is
+// saved when its download is interrupted.
+func hashStatePath(part string) string {
+	return part + ".hash"
+}
+
+// saveHashState saves the state of h, fed with the first offset bytes of
+// part, when its algorithm allows it.
+func saveHashState(part string, a hashing.Algorithm, h hash.Hash, offset int64) {
+	state, ok := hashing.State(h)
+	if !ok {
+		return
+	}
+	data := fmt.Sprintf("%s %d %s\n", a.Name(), offset, hex.EncodeToString(state))
+	err := os.WriteFile(hashStatePath(part), []byte(data), 0644)
+	if err != nil {
+		log.Println("Unable to save the hash state: ", err)
+	}
+}
+
+// loadHashState restores into h the state saved for the first offset bytes
+// of part. It returns false when there is no such state.
+func loadHashState(part string, a hashing.Algorithm, h hash.Hash, offset int64) bool {
+	data, err := os.ReadFile(hashStatePath(part))
+	if err != nil {
+		return false
+	}
+	var name, state string
+	var at int64
+	_, err = fmt.Sscan(string(data), &name, &at, &state)
+	if err != nil || name != a.Name() || at != offset {
+		return false
+	}
+	raw, err := hex.DecodeString(state)
+	if err != nil {
+		return false
+	}
+	return hashing.Restore(h, raw) == nil
+}
+
+// removeHashState removes the saved state of part, if any.
+func removeHashState(part string) {
+	os.Remove(hashStatePath(part))
+}
+
+// resumeHash returns a hasher fed with the first offset bytes of the .part
+// file f: restored from the state saved when its download was interrupted if
+// possible, by reading them again otherwise.
+func resumeHash(f *os.File, part string, a hashing.Algorithm, offset int64) (hash.Hash, error) {
+	h := a.New()
+	if offset == 0 || loadHashState(part, a, h, offset) {
+		return h, nil
+	}
+	buf := make([]byte, hashing.DefaultBufferSize)
+	if bufferSize > 0 {
+		buf = make([]byte, bufferSize)
+	}
+	prog := startProgress("Hashing "+part, 0, offset)
+	_, err := io.CopyBuffer(h, prog.reader(io.NewSectionReader(f, 0, offset)), buf)
+	prog.finish()
+	return h, err
+}
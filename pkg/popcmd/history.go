@@ -0,0 +1,28 @@
+package popcmd
+
+import (
+	"log"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/history"
+	"github.com/yifu/pushpop/pkg/notify"
+)
+
+// received collects what pop learns about the download as it goes, for the
+// history.
+var received = history.Entry{Direction: history.Receive}
+
+// notifier tells about the download when it ends, set by -notify and
+// -webhook.
+var notifier notify.Options
+
+// recordReceive adds the download to the history with the given result.
+func recordReceive(result string) {
+	received.Duration = time.Since(received.Time)
+	received.Result = result
+	err := history.Append(received)
+	if err != nil {
+		log.Println("Unable to record history: ", err)
+	}
+	notifier.Transfer(received)
+}
@@ -0,0 +1,79 @@
+package popcmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// What to do with a file once it was received and verified, set by -exec
+// and -open.
+var (
+	execCommand string
+	openFile    bool
+)
+
+// afterReceive runs -exec and -open on fn, a file that was received and
+// verified.
+func afterReceive(fn string) {
+	if execCommand != "" {
+		err := runExec(execCommand, fn)
+		if err != nil {
+			fatalf("-exec %q failed: %v", execCommand, err)
+		}
+	}
+	if openFile {
+		err := launch(fn)
+		if err != nil {
+			fatal("Unable to open ", fn, ": ", err)
+		}
+	}
+}
+
+// runExec runs command with the shell, {} standing for fn, quoted. Without
+// {}, fn is added at the end.
+func runExec(command, fn string) error {
+	quoted := shellQuote(fn)
+	if strings.Contains(command, "{}") {
+		command = strings.ReplaceAll(command, "{}", quoted)
+	} else {
+		command += " " + quoted
+	}
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("/bin/sh", "-c", command)
+	}
+	cmd.Stdout = msg
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// shellQuote quotes s for the shell runExec uses.
+func shellQuote(s string) string {
+	if runtime.GOOS == "windows" {
+		return `"` + s + `"`
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// launch opens fn with the application the desktop associates with it.
+func launch(fn string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", fn)
+	case "windows":
+		cmd = exec.Command("cmd", "/C", "start", "", fn)
+	default:
+		cmd = exec.Command("xdg-open", fn)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil && len(out) > 0 {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return err
+}
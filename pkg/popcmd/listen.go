@@ -0,0 +1,436 @@
+package popcmd
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/hashing"
+	"github.com/yifu/pushpop/pkg/history"
+	"github.com/yifu/pushpop/pkg/quarantine"
+	"github.com/yifu/pushpop/pkg/safename"
+	"github.com/yifu/pushpop/pkg/tempfile"
+	"github.com/yifu/pushpop/pkg/transfer"
+	"github.com/yifu/pushpop/pkg/units"
+	"github.com/yifu/pushpop/pkg/version"
+)
+
+// inbox receives the files anyone on the network uploads with a browser,
+// or with curl -T, into a directory. Each upload is written to a .part file
+// renamed once complete and, when the client sent a checksum, verified.
+type inbox struct {
+	dir string
+	// onExists is the -on-exists policy for uploads named like a file that
+	// is there already. Nobody can be asked, so "ask" refuses them as
+	// "skip" does.
+	onExists string
+	// maxSize is the largest upload accepted, 0 for no limit.
+	maxSize int64
+
+	mu sync.Mutex
+	// busy holds the names being uploaded, so that two uploads of the same
+	// name do not write the same .part file.
+	busy map[string]bool
+}
+
+// maxUpload is the -max-upload flag, the size of the largest upload
+// accepted by pop -listen, whose page anyone on the network can upload to.
+var maxUpload = sizeFlag(16 << 30)
+
+// sizeFlag is a flag taking a size with an optional unit, such as 2GiB.
+type sizeFlag int64
+
+func (f *sizeFlag) String() string {
+	if f == nil || *f == 0 {
+		return ""
+	}
+	return units.Bytes(int64(*f))
+}
+
+func (f *sizeFlag) Set(value string) error {
+	size, err := units.ParseSize(value)
+	if err != nil {
+		return err
+	}
+	if size < 0 {
+		return fmt.Errorf("Invalid size %q", value)
+	}
+	*f = sizeFlag(size)
+	return nil
+}
+
+// listen serves the upload page on addr, saving uploads to dir, until
+// interrupted. Connections are bounded by the default limits of netlimit,
+// like those of push.
+func listen(addr, dir, onExists string) {
+	switch onExists {
+	case "ask", "skip", "overwrite", "rename":
+	default:
+		fatalCodef(exitUsage, "Invalid -on-exists value %q", onExists)
+	}
+	if dir == "" {
+		// Not the current directory, which may hold anything anyone could
+		// then overwrite or add to.
+		data, err := history.Dir()
+		if err != nil {
+			fatal(err)
+		}
+		dir = filepath.Join(data, "inbox")
+	}
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		fatal(err)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	host := "localhost"
+	if ip := lanIPv4(); ip != nil {
+		host = ip.String()
+	}
+	fmt.Fprintf(msg, "Saving uploads to %s, upload page at http://%s/\n", dir, net.JoinHostPort(host, strconv.Itoa(port)))
+	in := &inbox{dir: dir, onExists: onExists, maxSize: int64(maxUpload), busy: map[string]bool{}}
+	fatal(limits.Server(in).Serve(limits.Listener(ln)))
+}
+
+func (in *inbox) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("Request", "method", r.Method, "path", r.URL.Path, "addr", r.RemoteAddr, "agent", r.UserAgent())
+	if in.maxSize > 0 {
+		if r.ContentLength > in.maxSize {
+			http.Error(w, "uploads are limited to "+units.Bytes(in.maxSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, in.maxSize)
+	}
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/":
+		serveUploadPage(w, nil)
+	case r.Method == http.MethodPost && r.URL.Path == "/":
+		in.serveForm(w, r)
+	case r.Method == http.MethodPut:
+		name, err := uploadName(r.URL.Path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		meta, err := transfer.ParseMetaHeader(r.Header)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if meta.Size < 0 {
+			meta.Size = r.ContentLength
+		}
+		saved, sum, status, err := in.save(r, name, meta, r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.Header().Set(transfer.HashHeader(meta.Algorithm), sum)
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintln(w, "Saved", saved)
+	default:
+		w.Header().Set("Allow", "GET, POST, PUT")
+		http.Error(w, "only GET, POST and PUT are accepted", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveForm saves the files of a multipart form, as browsers without
+// JavaScript send them.
+func (in *inbox) serveForm(w http.ResponseWriter, r *http.Request) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var results []string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			results = append(results, "Upload interrupted: "+err.Error())
+			break
+		}
+		if part.FileName() == "" {
+			continue
+		}
+		name, err := uploadName(part.FileName())
+		if err != nil {
+			results = append(results, err.Error())
+			continue
+		}
+		meta := transfer.Meta{Size: -1, Algorithm: hashing.Default}
+		saved, _, _, err := in.save(r, name, meta, part)
+		if err != nil {
+			results = append(results, name+": "+err.Error())
+			continue
+		}
+		results = append(results, "Saved "+saved)
+	}
+	serveUploadPage(w, results)
+}
+
+// uploadName returns the name to save an upload called name as, refusing
+// hidden files such as .bashrc or .pushpopignore: nobody should get to
+// drop those by uploading.
+func uploadName(name string) (string, error) {
+	name, err := safename.Name(name)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("Invalid file name %q: hidden files are refused", name)
+	}
+	return name, nil
+}
+
+// save writes body, the file described by meta, to dir as name, or the
+// name onExists leads to, and returns that name and the file's checksum, or
+// the status to answer and why the upload failed.
+func (in *inbox) save(r *http.Request, name string, meta transfer.Meta, body io.Reader) (string, string, int, error) {
+	name, err := in.reserve(name)
+	if err != nil {
+		return "", "", http.StatusConflict, err
+	}
+	fn := filepath.Join(in.dir, name)
+	defer func() {
+		in.mu.Lock()
+		delete(in.busy, name)
+		in.mu.Unlock()
+	}()
+
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		addr = r.RemoteAddr
+	}
+	e := history.Entry{
+		Time:      time.Now(),
+		Direction: history.Receive,
+		Addr:      addr,
+		Agent:     version.Peer(r.UserAgent()),
+		Name:      name,
+		Path:      fn,
+		Algorithm: meta.Algorithm.Name(),
+	}
+	sum, status, err := in.write(fn, meta, body, &e)
+	e.Duration = time.Since(e.Time)
+	e.Result = history.ResultOK
+	if err != nil {
+		e.Result = err.Error()
+		log.Println("Upload of", name, "failed: ", err)
+	} else {
+		fmt.Fprintf(msg, "Received %s (%s) from %s\n", fn, units.Bytes(e.Size), addr)
+	}
+	if herr := history.Append(e); herr != nil {
+		log.Println("Unable to record history: ", herr)
+	}
+	return name, sum, status, err
+}
+
+// reserve returns the name to save an upload of name as, according to
+// onExists, and marks it busy until the upload is over.
+func (in *inbox) reserve(name string) (string, error) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	exists := func(name string) bool {
+		_, err := os.Lstat(filepath.Join(in.dir, name))
+		return err == nil
+	}
+	switch {
+	case in.busy[name] && in.onExists != "rename":
+		return "", fmt.Errorf("%s is already being uploaded", name)
+	case in.onExists == "rename" && (in.busy[name] || exists(name)):
+		orig := name
+		for n := 1; in.busy[name] || exists(name); n++ {
+			name = numbered(orig, n)
+		}
+	case exists(name) && in.onExists != "overwrite":
+		return "", fmt.Errorf("%s already exists", name)
+	}
+	in.busy[name] = true
+	return name, nil
+}
+
+// write does the work of save, filling in the size and checksum of e.
+func (in *inbox) write(fn string, meta transfer.Meta, body io.Reader, e *history.Entry) (string, int, error) {
+	if err := spaceError(fn, meta.Size); err != nil && !ignoreSpace {
+		return "", http.StatusInsufficientStorage, err
+	}
+	part := tempfile.Part(fn)
+	f, err := os.OpenFile(part, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+	if err := preallocate(f, meta.Size); err != nil {
+		f.Close()
+		os.Remove(part)
+		return "", http.StatusInsufficientStorage, err
+	}
+	h := meta.Algorithm.New()
+	n, err := bufferSize.Copy(io.MultiWriter(f, h), body, 0)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	e.Size = n
+	if err == nil && meta.Size >= 0 && n != meta.Size {
+		err = fmt.Errorf("Received %d bytes out of %d", n, meta.Size)
+	}
+	if err != nil {
+		os.Remove(part)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return "", http.StatusRequestEntityTooLarge, fmt.Errorf("Uploads are limited to %s", units.Bytes(tooLarge.Limit))
+		}
+		return "", http.StatusBadRequest, err
+	}
+	sum := hashing.Hex(h)
+	e.Sum = sum
+	if meta.Sum != "" && sum != meta.Sum {
+		report := quarantine.Report{
+			Reason:    "checksum mismatch",
+			Algorithm: meta.Algorithm.Name(),
+			Expected:  meta.Sum,
+			Actual:    sum,
+		}
+		if !quarantineFile(part, report) {
+			os.Remove(part)
+		}
+		return "", http.StatusUnprocessableEntity, fmt.Errorf("checksum mismatch, expected %s, got %s", meta.Sum, sum)
+	}
+	err = saving.Finalize(part, fn)
+	if err != nil {
+		os.Remove(part)
+		return "", http.StatusInternalServerError, err
+	}
+	if meta.Sum != "" {
+		log.Println("Verified", fn, meta.Algorithm.Name(), sum)
+	}
+	return sum, http.StatusCreated, nil
+}
+
+// lanIPv4 returns an IPv4 address of the machine other machines of the LAN
+// may reach, or nil.
+func lanIPv4() net.IP {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil && !ipnet.IP.IsLinkLocalUnicast() {
+				return ipnet.IP.To4()
+			}
+		}
+	}
+	return nil
+}
+
+func serveUploadPage(w http.ResponseWriter, results []string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := uploadTemplate.Execute(w, results)
+	if err != nil {
+		log.Println("Unable to render upload page: ", err)
+	}
+}
+
+// uploadTemplate is the upload page. Files dropped on it, or picked, are
+// sent one by one with PUT, along with their SHA-256 checksum where the
+// browser can compute it; the form is the fallback without JavaScript.
+var uploadTemplate = template.Must(template.New("upload").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Send files - pushpop</title>
+<style>
+body { font-family: sans-serif; max-width: 40em; margin: 2em auto; padding: 0 1em; }
+#drop { border: .2em dashed #999; border-radius: .5em; padding: 3em 1em; text-align: center; }
+#drop.over { border-color: #2a6ad3; background: #eef3fc; }
+progress { width: 100%; }
+</style>
+</head>
+<body>
+<h1>Send files</h1>
+<form id="form" method="post" enctype="multipart/form-data">
+<div id="drop">
+<p>Drop files here, or pick them:</p>
+<input type="file" name="file" multiple>
+<noscript><p><button type="submit">Send</button></p></noscript>
+</div>
+</form>
+<ul id="results">{{range .}}<li>{{.}}</li>{{end}}</ul>
+<p>Received with <a href="https://github.com/yifu/pushpop">pushpop</a>.</p>
+<script>
+const maxHashed = 256 << 20;
+const drop = document.getElementById("drop");
+const input = document.querySelector("input[type=file]");
+const results = document.getElementById("results");
+
+function hex(buf) {
+	return Array.from(new Uint8Array(buf), b => b.toString(16).padStart(2, "0")).join("");
+}
+
+async function upload(file) {
+	const li = document.createElement("li");
+	const bar = document.createElement("progress");
+	li.textContent = file.name + " ";
+	li.appendChild(bar);
+	results.appendChild(li);
+	const headers = {};
+	if (window.crypto && crypto.subtle && file.size <= maxHashed) {
+		headers["X-PushPop-Hash-Algorithm"] = "sha256";
+		headers["X-PushPop-Sha256"] = hex(await crypto.subtle.digest("SHA-256", await file.arrayBuffer()));
+	}
+	await new Promise(done => {
+		const xhr = new XMLHttpRequest();
+		xhr.open("PUT", "/" + encodeURIComponent(file.name));
+		for (const h in headers) {
+			xhr.setRequestHeader(h, headers[h]);
+		}
+		xhr.upload.onprogress = e => { bar.max = e.total; bar.value = e.loaded; };
+		xhr.onload = () => { li.textContent = xhr.status == 201 ? xhr.responseText : file.name + ": " + xhr.responseText; done(); };
+		xhr.onerror = () => { li.textContent = file.name + ": upload failed"; done(); };
+		xhr.send(file);
+	});
+}
+
+async function uploadAll(files) {
+	for (const file of files) {
+		await upload(file);
+	}
+}
+
+drop.addEventListener("dragover", e => { e.preventDefault(); drop.classList.add("over"); });
+drop.addEventListener("dragleave", () => drop.classList.remove("over"));
+drop.addEventListener("drop", e => {
+	e.preventDefault();
+	drop.classList.remove("over");
+	uploadAll(e.dataTransfer.files);
+});
+input.addEventListener("change", () => { uploadAll(input.files); input.value = ""; });
+</script>
+</body>
+</html>
+`))
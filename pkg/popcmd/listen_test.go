@@ -0,0 +1,66 @@
+package popcmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInboxMaxSize(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	dir := t.TempDir()
+	in := &inbox{dir: dir, onExists: "skip", maxSize: 10, busy: map[string]bool{}}
+	for _, tt := range []struct {
+		name, body string
+		chunked    bool
+		want       int
+	}{
+		{"small", "0123456789", false, http.StatusCreated},
+		{"declared", "0123456789a", false, http.StatusRequestEntityTooLarge},
+		{"chunked", "0123456789a", true, http.StatusRequestEntityTooLarge},
+	} {
+		r := httptest.NewRequest(http.MethodPut, "/"+tt.name, strings.NewReader(tt.body))
+		if tt.chunked {
+			r.ContentLength = -1
+		}
+		w := httptest.NewRecorder()
+		in.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: got %d, want %d: %s", tt.name, w.Code, tt.want, w.Body)
+		}
+		_, err := os.Stat(filepath.Join(dir, tt.name))
+		if saved := err == nil; saved != (tt.want == http.StatusCreated) {
+			t.Errorf("%s: saved = %v", tt.name, saved)
+		}
+	}
+}
+
+func TestInboxHidden(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	dir := t.TempDir()
+	in := &inbox{dir: dir, onExists: "overwrite", busy: map[string]bool{}}
+	for _, tt := range []struct {
+		path string
+		want int
+	}{
+		{"/notes.txt", http.StatusCreated},
+		{"/.bashrc", http.StatusBadRequest},
+		{"/.pushpopignore", http.StatusBadRequest},
+		{"/sub/.profile", http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		in.ServeHTTP(w, httptest.NewRequest(http.MethodPut, tt.path, strings.NewReader("x")))
+		if w.Code != tt.want {
+			t.Errorf("%s: got %d, want %d: %s", tt.path, w.Code, tt.want, w.Body)
+		}
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			t.Errorf("%s was saved", e.Name())
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package popcmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+	"github.com/yifu/pushpop/pkg/discovery"
+)
+
+// locateTimeout bounds how long locate waits for the sender to answer.
+const locateTimeout = 5 * time.Second
+
+// entryURL returns the URL of the file announced by entry and the sender's
+// IP address, reached through the interface called iface when it is not
+// empty.
+func entryURL(entry *zeroconf.ServiceEntry, iface string) (string, string, error) {
+	ip, err := pickIP(entry, iface)
+	if err != nil {
+		return "", "", err
+	}
+	port := strconv.Itoa(entry.Port)
+	return fmt.Sprintf("http://%s/", net.JoinHostPort(ip, port)), ip, nil
+}
+
+// locate looks up the share called instance by username over mDNS and
+// returns its URL. An empty username matches private shares, which have
+// none.
+func locate(username, instance, iface string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), locateTimeout)
+	defer cancel()
+	entries, err := discovery.Lookup(ctx, instance)
+	if err != nil {
+		return "", err
+	}
+	for entry := range entries {
+		user, err := getUserName(entry)
+		if username != "" && (err != nil || user != username) {
+			continue
+		}
+		url, _, err := entryURL(entry, iface)
+		return url, err
+	}
+	return "", fmt.Errorf("%s is no longer shared by %s", instance, username)
+}
+
+// txtValue returns the value of key in the TXT record of entry, or "". A
+// nil entry, for a share reached with -url, has no TXT record.
+func txtValue(entry *zeroconf.ServiceEntry, key string) string {
+	if entry == nil {
+		return ""
+	}
+	for _, kv := range entry.Text {
+		if strings.HasPrefix(kv, key+"=") {
+			return kv[len(key)+1:]
+		}
+	}
+	return ""
+}
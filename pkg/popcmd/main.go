@@ -0,0 +1,602 @@
+// Package popcmd is the pop command, which the pop binary runs, and
+// pushpop pop.
+package popcmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"context"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"io"
+	"os"
+	"github.com/grandcat/zeroconf"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"github.com/yifu/pushpop/pkg/hashing"
+	"github.com/yifu/pushpop/pkg/clipboard"
+	"github.com/yifu/pushpop/pkg/config"
+	"github.com/yifu/pushpop/pkg/debugserver"
+	"github.com/yifu/pushpop/pkg/discovery"
+	"github.com/yifu/pushpop/pkg/history"
+	"github.com/yifu/pushpop/pkg/logging"
+	"github.com/yifu/pushpop/pkg/tempfile"
+	"github.com/yifu/pushpop/pkg/filter"
+	"github.com/yifu/pushpop/pkg/safename"
+	"github.com/yifu/pushpop/pkg/transfer"
+)
+
+// msg receives pop's informational output. It is stderr when the download
+// itself goes to stdout.
+var msg io.Writer = os.Stdout
+
+// Main runs pop with args, the command line arguments after Command.
+func Main(args []string) {
+	arguments = args
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", strings.Join(Command, " "))
+		flag.PrintDefaults()
+	}
+	clip := flag.Bool("clipboard", false, "put the received text into the clipboard instead of a file")
+	onExists := flag.String("on-exists", "ask", "when the file already exists: ask, overwrite, skip or rename")
+	onPart := flag.String("on-part", "ask", "when a .part file is left over: ask, resume or restart")
+	flag.BoolVar(&useTLS, "tls", false, "download over TLS, checking the certificate against the fingerprint the sender announces")
+	flag.BoolVar(&allowUnsigned, "allow-unsigned", false, "download shares of users whose key is pinned even when they are not signed, as directories and streams are not")
+	flag.BoolVar(&ignoreSpace, "ignore-space", false, "download even when the file does not seem to fit on the disk, only warning")
+	flag.BoolVar(&saving.Sync, "fsync", false, "flush the file and its directory to the disk before reporting the download as done")
+	flag.StringVar(&onCancel, "on-cancel", onCancel, "when a download is canceled with q or Ctrl-C: ask, keep or delete its .part file")
+	var output string
+	flag.StringVar(&output, "output", "", "save the download to this path, or to stdout when set to -")
+	flag.StringVar(&output, "o", "", "shorthand for -output")
+	dir := flag.String("dir", "", "save the download in this directory")
+	profile := flag.String("profile", "", "configuration profile to use (default $PUSHPOP_PROFILE)")
+	iface := flag.String("interface", "", "only reach the sender through this network interface")
+	flag.Var(&bufferSize, "buffer-size", "copy and hash files through buffers of this size, e.g. 1MiB, instead of sizes suiting the link and the disk")
+	flag.IntVar(&retries, "retries", retries, "how many times to retry an interrupted download")
+	flag.DurationVar(&seedFor, "seed", 0, "when swarming, go on serving the other receivers for this long once done")
+	flag.DurationVar(&stallTimeout, "stall-timeout", stallTimeout, "retry a download that received nothing for this long, 0 to wait forever")
+	noPreserve := flag.Bool("no-preserve", false, "do not apply the sender's modification time and permissions")
+	verifyMode := flag.Bool("verify", false, "compare the existing local file with the sender's instead of downloading it")
+	flag.BoolVar(&hashes.Xattr, "hash-xattr", false, "with -verify, also cache checksums in an extended attribute of the file, which survives renames")
+	probe := flag.Bool("probe", false, "measure the throughput and show how long the download should take before starting it")
+	flag.BoolVar(&logging.Verbose, "verbose", false, "log debugging details too, same as -log-level debug")
+	flag.StringVar(&logging.Level, "log-level", logging.Level, "least severe messages to log: debug, info, warn or error")
+	flag.StringVar(&logging.File, "log-file", "", "append the log to this file instead of printing it on stderr")
+	debugListen := flag.String("debug-listen", "", "serve Go profiling and tracing endpoints on this address, e.g. 127.0.0.1:6060")
+	debugBundle := flag.String("debug-bundle", "", "save logs, timings and what the sender said to this tar.gz, for bug reports")
+	asJSON := flag.Bool("json", false, "print progress as JSON lines on stdout, for scripts")
+	code := flag.String("code", "", "receive the private share with this code, printed by push -private")
+	gen := flag.Int("gen", 0, "download this generation of the file rather than the newest, when it was pushed several times")
+	watchMode := flag.Bool("watch", false, "keep downloading whatever the user shares, skipping files already there")
+	all := flag.Bool("all", false, "download every file the user shares rather than the first, through a queue")
+	concurrency := flag.Int("concurrency", 1, "with -all, how many files to download at once")
+	fromURL := flag.String("url", "", "download the share at this URL, as printed by push, without looking for it over mDNS")
+	flag.StringVar(&proxyURL, "proxy", "", "reach senders through this HTTP proxy, instead of the one of $HTTP_PROXY and $ALL_PROXY")
+	flag.StringVar(&socksAddr, "socks5", "", "reach senders through the SOCKS5 proxy at this host:port")
+	flag.BoolVar(&noMDNS, "no-mdns", false, "do not browse mDNS, only reach the senders listed in the peers file")
+	instance := flag.String("instance", "", "only download the share announced under this mDNS instance name")
+	receiveMode := flag.Bool("receive", false, "wait for a file sent with push -to instead of looking for a share")
+	listenAddr := flag.String("listen", "", "serve a page on this address, e.g. :8080, where anyone can upload files from a browser into -dir, by default $XDG_DATA_HOME/pushpop/inbox")
+	flag.Var(&maxUpload, "max-upload", "with -listen, refuse uploads larger than this, e.g. 100GiB, 0 for no limit")
+	flag.StringVar(&strategy, "strategy", strategy, "how to download: auto, stream, compressed, parallel, delta or swarm")
+	flag.DurationVar(&maxSkew, "max-skew", maxSkew, "how far the sender's clock may be off before warning")
+	filterExpr := flag.String("filter", "", "only download shares matching this expression, e.g. 'size < 1GB && name =~ \"\\.iso$\"'")
+	flag.BoolVar(&notifier.Desktop, "notify", false, "show a desktop notification when the transfer ends")
+	flag.StringVar(&notifier.Webhook, "webhook", "", "post the transfer to this URL, as JSON, when it ends")
+	flag.StringVar(&execCommand, "exec", "", "run this shell command on the received file, {} standing for its path")
+	flag.BoolVar(&openFile, "open", false, "open the received file with the desktop's application for it")
+	flag.StringVar(&preferFamily, "prefer", "", "reach senders over this address family, v4 or v6, rather than the one they suggest")
+	flag.BoolVar(&prompts.NonInteractive, "yes", false, "never ask, going with the default answer of every question")
+	flag.BoolVar(&prompts.NonInteractive, "non-interactive", false, "same as -yes")
+	flag.Var(policyFlag{"on-exists", "skip"}, "skip-existing", "same as -on-exists skip")
+	flag.Var(policyFlag{"on-exists", "rename"}, "rename", "same as -on-exists rename, saving to \"name (1).ext\" when the file already exists")
+	flag.Var(policyFlag{"on-part", "resume"}, "resume", "same as -on-part resume")
+	flag.Var(policyFlag{"on-part", "restart"}, "restart", "same as -on-part restart")
+	flag.CommandLine.Parse(args)
+	err := config.Apply(flag.CommandLine, "pop", *profile)
+	if err != nil {
+		fatal(err)
+	}
+	closeLog, err := logging.Setup()
+	if err != nil {
+		fatalCodef(exitUsage, "%v", err)
+	}
+	defer closeLog()
+	notifier.Logger = slog.Default()
+	limits.Logger = slog.Default()
+	hashes.Hashing.BufferSize = int(bufferSize)
+
+	handleSignals()
+	if *debugListen != "" {
+		err = debugserver.Start(*debugListen, slog.Default())
+		if err != nil {
+			fatal(err)
+		}
+	}
+	if preferFamily != "" && preferFamily != transfer.PreferV4 && preferFamily != transfer.PreferV6 {
+		fatalCodef(exitUsage, "Invalid -prefer value %q, expected v4 or v6", preferFamily)
+	}
+	err = setupProxy()
+	if err != nil {
+		fatal(err)
+	}
+	if !validStrategy(strategy) {
+		fatalCodef(exitUsage, "Invalid -strategy value %q", strategy)
+	}
+	var flt *filter.Filter
+	if *filterExpr != "" {
+		flt, err = filter.Parse(*filterExpr, filterFields)
+		if err != nil {
+			fatal(err)
+		}
+	}
+	toStdout := output == "-"
+	if toStdout && *asJSON {
+		fatalCodef(exitUsage, "-json and -o - both write to stdout")
+	}
+	if toStdout || *asJSON {
+		msg = os.Stderr
+	}
+	if *asJSON {
+		events = json.NewEncoder(os.Stdout)
+	}
+	if *debugBundle != "" {
+		startBundle(*debugBundle)
+	}
+	if (execCommand != "" || openFile) && (toStdout || *clip || *verifyMode) {
+		fatalCodef(exitUsage, "-exec and -open need the file saved, not -o -, -clipboard or -verify")
+	}
+
+	if *listenAddr != "" {
+		if flag.NArg() != 0 || *fromURL != "" || *receiveMode || *clip || output != "" || *verifyMode {
+			fatalCodef(exitUsage, "USAGE: pop -listen addr [-dir dir]")
+		}
+		listen(*listenAddr, *dir, *onExists)
+		return
+	}
+
+	if *receiveMode {
+		if flag.NArg() != 0 || *fromURL != "" || *clip || toStdout || *verifyMode {
+			fatalCodef(exitUsage, "USAGE: pop -receive [-o path] [-dir dir]")
+		}
+		fn := receive(output, *dir, *onExists, *noPreserve)
+		afterReceive(fn)
+		writeBundle()
+		return
+	}
+
+	usr, err := user.Current()
+	if err != nil {
+		fatal(err)
+	}
+	// Shares meant for a single recipient check who is asking.
+	client.User = usr.Username
+
+	if *watchMode {
+		if flag.NArg() > 1 || *code != "" || *fromURL != "" || *clip || output != "" || *verifyMode {
+			fatalCodef(exitUsage, "USAGE: pop -watch [-dir dir] [username]")
+		}
+		if flag.NArg() == 1 {
+			watch(flag.Arg(0), *dir, *iface, *onExists)
+		} else {
+			watch(usr.Username, *dir, *iface, *onExists)
+		}
+		return
+	}
+
+	if *all {
+		if flag.NArg() > 1 || *code != "" || *fromURL != "" || *clip || toStdout || *verifyMode || *instance != "" {
+			fatalCodef(exitUsage, "USAGE: pop -all [-concurrency n] [-dir dir] [username]")
+		}
+		if flag.NArg() == 1 {
+			downloadAll(flag.Arg(0), *iface, flt, *gen, *concurrency)
+		} else {
+			downloadAll(usr.Username, *iface, flt, *gen, *concurrency)
+		}
+		writeBundle()
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// get downloads the share at url, announced by entry, as name. entry is
+	// nil for -url.
+	get := func(url, ip, username, name string, entry *zeroconf.ServiceEntry) {
+		if s := fetchSession(url); s != nil {
+			if s.Files[0].Name != "" {
+				name = s.Files[0].Name
+			}
+			if username == "" {
+				username = s.User
+			}
+		}
+		announce(entry)
+		received.Time = time.Now()
+		received.User = username
+		received.Addr = ip
+		received.Name = name
+		emit(event{Event: eventDiscovered, User: received.User, Addr: received.Addr, Name: name})
+		checkManifest(url, entry)
+		checkSender(url, username, entry)
+		// Private shares are never swarmed: peers would serve them to anyone.
+		if txtValue(entry, transfer.SwarmKey) != "" && pinned != nil && client.Code == "" {
+			swarmID = txtValue(entry, transfer.ManifestKey)
+		}
+
+		if *clip {
+			receiveClipboard(url)
+			finish()
+			cancel()
+			return
+		}
+
+		if toStdout {
+			downloadTo(url, os.Stdout)
+			finish()
+			cancel()
+			return
+		}
+
+		name, err = safename.Name(name)
+		if err != nil {
+			fatal(err)
+		}
+		fn := destination(name, output, *dir)
+		if *verifyMode {
+			verifyOnly(url, fn)
+			pipe.enter(stateDone)
+			cancel()
+			return
+		}
+		if have, ok := upToDateCopy(url, fn, *onExists); ok {
+			fmt.Fprintln(msg, have, "is already up to date")
+			emit(event{Event: eventSkipped, Name: name, Path: have})
+			pipe.enter(stateDone)
+			cancel()
+			return
+		}
+		fn, ok := resolveExisting(fn, *onExists)
+		if !ok {
+			fmt.Fprintln(msg, "Skipping", fn)
+			emit(event{Event: eventSkipped, Name: name, Path: fn})
+			cancel()
+			return
+		}
+		fresh := false
+		if _, err := os.Stat(tempfile.Part(fn)); err == nil && !resumable(url) {
+			log.Println("The sender cannot resume downloads, restarting", tempfile.Part(fn))
+			fresh = true
+		} else {
+			fresh = resolvePart(tempfile.Part(fn), *onPart)
+		}
+		received.Path = fn
+		if *probe && !probeFirst(url) {
+			fmt.Fprintln(msg, "Not downloading", fn)
+			cancel()
+			return
+		}
+
+		meta, sum, url := download(url, fn, fresh)
+		if fi, err := os.Stat(fn); err == nil {
+			received.Size = fi.Size()
+		}
+		if !*noPreserve {
+			preserve(fn, meta)
+		}
+		verify(url, meta, sum)
+		keepSignature(fn)
+		finish()
+		afterReceive(fn)
+		seed()
+		cancel()
+	}
+
+	if *fromURL != "" {
+		if flag.NArg() != 0 {
+			fatalCodef(exitUsage, "USAGE: pop -url url")
+		}
+		client.Code = *code
+		pipe.enter(stateConnect)
+		url, ip, fp, err := parseShareURL(*fromURL)
+		if err != nil {
+			fatal(err)
+		}
+		if fp != "" {
+			err = pinCert(fp)
+			if err != nil {
+				fatalCodef(exitUsage, "%v", err)
+			}
+		}
+		get(url, ip, "", askName(url, urlName(url)), nil)
+		writeBundle()
+		return
+	}
+
+	var username string
+	if *code != "" {
+		if flag.NArg() != 0 {
+			fatalCodef(exitUsage, "USAGE: pop -code code")
+		}
+		client.Code = *code
+	} else if flag.NArg() == 0 {
+		username = usr.Username
+	} else if flag.NArg() == 1 {
+		username = flag.Arg(0)
+	} else {
+		fmt.Println("USAGE: pop [-clipboard] [-o path|-] [-dir dir] <username|-url url>")
+		os.Exit(exitUsage)
+	}
+
+	// Whichever of mDNS and the peers file finds the share first gets it.
+	token := make(chan struct{}, 1)
+	token <- struct{}{}
+	claim := func() bool {
+		select {
+		case <-token:
+			return true
+		default:
+			return false
+		}
+	}
+	fromPeers := func() bool {
+		if username == "" || len(token) == 0 {
+			return false
+		}
+		url, ip, ok := staticPeer(username)
+		if !ok || !claim() {
+			return false
+		}
+		pipe.enter(stateConnect)
+		get(url, ip, username, askName(url, urlName(url)), nil)
+		return true
+	}
+	if noMDNS {
+		if *code != "" {
+			fatal("Private shares are only found over mDNS, not with -no-mdns")
+		}
+		if !fromPeers() {
+			fatalf("No reachable address of %s in the peers file", username)
+		}
+		writeBundle()
+		return
+	}
+
+	pipe.enter(stateDiscover)
+	entries, err := discovery.Browse(ctx)
+	if err != nil {
+		log.Println("Failed to browse, trying the peers file: ", err)
+		if !fromPeers() {
+			fatal("Failed to browse: ", err)
+		}
+		writeBundle()
+		return
+	}
+	go func() {
+		time.Sleep(staticAfter)
+		fromPeers()
+	}()
+	matches := func(entry *zeroconf.ServiceEntry) bool {
+		if *code != "" {
+			// Private shares announce nothing but an opaque name.
+			return entry.Instance == transfer.CodeInstance(*code)
+		}
+		entry_username, err := getUserName(entry)
+		if err != nil {
+			log.Println(err)
+			return false
+		}
+		if username != entry_username {
+			return false
+		}
+		if txtValue(entry, transfer.RoleKey) != "" {
+			// Another pop waiting for a push -to, or serving a swarm.
+			return false
+		}
+		if *instance != "" && entry.Instance != *instance {
+			return false
+		}
+		if *gen != 0 && generation(entry) != *gen {
+			return false
+		}
+		return flt == nil || flt.Match(shareFields(entry, *iface))
+	}
+	go func(results <-chan *zeroconf.ServiceEntry) {
+		for entry := range results {
+			slog.Debug("Found a service", "instance", entry.Instance, "host", entry.HostName, "port", entry.Port, "text", entry.Text)
+			if !matches(entry) {
+				continue
+			}
+			if *gen == 0 && *instance == "" {
+				entry = newest(entry, results, matches)
+			}
+			if !claim() {
+				return
+			}
+			entry_username, _ := getUserName(entry)
+			if *code == "" {
+				fmt.Fprintln(msg, "Found", describe(entry))
+			}
+
+			pipe.enter(stateConnect)
+			url, ip, err := entryURL(entry, *iface)
+			if err != nil {
+				fatal(err)
+			}
+			if useTLS {
+				url, err = overTLS(url, entry)
+				if err != nil {
+					fatal(err)
+				}
+			}
+			instance := entry.Instance
+			relocate = func() (string, error) {
+				return locate(username, instance, *iface)
+			}
+			name := fileName(entry)
+			if *code != "" {
+				name = askName(url, name)
+			}
+			get(url, ip, entry_username, name, entry)
+			return
+		}
+		slog.Debug("No more services")
+	}(entries)
+
+	<-ctx.Done()
+	writeBundle()
+}
+
+// finish records the completed download.
+func finish() {
+	recordReceive(history.ResultOK)
+	emit(event{Event: eventDone, Name: received.Name, Path: received.Path, Size: received.Size,
+		Algorithm: received.Algorithm, Sum: received.Sum})
+	pipe.enter(stateDone)
+}
+
+// destination returns the path where the file announced as name is saved.
+// output, when set, replaces the name; a directory output, or dir, receives
+// the file under its announced name. Missing parent directories are created.
+func destination(name, output, dir string) string {
+	fn := name
+	if output != "" {
+		fn = output
+		fi, err := os.Stat(output)
+		if (err == nil && fi.IsDir()) || os.IsPathSeparator(output[len(output)-1]) {
+			fn = filepath.Join(output, name)
+		}
+	}
+	if dir != "" && !filepath.IsAbs(fn) {
+		fn = filepath.Join(dir, fn)
+	}
+
+	err := os.MkdirAll(filepath.Dir(fn), 0755)
+	if err != nil {
+		fatal(err)
+	}
+	return fn
+}
+
+// downloadTo streams url into w, hashing it on the way so the download can
+// still be verified. There is no .part file, so no resume either.
+func downloadTo(url string, w io.Writer) {
+	resp, err := fetch(newRequest(url))
+	if err != nil {
+		fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fatal("Unexpected status: ", resp.Status)
+	}
+	meta, err := senderMeta(url, resp)
+	if err != nil {
+		fatal(err)
+	}
+
+	pipe.enter(stateDownload)
+	emit(event{Event: eventStarted, Name: received.Name, Size: meta.Size})
+	h := meta.Algorithm.New()
+	prog := startEvents(0, meta.Size)
+	received.Size, err = bufferSize.Copy(io.MultiWriter(w, h), prog.reader(resp.Body), linkRate)
+	prog.finish()
+	if err != nil {
+		fatal("Download interrupted: ", err)
+	}
+	checkSigned(url, "", meta.Algorithm, hashing.Hex(h))
+	verify(url, meta, hashing.Hex(h))
+}
+
+func receiveClipboard(url string) {
+	resp, err := fetch(newRequest(url))
+	if err != nil {
+		fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fatal("Unexpected status: ", resp.Status)
+	}
+	meta, err := senderMeta(url, resp)
+	if err != nil {
+		fatal(err)
+	}
+
+	pipe.enter(stateDownload)
+	emit(event{Event: eventStarted, Name: received.Name, Size: meta.Size})
+	prog := startEvents(0, meta.Size)
+	data, err := io.ReadAll(prog.reader(resp.Body))
+	prog.finish()
+	if err != nil {
+		fatal(err)
+	}
+	received.Size = int64(len(data))
+	sum, err := hashing.Sum(meta.Algorithm, bytes.NewReader(data))
+	if err != nil {
+		fatal(err)
+	}
+	checkSigned(url, "", meta.Algorithm, sum)
+	verify(url, meta, sum)
+	err = clipboard.Write(data)
+	if err != nil {
+		fatal("Unable to write clipboard: ", err)
+	}
+	fmt.Fprintln(msg, "Copied", len(data), "bytes to the clipboard.")
+}
+
+func getUserName(entry *zeroconf.ServiceEntry) (string, error) {
+	var reg = regexp.MustCompile("(\\w+)=(\\w+)")
+	for _, val := range entry.Text {
+		//fmt.Printf("val = %q\n", val)
+		data := reg.FindAllStringSubmatch(val, -1)
+		//fmt.Printf("data = %q\n", data)
+		if len(data) < 1 || len(data[0]) != 3 {
+			continue
+		}
+		if data[0][1] == "user" {
+			return data[0][2], nil
+		}
+	}
+	return "", fmt.Errorf("User key/value pair not found")
+}
+
+// findMatchingIP returns the first of ips reachable on a local network,
+// looking only at the interface called only when it is not empty.
+func findMatchingIP(ips []net.IP, only string) (string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		fatal(err);
+	}
+	for _, iface := range ifaces {
+		if only != "" && iface.Name != only {
+			continue
+		}
+		//fmt.Println("iface name: ", iface.Name)
+		iface_addrs, err := iface.Addrs()
+		if err != nil {
+			fatal(err)
+		}
+		//fmt.Println(addrs)
+		for _, iface_addr := range iface_addrs {
+			_, iface_net, err := net.ParseCIDR(iface_addr.String())
+			if err != nil {
+				fatal(err)
+			}
+			for _, ip := range ips {
+				if iface_net.Contains(ip) {
+					fmt.Fprintln(msg, "Found an interface: ", iface.Name,
+								" with ip: ", iface_addr,
+								" with net: ", iface_net,
+								" corresponding to ip: ", ip)
+					return ip.String(), nil
+				}
+			}
+		}
+	}
+	return "", fmt.Errorf("Found no matching interface")
+}
\ No newline at end of file
@@ -0,0 +1,30 @@
+package popcmd
+
+import (
+	"github.com/grandcat/zeroconf"
+	"github.com/yifu/pushpop/pkg/hashing"
+	"github.com/yifu/pushpop/pkg/transfer"
+)
+
+// pinned is the manifest of the share being downloaded, when its sender
+// pinned one in its TXT record. Its checksum then takes precedence over
+// whatever the sender says over HTTP.
+var pinned *transfer.Manifest
+
+// checkManifest fetches the manifest pinned in the TXT record of entry, if
+// any, and refuses to go on when it does not match.
+func checkManifest(url string, entry *zeroconf.ServiceEntry) {
+	sum := txtValue(entry, transfer.ManifestKey)
+	if sum == "" {
+		return
+	}
+	alg, err := hashing.Lookup(txtValue(entry, "hash"))
+	if err != nil {
+		fatal(err)
+	}
+	m, err := client.FetchManifest(url, sum, alg)
+	if err != nil {
+		fatal(err)
+	}
+	pinned = &m
+}
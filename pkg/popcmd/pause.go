@@ -0,0 +1,290 @@
+package popcmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/yifu/pushpop/pkg/tempfile"
+	"golang.org/x/term"
+)
+
+// pauseKey pauses the download under way, and resumes it; cancelKey
+// cancels it, like Ctrl-C.
+const (
+	pauseKey  = 'p'
+	cancelKey = 'q'
+)
+
+// onCancel is the -on-cancel flag: what to do with the .part file of a
+// canceled download, "ask", "keep" or "delete".
+var onCancel = "ask"
+
+// Errors returned by downloadOnce when the download was paused or
+// canceled.
+var (
+	errPaused   = errors.New("Download paused")
+	errCanceled = errors.New("Download canceled")
+)
+
+// pauser pauses a download when pauseKey is pressed, by closing its body so
+// that the .part file stays as it is, and resumes it when pressed again,
+// downloadOnce then asking for the rest with a fresh Range request. The
+// sender's connection is not held open meanwhile. It cancels the download
+// the same way on cancelKey, SIGINT or SIGTERM, so that the .part file is
+// closed before deciding whether to keep it.
+type pauser struct {
+	stopKeys func()
+
+	mu       sync.Mutex
+	paused   bool
+	canceled bool
+	// resumed is closed when the download resumes or is canceled.
+	resumed chan struct{}
+	// body is that of the request under way, nil between requests.
+	body io.Closer
+	// fn is the destination of the download under way, "" before it starts.
+	fn string
+}
+
+var pause = &pauser{}
+
+// handleSignals cancels the download on SIGINT and SIGTERM, instead of
+// exiting at once.
+func handleSignals() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for range sig {
+			pause.cancel()
+		}
+	}()
+}
+
+// start listens to the keys pressed while downloading fn, when progress
+// bars are shown on a terminal.
+func (p *pauser) start(fn string) {
+	p.mu.Lock()
+	p.fn = fn
+	p.mu.Unlock()
+	if events != nil || !term.IsTerminal(int(os.Stderr.Fd())) {
+		return
+	}
+	keys, stop := prompts.Keys()
+	if keys == nil {
+		return
+	}
+	p.mu.Lock()
+	p.stopKeys = stop
+	p.mu.Unlock()
+	fmt.Fprintf(msg, "Press %c to pause the download, %c to cancel it.\n", pauseKey, cancelKey)
+	go func() {
+		for k := range keys {
+			switch k {
+			case pauseKey:
+				p.toggle()
+			case cancelKey:
+				p.cancel()
+			}
+		}
+	}()
+}
+
+// stop stops listening to the keys, giving the terminal back as it was,
+// once the download is over.
+func (p *pauser) stop() {
+	p.mu.Lock()
+	stop := p.stopKeys
+	p.stopKeys = nil
+	p.fn = ""
+	p.mu.Unlock()
+	if stop != nil {
+		stop()
+	}
+}
+
+func (p *pauser) toggle() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.canceled {
+		return
+	}
+	if p.paused {
+		p.paused = false
+		close(p.resumed)
+		return
+	}
+	p.paused = true
+	p.resumed = make(chan struct{})
+	if p.body != nil {
+		p.body.Close()
+	}
+}
+
+// cancel cancels the download. The download under way, or paused, stops
+// and goes through abandon; pop exits at once when none is.
+func (p *pauser) cancel() {
+	p.mu.Lock()
+	if p.canceled {
+		p.mu.Unlock()
+		return
+	}
+	p.canceled = true
+	fn, body := p.fn, p.body
+	if p.paused {
+		p.paused = false
+		close(p.resumed)
+		p.mu.Unlock()
+		return
+	}
+	p.mu.Unlock()
+	if body != nil {
+		body.Close()
+		return
+	}
+	if fn == "" {
+		p.stop()
+		fatalCodef(exitCanceled, "Canceled")
+	}
+	// Downloads other than a single stream have no body to close; the
+	// .part file is dealt with as is.
+	abandon(fn)
+}
+
+// watch makes pausing and canceling close body, at once if the download is
+// paused or canceled already, until done is called.
+func (p *pauser) watch(body io.Closer) (done func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.body = body
+	if p.paused || p.canceled {
+		body.Close()
+	}
+	return func() {
+		p.mu.Lock()
+		p.body = nil
+		p.mu.Unlock()
+	}
+}
+
+// interrupted returns errPaused or errCanceled when the download was paused
+// or canceled, nil otherwise.
+func (p *pauser) interrupted() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case p.canceled:
+		return errCanceled
+	case p.paused:
+		return errPaused
+	}
+	return nil
+}
+
+// wait returns once the download is resumed, or canceled.
+func (p *pauser) wait() {
+	p.mu.Lock()
+	paused, resumed := p.paused, p.resumed
+	p.mu.Unlock()
+	if paused {
+		<-resumed
+	}
+}
+
+// abandon ends pop once the download to fn was canceled, keeping its .part
+// file or deleting it as -on-cancel says, and tells how to resume.
+func abandon(fn string) {
+	pause.stop()
+	part := tempfile.Part(fn)
+	emit(event{Event: eventCanceled, Name: received.Name, Path: fn})
+	recordReceive("canceled")
+	if _, err := os.Stat(part); err != nil {
+		fmt.Fprintln(msg, "Download canceled.")
+		writeBundle()
+		os.Exit(exitCanceled)
+	}
+	keep := true
+	switch onCancel {
+	case "keep":
+	case "delete":
+		keep = false
+	case "ask":
+		sel, err := prompts.Choose("Download canceled.", []string{"Keep " + part + " to resume later", "Delete " + part}, 0)
+		keep = err != nil || sel == 0
+	default:
+		fatalCodef(exitUsage, "Invalid -on-cancel value %q", onCancel)
+	}
+	if keep {
+		fmt.Fprintf(msg, "Download canceled, keeping %s. Resume it with:\n  %s\n", part, resumeCommand())
+	} else {
+		removeHashState(part)
+		os.Remove(part)
+		fmt.Fprintln(msg, "Download canceled, deleted", part)
+	}
+	writeBundle()
+	os.Exit(exitCanceled)
+}
+
+// resumeCommand returns the command line running pop again, resuming from
+// the .part file without asking.
+func resumeCommand() string {
+	var args []string
+	for _, a := range Command {
+		args = append(args, quoteArg(a))
+	}
+	args = append(args, "-on-part=resume")
+	rest := arguments
+	for i := 0; i < len(rest); i++ {
+		a := rest[i]
+		if a == "--" || !strings.HasPrefix(a, "-") {
+			for _, a := range rest[i:] {
+				args = append(args, quoteArg(a))
+			}
+			break
+		}
+		name := strings.TrimLeft(a, "-")
+		value := strings.Contains(name, "=")
+		if value {
+			name = name[:strings.Index(name, "=")]
+		}
+		// The value of a flag other than a boolean one may be the next
+		// argument.
+		next := !value && i+1 < len(rest) && !isBoolFlag(name)
+		if name == "on-part" || name == "resume" || name == "restart" {
+			if next {
+				i++
+			}
+			continue
+		}
+		args = append(args, quoteArg(a))
+		if next {
+			i++
+			args = append(args, quoteArg(rest[i]))
+		}
+	}
+	return strings.Join(args, " ")
+}
+
+// isBoolFlag tells whether the flag name is a boolean one, taking no value.
+func isBoolFlag(name string) bool {
+	f := flag.Lookup(name)
+	if f == nil {
+		return true
+	}
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// quoteArg quotes s with shellQuote, when it needs to be.
+func quoteArg(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=./:@,+%") == "" {
+		return s
+	}
+	return shellQuote(s)
+}
@@ -0,0 +1,44 @@
+package popcmd
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/peers"
+)
+
+// noMDNS is the -no-mdns flag: only reach senders listed in the peers file.
+var noMDNS bool
+
+// staticAfter is how long pop browses mDNS for a share before trying the
+// peers file.
+const staticAfter = 5 * time.Second
+
+// staticPeer returns the URL and IP address of the first reachable address
+// of username in the peers file, or false when none is.
+func staticPeer(username string) (string, string, bool) {
+	all, err := peers.Load()
+	if err != nil {
+		log.Println("Unable to read the peers file: ", err)
+		return "", "", false
+	}
+	for _, hostport := range all[username] {
+		host, port, err := net.SplitHostPort(hostport)
+		if err != nil {
+			continue
+		}
+		portn, err := strconv.Atoi(port)
+		if err != nil {
+			continue
+		}
+		if !reachable(host, portn) {
+			log.Println("The static peer", hostport, "of", username, "is not reachable")
+			continue
+		}
+		return fmt.Sprintf("http://%s/", hostport), host, true
+	}
+	return "", "", false
+}
@@ -0,0 +1,88 @@
+package popcmd
+
+import (
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+	"github.com/yifu/pushpop/pkg/transfer"
+)
+
+// preferFamily is the -prefer flag: the address family to reach senders
+// with when they announce both, overriding the one they suggest.
+var preferFamily string
+
+// reachTimeout bounds how long to wait for the preferred address to
+// answer before trying the other family.
+const reachTimeout = time.Second
+
+// families returns the address families to try for entry, preferred first:
+// the one set with -prefer, else the one the sender suggests, else IPv4.
+func families(entry *zeroconf.ServiceEntry) []string {
+	prefer := preferFamily
+	if prefer == "" {
+		prefer = txtValue(entry, transfer.PreferKey)
+	}
+	if prefer == transfer.PreferV6 {
+		return []string{transfer.PreferV6, transfer.PreferV4}
+	}
+	return []string{transfer.PreferV4, transfer.PreferV6}
+}
+
+// pickIP returns the address to reach the sender announced by entry with,
+// through the interface called iface when it is not empty. It goes with the
+// preferred family when the sender answers there, and falls back to the
+// other one when it does not, since on some networks an announced address
+// does not work.
+func pickIP(entry *zeroconf.ServiceEntry, iface string) (string, error) {
+	var ips []string
+	var err error
+	for _, family := range families(entry) {
+		addrs := entry.AddrIPv4
+		if family == transfer.PreferV6 {
+			addrs = routableIPv6(entry.AddrIPv6)
+		}
+		var ip string
+		ip, err = findMatchingIP(addrs, iface)
+		if err == nil {
+			ips = append(ips, ip)
+		}
+	}
+	if len(ips) == 0 {
+		return "", err
+	}
+	if len(ips) > 1 && !reachable(ips[0], entry.Port) {
+		log.Printf("The sender does not answer on %s, using %s.", ips[0], ips[1])
+		return ips[1], nil
+	}
+	return ips[0], nil
+}
+
+// routableIPv6 leaves out link-local addresses, which cannot be used
+// without a zone.
+func routableIPv6(ips []net.IP) []net.IP {
+	var routable []net.IP
+	for _, ip := range ips {
+		if !ip.IsLinkLocalUnicast() {
+			routable = append(routable, ip)
+		}
+	}
+	return routable
+}
+
+// reachable reports whether a connection to ip on port succeeds quickly.
+// Through a proxy, only the proxy can tell, so every address is taken to
+// be.
+func reachable(ip string, port int) bool {
+	if proxied {
+		return true
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, strconv.Itoa(port)), reachTimeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
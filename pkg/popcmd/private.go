@@ -0,0 +1,36 @@
+package popcmd
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/yifu/pushpop/pkg/transfer"
+)
+
+// askName asks the share at url for its file name, when its announcement
+// does not tell, as for private shares, or when there is none, as with
+// -url. It returns fallback when the sender does not say.
+func askName(url, fallback string) string {
+	if s := fetchSession(url); s != nil && s.Files[0].Name != "" {
+		return s.Files[0].Name
+	}
+	req := newRequest(url)
+	req.Header.Set("Range", "bytes=0-0")
+	resp, err := fetch(req)
+	if err != nil {
+		fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusForbidden && client.Code != "" {
+		fatal("The sender refused the code")
+	}
+	if resp.StatusCode == http.StatusForbidden {
+		fatal("The sender refused to serve this user or address")
+	}
+	name := transfer.FileName(resp)
+	if name == "" {
+		log.Println("The sender did not give a file name, saving as", fallback)
+		return fallback
+	}
+	return name
+}
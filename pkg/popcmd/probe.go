@@ -0,0 +1,49 @@
+package popcmd
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/prompt"
+	"github.com/yifu/pushpop/pkg/transfer"
+	"github.com/yifu/pushpop/pkg/units"
+)
+
+// probeFirst measures the throughput to the sender at url, shows how long
+// the download should take and asks whether to go on. It returns false
+// when the user would rather download later. Probing problems only produce
+// a warning.
+func probeFirst(url string) bool {
+	meta, err := headMeta(url)
+	if err != nil {
+		log.Println("Unable to probe: ", err)
+		return true
+	}
+
+	rate, err := client.Probe(url, transfer.DefaultProbeTime)
+	if err == transfer.ErrNoProbe {
+		log.Println(err)
+		return true
+	}
+	if err != nil {
+		log.Println("Unable to probe: ", err)
+		return true
+	}
+	linkRate = rate
+	line := fmt.Sprintf("Throughput is about %s/s", units.Bytes(int64(rate)))
+	if meta.Size >= 0 {
+		eta := time.Duration(float64(meta.Size) / rate * float64(time.Second))
+		line += fmt.Sprintf(", %s should take %v", units.Bytes(meta.Size), eta.Round(time.Second))
+	}
+	fmt.Fprintln(msg, line+".")
+
+	sel, err := prompts.Choose("Download now?", []string{"Download now", "Later"}, 0)
+	if err == prompt.ErrNotAsked {
+		return true
+	}
+	if err != nil {
+		fatalPrompt(err)
+	}
+	return sel == 0
+}
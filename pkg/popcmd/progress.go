@@ -0,0 +1,221 @@
+package popcmd
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/hashing"
+	"github.com/yifu/pushpop/pkg/prompt"
+	"github.com/yifu/pushpop/pkg/units"
+	"golang.org/x/term"
+)
+
+// barInterval is the time between two redraws of a progress bar.
+const barInterval = 100 * time.Millisecond
+
+// rateSmoothing is the time constant of the rate shown by progress bars:
+// bursts shorter than that barely move it, so the ETA does not jump around.
+const rateSmoothing = 3 * time.Second
+
+// progress shows how a download goes: as events with -json, or as a bar
+// when stderr is a terminal. The download only counts its bytes with add,
+// from as many goroutines as it takes, and a goroutine of progress samples
+// the count on a tick to show it, so that showing progress never holds up
+// the download, and the rate drops while nothing comes.
+type progress struct {
+	// n counts the bytes so far, including those there before the download
+	// started; size is -1 when unknown.
+	n     atomic.Int64
+	label string
+	size  int64
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+
+	last time.Time
+	// drawn is n at the last redraw; rate is the smoothed rate in bytes per
+	// second.
+	drawn int64
+	rate  float64
+}
+
+// shown is the progress shown last.
+var shown atomic.Pointer[progress]
+
+// stopProgress stops showing progress, before pop exits on an error.
+func stopProgress() {
+	if p := shown.Load(); p != nil {
+		p.finish()
+	}
+}
+
+// startProgress starts showing the progress of a download of size bytes,
+// offset of which are already there.
+func startProgress(label string, offset, size int64) *progress {
+	p := newProgress(label, offset, size)
+	switch {
+	case events != nil:
+		p.start(p.emit, progressInterval)
+	case term.IsTerminal(int(os.Stderr.Fd())):
+		p.start(p.draw, barInterval)
+	default:
+		close(p.done)
+	}
+	return p
+}
+
+// startEvents is startProgress showing progress with -json only, for a
+// download that is not saved to a file.
+func startEvents(offset, size int64) *progress {
+	p := newProgress("", offset, size)
+	if events != nil {
+		p.start(p.emit, progressInterval)
+	} else {
+		close(p.done)
+	}
+	return p
+}
+
+func newProgress(label string, offset, size int64) *progress {
+	// The rate measured by -probe is a better first guess than nothing.
+	p := &progress{label: label, size: size, drawn: offset, rate: linkRate, stop: make(chan struct{}), done: make(chan struct{})}
+	p.n.Store(offset)
+	return p
+}
+
+// start calls show every interval until finish, and a last time then.
+func (p *progress) start(show func(last bool), interval time.Duration) {
+	shown.Store(p)
+	show(false)
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				show(false)
+			case <-p.stop:
+				show(true)
+				return
+			}
+		}
+	}()
+}
+
+// add counts n more bytes.
+func (p *progress) add(n int64) {
+	p.n.Add(n)
+}
+
+// reader returns r, counting the bytes read from it.
+func (p *progress) reader(r io.Reader) io.Reader {
+	return &countingReader{r: r, p: p}
+}
+
+// finish shows the progress a last time and stops showing it.
+func (p *progress) finish() {
+	p.once.Do(func() { close(p.stop) })
+	<-p.done
+}
+
+// emit emits a progress event.
+func (p *progress) emit(last bool) {
+	n := p.n.Load()
+	e := event{Event: eventProgress, Name: received.Name, Bytes: n, Size: p.size}
+	if p.size > 0 {
+		percent := float64(n) * 100 / float64(p.size)
+		e.Percent = &percent
+	}
+	emit(e)
+}
+
+// draw redraws the progress bar, with the rate and the time left, going
+// to the next line the last time.
+func (p *progress) draw(last bool) {
+	n := p.n.Load()
+	p.measure(n)
+	width, _, err := term.GetSize(int(os.Stderr.Fd()))
+	if err != nil {
+		width = 80
+	}
+	fraction := 1.0
+	if p.size > 0 {
+		fraction = float64(n) / float64(p.size)
+	}
+	// The label, a space, the percentage and the brackets.
+	barWidth := width - len(p.label) - 8
+	if barWidth > 40 {
+		barWidth = 40
+	}
+	line := fmt.Sprintf("%s %3.0f%%", p.label, fraction*100)
+	if p.size < 0 {
+		line = p.label + " " + units.Bytes(n)
+	} else if barWidth >= 10 {
+		done := int(fraction * float64(barWidth))
+		line += " [" + strings.Repeat("=", done) + strings.Repeat(" ", barWidth-done) + "]"
+	}
+	if p.rate >= 1 {
+		line += " " + units.Bytes(int64(p.rate)) + "/s"
+		if p.size > n {
+			left := time.Duration(float64(p.size-n) / p.rate * float64(time.Second))
+			line += ", " + left.Round(time.Second).String() + " left"
+		}
+	}
+	fmt.Fprint(os.Stderr, "\r"+prompt.Clamp(line, width))
+	if last {
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+// measure updates the rate with the n bytes there now, weighing what came
+// since the last redraw by how long ago that was.
+func (p *progress) measure(n int64) {
+	now := time.Now()
+	if !p.last.IsZero() {
+		elapsed := now.Sub(p.last)
+		current := float64(n-p.drawn) / elapsed.Seconds()
+		if p.rate == 0 {
+			p.rate = current
+		} else {
+			p.rate += (current - p.rate) * (1 - math.Exp(-float64(elapsed)/float64(rateSmoothing)))
+		}
+	}
+	p.last, p.drawn = now, n
+}
+
+// countingReader counts the bytes read from r with p.
+type countingReader struct {
+	r io.Reader
+	p *progress
+}
+
+func (c *countingReader) Read(buf []byte) (int, error) {
+	n, err := c.r.Read(buf)
+	c.p.add(int64(n))
+	return n, err
+}
+
+// hashFile returns the checksum of fn computed with a, showing progress.
+func hashFile(a hashing.Algorithm, fn string) (string, error) {
+	return hashFileWith(hashes.Hashing.SumFileProgress, a, fn)
+}
+
+// hashFileWith is hashFile computing the checksum with sum.
+func hashFileWith(sum func(hashing.Algorithm, string, func(int64)) (string, error), a hashing.Algorithm, fn string) (string, error) {
+	fi, err := os.Stat(fn)
+	if err != nil {
+		return "", err
+	}
+	p := startProgress("Hashing "+fn, 0, fi.Size())
+	s, err := sum(a, fn, p.add)
+	p.finish()
+	return s, err
+}
@@ -0,0 +1,109 @@
+package popcmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// proxyURL and socksAddr are the -proxy and -socks5 flags.
+var proxyURL, socksAddr string
+
+// proxied is set when requests to senders go through a proxy, which pop
+// then cannot check the reachability of senders without.
+var proxied bool
+
+// setupProxy sends the requests of pop through the proxy of -proxy or
+// -socks5, or else of the environment: HTTP_PROXY and HTTPS_PROXY as Go
+// reads them, with NO_PROXY, and then ALL_PROXY, as curl does.
+func setupProxy() error {
+	var fixed *url.URL
+	switch {
+	case proxyURL != "" && socksAddr != "":
+		return fmt.Errorf("-proxy and -socks5 both set a proxy")
+	case proxyURL != "":
+		u, err := parseProxy(proxyURL, "http")
+		if err != nil {
+			return err
+		}
+		fixed = u
+	case socksAddr != "":
+		u, err := parseProxy(socksAddr, "socks5")
+		if err != nil {
+			return err
+		}
+		fixed = u
+	}
+
+	all := os.Getenv("ALL_PROXY")
+	if all == "" {
+		all = os.Getenv("all_proxy")
+	}
+	var fallback *url.URL
+	if all != "" {
+		u, err := parseProxy(all, "http")
+		if err != nil {
+			return fmt.Errorf("Invalid ALL_PROXY: %v", err)
+		}
+		fallback = u
+	}
+	if fixed == nil && fallback == nil && os.Getenv("HTTP_PROXY") == "" && os.Getenv("http_proxy") == "" &&
+		os.Getenv("HTTPS_PROXY") == "" && os.Getenv("https_proxy") == "" {
+		return nil
+	}
+
+	proxied = true
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		if fixed != nil {
+			return fixed, nil
+		}
+		u, err := http.ProxyFromEnvironment(req)
+		if u != nil || err != nil || fallback == nil || noProxy(req.URL.Hostname()) {
+			return u, err
+		}
+		return fallback, nil
+	}
+	return nil
+}
+
+// parseProxy parses a proxy address, a URL or host:port, the latter taken
+// with scheme.
+func parseProxy(value, scheme string) (*url.URL, error) {
+	if !strings.Contains(value, "://") {
+		value = scheme + "://" + value
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("Unsupported proxy scheme %q, expected http, https or socks5", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("Invalid proxy %q", value)
+	}
+	return u, nil
+}
+
+// noProxy reports whether NO_PROXY lists host, by name, domain suffix or
+// as *.
+func noProxy(host string) bool {
+	list := os.Getenv("NO_PROXY")
+	if list == "" {
+		list = os.Getenv("no_proxy")
+	}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimPrefix(strings.TrimSpace(entry), ".")
+		if entry == "" {
+			continue
+		}
+		if entry == "*" || host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
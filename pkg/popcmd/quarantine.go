@@ -0,0 +1,45 @@
+package popcmd
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+
+	"github.com/yifu/pushpop/pkg/hashing"
+	"github.com/yifu/pushpop/pkg/quarantine"
+)
+
+// quarantineFile moves the corrupted file fn aside with report r instead
+// of deleting it. It returns false when fn could not be moved, and is still
+// there.
+func quarantineFile(fn string, r quarantine.Report) bool {
+	dest, err := quarantine.Keep(fn, r)
+	if dest == "" {
+		log.Println("Unable to quarantine", fn+": ", err)
+		return false
+	}
+	if err != nil {
+		log.Println("Unable to write the quarantine report: ", err)
+	}
+	fmt.Fprintln(msg, "Moved", fn, "to", dest, "for inspection, see", dest+quarantine.ReportSuffix)
+	return true
+}
+
+// diffReport describes where local, read at offset, differs from remote.
+func diffReport(reason string, offset int64, local, remote []byte) quarantine.Report {
+	r := quarantine.Report{
+		Reason:    reason,
+		Offset:    offset,
+		Length:    int64(len(local)),
+		Algorithm: hashing.Default.Name(),
+	}
+	r.Expected, _ = hashing.Sum(hashing.Default, bytes.NewReader(remote))
+	r.Actual, _ = hashing.Sum(hashing.Default, bytes.NewReader(local))
+	for i := range local {
+		if local[i] != remote[i] {
+			r.Mismatch = offset + int64(i)
+			break
+		}
+	}
+	return r
+}
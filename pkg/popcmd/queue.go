@@ -0,0 +1,351 @@
+package popcmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+	"github.com/yifu/pushpop/pkg/discovery"
+	"github.com/yifu/pushpop/pkg/filter"
+	"github.com/yifu/pushpop/pkg/prompt"
+	"github.com/yifu/pushpop/pkg/transfer"
+	"github.com/yifu/pushpop/pkg/units"
+	"golang.org/x/term"
+)
+
+// queueBrowse is how long pop -all browses for shares before downloading
+// the ones found.
+const queueBrowse = 2 * time.Second
+
+// States of a queued file.
+const (
+	queueWaiting     = "waiting"
+	queueDownloading = "downloading"
+	queueDone        = "done"
+	queueSkipped     = "skipped"
+	queueFailed      = "failed"
+)
+
+// queued is a file of the queue.
+type queued struct {
+	entry *zeroconf.ServiceEntry
+	name  string
+	// size is -1 until known; bytes is how much of the file is there.
+	size  int64
+	bytes int64
+	state string
+	// message tells why the download failed.
+	message string
+}
+
+// queue downloads the files of several shares, each in a pop of its own like
+// -watch does, a few at once, and shows the progress of each along with the
+// overall progress.
+type queue struct {
+	username string
+
+	mu    sync.Mutex
+	files []*queued
+	start time.Time
+	// drawn is how many lines of bars are on screen, redrawn in place.
+	drawn int
+	tty   bool
+}
+
+// downloadAll downloads every file username shares that matches, with
+// concurrency downloads at once.
+func downloadAll(username, iface string, flt *filter.Filter, gen, concurrency int) {
+	if concurrency < 1 {
+		fatalCodef(exitUsage, "Invalid -concurrency value %d", concurrency)
+	}
+	pipe.enter(stateDiscover)
+	q := &queue{username: username, tty: events == nil && term.IsTerminal(int(os.Stderr.Fd()))}
+	q.files = findAll(username, iface, flt, gen)
+	if len(q.files) == 0 {
+		fatalf("No share found for %s", username)
+	}
+	// The sizes from the manifests give the overall progress from the start.
+	for _, f := range q.files {
+		if s, ok := shareSize(f.entry, iface); ok {
+			f.size, _ = strconv.ParseInt(s, 10, 64)
+		}
+	}
+	fmt.Fprintf(msg, "Downloading %d files from %s.\n", len(q.files), username)
+
+	pipe.enter(stateDownload)
+	q.start = time.Now()
+	todo := make(chan *queued, len(q.files))
+	for _, f := range q.files {
+		todo <- f
+	}
+	close(todo)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range todo {
+				q.download(f)
+			}
+		}()
+	}
+	stop := make(chan struct{})
+	if q.tty {
+		go func() {
+			ticker := time.NewTicker(barInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-ticker.C:
+					q.mu.Lock()
+					q.draw()
+					q.mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+
+	q.mu.Lock()
+	q.draw()
+	q.mu.Unlock()
+	failed := 0
+	for _, f := range q.files {
+		if f.state == queueFailed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		fatalf("%d of %d downloads failed", failed, len(q.files))
+	}
+	pipe.enter(stateDone)
+}
+
+// findAll browses the network for queueBrowse and returns the files
+// username shares that match, the newest generation of each unless gen
+// asks for another.
+func findAll(username, iface string, flt *filter.Filter, gen int) []*queued {
+	ctx, cancel := context.WithTimeout(context.Background(), queueBrowse)
+	defer cancel()
+	entries, err := discovery.Browse(ctx)
+	if err != nil {
+		fatal("Failed to browse: ", err)
+	}
+	var files []*queued
+	byName := map[string]*queued{}
+	for entry := range entries {
+		user, err := getUserName(entry)
+		if err != nil || user != username || txtValue(entry, transfer.RoleKey) != "" {
+			continue
+		}
+		if gen != 0 && generation(entry) != gen {
+			continue
+		}
+		if flt != nil && !flt.Match(shareFields(entry, iface)) {
+			continue
+		}
+		name := fileName(entry)
+		if f, ok := byName[name]; ok {
+			if generation(entry) > generation(f.entry) {
+				f.entry = entry
+			}
+			continue
+		}
+		f := &queued{entry: entry, name: name, size: -1, state: queueWaiting}
+		byName[name] = f
+		files = append(files, f)
+	}
+	return files
+}
+
+// download runs a pop for f, following its progress from its -json events.
+func (q *queue) download(f *queued) {
+	q.update(f, func() { f.state = queueDownloading })
+	args, given := givenFlags("all", "concurrency", "json", "filter", "debug-bundle")
+	if !given["on-cancel"] {
+		// The pops of the queue would all ask at once.
+		args = append(args, "-on-cancel=keep")
+	}
+	args = append(args, "-json", "-instance", f.entry.Instance, q.username)
+	cmd := command(args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		q.update(f, func() { f.state, f.message = queueFailed, err.Error() })
+		return
+	}
+	err = cmd.Start()
+	if err != nil {
+		q.update(f, func() { f.state, f.message = queueFailed, err.Error() })
+		return
+	}
+	sc := bufio.NewScanner(out)
+	for sc.Scan() {
+		if events != nil {
+			eventsMu.Lock()
+			os.Stdout.Write(append(sc.Bytes(), '\n'))
+			eventsMu.Unlock()
+		}
+		var e event
+		if json.Unmarshal(sc.Bytes(), &e) != nil {
+			continue
+		}
+		q.update(f, func() {
+			switch e.Event {
+			case eventStarted, eventProgress:
+				f.bytes = e.Bytes
+				if e.Size > 0 {
+					f.size = e.Size
+				}
+			case eventDone:
+				f.state = queueDone
+				if e.Size > 0 {
+					f.size = e.Size
+				}
+				f.bytes = f.size
+			case eventSkipped:
+				f.state = queueSkipped
+			case eventError:
+				f.message = e.Message
+			}
+		})
+	}
+	err = cmd.Wait()
+	q.update(f, func() {
+		if err == nil && f.state == queueDownloading {
+			f.state = queueDone
+		}
+		if err != nil {
+			f.state = queueFailed
+			if f.message == "" {
+				f.message = strings.TrimSpace(lastLine(stderr.String()))
+			}
+		}
+	})
+}
+
+// lastLine returns the last line of s.
+func lastLine(s string) string {
+	s = strings.TrimRight(s, "\n")
+	return s[strings.LastIndex(s, "\n")+1:]
+}
+
+// update changes f with change, telling when it is over.
+func (q *queue) update(f *queued, change func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	before := f.state
+	change()
+	if f.state == before {
+		return
+	}
+	var line string
+	switch f.state {
+	case queueDone:
+		line = "Downloaded " + f.name
+	case queueSkipped:
+		line = "Skipped " + f.name
+	case queueFailed:
+		line = "Failed to download " + f.name + ": " + f.message
+	default:
+		return
+	}
+	q.clear()
+	fmt.Fprintln(msg, line)
+	q.draw()
+}
+
+// clear erases the bars drawn last.
+func (q *queue) clear() {
+	if q.drawn > 0 {
+		fmt.Fprintf(os.Stderr, "\x1b[%dA\x1b[J", q.drawn)
+		q.drawn = 0
+	}
+}
+
+// draw redraws a bar per file being downloaded and the overall progress
+// below them, when stderr is a terminal.
+func (q *queue) draw() {
+	if !q.tty {
+		return
+	}
+	width, _, err := term.GetSize(int(os.Stderr.Fd()))
+	if err != nil {
+		width = 80
+	}
+	var lines []string
+	var done, total, files int64
+	known := true
+	for _, f := range q.files {
+		if f.state == queueSkipped || f.state == queueFailed {
+			// Not downloaded, and left out of the bytes to download.
+			files++
+			continue
+		}
+		if f.size < 0 {
+			known = false
+		} else {
+			total += f.size
+		}
+		switch f.state {
+		case queueDownloading:
+			lines = append(lines, fileBar(f, width))
+			done += f.bytes
+		case queueDone:
+			files++
+			done += f.bytes
+		}
+	}
+	line := fmt.Sprintf("%d of %d files, %s", files, len(q.files), units.Bytes(done))
+	elapsed := time.Since(q.start).Seconds()
+	rate := float64(done) / elapsed
+	if known {
+		line += " of " + units.Bytes(total)
+		if total > 0 {
+			line += fmt.Sprintf(" (%.0f%%)", float64(done)*100/float64(total))
+		}
+	}
+	if elapsed >= 1 && rate >= 1 {
+		line += ", " + units.Bytes(int64(rate)) + "/s"
+		if known && total > done {
+			left := time.Duration(float64(total-done) / rate * float64(time.Second))
+			line += ", " + left.Round(time.Second).String() + " left"
+		}
+	}
+	lines = append(lines, line)
+	q.clear()
+	for _, l := range lines {
+		fmt.Fprintln(os.Stderr, prompt.Clamp(l, width))
+	}
+	q.drawn = len(lines)
+}
+
+// fileBar returns the progress bar line of f.
+func fileBar(f *queued, width int) string {
+	if f.size <= 0 {
+		return f.name + " " + units.Bytes(f.bytes)
+	}
+	fraction := float64(f.bytes) / float64(f.size)
+	line := fmt.Sprintf("%s %3.0f%%", f.name, fraction*100)
+	barWidth := width - len(f.name) - 8
+	if barWidth > 40 {
+		barWidth = 40
+	}
+	if barWidth >= 10 {
+		n := int(fraction * float64(barWidth))
+		line += " [" + strings.Repeat("=", n) + strings.Repeat(" ", barWidth-n) + "]"
+	}
+	return line
+}
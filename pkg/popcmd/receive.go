@@ -0,0 +1,353 @@
+package popcmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/discovery"
+	"github.com/yifu/pushpop/pkg/hashing"
+	"github.com/yifu/pushpop/pkg/quarantine"
+	"github.com/yifu/pushpop/pkg/safename"
+	"github.com/yifu/pushpop/pkg/tempfile"
+	"github.com/yifu/pushpop/pkg/transfer"
+	"github.com/yifu/pushpop/pkg/version"
+)
+
+// receiver saves the file a sender uploads with push -to. It takes a single
+// file and refuses the next ones.
+type receiver struct {
+	output, dir string
+	onExists    string
+	noPreserve  bool
+
+	mu sync.Mutex
+	// done is closed once a file was received.
+	done     chan struct{}
+	received bool
+	// accepted maps the names of the uploads seen to their destination,
+	// "" when refused.
+	accepted map[string]string
+	// busy holds the names being uploaded, so that two uploads of the same
+	// name do not write the same .part file.
+	busy map[string]bool
+}
+
+// receive announces that the current user waits for a file and saves the
+// first one pushed to them, then returns where it was saved.
+func receive(output, dir, onExists string, noPreserve bool) string {
+	usr, err := user.Current()
+	if err != nil {
+		fatal(err)
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		fatal(err)
+	}
+	ln, err := net.Listen("tcp", ":0")
+	if err != nil {
+		fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	rc := &receiver{output: output, dir: dir, onExists: onExists, noPreserve: noPreserve, done: make(chan struct{}), accepted: map[string]string{}, busy: map[string]bool{}}
+	srv := limits.Server(rc)
+	go srv.Serve(limits.Listener(ln))
+
+	text := []string{"user=" + usr.Username, transfer.RoleKey + "=" + transfer.RoleReceive}
+	announced, stop := context.WithCancel(context.Background())
+	defer stop()
+	server, err := discovery.Announce(announced, host, nil, port, text)
+	if err != nil {
+		fatal("Failed to announce: ", err)
+	}
+	fmt.Fprintf(msg, "Waiting for a file pushed to %s on port %d.\n", usr.Username, port)
+	pipe.enter(stateDiscover)
+
+	<-rc.done
+	server.Shutdown()
+	// Let the sender read the response before exiting.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.Shutdown(ctx)
+	return received.Path
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("Request", "method", r.Method, "path", r.URL.Path, "addr", r.RemoteAddr, "agent", r.UserAgent())
+	switch r.Method {
+	case http.MethodPut, http.MethodPatch, http.MethodHead:
+	default:
+		w.Header().Set("Allow", "HEAD, PUT, PATCH")
+		http.Error(w, "only HEAD, PUT and PATCH are accepted", http.StatusMethodNotAllowed)
+		return
+	}
+	// The name only ever comes from the last path element, so an upload
+	// cannot escape the destination directory.
+	name, err := safename.Name(r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	meta, err := transfer.ParseMetaHeader(r.Header)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// One upload of a name at a time, and none once a file was received.
+	rc.mu.Lock()
+	if rc.received {
+		rc.mu.Unlock()
+		http.Error(w, "a file was already received", http.StatusGone)
+		return
+	}
+	if rc.busy[name] {
+		rc.mu.Unlock()
+		http.Error(w, "an upload of this name is in progress", http.StatusConflict)
+		return
+	}
+	fn, ok := rc.accept(r, name)
+	if !ok {
+		rc.mu.Unlock()
+		http.Error(w, "file already exists", http.StatusConflict)
+		return
+	}
+	rc.busy[name] = true
+	rc.mu.Unlock()
+	defer func() {
+		rc.mu.Lock()
+		delete(rc.busy, name)
+		rc.mu.Unlock()
+	}()
+	part := tempfile.Part(fn)
+
+	// Interrupted uploads resume like tus: the sender asks for the offset
+	// with HEAD, then sends the rest with PATCH.
+	have := uploadOffset(part, meta)
+	if r.Method == http.MethodHead {
+		w.Header().Set(transfer.OffsetHeader, strconv.FormatInt(have, 10))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	var offset int64
+	if r.Method == http.MethodPatch {
+		offset, err = strconv.ParseInt(r.Header.Get(transfer.OffsetHeader), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid "+transfer.OffsetHeader, http.StatusBadRequest)
+			return
+		}
+		if offset != have {
+			w.Header().Set(transfer.OffsetHeader, strconv.FormatInt(have, 10))
+			http.Error(w, "offset mismatch", http.StatusConflict)
+			return
+		}
+	}
+
+	if err := spaceError(fn, meta.Size-offset); meta.Size >= 0 && err != nil && !ignoreSpace {
+		log.Println("Refusing the upload: ", err)
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	}
+
+	sum, n, err := rc.save(name, fn, meta, offset, r.Body)
+	if err != nil {
+		log.Println("Upload interrupted: ", err)
+		status := http.StatusBadRequest
+		if errors.Is(err, syscall.ENOSPC) {
+			status = http.StatusInsufficientStorage
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if meta.Sum != "" && sum != meta.Sum {
+		report := quarantine.Report{
+			Reason:    "checksum mismatch",
+			Algorithm: meta.Algorithm.Name(),
+			Expected:  meta.Sum,
+			Actual:    sum,
+		}
+		if !quarantineFile(part, report) {
+			os.Remove(part)
+		}
+		recordReceive("checksum mismatch, expected " + meta.Sum)
+		log.Printf("Checksum mismatch: expected %s, got %s", meta.Sum, sum)
+		http.Error(w, "checksum mismatch", http.StatusUnprocessableEntity)
+		return
+	}
+	if meta.Sum != "" {
+		fmt.Fprintln(msg, "Verified", meta.Algorithm.Name(), sum)
+	} else {
+		log.Println("The sender sent no checksum - skipping verification.")
+	}
+
+	// Of uploads of different names, the first one complete is kept.
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.received {
+		http.Error(w, "a file was already received", http.StatusGone)
+		return
+	}
+	received.Name = name
+	received.Path = fn
+	received.Size = n
+	received.Algorithm = meta.Algorithm.Name()
+	received.Sum = sum
+	err = saving.Finalize(part, fn)
+	if err != nil {
+		os.Remove(part)
+		recordReceive(err.Error())
+		log.Println(err)
+		http.Error(w, "unable to save file", http.StatusInternalServerError)
+		return
+	}
+	if !rc.noPreserve {
+		preserve(fn, meta)
+	}
+
+	w.Header().Set(transfer.HashHeader(meta.Algorithm), sum)
+	w.WriteHeader(http.StatusCreated)
+	rc.received = true
+	finish()
+	close(rc.done)
+}
+
+// accept returns where the upload called name from r is saved, or false
+// when it is refused. The user is only asked about an existing file the
+// first time, not again for each part of a resumed upload.
+func (rc *receiver) accept(r *http.Request, name string) (string, bool) {
+	if fn, ok := rc.accepted[name]; ok {
+		return fn, fn != ""
+	}
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		addr = r.RemoteAddr
+	}
+	received.Time = time.Now()
+	received.Addr = addr
+	received.Agent = version.Peer(r.UserAgent())
+	received.Name = name
+	emit(event{Event: eventDiscovered, Addr: addr, Name: name})
+
+	fn, ok := resolveExisting(destination(name, rc.output, rc.dir), rc.onExists)
+	if !ok {
+		fmt.Fprintln(msg, "Refusing", fn, "which already exists")
+		emit(event{Event: eventSkipped, Name: name, Path: fn})
+		rc.accepted[name] = ""
+		return "", false
+	}
+	received.Path = fn
+	rc.accepted[name] = fn
+	return fn, true
+}
+
+// save writes body, the file called name described by meta from offset
+// on, to the .part file of fn and returns the checksum and size of the
+// whole file. The .part file is kept when the upload is interrupted, so
+// that it can resume.
+func (rc *receiver) save(name, fn string, meta transfer.Meta, offset int64, body io.Reader) (string, int64, error) {
+	part := tempfile.Part(fn)
+	f, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	if offset == 0 {
+		saveUploadState(part, meta)
+	}
+	err = f.Truncate(offset)
+	if err != nil {
+		return "", 0, err
+	}
+	err = preallocate(f, meta.Size)
+	if err != nil {
+		return "", 0, err
+	}
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		return "", 0, err
+	}
+
+	pipe.enter(stateDownload)
+	emit(event{Event: eventStarted, Name: name, Path: fn, Bytes: offset, Size: meta.Size})
+	h := meta.Algorithm.New()
+	var w io.Writer = f
+	if offset == 0 {
+		w = io.MultiWriter(f, h)
+	}
+	prog := startProgress(name, 0, meta.Size-offset)
+	n, err := bufferSize.Copy(w, prog.reader(body), 0)
+	prog.finish()
+	if err != nil {
+		return "", 0, err
+	}
+	if meta.Size >= 0 && offset+n != meta.Size {
+		return "", 0, fmt.Errorf("Received %d bytes out of %d", offset+n, meta.Size)
+	}
+	err = f.Close()
+	if err != nil {
+		return "", 0, err
+	}
+	removeUploadState(part)
+	sum := hashing.Hex(h)
+	if offset > 0 {
+		sum, err = hashFile(meta.Algorithm, part)
+		if err != nil {
+			return "", 0, err
+		}
+	}
+	return sum, offset + n, nil
+}
+
+// uploadStatePath returns the file describing the upload a .part file is
+// for, so that it only resumes an upload of the same file.
+func uploadStatePath(part string) string {
+	return part + ".upload"
+}
+
+// uploadState describes meta in the state file of an upload.
+func uploadState(meta transfer.Meta) string {
+	return fmt.Sprintf("%s %s %d\n", meta.Algorithm.Name(), meta.Sum, meta.Size)
+}
+
+// saveUploadState records which file part is for. Uploads without a
+// checksum or size cannot be told apart, and do not resume.
+func saveUploadState(part string, meta transfer.Meta) {
+	removeUploadState(part)
+	if meta.Sum == "" || meta.Size < 0 {
+		return
+	}
+	err := os.WriteFile(uploadStatePath(part), []byte(uploadState(meta)), 0644)
+	if err != nil {
+		log.Println("Unable to save the upload state, it will not resume: ", err)
+	}
+}
+
+func removeUploadState(part string) {
+	os.Remove(uploadStatePath(part))
+}
+
+// uploadOffset returns how much of the file described by meta part holds,
+// 0 unless it is left from an upload of that same file.
+func uploadOffset(part string, meta transfer.Meta) int64 {
+	state, err := os.ReadFile(uploadStatePath(part))
+	if err != nil || string(state) != uploadState(meta) || meta.Sum == "" {
+		return 0
+	}
+	fi, err := os.Stat(part)
+	if err != nil || fi.Size() > meta.Size {
+		return 0
+	}
+	return fi.Size()
+}
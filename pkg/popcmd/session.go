@@ -0,0 +1,130 @@
+package popcmd
+
+import (
+	"log"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/grandcat/zeroconf"
+	"github.com/yifu/pushpop/pkg/transfer"
+)
+
+// session describes the share at sessionOf, as its sender tells at
+// transfer.SessionPath. It is nil for senders that predate it, whose
+// headers and announcement are then all there is to go by.
+var (
+	session   *transfer.Session
+	sessionOf string
+)
+
+// fetchSession returns the description of the share at url, fetched the
+// first time it is asked for, or nil.
+func fetchSession(url string) *transfer.Session {
+	if url == sessionOf {
+		return session
+	}
+	session, sessionOf = nil, url
+	s, err := client.FetchSession(url)
+	if err != nil {
+		if err != transfer.ErrNoSession {
+			slog.Debug("Unable to fetch the share description", "url", url, "err", err)
+		}
+		return nil
+	}
+	slog.Debug("Share", "version", s.Version, "user", s.User, "host", s.Host, "name", s.Files[0].Name,
+		"resume", s.Capabilities.Resume, "compression", s.Capabilities.Compression, "tls", s.Capabilities.TLS)
+	session = &s
+	return session
+}
+
+// sessionMeta returns what the description of the share at url tells
+// about its file, false when there is none.
+func sessionMeta(url string) (transfer.Meta, bool) {
+	s := fetchSession(url)
+	if s == nil {
+		return transfer.Meta{}, false
+	}
+	meta, err := s.Files[0].Meta()
+	if err != nil {
+		slog.Debug("Unusable share description", "err", err)
+		return transfer.Meta{}, false
+	}
+	return meta, true
+}
+
+// senderMeta returns what the sender at url tells about its file, having
+// answered resp: its share description when it has one, the headers of
+// resp otherwise.
+func senderMeta(url string, resp *http.Response) (transfer.Meta, error) {
+	if meta, ok := sessionMeta(url); ok {
+		return meta, nil
+	}
+	return transfer.ParseMeta(resp)
+}
+
+// announced is what the announcement of the share being downloaded tells
+// about its sender, nil when it does not, as with -url or senders that
+// predate transfer.VersionKey.
+var announced *transfer.Capabilities
+
+// announce takes note of what the announcement of the share tells about its
+// sender.
+func announce(entry *zeroconf.ServiceEntry) {
+	v := txtValue(entry, transfer.VersionKey)
+	if v == "" {
+		return
+	}
+	n, _ := strconv.Atoi(v)
+	checkVersion(n)
+	caps := transfer.ParseCapabilities(txtValue(entry, transfer.CapsKey))
+	announced = &caps
+}
+
+// versionOnce makes checkVersion tell only once.
+var versionOnce sync.Once
+
+// checkVersion tells when the sender speaks a later version v of the
+// protocol than pop.
+func checkVersion(v int) {
+	if v <= transfer.ProtocolVersion {
+		return
+	}
+	versionOnce.Do(func() {
+		log.Printf("The sender speaks version %d of the protocol, and pop version %d: pop only uses what both support, update it for the rest.", v, transfer.ProtocolVersion)
+	})
+}
+
+// capabilities returns what the sender at url supports, as its share
+// description, pinned manifest or announcement tells, and false when none
+// does: the sender predates them, and pop finds out as it goes.
+func capabilities(url string) (transfer.Capabilities, bool) {
+	if s := fetchSession(url); s != nil {
+		checkVersion(s.Version)
+		return s.Capabilities, true
+	}
+	if pinned != nil && pinned.Version > 0 {
+		return transfer.ParseCapabilities(pinned.Caps), true
+	}
+	if announced != nil {
+		return *announced, true
+	}
+	return transfer.Capabilities{}, false
+}
+
+// resumable reports whether the sender at url serves ranges, as far as
+// pop can tell before asking for one.
+func resumable(url string) bool {
+	caps, ok := capabilities(url)
+	return !ok || caps.Resume
+}
+
+// compressible reports whether the sender at url compresses the file
+// called name for receivers that ask.
+func compressible(url, name string) bool {
+	if caps, ok := capabilities(url); ok {
+		return caps.Compression
+	}
+	return transfer.Compressible(name)
+}
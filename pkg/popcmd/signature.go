@@ -0,0 +1,157 @@
+package popcmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/grandcat/zeroconf"
+	"github.com/yifu/pushpop/pkg/hashing"
+	"github.com/yifu/pushpop/pkg/identity"
+	"github.com/yifu/pushpop/pkg/quarantine"
+	"github.com/yifu/pushpop/pkg/transfer"
+)
+
+// allowUnsigned is the -allow-unsigned flag: download shares of a user
+// with a pinned key even when they are not signed, as directories and
+// streams are not.
+var allowUnsigned bool
+
+// signed is the signature of the share being downloaded, as checked by
+// checkSender, and signedData its encoding, nil when it is not signed.
+var (
+	signed     *transfer.Signature
+	signedData []byte
+)
+
+// checkSender checks, before downloading, that the share at url announced
+// by entry as username's is signed by a key pinned for username, pinning
+// it when pop never received anything signed from them: someone else
+// announcing as username cannot sign as them. Once a key is pinned, shares
+// of username that are not signed by it are refused, unless
+// allowUnsigned. The signature must be of the file the sender serves, so
+// that an old signature cannot be replayed with another file.
+func checkSender(url, username string, entry *zeroconf.ServiceEntry) {
+	signed, signedData = nil, nil
+	if username == "" {
+		return
+	}
+	known, err := identity.Known(username)
+	if err != nil {
+		fatal("Unable to read the known senders: ", err)
+	}
+	signer := txtValue(entry, transfer.SignerKey)
+	if signer == "" && len(known) == 0 {
+		return
+	}
+	// push announces the signer once the signature is built; the signature
+	// of a share of a known user that does not announce one yet is waited
+	// for, and required all the same.
+	s, data, err := client.FetchSignature(url)
+	if err != nil {
+		if len(known) == 0 {
+			log.Printf("Warning: unable to check the signature, not pinning the key of %s: %v", username, err)
+			return
+		}
+		if allowUnsigned {
+			log.Printf("Warning: the share is not signed, so it cannot be told from someone else announcing as %s: %v", username, err)
+			return
+		}
+		fatalCodef(exitMismatch, "The share is not signed by the key pop knows for %s, someone else may be announcing as them (%v). Use -allow-unsigned to download it anyway, as for directories and streams, which are not signed.", username, err)
+	}
+	if signer != "" && s.Signer() != signer {
+		fatalCodef(exitMismatch, "The share is signed by %s, not by the announced %s", s.Signer(), signer)
+	}
+	checkServed(url, s.Manifest)
+	signed, signedData = &s, data
+	if len(known) == 0 {
+		err = identity.Trust(username, s.Signer())
+		if err != nil {
+			log.Println("Unable to pin the key of", username+": ", err)
+			return
+		}
+		fmt.Fprintf(msg, "Trusting %s as the key of %s from now on.\n", s.Signer(), username)
+		return
+	}
+	for _, fp := range known {
+		if fp == s.Signer() {
+			return
+		}
+	}
+	path, _ := identity.KnownPath()
+	fatalCodef(exitMismatch, "The share is signed by %s, not by the key pop knows for %s: someone else may be announcing as them. If %s shares from another machine or changed keys, add the line \"%s %s\" to %s.",
+		s.Signer(), username, username, username, s.Signer(), path)
+}
+
+// checkServed checks that m, the manifest a signature is of, describes the
+// file the sender at url serves: the pinned manifest when there is one,
+// what the sender tells about its file otherwise.
+func checkServed(url string, m transfer.Manifest) {
+	var served transfer.Manifest
+	if pinned != nil {
+		served = *pinned
+	} else {
+		meta, err := headMeta(url)
+		if err != nil {
+			fatal("Unable to check the signature against the share: ", err)
+		}
+		if meta.Sum == "" {
+			meta.Sum, err = client.FetchHash(url, meta.Algorithm)
+			if err != nil {
+				fatal("Unable to check the signature against the share: ", err)
+			}
+		}
+		served = transfer.NewManifest(received.Name, meta)
+	}
+	if m.Name != received.Name || m.Name != served.Name || m.Size != served.Size ||
+		m.Algorithm != served.Algorithm || m.Sum != served.Sum {
+		fatalCodef(exitMismatch, "The signature is of another file than the one shared")
+	}
+}
+
+// checkSigned checks, before the download is kept, that part, received
+// from url with sum, is the file the share is signed for, if it is. A
+// part that is not is set aside for inspection. part is "" for streams
+// that are not saved, of received.Size bytes.
+func checkSigned(url, part string, alg hashing.Algorithm, sum string) {
+	if signed == nil {
+		return
+	}
+	size := received.Size
+	if part != "" {
+		fi, err := os.Stat(part)
+		if err != nil {
+			fatal(err)
+		}
+		size = fi.Size()
+	}
+	m := signed.Manifest
+	if m.Sum == sum && m.Algorithm == alg.Name() && m.Size == size {
+		return
+	}
+	recordReceive("signature mismatch, expected " + m.Sum)
+	if part != "" {
+		quarantineFile(part, quarantine.Report{
+			URL:       url,
+			Reason:    "signature mismatch",
+			Algorithm: alg.Name(),
+			Expected:  m.Sum,
+			Actual:    sum,
+		})
+	}
+	fatalCodef(exitMismatch, "The signature is of another file than the one received: expected %s %s, got %s", m.Algorithm, m.Sum, sum)
+}
+
+// keepSignature stores the signature of the file received as fn next to
+// it, for pushpop verify, when the share is signed.
+func keepSignature(fn string) {
+	if signed == nil {
+		return
+	}
+	sig := fn + transfer.SignatureSuffix
+	err := os.WriteFile(sig, signedData, 0644)
+	if err != nil {
+		fatal("Unable to save the signature: ", err)
+	}
+	fmt.Fprintln(msg, "Signed by", signed.Signer()+", signature saved to", sig)
+}
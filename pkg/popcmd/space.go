@@ -0,0 +1,70 @@
+package popcmd
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/yifu/pushpop/pkg/diskspace"
+	"github.com/yifu/pushpop/pkg/tempfile"
+	"github.com/yifu/pushpop/pkg/units"
+)
+
+// ignoreSpace is the -ignore-space flag: download even when the file does
+// not seem to fit, only warning.
+var ignoreSpace bool
+
+// spaceError returns an error telling how much is missing when need more
+// bytes do not fit next to fn, nil when they do or when there is no telling.
+func spaceError(fn string, need int64) error {
+	if need <= 0 {
+		return nil
+	}
+	dir := filepath.Dir(fn)
+	free, err := diskspace.Free(dir)
+	if err != nil {
+		slog.Debug("Unable to tell the free space", "dir", dir, "err", err)
+		return nil
+	}
+	if need <= free {
+		return nil
+	}
+	return fmt.Errorf("Not enough space in %s: %s more needed, %s free", dir, units.Bytes(need), units.Bytes(free))
+}
+
+// checkSpace returns an error, before downloading need more bytes to fn,
+// when they do not fit, unless -ignore-space makes it a warning.
+func checkSpace(fn string, need int64) error {
+	err := spaceError(fn, need)
+	if err == nil {
+		return nil
+	}
+	if ignoreSpace {
+		slog.Warn(err.Error() + ", downloading anyway")
+		return nil
+	}
+	return fmt.Errorf("%v (use -ignore-space to try anyway)", err)
+}
+
+// preallocate reserves the first size bytes of the .part file f, so that
+// the file is less fragmented and a disk too full for it fails now rather
+// than near the end. It only returns an error when the disk is too full,
+// unless -ignore-space; the file is left to grow as it is written where
+// preallocating is not supported.
+func preallocate(f *os.File, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	err := tempfile.Preallocate(f, size)
+	if err == nil || errors.Is(err, errors.ErrUnsupported) {
+		return nil
+	}
+	if errors.Is(err, syscall.ENOSPC) && !ignoreSpace {
+		return fmt.Errorf("Unable to reserve %s for %s: %w (use -ignore-space to try anyway)", units.Bytes(size), f.Name(), err)
+	}
+	slog.Debug("Unable to preallocate", "path", f.Name(), "err", err)
+	return nil
+}
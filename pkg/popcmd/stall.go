@@ -0,0 +1,77 @@
+package popcmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// stallTimeout is the -stall-timeout flag: how long a download may receive
+// nothing before it is considered stalled and retried, 0 to wait forever.
+// A slow download still receives something and is left alone.
+var stallTimeout = 20 * time.Second
+
+// errStalled is returned by the reads of a stalled download.
+var errStalled = errors.New("Download stalled")
+
+// stallReader fails its reads once its body stalled, see guardStall.
+type stallReader struct {
+	body io.ReadCloser
+
+	mu      sync.Mutex
+	last    time.Time
+	stalled bool
+	done    chan struct{}
+}
+
+// guardStall returns body, which is closed once nothing was read from it
+// for stallTimeout so that the download fails with errStalled and goes
+// through the usual retries, instead of waiting for a sender that is gone
+// while the ETA grows. stop ends the watch once done reading.
+func guardStall(body io.ReadCloser) (r io.Reader, stop func()) {
+	if stallTimeout <= 0 {
+		return body, func() {}
+	}
+	s := &stallReader{body: body, last: time.Now(), done: make(chan struct{})}
+	go s.watch()
+	var once sync.Once
+	return s, func() { once.Do(func() { close(s.done) }) }
+}
+
+func (s *stallReader) Read(buf []byte) (int, error) {
+	n, err := s.body.Read(buf)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n > 0 {
+		s.last = time.Now()
+	}
+	if err != nil && s.stalled {
+		err = errStalled
+	}
+	return n, err
+}
+
+// watch closes the body once it stalled.
+func (s *stallReader) watch() {
+	ticker := time.NewTicker(stallTimeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		s.mu.Lock()
+		idle := time.Since(s.last)
+		s.stalled = idle >= stallTimeout
+		s.mu.Unlock()
+		if idle >= stallTimeout {
+			fmt.Fprintf(msg, "\nStalled, nothing received for %v - retrying.\n", idle.Round(time.Second))
+			emit(event{Event: eventStalled, Name: received.Name})
+			s.body.Close()
+			return
+		}
+	}
+}
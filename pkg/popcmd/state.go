@@ -0,0 +1,82 @@
+package popcmd
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// state is a step of pop's download pipeline. A download walks through them
+// in order; pipe records where it currently is.
+type state int
+
+const (
+	stateIdle state = iota
+	stateDiscover
+	stateConnect
+	stateDownload
+	stateRename
+	stateFetchHash
+	stateVerify
+	stateAck
+	stateDone
+)
+
+var stateNames = [...]string{
+	stateIdle:      "idle",
+	stateDiscover:  "discover",
+	stateConnect:   "connect",
+	stateDownload:  "download",
+	stateRename:    "rename",
+	stateFetchHash: "fetch-hash",
+	stateVerify:    "verify",
+	stateAck:       "ack",
+	stateDone:      "done",
+}
+
+func (s state) String() string {
+	return stateNames[s]
+}
+
+// observer is told about every transition, with the time spent in the state
+// being left.
+type observer func(from, to state, elapsed time.Duration)
+
+// pipeline tracks the current state of the download so that a stuck transfer
+// can be traced to the step it is stuck in.
+type pipeline struct {
+	mu        sync.Mutex
+	cur       state
+	since     time.Time
+	observers []observer
+}
+
+var pipe = &pipeline{since: time.Now()}
+
+// enter moves the pipeline to s and notifies the observers.
+func (p *pipeline) enter(s state) {
+	p.mu.Lock()
+	from, elapsed := p.cur, time.Since(p.since)
+	p.cur, p.since = s, time.Now()
+	observers := p.observers
+	p.mu.Unlock()
+
+	slog.Debug("State", "from", from, "to", s, "elapsed", elapsed.Round(time.Millisecond))
+	for _, o := range observers {
+		o(from, s, elapsed)
+	}
+}
+
+// current returns the current state and how long the pipeline has been in it.
+func (p *pipeline) current() (state, time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.cur, time.Since(p.since)
+}
+
+// observe registers o for all future transitions.
+func (p *pipeline) observe(o observer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.observers = append(p.observers, o)
+}
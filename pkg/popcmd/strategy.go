@@ -0,0 +1,315 @@
+package popcmd
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/yifu/pushpop/pkg/hashing"
+	"github.com/yifu/pushpop/pkg/tempfile"
+	"github.com/yifu/pushpop/pkg/transfer"
+)
+
+// Ways of downloading a file, picked by -strategy.
+const (
+	// strategyAuto picks one of the others, see pickStrategy.
+	strategyAuto = "auto"
+	// strategyStream downloads the file as is with a single request. It is
+	// the only one that resumes.
+	strategyStream = "stream"
+	// strategyCompressed asks the sender to compress the file on the fly.
+	strategyCompressed = "compressed"
+	// strategyParallel downloads parallelStreams ranges of the file at once.
+	strategyParallel = "parallel"
+	// strategyDelta patches an older version of the file already there,
+	// only downloading the blocks that changed.
+	strategyDelta = "delta"
+	// strategySwarm downloads parts of the file from the other receivers of
+	// a share announced with push -swarm, and serves them the parts it has.
+	strategySwarm = "swarm"
+)
+
+// strategies lists the valid -strategy values.
+var strategies = []string{strategyAuto, strategyStream, strategyCompressed, strategyParallel, strategyDelta, strategySwarm}
+
+// strategy is the -strategy flag.
+var strategy = strategyAuto
+
+// Thresholds of the automatic strategy.
+const (
+	// compressMin is the smallest file worth compressing.
+	compressMin = 1 << 20
+	// parallelMin is the smallest file worth downloading in ranges.
+	parallelMin = 256 << 20
+	// fastLink is the throughput, in bytes per second, above which
+	// compressing costs more time than it saves.
+	fastLink = 100 << 20
+)
+
+// parallelStreams is how many ranges strategyParallel downloads at once.
+const parallelStreams = 4
+
+// linkRate is the throughput to the sender measured by -probe, in bytes per
+// second, or 0 when it was not measured.
+var linkRate float64
+
+// validStrategy reports whether s is a -strategy value.
+func validStrategy(s string) bool {
+	for _, v := range strategies {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// pickStrategy returns the strategy to download the file called name from
+// url into fn with: the -strategy flag, or with auto, a guess from the
+// file's type and size, whether an older version is in fn and, when -probe
+// measured it, the speed of the link. It also returns what the sender told
+// about the file, when it had to ask.
+func pickStrategy(url, name, fn string) (string, transfer.Meta) {
+	if strategy == strategyStream || strategy == strategyCompressed {
+		return strategy, transfer.Meta{}
+	}
+	meta, ranges := transfer.Meta{}, false
+	var err error
+	if m, ok := sessionMeta(url); ok {
+		meta, ranges = m, resumable(url)
+	} else {
+		meta, ranges, err = rangeMeta(url)
+	}
+	if err != nil {
+		log.Println("Unable to ask the sender about the file, downloading it as is: ", err)
+		return strategyStream, meta
+	}
+	if strategy == strategyParallel || strategy == strategyDelta || strategy == strategySwarm {
+		if !ranges {
+			log.Println("The sender does not serve ranges, downloading the file as is.")
+			return strategyStream, meta
+		}
+		if strategy == strategyDelta && !hasOlder(fn, meta) {
+			log.Println("No older version of the file to patch, downloading it as is.")
+			return strategyStream, meta
+		}
+		if strategy == strategySwarm && swarmID == "" {
+			log.Println("The sender does not allow swarming, downloading the file as is.")
+			return strategyStream, meta
+		}
+		return strategy, meta
+	}
+
+	switch {
+	case ranges && swarmID != "":
+		return strategySwarm, meta
+	case ranges && hasOlder(fn, meta):
+		return strategyDelta, meta
+	case meta.Size >= compressMin && compressible(url, name) && (linkRate == 0 || linkRate < fastLink):
+		return strategyCompressed, meta
+	case meta.Size >= parallelMin && ranges:
+		return strategyParallel, meta
+	}
+	return strategyStream, meta
+}
+
+// rangeMeta asks the sender at url for the first byte of its file, which
+// tells what it is and whether the sender serves ranges, without making it
+// compute its checksum as a HEAD request would.
+func rangeMeta(url string) (transfer.Meta, bool, error) {
+	req := newRequest(url)
+	req.Header.Set("Range", "bytes=0-0")
+	resp, err := fetch(req)
+	if err != nil {
+		return transfer.Meta{}, false, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return transfer.Meta{}, false, fmt.Errorf("Unexpected status: %s", resp.Status)
+	}
+	meta, err := transfer.ParseMeta(resp)
+	if err != nil {
+		return meta, false, err
+	}
+	if meta.Size < 0 {
+		if i := strings.LastIndexByte(resp.Header.Get("Content-Range"), '/'); i >= 0 {
+			size, err := strconv.ParseInt(resp.Header.Get("Content-Range")[i+1:], 10, 64)
+			if err == nil {
+				meta.Size = size
+			}
+		}
+	}
+	return meta, resp.StatusCode == http.StatusPartialContent && meta.Size > 0, nil
+}
+
+// downloadWith makes one attempt at downloading url into fn with strategy s,
+// other than strategyStream, starting from an empty .part file.
+func downloadWith(s, url, fn string, meta transfer.Meta) (transfer.Meta, string, error) {
+	log.Println("Downloading with the", s, "strategy.")
+	// The size is only known ahead of the compressed download when the
+	// strategy was picked for it.
+	if err := checkSpace(fn, meta.Size); err != nil {
+		fatalCodef(exitNoSpace, "%v", err)
+	}
+	part := tempfile.Part(fn)
+	removeHashState(part)
+	f, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		fatal(err)
+	}
+	defer f.Close()
+	if err := preallocate(f, meta.Size); err != nil {
+		f.Close()
+		os.Remove(part)
+		fatalCodef(exitNoSpace, "%v", err)
+	}
+
+	var sum string
+	switch s {
+	case strategyCompressed:
+		meta, sum, err = downloadCompressed(f, url, fn)
+	case strategyParallel:
+		sum, err = downloadParallel(f, url, fn, meta)
+	case strategyDelta:
+		sum, err = downloadDelta(f, url, fn, meta)
+	case strategySwarm:
+		sum, err = downloadSwarm(f, url, fn, meta)
+	}
+	if err != nil {
+		return meta, "", err
+	}
+	err = f.Close()
+	if err != nil {
+		fatal(err)
+	}
+	checkSigned(url, part, meta.Algorithm, sum)
+	pipe.enter(stateRename)
+	err = saving.Finalize(part, fn)
+	if err != nil {
+		fatal(err)
+	}
+	return meta, sum, nil
+}
+
+// downloadCompressed downloads url into f, asking for gzip. A sender that
+// does not compress the file sends it as is, which is just as good.
+func downloadCompressed(f *os.File, url, fn string) (transfer.Meta, string, error) {
+	req := newRequest(url)
+	transfer.AcceptGzip(req)
+	resp, err := fetch(req)
+	if err != nil {
+		return transfer.Meta{}, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return transfer.Meta{}, "", fmt.Errorf("Unexpected status: %s", resp.Status)
+	}
+	meta, err := senderMeta(url, resp)
+	if err != nil {
+		fatal(err)
+	}
+
+	body, stop := guardStall(resp.Body)
+	defer stop()
+	if transfer.ContentGzip(resp) {
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return meta, "", err
+		}
+		body = zr
+	} else {
+		log.Println("The sender did not compress the file.")
+	}
+
+	pipe.enter(stateDownload)
+	emit(event{Event: eventStarted, Name: received.Name, Path: fn, Size: meta.Size})
+	h := meta.Algorithm.New()
+	prog := startProgress(received.Name, 0, meta.Size)
+	n, err := bufferSize.Copy(io.MultiWriter(f, h), prog.reader(body), linkRate)
+	prog.finish()
+	if err != nil {
+		return meta, "", err
+	}
+	if meta.Size >= 0 && n != meta.Size {
+		return meta, "", fmt.Errorf("Received %d bytes out of %d", n, meta.Size)
+	}
+	return meta, hashing.Hex(h), nil
+}
+
+// downloadParallel downloads the meta.Size bytes of url into f in
+// parallelStreams ranges at once, then hashes the result.
+func downloadParallel(f *os.File, url, fn string, meta transfer.Meta) (string, error) {
+	err := f.Truncate(meta.Size)
+	if err != nil {
+		fatal(err)
+	}
+	pipe.enter(stateDownload)
+	emit(event{Event: eventStarted, Name: received.Name, Path: fn, Size: meta.Size})
+	prog := startProgress(received.Name, 0, meta.Size)
+
+	piece := (meta.Size + parallelStreams - 1) / parallelStreams
+	errs := make(chan error, parallelStreams)
+	for start := int64(0); start < meta.Size; start += piece {
+		end := start + piece
+		if end > meta.Size {
+			end = meta.Size
+		}
+		go func(start, end int64) {
+			errs <- downloadRange(f, url, start, end, prog)
+		}(start, end)
+	}
+	for start := int64(0); start < meta.Size; start += piece {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	prog.finish()
+	if err != nil {
+		return "", err
+	}
+	return hashFile(meta.Algorithm, f.Name())
+}
+
+// downloadRange downloads the bytes from start to end of url into the same
+// place in f, counting them with prog.
+func downloadRange(f *os.File, url string, start, end int64, prog *progress) error {
+	req := newRequest(url)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+	resp, err := fetch(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("Unexpected status for a range: %s", resp.Status)
+	}
+	body, stop := guardStall(resp.Body)
+	defer stop()
+	buf := bufferSize.Buffer(linkRate)
+	off := start
+	for off < end {
+		n, err := body.Read(buf)
+		if int64(n) > end-off {
+			n = int(end - off)
+		}
+		if n > 0 {
+			_, werr := f.WriteAt(buf[:n], off)
+			if werr != nil {
+				return werr
+			}
+			off += int64(n)
+			prog.add(int64(n))
+		}
+		if err == io.EOF && off < end {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,335 @@
+package popcmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+	"github.com/yifu/pushpop/pkg/delta"
+	"github.com/yifu/pushpop/pkg/discovery"
+	"github.com/yifu/pushpop/pkg/tempfile"
+	"github.com/yifu/pushpop/pkg/transfer"
+)
+
+// swarmID identifies the file being downloaded among the receivers of a
+// share announced with push -swarm: the checksum of its pinned manifest.
+// It is empty for other shares.
+var swarmID string
+
+// seedFor is the -seed flag: how long pop goes on serving the parts of a
+// swarmed file once it has all of it.
+var seedFor time.Duration
+
+// swarmChunk is about how much of the file a receiver asks for at once, a
+// whole number of blocks.
+const swarmChunk = 4 << 20
+
+// swarmPeerTries is how many peers are asked for a chunk before the sender.
+const swarmPeerTries = 2
+
+// swarm downloads a file from the sender and from the other receivers that
+// already have parts of it, and serves the parts it has to them. Chunks are
+// fetched in random order, so that receivers starting together soon have
+// different ones to trade, and every block is checked against the sender's
+// block checksums wherever it came from.
+type swarm struct {
+	origin string
+	blocks delta.Blocks
+	// chunk is the size of a chunk, perBlocks blocks.
+	chunk     int64
+	perBlocks int
+	// file is the .part file, opened apart from the one written to so that
+	// it still serves peers once renamed.
+	file *os.File
+
+	mu   sync.Mutex
+	have []bool
+	// peers maps the instance names of the other receivers to their URLs.
+	peers map[string]string
+}
+
+// active is the swarm of this pop, nil when not swarming.
+var active *swarm
+
+// chunks returns how many chunks the file has.
+func (s *swarm) chunks() int {
+	return int((s.blocks.Size + s.chunk - 1) / s.chunk)
+}
+
+// bounds returns the start and end of chunk i in the file.
+func (s *swarm) bounds(i int) (int64, int64) {
+	start := int64(i) * s.chunk
+	end := start + s.chunk
+	if end > s.blocks.Size {
+		end = s.blocks.Size
+	}
+	return start, end
+}
+
+// downloadSwarm downloads the meta.Size bytes of url into f with the help
+// of the other receivers, then hashes the result.
+func downloadSwarm(f *os.File, url, fn string, meta transfer.Meta) (string, error) {
+	blocks, err := client.FetchBlocks(url)
+	if err != nil {
+		return "", err
+	}
+	if blocks.Size != meta.Size {
+		return "", fmt.Errorf("The block checksums describe %d bytes, not %d", blocks.Size, meta.Size)
+	}
+	err = f.Truncate(meta.Size)
+	if err != nil {
+		fatal(err)
+	}
+	file, err := os.Open(tempfile.Part(fn))
+	if err != nil {
+		return "", err
+	}
+	per := swarmChunk / blocks.BlockSize
+	if per < 1 {
+		per = 1
+	}
+	s := &swarm{
+		origin:    url,
+		blocks:    blocks,
+		chunk:     int64(per) * int64(blocks.BlockSize),
+		perBlocks: per,
+		file:      file,
+		peers:     map[string]string{},
+	}
+	s.have = make([]bool, s.chunks())
+	err = s.serve()
+	if err != nil {
+		log.Println("Unable to serve other receivers, only downloading: ", err)
+	}
+	active = s
+
+	pipe.enter(stateDownload)
+	emit(event{Event: eventStarted, Name: received.Name, Path: fn, Size: meta.Size})
+	prog := startProgress(received.Name, 0, meta.Size)
+	todo := make(chan int, s.chunks())
+	order := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for _, i := range order.Perm(s.chunks()) {
+		todo <- i
+	}
+	close(todo)
+	errs := make(chan error, parallelStreams)
+	var fromPeers int64
+	var peersMu sync.Mutex
+	for w := 0; w < parallelStreams; w++ {
+		go func() {
+			for i := range todo {
+				n, peer, err := s.fetch(f, i)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if peer {
+					peersMu.Lock()
+					fromPeers += n
+					peersMu.Unlock()
+				}
+				prog.add(n)
+			}
+			errs <- nil
+		}()
+	}
+	for w := 0; w < parallelStreams; w++ {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	prog.finish()
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(msg, "Got %d%% of the file from other receivers.\n", fromPeers*100/max64(meta.Size, 1))
+	return hashFile(meta.Algorithm, f.Name())
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// fetch gets chunk i into f, from peers that may have it or else from the
+// sender, and returns its size and whether a peer sent it.
+func (s *swarm) fetch(f *os.File, i int) (int64, bool, error) {
+	start, end := s.bounds(i)
+	for _, peer := range s.somePeers(swarmPeerTries) {
+		data, err := s.get(peer+strings.TrimPrefix(transfer.ChunkPath, "/")+strconv.Itoa(i), "")
+		if err != nil || !s.check(i, data) {
+			continue
+		}
+		return int64(len(data)), true, s.store(f, i, data)
+	}
+	data, err := s.get(s.origin, fmt.Sprintf("bytes=%d-%d", start, end-1))
+	if err != nil {
+		return 0, false, err
+	}
+	if !s.check(i, data) {
+		return 0, false, fmt.Errorf("Bytes %d to %d from the sender do not match its block checksums", start, end)
+	}
+	return int64(len(data)), false, s.store(f, i, data)
+}
+
+// get fetches url, or the range of it when not empty.
+func (s *swarm) get(url, byteRange string) ([]byte, error) {
+	req := newRequest(url)
+	want := http.StatusOK
+	if byteRange != "" {
+		req.Header.Set("Range", byteRange)
+		want = http.StatusPartialContent
+	}
+	resp, err := fetch(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != want {
+		return nil, fmt.Errorf("Unexpected status: %s", resp.Status)
+	}
+	body, stop := guardStall(resp.Body)
+	defer stop()
+	return io.ReadAll(io.LimitReader(body, s.chunk+1))
+}
+
+// check reports whether data is chunk i, block by block.
+func (s *swarm) check(i int, data []byte) bool {
+	start, end := s.bounds(i)
+	if int64(len(data)) != end-start {
+		return false
+	}
+	first := i * s.perBlocks
+	for b := first; b < len(s.blocks.Sums) && b < first+s.perBlocks; b++ {
+		off := int64(b-first) * int64(s.blocks.BlockSize)
+		if !s.blocks.Check(b, data[off:off+s.blocks.Len(b)]) {
+			return false
+		}
+	}
+	return true
+}
+
+// store writes chunk i to f and offers it to peers.
+func (s *swarm) store(f *os.File, i int, data []byte) error {
+	start, _ := s.bounds(i)
+	_, err := f.WriteAt(data, start)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.have[i] = true
+	s.mu.Unlock()
+	return nil
+}
+
+// somePeers returns up to n peers picked at random.
+func (s *swarm) somePeers(n int) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var peers []string
+	for _, url := range s.peers {
+		peers = append(peers, url)
+	}
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	if len(peers) > n {
+		peers = peers[:n]
+	}
+	return peers
+}
+
+// serve serves the chunks s has to other receivers, announces them, and
+// looks for the other receivers' announcements.
+func (s *swarm) serve() error {
+	host, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return err
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	go limits.Server(s).Serve(limits.Listener(ln))
+
+	instance := fmt.Sprintf("%s peer %d", host, port)
+	text := []string{transfer.RoleKey + "=" + transfer.RolePeer, transfer.SwarmKey + "=" + swarmID}
+	_, err = discovery.Announce(context.Background(), instance, nil, port, text)
+	if err != nil {
+		ln.Close()
+		return err
+	}
+	entries, err := discovery.BrowseNetwork(context.Background())
+	if err != nil {
+		return err
+	}
+	go s.findPeers(entries, instance)
+	return nil
+}
+
+// findPeers adds the other receivers of the file as they are announced.
+func (s *swarm) findPeers(entries <-chan *zeroconf.ServiceEntry, self string) {
+	for entry := range entries {
+		if txtValue(entry, transfer.RoleKey) != transfer.RolePeer || txtValue(entry, transfer.SwarmKey) != swarmID {
+			continue
+		}
+		instance := discovery.Unescape(entry.Instance)
+		s.mu.Lock()
+		_, known := s.peers[instance]
+		s.mu.Unlock()
+		if instance == self || known {
+			continue
+		}
+		url, _, err := entryURL(entry, "")
+		if err != nil {
+			continue
+		}
+		log.Println("Found another receiver at", url)
+		s.mu.Lock()
+		s.peers[instance] = url
+		s.mu.Unlock()
+	}
+}
+
+// ServeHTTP answers the chunks s has, and 404 for the others.
+func (s *swarm) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	i, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, transfer.ChunkPath))
+	if err != nil || !strings.HasPrefix(r.URL.Path, transfer.ChunkPath) || i < 0 || i >= len(s.have) {
+		http.NotFound(w, r)
+		return
+	}
+	s.mu.Lock()
+	have := s.have[i]
+	s.mu.Unlock()
+	if !have {
+		http.Error(w, "chunk not there yet", http.StatusNotFound)
+		return
+	}
+	start, end := s.bounds(i)
+	slog.Debug("Serving a chunk", "chunk", i, "addr", r.RemoteAddr)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start, 10))
+	io.Copy(w, io.NewSectionReader(s.file, start, end-start))
+}
+
+// seed goes on serving the other receivers for -seed once the file was
+// downloaded with a swarm.
+func seed() {
+	if active == nil || seedFor <= 0 {
+		return
+	}
+	fmt.Fprintf(msg, "Serving the other receivers for %v.\n", seedFor)
+	time.Sleep(seedFor)
+}
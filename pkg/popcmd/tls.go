@@ -0,0 +1,39 @@
+package popcmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/grandcat/zeroconf"
+	"github.com/yifu/pushpop/pkg/transfer"
+)
+
+// useTLS is the -tls flag: download over TLS, from the port the sender
+// announces, checking its certificate against the fingerprint it
+// announces.
+var useTLS bool
+
+// pinCert makes requests over TLS accept the certificate with the
+// fingerprint fp only, push's certificate being self-signed.
+func pinCert(fp string) error {
+	config, err := transfer.PinnedTLS(fp)
+	if err != nil {
+		return err
+	}
+	transport.TLSClientConfig = config
+	return nil
+}
+
+// overTLS returns the HTTPS URL of the share at url announced by entry,
+// pinning the certificate it announces.
+func overTLS(url string, entry *zeroconf.ServiceEntry) (string, error) {
+	fp := txtValue(entry, transfer.CertKey)
+	if fp == "" {
+		return "", fmt.Errorf("The sender announces no TLS certificate, download without -tls")
+	}
+	err := pinCert(fp)
+	if err != nil {
+		return "", err
+	}
+	return "https" + strings.TrimPrefix(url, "http"), nil
+}
@@ -0,0 +1,51 @@
+package popcmd
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/yifu/pushpop/pkg/transfer"
+)
+
+// parseShareURL checks the -url flag, the URL push prints for a share, and
+// returns it ending with a slash as entryURL does, with the sender's IP
+// address or host name and the fingerprint of its certificate, given in
+// the HTTPS URL.
+func parseShareURL(raw string) (string, string, string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", "", fmt.Errorf("Invalid -url: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", "", "", fmt.Errorf("Invalid -url %q, expected one such as http://192.168.1.5:41234/", raw)
+	}
+	var fp string
+	if u.Scheme == "https" {
+		fp = u.Query().Get(transfer.CertParam)
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	u.RawQuery, u.Fragment = "", ""
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		host = ip.String()
+	}
+	return u.String(), host, fp, nil
+}
+
+// urlName returns the name to save the share at url as when the sender
+// does not tell: the last element of its path, or its host.
+func urlName(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "download"
+	}
+	if name := path.Base(parsed.Path); name != "/" && name != "." {
+		return name
+	}
+	return parsed.Hostname()
+}
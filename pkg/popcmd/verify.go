@@ -0,0 +1,140 @@
+package popcmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/yifu/pushpop/pkg/hashing"
+	"github.com/yifu/pushpop/pkg/quarantine"
+	"github.com/yifu/pushpop/pkg/transfer"
+)
+
+// fetchHash returns the sender's checksum for url, or "" when the sender
+// does not publish one. The hash endpoint is only queried when the checksum
+// was not already part of the response headers. A pinned manifest overrides
+// both, and the headers must agree with it.
+func fetchHash(url string, meta transfer.Meta) string {
+	pipe.enter(stateFetchHash)
+	if pinned != nil {
+		if pinned.Algorithm != meta.Algorithm.Name() || (meta.Sum != "" && meta.Sum != pinned.Sum) {
+			fatal("The sender's checksum does not match its manifest")
+		}
+		return pinned.Sum
+	}
+	if meta.Sum != "" {
+		return meta.Sum
+	}
+	remote, err := client.FetchHash(url, meta.Algorithm)
+	if err == transfer.ErrNoHash {
+		log.Println(err, "- skipping verification.")
+		return ""
+	}
+	if err != nil {
+		fatal("Unable to fetch checksum: ", err)
+	}
+	return remote
+}
+
+// check compares the sender's checksum with the local one, then lets the
+// sender know the download is complete.
+func check(url string, alg hashing.Algorithm, remote, local string) {
+	received.Algorithm = alg.Name()
+	received.Sum = local
+	if remote != local {
+		recordReceive("checksum mismatch, expected " + remote)
+		if received.Path != "" {
+			quarantineFile(received.Path, quarantine.Report{
+				URL:       url,
+				Reason:    "checksum mismatch",
+				Algorithm: alg.Name(),
+				Expected:  remote,
+				Actual:    local,
+			})
+		}
+		fatalCodef(exitMismatch, "Checksum mismatch: expected %s, got %s", remote, local)
+	}
+	fmt.Fprintln(msg, "Verified", alg.Name(), local)
+
+	pipe.enter(stateAck)
+	err := client.Ack(url, local)
+	if err != nil {
+		log.Println("Unable to acknowledge: ", err)
+	}
+}
+
+// verify compares the sender's checksum for url with local, the checksum of
+// what was received computed with the sender's algorithm. A sender without a
+// checksum only produces a warning.
+func verify(url string, meta transfer.Meta, local string) {
+	remote := fetchHash(url, meta)
+	if remote == "" {
+		return
+	}
+	pipe.enter(stateVerify)
+	emit(event{Event: eventVerifying, Name: received.Name, Algorithm: meta.Algorithm.Name()})
+	check(url, meta.Algorithm, remote, local)
+}
+
+// verifyOnly compares fn with the file the sender at url shares, without
+// downloading anything, and exits with status 1 when they differ.
+func verifyOnly(url, fn string) {
+	meta, err := headMeta(url)
+	if err != nil {
+		fatal(err)
+	}
+	remote := fetchHash(url, meta)
+	if remote == "" {
+		fatal("The sender publishes no checksum to verify against")
+	}
+	pipe.enter(stateVerify)
+	emit(event{Event: eventVerifying, Name: received.Name, Path: fn, Algorithm: meta.Algorithm.Name()})
+	local, err := hashFileWith(hashes.SumFile, meta.Algorithm, fn)
+	if err != nil {
+		fatal("Unable to hash ", fn, ": ", err)
+	}
+	if local != remote {
+		fatalCodef(exitMismatch, "%s differs from the sender's file: expected %s %s, got %s", fn, meta.Algorithm.Name(), remote, local)
+	}
+	fmt.Fprintln(msg, fn, "matches the sender's file,", meta.Algorithm.Name(), local)
+	emit(event{Event: eventDone, Name: received.Name, Path: fn, Algorithm: meta.Algorithm.Name(), Sum: local})
+}
+
+// upToDateCopy returns which of the copies of fn policy leads to already
+// holds the file the sender at url shares, if any.
+func upToDateCopy(url, fn, policy string) (string, bool) {
+	for _, c := range copies(fn, policy) {
+		if upToDate(url, c) {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+// upToDate reports whether fn already holds the file the sender at url
+// shares. Sizes are compared first, so that a different file is seldom
+// hashed, against the pinned manifest when there is one.
+func upToDate(url, fn string) bool {
+	fi, err := os.Stat(fn)
+	if err != nil || !fi.Mode().IsRegular() {
+		return false
+	}
+	var meta transfer.Meta
+	if pinned != nil {
+		meta.Algorithm, err = hashing.Lookup(pinned.Algorithm)
+		meta.Size, meta.Sum = pinned.Size, pinned.Sum
+	} else {
+		meta, err = headMeta(url)
+	}
+	if err != nil || meta.Size != fi.Size() {
+		return false
+	}
+	if meta.Sum == "" {
+		meta.Sum, err = client.FetchHash(url, meta.Algorithm)
+		if err != nil {
+			return false
+		}
+	}
+	local, err := hashFile(meta.Algorithm, fn)
+	return err == nil && local == meta.Sum
+}
@@ -0,0 +1,151 @@
+package popcmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/grandcat/zeroconf"
+	"github.com/yifu/pushpop/pkg/discovery"
+	"github.com/yifu/pushpop/pkg/hashing"
+	"github.com/yifu/pushpop/pkg/safename"
+	"github.com/yifu/pushpop/pkg/transfer"
+)
+
+// watch downloads every file username shares into dir, for as long as it
+// runs. Each download runs in a pop of its own, so that a failed one does
+// not end the watch. Files already there with the sender's checksum, under
+// their name or, when onExists renames them, a numbered one, are skipped.
+// Unless -on-exists is given, a changed file is saved next to the one
+// there: nobody is there to be asked, and overwriting would lose it.
+func watch(username, dir, iface, onExists string) {
+	if _, given := givenFlags(); !given["on-exists"] {
+		onExists = "rename"
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	entries, err := discovery.Browse(ctx)
+	if err != nil {
+		fatal("Failed to browse: ", err)
+	}
+	fmt.Fprintf(msg, "Watching for files from %s.\n", username)
+	pipe.enter(stateDiscover)
+
+	seen := map[string]bool{}
+	for entry := range entries {
+		user, err := getUserName(entry)
+		if err != nil || user != username || txtValue(entry, transfer.RoleKey) != "" {
+			continue
+		}
+		key := entry.Instance + "\x00" + strconv.Itoa(entry.Port)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		name, err := safename.Name(fileName(entry))
+		if err != nil {
+			log.Println("Skipping ", entry.Instance, ": ", err)
+			continue
+		}
+		fn := destination(name, "", dir)
+		if have, ok := alreadyThere(entry, copies(fn, onExists), iface); ok {
+			fmt.Fprintln(msg, "Already have", have)
+			continue
+		}
+		fmt.Fprintf(msg, "Downloading %s to %s\n", describe(entry), fn)
+		err = popInstance(entry.Instance, username)
+		if err != nil {
+			log.Printf("Downloading %s failed: %v", fn, err)
+		}
+	}
+}
+
+// alreadyThere returns which of fns exists with the checksum of the file
+// announced by entry, if any. The sender is only asked for the checksum
+// when it does not announce its size and prefix.
+func alreadyThere(entry *zeroconf.ServiceEntry, fns []string, iface string) (string, bool) {
+	size, sized := announcedSize(entry)
+	var there []string
+	for _, fn := range fns {
+		if fi, err := os.Stat(fn); err == nil && (!sized || fi.Size() == size) {
+			there = append(there, fn)
+		}
+	}
+	if len(there) == 0 {
+		return "", false
+	}
+	alg, err := hashing.Lookup(txtValue(entry, "hash"))
+	if err != nil {
+		return "", false
+	}
+	if prefix := txtValue(entry, transfer.SumKey); prefix != "" {
+		for _, fn := range there {
+			local, err := hashFile(alg, fn)
+			if err == nil && transfer.SumPrefix(local) == prefix {
+				return fn, true
+			}
+		}
+		return "", false
+	}
+	url, _, err := entryURL(entry, iface)
+	if err != nil {
+		return "", false
+	}
+	remote, err := client.FetchHash(url, alg)
+	if err != nil {
+		return "", false
+	}
+	for _, fn := range there {
+		local, err := hashFile(alg, fn)
+		if err == nil && local == remote {
+			return fn, true
+		}
+	}
+	return "", false
+}
+
+// popInstance runs pop again to download the share username announced as
+// instance, with the flags this pop was given.
+func popInstance(instance, username string) error {
+	args, given := givenFlags("watch", "debug-bundle")
+	args = append(args, "-instance", instance)
+	if !given["on-exists"] {
+		// The file changed since it is not the sender's, and may be the
+		// only copy of someone's edits: keep it, see watch.
+		args = append(args, "-on-exists=rename")
+	}
+	if !given["on-part"] {
+		args = append(args, "-on-part=resume")
+	}
+	if !given["on-cancel"] {
+		// Ctrl-C ends this pop too, which would not wait for an answer; the
+		// next download resumes from the .part file.
+		args = append(args, "-on-cancel=keep")
+	}
+	cmd := command(append(args, username)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// givenFlags returns the flags pop was given, but those in skip, as the
+// arguments of another pop, and which were given.
+func givenFlags(skip ...string) ([]string, map[string]bool) {
+	var args []string
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+		for _, s := range skip {
+			if f.Name == s {
+				return
+			}
+		}
+		args = append(args, "-"+f.Name+"="+f.Value.String())
+	})
+	return args, given
+}
@@ -0,0 +1,135 @@
+package portmap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// natpmpPort is where routers answer NAT-PMP.
+const natpmpPort = 5351
+
+// NAT-PMP opcodes; answers add 128.
+const (
+	opExternalAddress = 0
+	opMapTCP          = 2
+)
+
+// mapNATPMP maps port with the NAT-PMP router of the default route.
+func mapNATPMP(port int) (*Mapping, error) {
+	gw, err := gateway()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: gw, Port: natpmpPort})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	resp, err := natpmpRequest(conn, []byte{0, opExternalAddress}, 12)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.IP(append([]byte(nil), resp[8:12]...))
+
+	external, err := natpmpMap(gw, port, port, Lifetime)
+	if err != nil {
+		return nil, err
+	}
+	return &Mapping{
+		ExternalIP:   ip,
+		ExternalPort: external,
+		Method:       "NAT-PMP",
+		renew: func() error {
+			_, err := natpmpMap(gw, port, external, Lifetime)
+			return err
+		},
+		remove: func() error {
+			// A lifetime of 0 deletes the mapping.
+			_, err := natpmpMap(gw, port, 0, 0)
+			return err
+		},
+	}, nil
+}
+
+// natpmpMap asks gw to map internal to external for lifetime and returns
+// the external port it mapped.
+func natpmpMap(gw net.IP, internal, external int, lifetime time.Duration) (int, error) {
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: gw, Port: natpmpPort})
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	req := make([]byte, 12)
+	req[1] = opMapTCP
+	binary.BigEndian.PutUint16(req[4:], uint16(internal))
+	binary.BigEndian.PutUint16(req[6:], uint16(external))
+	binary.BigEndian.PutUint32(req[8:], uint32(lifetime/time.Second))
+	resp, err := natpmpRequest(conn, req, 16)
+	if err != nil {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint16(resp[10:])), nil
+}
+
+// natpmpRequest sends req and returns the answer, of at least size bytes.
+// Like RFC 6886 asks, requests are sent again after 250ms, then twice as
+// long each time.
+func natpmpRequest(conn *net.UDPConn, req []byte, size int) ([]byte, error) {
+	deadline := time.Now().Add(timeout)
+	buf := make([]byte, 32)
+	for wait := 250 * time.Millisecond; time.Now().Before(deadline); wait *= 2 {
+		_, err := conn.Write(req)
+		if err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(wait))
+		n, err := conn.Read(buf)
+		var nerr net.Error
+		if errors.As(err, &nerr) && nerr.Timeout() {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n < size || buf[1] != req[1]+128 {
+			return nil, fmt.Errorf("Invalid NAT-PMP answer")
+		}
+		if code := binary.BigEndian.Uint16(buf[2:]); code != 0 {
+			return nil, fmt.Errorf("NAT-PMP error %d", code)
+		}
+		return buf[:n], nil
+	}
+	return nil, fmt.Errorf("No NAT-PMP answer from the router")
+}
+
+// gateway returns the IPv4 gateway of the default route, as Linux lists it
+// in /proc/net/route.
+func gateway() (net.IP, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to find the router: %v", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// Iface Destination Gateway ..., in little endian hex.
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		b, err := hex.DecodeString(fields[2])
+		if err != nil || len(b) != 4 || fields[2] == "00000000" {
+			continue
+		}
+		return net.IPv4(b[3], b[2], b[1], b[0]), nil
+	}
+	return nil, fmt.Errorf("No default route")
+}
@@ -0,0 +1,91 @@
+// Package portmap asks the home router to forward a TCP port to this
+// machine, so that a share can be reached from outside the LAN. It speaks
+// NAT-PMP (RFC 6886), then UPnP IGD when the router does not answer it.
+// Mappings are leased, renewed while in use and removed when closed.
+package portmap
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Lifetime is how long a mapping is leased for. It is renewed halfway.
+const Lifetime = 2 * time.Hour
+
+// timeout bounds each exchange with the router.
+const timeout = 3 * time.Second
+
+// Mapping is a port the router forwards to this machine.
+type Mapping struct {
+	// ExternalIP and ExternalPort are where the port is reached from
+	// outside.
+	ExternalIP   net.IP
+	ExternalPort int
+	// Method is "NAT-PMP" or "UPnP".
+	Method string
+
+	renew  func() error
+	remove func() error
+	once   sync.Once
+	done   chan struct{}
+}
+
+// Map asks the router to forward an external port, port itself when
+// possible, to port on this machine, and keeps the mapping until it is
+// closed. description names the mapping in the router's interface.
+func Map(port int, description string) (*Mapping, error) {
+	m, err := mapNATPMP(port)
+	if err != nil {
+		var upnpErr error
+		m, upnpErr = mapUPnP(port, description)
+		if upnpErr != nil {
+			return nil, fmt.Errorf("The router maps no port with NAT-PMP (%v) nor UPnP (%v)", err, upnpErr)
+		}
+	}
+	m.done = make(chan struct{})
+	go m.keep()
+	return m, nil
+}
+
+// keep renews the lease of m until it is closed.
+func (m *Mapping) keep() {
+	ticker := time.NewTicker(Lifetime / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// A failed renewal is tried again next time, the lease
+			// still having half its time left.
+			m.renew()
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// Close removes the mapping from the router.
+func (m *Mapping) Close() error {
+	err := error(nil)
+	m.once.Do(func() {
+		close(m.done)
+		err = m.remove()
+	})
+	return err
+}
+
+// URL returns the http URL of the root of the mapped port.
+func (m *Mapping) URL() string {
+	return fmt.Sprintf("http://%s/", net.JoinHostPort(m.ExternalIP.String(), fmt.Sprint(m.ExternalPort)))
+}
+
+// localIP returns the address of this machine facing gw.
+func localIP(gw net.IP) (net.IP, error) {
+	conn, err := net.Dial("udp", net.JoinHostPort(gw.String(), "1"))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
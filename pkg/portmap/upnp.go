@@ -0,0 +1,247 @@
+package portmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ssdpAddr is where UPnP devices are searched for.
+const ssdpAddr = "239.255.255.250:1900"
+
+// wanServices are the UPnP services that map ports, by preference.
+var wanServices = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+// errOnlyPermanent is the UPnP error of routers that only take mappings
+// without a lease.
+const errOnlyPermanent = "725"
+
+// mapUPnP maps port with the first Internet gateway device answering.
+func mapUPnP(port int, description string) (*Mapping, error) {
+	location, err := ssdpSearch()
+	if err != nil {
+		return nil, err
+	}
+	control, service, err := wanService(location)
+	if err != nil {
+		return nil, err
+	}
+	host, _, err := net.SplitHostPort(control.Host)
+	if err != nil {
+		host = control.Host
+	}
+	local, err := localIP(net.ParseIP(host))
+	if err != nil {
+		return nil, err
+	}
+	c := &upnpClient{url: control.String(), service: service}
+
+	out, err := c.call("GetExternalIPAddress", nil)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(out["NewExternalIPAddress"])
+	if ip == nil {
+		return nil, fmt.Errorf("The router has no external address")
+	}
+	lease := Lifetime
+	add := func() error {
+		_, err := c.call("AddPortMapping", [][2]string{
+			{"NewRemoteHost", ""},
+			{"NewExternalPort", strconv.Itoa(port)},
+			{"NewProtocol", "TCP"},
+			{"NewInternalPort", strconv.Itoa(port)},
+			{"NewInternalClient", local.String()},
+			{"NewEnabled", "1"},
+			{"NewPortMappingDescription", description},
+			{"NewLeaseDuration", strconv.Itoa(int(lease / time.Second))},
+		})
+		return err
+	}
+	err = add()
+	if err != nil && strings.Contains(err.Error(), errOnlyPermanent) {
+		lease = 0
+		err = add()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Mapping{
+		ExternalIP:   ip,
+		ExternalPort: port,
+		Method:       "UPnP",
+		renew:        add,
+		remove: func() error {
+			_, err := c.call("DeletePortMapping", [][2]string{
+				{"NewRemoteHost", ""},
+				{"NewExternalPort", strconv.Itoa(port)},
+				{"NewProtocol", "TCP"},
+			})
+			return err
+		},
+	}, nil
+}
+
+// ssdpSearch returns the description URL of the first Internet gateway
+// device answering an SSDP search.
+func ssdpSearch() (string, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	dst, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return "", err
+	}
+	req := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + ssdpAddr + "\r\n" +
+		"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n\r\n"
+	_, err = conn.WriteTo([]byte(req), dst)
+	if err != nil {
+		return "", err
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return "", fmt.Errorf("No UPnP gateway answered")
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		if location := resp.Header.Get("Location"); location != "" {
+			return location, nil
+		}
+	}
+}
+
+// device is the part of a UPnP device description listing services.
+type device struct {
+	Services []struct {
+		Type       string `xml:"serviceType"`
+		ControlURL string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []device `xml:"deviceList>device"`
+}
+
+// wanService returns the control URL and type of the service that maps
+// ports in the device described at location.
+func wanService(location string) (*url.URL, string, error) {
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(location)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	var root struct {
+		Device device `xml:"device"`
+	}
+	err = xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&root)
+	if err != nil {
+		return nil, "", err
+	}
+	base, err := url.Parse(location)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, want := range wanServices {
+		if control := findService(root.Device, want); control != "" {
+			u, err := base.Parse(control)
+			return u, want, err
+		}
+	}
+	return nil, "", fmt.Errorf("The UPnP gateway maps no ports")
+}
+
+// findService returns the control URL of the service of type typ in d or
+// the devices it embeds.
+func findService(d device, typ string) string {
+	for _, s := range d.Services {
+		if s.Type == typ {
+			return s.ControlURL
+		}
+	}
+	for _, sub := range d.Devices {
+		if control := findService(sub, typ); control != "" {
+			return control
+		}
+	}
+	return ""
+}
+
+// upnpClient calls the actions of a UPnP service.
+type upnpClient struct {
+	url, service string
+}
+
+// call calls action with args and returns the values it answered.
+func (c *upnpClient) call(action string, args [][2]string) (map[string]string, error) {
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+	fmt.Fprintf(&body, `<u:%s xmlns:u="%s">`, action, c.service)
+	for _, arg := range args {
+		fmt.Fprintf(&body, "<%s>", arg[0])
+		xml.EscapeText(&body, []byte(arg[1]))
+		fmt.Fprintf(&body, "</%s>", arg[0])
+	}
+	fmt.Fprintf(&body, "</u:%s></s:Body></s:Envelope>", action)
+
+	req, err := http.NewRequest(http.MethodPost, c.url, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", fmt.Sprintf(`"%s#%s"`, c.service, action))
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// The answer is flat: every element with text is a value, and a fault
+	// carries an errorCode.
+	values := map[string]string{}
+	dec := xml.NewDecoder(io.LimitReader(resp.Body, 1<<20))
+	var name string
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name = t.Name.Local
+		case xml.CharData:
+			if s := strings.TrimSpace(string(t)); s != "" && name != "" {
+				values[name] = s
+			}
+		case xml.EndElement:
+			name = ""
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("UPnP %s failed: %s %s", action, values["errorCode"], values["errorDescription"])
+	}
+	return values, nil
+}
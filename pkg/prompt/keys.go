@@ -0,0 +1,70 @@
+package prompt
+
+import (
+	"io"
+	"os"
+)
+
+// Keys reads the keys pressed on the controlling terminal one by one, as
+// they are pressed and without echoing them, and sends them on the
+// returned channel until stop is called. Unlike Choose, the terminal is
+// not put in raw mode, and Ctrl-C still sends SIGINT: whoever handles it
+// must call stop before exiting, to give the terminal back as it was. Keys
+// returns a nil channel when there is no terminal to read from, or with
+// NonInteractive.
+func (o Options) Keys() (keys <-chan byte, stop func()) {
+	if o.NonInteractive {
+		return nil, func() {}
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, func() {}
+	}
+	restore, err := cbreak(tty)
+	if err != nil {
+		tty.Close()
+		return nil, func() {}
+	}
+	ch := make(chan byte)
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		defer close(ch)
+		buf := make([]byte, 1)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			n, err := tty.Read(buf)
+			if err == io.EOF {
+				// No key yet.
+				continue
+			}
+			if err != nil {
+				return
+			}
+			if n == 1 {
+				select {
+				case ch <- buf[0]:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+	stopped := false
+	return ch, func() {
+		if stopped {
+			return
+		}
+		stopped = true
+		close(done)
+		// A key pressed from now on is for whoever reads the terminal next.
+		<-finished
+		restore()
+		tty.Close()
+	}
+}
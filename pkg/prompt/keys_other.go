@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package prompt
+
+import (
+	"errors"
+	"os"
+)
+
+func cbreak(tty *os.File) (func(), error) {
+	return nil, errors.New("Reading single keys is not supported on this system")
+}
@@ -0,0 +1,34 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package prompt
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cbreak turns off the line buffering and echo of tty, and returns the
+// function turning them back on. Reads from tty then return io.EOF when no
+// key is pressed for a while.
+func cbreak(tty *os.File) (func(), error) {
+	fd := int(tty.Fd())
+	old, err := unix.IoctlGetTermios(fd, getTermios)
+	if err != nil {
+		return nil, err
+	}
+	t := *old
+	t.Lflag &^= unix.ICANON | unix.ECHO
+	// Reads return after a tenth of a second without a key, so that
+	// reading stops soon once asked to.
+	t.Cc[unix.VMIN] = 0
+	t.Cc[unix.VTIME] = 1
+	err = unix.IoctlSetTermios(fd, setTermios, &t)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		unix.IoctlSetTermios(fd, setTermios, old)
+	}, nil
+}
@@ -0,0 +1,127 @@
+// Package prompt asks the user questions on the terminal. Every prompt goes
+// through the Options of the program, so that a single switch makes them
+// all take their default.
+package prompt
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// Options are how prompts are shown. The zero Options ask whenever there
+// is a terminal.
+type Options struct {
+	// NonInteractive makes every prompt go with its default answer
+	// without asking, as when there is no terminal. It is the -yes flag.
+	NonInteractive bool
+}
+
+// ErrNotAsked is returned with the default answer by a prompt that did not
+// ask, because of NonInteractive or for lack of a terminal.
+var ErrNotAsked = fmt.Errorf("Not asked")
+
+// ErrAborted is returned when the user quits a prompt instead of answering.
+var ErrAborted = fmt.Errorf("Aborted")
+
+// Choose shows question with a menu of options on the controlling terminal
+// and returns the index of the selected option. The arrow keys (or j/k) move
+// the selection and enter confirms it; a digit picks an option directly.
+// The terminal is opened directly so that Choose works whatever stdin and
+// stdout are redirected to. Option def is selected first, and without
+// asking, Choose returns it and ErrNotAsked.
+func (o Options) Choose(question string, options []string, def int) (int, error) {
+	if o.NonInteractive {
+		return def, ErrNotAsked
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return def, ErrNotAsked
+	}
+	defer tty.Close()
+	fd := int(tty.Fd())
+	if !term.IsTerminal(fd) {
+		return def, ErrNotAsked
+	}
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return 0, err
+	}
+	defer term.Restore(fd, state)
+
+	width, _, err := term.GetSize(fd)
+	if err != nil {
+		width = 80
+	}
+	return menu(tty, question, options, def, width)
+}
+
+// menu shows the menu of Choose on tty, width columns wide, and reads the
+// keys pressed on it until an option is picked. Option def is selected
+// first.
+func menu(tty io.ReadWriter, question string, options []string, def, width int) (int, error) {
+	sel := def
+	if sel < 0 || sel >= len(options) {
+		sel = 0
+	}
+	var lines []string
+	render := func() {
+		lines = renderMenu(question, options, sel, width)
+		fmt.Fprint(tty, "\r\x1b[J")
+		for _, line := range lines {
+			fmt.Fprintf(tty, "%s\r\n", line)
+		}
+	}
+	render()
+
+	buf := make([]byte, 3)
+	for {
+		n, err := tty.Read(buf)
+		if err != nil {
+			return 0, err
+		}
+		key := string(buf[:n])
+		switch {
+		case key == "\r" || key == "\n":
+			return sel, nil
+		case key == "q" || key == "\x03" || key == "\x1b":
+			return 0, ErrAborted
+		case key == "k" || key == "\x1b[A":
+			sel = (sel + len(options) - 1) % len(options)
+		case key == "j" || key == "\x1b[B":
+			sel = (sel + 1) % len(options)
+		case n == 1 && key[0] >= '1' && int(key[0]-'1') < len(options):
+			return int(key[0] - '1'), nil
+		default:
+			continue
+		}
+		fmt.Fprintf(tty, "\x1b[%dA", len(lines))
+		render()
+	}
+}
+
+// renderMenu returns the lines of the menu with option sel highlighted.
+// Lines are clamped to width so that none wraps, which would throw off the
+// redraw.
+func renderMenu(question string, options []string, sel, width int) []string {
+	lines := []string{Clamp(question, width)}
+	for i, o := range options {
+		cursor := "  "
+		if i == sel {
+			cursor = "> "
+		}
+		lines = append(lines, Clamp(fmt.Sprintf("%s%d) %s", cursor, i+1, o), width))
+	}
+	return lines
+}
+
+// Clamp shortens s to fit in width columns, marking the cut with an ellipsis.
+func Clamp(s string, width int) string {
+	r := []rune(s)
+	if width < 2 || len(r) < width {
+		return s
+	}
+	return string(r[:width-2]) + "…"
+}
@@ -0,0 +1,133 @@
+package prompt
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestClamp(t *testing.T) {
+	for _, tt := range []struct {
+		s     string
+		width int
+		want  string
+	}{
+		{"short", 20, "short"},
+		{"exactly twenty runes", 20, "exactly twenty run…"},
+		{"nineteen runes here", 20, "nineteen runes here"},
+		{"a longer line than forty columns, by some way", 40, "a longer line than forty columns, by s…"},
+		{"héllo wörld, ünïcode counts runes not bytes", 20, "héllo wörld, ünïco…"},
+		{"anything", 1, "anything"},
+		{"anything", 0, "anything"},
+	} {
+		if got := Clamp(tt.s, tt.width); got != tt.want {
+			t.Errorf("Clamp(%q, %d) = %q, want %q", tt.s, tt.width, got, tt.want)
+		}
+	}
+}
+
+func TestRenderMenu(t *testing.T) {
+	question := "/home/alice/Downloads/quarterly report (final, really).pdf already exists."
+	options := []string{"Overwrite", "Skip", "Save as quarterly report (final, really) (1).pdf"}
+	for _, tt := range []struct {
+		width int
+		want  []string
+	}{
+		{20, []string{
+			"/home/alice/Downlo…",
+			"  1) Overwrite",
+			"> 2) Skip",
+			"  3) Save as quart…",
+		}},
+		{40, []string{
+			"/home/alice/Downloads/quarterly report…",
+			"  1) Overwrite",
+			"> 2) Skip",
+			"  3) Save as quarterly report (final, …",
+		}},
+		{80, []string{
+			"/home/alice/Downloads/quarterly report (final, really).pdf already exists.",
+			"  1) Overwrite",
+			"> 2) Skip",
+			"  3) Save as quarterly report (final, really) (1).pdf",
+		}},
+		{200, []string{
+			"/home/alice/Downloads/quarterly report (final, really).pdf already exists.",
+			"  1) Overwrite",
+			"> 2) Skip",
+			"  3) Save as quarterly report (final, really) (1).pdf",
+		}},
+	} {
+		got := renderMenu(question, options, 1, tt.width)
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("width %d: got\n%s\nwant\n%s", tt.width, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+		for _, line := range got {
+			if utf8.RuneCountInString(line) >= tt.width {
+				t.Errorf("width %d: %q would wrap", tt.width, line)
+			}
+		}
+	}
+}
+
+// keys is a terminal on which keys are pressed one by one.
+type keys struct {
+	pressed []string
+	out     strings.Builder
+}
+
+func (k *keys) Read(p []byte) (int, error) {
+	if len(k.pressed) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, k.pressed[0])
+	k.pressed = k.pressed[1:]
+	return n, nil
+}
+
+func (k *keys) Write(p []byte) (int, error) {
+	return k.out.Write(p)
+}
+
+func TestChooseNonInteractive(t *testing.T) {
+	sel, err := Options{NonInteractive: true}.Choose("Overwrite?", []string{"Yes", "No"}, 1)
+	if sel != 1 || err != ErrNotAsked {
+		t.Errorf("Choose = %d, %v, want 1, ErrNotAsked", sel, err)
+	}
+}
+
+func TestMenu(t *testing.T) {
+	options := []string{"Overwrite", "Skip", "Rename"}
+	for _, tt := range []struct {
+		name    string
+		def     int
+		pressed []string
+		want    int
+		err     error
+	}{
+		{"enter takes the default", 2, []string{"\r"}, 2, nil},
+		{"enter takes the first without a default", -1, []string{"\r"}, 0, nil},
+		{"down from the default", 1, []string{"j", "\r"}, 2, nil},
+		{"arrows wrap", 0, []string{"\x1b[A", "\n"}, 2, nil},
+		{"down arrow wraps", 2, []string{"\x1b[B", "\r"}, 0, nil},
+		{"digit", 0, []string{"2"}, 1, nil},
+		{"digit out of range ignored", 0, []string{"9", "\r"}, 0, nil},
+		{"other keys ignored", 1, []string{"x", "\r"}, 1, nil},
+		{"q aborts", 1, []string{"q"}, 0, ErrAborted},
+		{"Ctrl-C aborts", 1, []string{"\x03"}, 0, ErrAborted},
+		{"end of input", 1, nil, 0, io.EOF},
+	} {
+		k := &keys{pressed: tt.pressed}
+		sel, err := menu(k, "file exists", options, tt.def, 80)
+		if sel != tt.want || err != tt.err {
+			t.Errorf("%s: menu = %d, %v, want %d, %v", tt.name, sel, err, tt.want, tt.err)
+		}
+	}
+
+	k := &keys{pressed: []string{"\r"}}
+	menu(k, "file exists", options, 1, 80)
+	if !strings.Contains(k.out.String(), "> 2) Skip") {
+		t.Errorf("the default is not selected first:\n%s", k.out.String())
+	}
+}
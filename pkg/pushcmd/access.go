@@ -0,0 +1,116 @@
+package pushcmd
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/yifu/pushpop/pkg/transfer"
+	"github.com/yifu/pushpop/pkg/version"
+)
+
+// accessList is the value of -allow or -deny: CIDR ranges, addresses and
+// user names, given as repeated flags or separated by commas.
+type accessList struct {
+	nets  []*net.IPNet
+	users []string
+}
+
+func (l *accessList) String() string {
+	if l == nil {
+		return ""
+	}
+	var s []string
+	for _, n := range l.nets {
+		s = append(s, n.String())
+	}
+	return strings.Join(append(s, l.users...), ",")
+}
+
+func (l *accessList) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if _, n, err := net.ParseCIDR(v); err == nil {
+			l.nets = append(l.nets, n)
+			continue
+		}
+		if ip := net.ParseIP(v); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			l.nets = append(l.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		if strings.ContainsAny(v, "/:") {
+			return fmt.Errorf("Invalid address or range %q", v)
+		}
+		l.users = append(l.users, v)
+	}
+	return nil
+}
+
+func (l *accessList) empty() bool {
+	return len(l.nets) == 0 && len(l.users) == 0
+}
+
+// match reports whether ip is in one of the ranges of l or user is one of
+// its users.
+func (l *accessList) match(ip net.IP, user string) bool {
+	for _, n := range l.nets {
+		if ip != nil && n.Contains(ip) {
+			return true
+		}
+	}
+	for _, u := range l.users {
+		if user != "" && user == u {
+			return true
+		}
+	}
+	return false
+}
+
+// Who may download, set by -allow and -deny. A request matching deny is
+// refused; when allow is not empty, a request must also match it.
+var allow, deny accessList
+
+// accessHandler enforces allow and deny before handing requests to the
+// share. Users are the ones pop claims to run as, see recipientHandler:
+// nothing verifies them.
+type accessHandler struct {
+	http.Handler
+}
+
+// withAccess returns handler, checking allow and deny first when either
+// is set.
+func withAccess(handler http.Handler) http.Handler {
+	if allow.empty() && deny.empty() {
+		return handler
+	}
+	return &accessHandler{handler}
+}
+
+func (h *accessHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	user := r.Header.Get(transfer.UserHeader)
+	denied := deny.match(ip, user)
+	if !denied && !allow.empty() && !allow.match(ip, user) {
+		denied = true
+	}
+	if denied {
+		log.Printf("Rejected %s %s from %s (%s) as user %q by -allow/-deny.",
+			r.Method, r.URL.Path, r.RemoteAddr, version.Peer(r.UserAgent()), user)
+		http.Error(w, "access denied", http.StatusForbidden)
+		return
+	}
+	h.Handler.ServeHTTP(w, r)
+}
@@ -0,0 +1,32 @@
+package pushcmd
+
+import (
+	"net"
+	"testing"
+)
+
+func TestAccessList(t *testing.T) {
+	var l accessList
+	err := l.Set("192.168.1.0/24, 10.0.0.7,alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		ip, user string
+		want     bool
+	}{
+		{"192.168.1.13", "", true},
+		{"192.168.2.13", "", false},
+		{"10.0.0.7", "bob", true},
+		{"10.0.0.8", "bob", false},
+		{"10.0.0.8", "alice", true},
+		{"", "", false},
+	} {
+		if got := l.match(net.ParseIP(tt.ip), tt.user); got != tt.want {
+			t.Errorf("match(%s, %q) = %v, want %v", tt.ip, tt.user, got, tt.want)
+		}
+	}
+	if err := l.Set("10.0.0.300/8"); err == nil {
+		t.Error("Set accepted an invalid range")
+	}
+}
@@ -0,0 +1,47 @@
+package pushcmd
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/yifu/pushpop/pkg/delta"
+)
+
+// blockSums returns the encoded block checksums of the file, computing them
+// again whenever the file changed. They are only computed for receivers
+// that ask, holding an older version of the file.
+func (h *fileHandler) blockSums() ([]byte, error) {
+	h.blocksMu.Lock()
+	defer h.blocksMu.Unlock()
+	v, err := h.currentVersion()
+	if err != nil {
+		return nil, err
+	}
+	if h.blocks != nil && v == h.blocksOf {
+		return h.blocks, nil
+	}
+	f, err := openReadOnly(h.fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b, err := delta.Compute(f, v.size)
+	if err != nil {
+		return nil, err
+	}
+	// A change while reading shows in the next version check.
+	h.blocks, h.blocksOf = b.Encode(), v
+	return h.blocks, nil
+}
+
+// serveBlocks answers the block checksums endpoint.
+func (h *fileHandler) serveBlocks(w http.ResponseWriter) {
+	data, err := h.blockSums()
+	if err != nil {
+		log.Println("Unable to compute the block checksums: ", err)
+		http.Error(w, "unable to compute the block checksums", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(data)
+}
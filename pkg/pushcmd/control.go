@@ -0,0 +1,284 @@
+package pushcmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/control"
+	"github.com/yifu/pushpop/pkg/hashing"
+	"github.com/yifu/pushpop/pkg/tempfile"
+	"github.com/yifu/pushpop/pkg/units"
+)
+
+// controlHandler serves the control API of a running push.
+type controlHandler struct {
+	tmpdir string
+	alg    hashing.Algorithm
+}
+
+// serveControl exposes the control API on the control socket, unless another
+// push already does. The returned function stops it.
+func serveControl(tmpdir string, alg hashing.Algorithm) func() {
+	ln, err := control.Listen()
+	if err != nil {
+		log.Println("Control API disabled: ", err)
+		return func() {}
+	}
+	mux := http.NewServeMux()
+	h := &controlHandler{tmpdir: tmpdir, alg: alg}
+	mux.HandleFunc("/push-bytes", h.pushBytes)
+	mux.HandleFunc("/share", h.shareFile)
+	mux.HandleFunc("/shares", listShares)
+	mux.HandleFunc("/downloads", listDownloads)
+	mux.HandleFunc("/revoke", revokeShare)
+	go http.Serve(ln, mux)
+	return func() {
+		ln.Close()
+	}
+}
+
+// pushBytes shares the request body as a new file named by the "name" query
+// parameter.
+func (h *controlHandler) pushBytes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := filepath.Base(r.URL.Query().Get("name"))
+	if name == "." || name == string(filepath.Separator) {
+		name = "snippet.txt"
+	}
+
+	fn, err := spool(r.Body, h.tmpdir, "pushpop-bytes-*")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fh := &fileHandler{fn: fn, name: name, alg: h.alg, signer: signingKey}
+	s, err := announce(name, h.alg, fh)
+	if err != nil {
+		os.Remove(fn)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.cleanup = func() {
+		os.Remove(fn)
+	}
+	go fh.watch(s)
+	log.Printf("Sharing %s on port %d for a control client, session %s.", name, s.port, s.id)
+	fmt.Fprintf(w, "Sharing %s on port %d, session %s\n", name, s.port, s.id)
+}
+
+// shareFile shares the file at the absolute path given by the "path" query
+// parameter, as the "name" parameter or its base name, alongside the shares
+// push already has.
+func (h *controlHandler) shareFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	fn := r.URL.Query().Get("path")
+	if !filepath.IsAbs(fn) {
+		http.Error(w, "path must be absolute", http.StatusBadRequest)
+		return
+	}
+	fi, err := os.Stat(fn)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !fi.Mode().IsRegular() {
+		http.Error(w, "only regular files can be added", http.StatusBadRequest)
+		return
+	}
+	f, err := openReadOnly(fn)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	f.Close()
+	name := filepath.Base(r.URL.Query().Get("name"))
+	if name == "." || name == string(filepath.Separator) {
+		name = filepath.Base(fn)
+	}
+
+	fh := &fileHandler{fn: fn, name: name, alg: h.alg, signer: signingKey}
+	s, err := announce(name, h.alg, fh)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	go fh.watch(s)
+	log.Printf("Sharing %s on port %d for a control client, session %s.", name, s.port, s.id)
+	if wantsJSON(r) {
+		writeJSON(w, describeShare(s))
+		return
+	}
+	fmt.Fprintf(w, "Sharing %s on port %d, session %s\n", name, s.port, s.id)
+}
+
+// shareInfo describes an open share to control clients asking for JSON.
+type shareInfo struct {
+	ID   string `json:"id"`
+	Port int    `json:"port"`
+	Name string `json:"name"`
+	Gen  int    `json:"gen,omitempty"`
+}
+
+func describeShare(s *share) shareInfo {
+	return shareInfo{ID: s.id, Port: s.port, Name: s.name, Gen: s.gen}
+}
+
+// listShares answers one line per open share: its id, port and name, or a
+// JSON array of shareInfo.
+func listShares(w http.ResponseWriter, r *http.Request) {
+	open := openShares()
+	if wantsJSON(r) {
+		list := []shareInfo{}
+		for _, s := range open {
+			list = append(list, describeShare(s))
+		}
+		writeJSON(w, list)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	for _, s := range open {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", s.id, s.port, s.name)
+	}
+}
+
+// downloadInfo describes a download in progress to control clients asking
+// for JSON. Label names the receiver as progress does; Rate is in bytes per
+// second.
+type downloadInfo struct {
+	Share string  `json:"share,omitempty"`
+	Label string  `json:"label"`
+	User  string  `json:"user,omitempty"`
+	Addr  string  `json:"addr"`
+	Sent  int64   `json:"sent"`
+	Size  int64   `json:"size"`
+	Rate  float64 `json:"rate"`
+}
+
+// listDownloads answers one line per download in progress: the id of its
+// share, the receiver, the bytes sent and to send and the rate, or a JSON
+// array of downloadInfo.
+func listDownloads(w http.ResponseWriter, r *http.Request) {
+	var list []downloadInfo
+	for _, t := range downloads() {
+		list = append(list, downloadInfo{
+			Share: t.share,
+			Label: t.label,
+			User:  t.user,
+			Addr:  t.addr,
+			Sent:  atomic.LoadInt64(&t.n),
+			Size:  t.total,
+			Rate:  t.rate(),
+		})
+	}
+	if wantsJSON(r) {
+		if list == nil {
+			list = []downloadInfo{}
+		}
+		writeJSON(w, list)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	for _, d := range list {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s/s\n", d.Share, d.Label, d.Sent, d.Size, units.Bytes(int64(d.Rate)))
+	}
+}
+
+// wantsJSON reports whether the control client asked for JSON with its
+// Accept header.
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// revokeShare stops the share whose id is the "id" query parameter.
+func revokeShare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s := findShare(r.URL.Query().Get("id"))
+	if s == nil {
+		http.Error(w, "no such share", http.StatusNotFound)
+		return
+	}
+	log.Printf("Revoking %s, session %s.", s.name, s.id)
+	s.close()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeInterval is how often push looks for kill-switch files.
+const revokeInterval = time.Second
+
+// watchRevocations closes the shares whose kill-switch file appears, see
+// control.Revoke.
+func watchRevocations() {
+	for range time.Tick(revokeInterval) {
+		for _, s := range openShares() {
+			if !control.Revoked(s.id) {
+				continue
+			}
+			log.Printf("Revoking %s, session %s.", s.name, s.id)
+			s.close()
+			err := control.Unrevoke(s.id)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
+
+// spool saves r to a new temporary file and returns its path.
+func spool(r io.Reader, tmpdir, pattern string) (string, error) {
+	f, err := tempfile.Create(tmpdir, pattern)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, r)
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// sendBytes asks a running push to share data as name. It fails when no
+// push is running.
+func sendBytes(data []byte, name string) error {
+	client, err := control.Client()
+	if err != nil {
+		return err
+	}
+	u := control.BaseURL + "/push-bytes?name=" + url.QueryEscape(name)
+	resp, err := client.Post(u, "application/octet-stream", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, body)
+	}
+	fmt.Print(string(body))
+	return nil
+}
@@ -0,0 +1,164 @@
+package pushcmd
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/hashing"
+	"github.com/yifu/pushpop/pkg/ignore"
+	"github.com/yifu/pushpop/pkg/transfer"
+)
+
+// dirHandler serves a directory as a tar archive built on the fly, leaving
+// out whatever its .pushpopignore excludes. The archive is deterministic,
+// so its checksum is the same for every download of an unchanged tree.
+type dirHandler struct {
+	dir  string
+	name string
+	alg  hashing.Algorithm
+
+	mu  sync.Mutex
+	sum string
+}
+
+func (h *dirHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logRequest(r)
+	switch r.URL.Path {
+	case "/", downloadPath:
+		if wantsLanding(r) {
+			h.mu.Lock()
+			sum := h.sum
+			h.mu.Unlock()
+			serveLanding(w, landing{Name: h.name, Size: -1, Alg: h.alg, Sum: sum})
+			return
+		}
+		h.serveTar(w, r)
+	case transfer.HashPath(h.alg):
+		sum, err := h.hash()
+		if err != nil {
+			log.Println("Unable to hash directory: ", err)
+			http.Error(w, "unable to hash directory", http.StatusInternalServerError)
+			return
+		}
+		serveHash(w, sum)
+	case transfer.SessionPath:
+		h.mu.Lock()
+		sum := h.sum
+		h.mu.Unlock()
+		serveSession(w, transfer.NewManifest(h.name, transfer.Meta{Algorithm: h.alg, Size: -1, Sum: sum}), shareCapabilities)
+	case transfer.ProbePath:
+		transfer.ServeProbe(w, r)
+	case transfer.AckPath:
+		h.mu.Lock()
+		sum := h.sum
+		h.mu.Unlock()
+		serveAck(w, r, sum)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// hash returns the checksum of the archive, building it once if no download
+// did yet.
+func (h *dirHandler) hash() (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.sum != "" {
+		return h.sum, nil
+	}
+	hasher := h.alg.New()
+	err := h.writeTar(hasher)
+	if err != nil {
+		return "", err
+	}
+	h.sum = hashing.Hex(hasher)
+	return h.sum, nil
+}
+
+func (h *dirHandler) serveTar(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-tar")
+	setDisposition(w, h.name)
+	transfer.SetMeta(w.Header(), transfer.Meta{Algorithm: h.alg, Size: -1})
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	start := time.Now()
+	hasher := h.alg.New()
+	out, leave := bandwidth.writer(w, weightOf(r))
+	defer leave()
+	cw := &countWriter{w: out}
+	err := h.writeTar(io.MultiWriter(cw, hasher))
+	if err != nil {
+		recordSend(r, h.name, h.dir, cw.n, h.alg, "", start, err)
+		log.Println("Unable to send directory: ", err)
+		return
+	}
+	sum := hashing.Hex(hasher)
+	h.mu.Lock()
+	h.sum = sum
+	h.mu.Unlock()
+	recordSend(r, h.name, h.dir, cw.n, h.alg, sum, start, nil)
+}
+
+// countWriter counts the bytes written through it.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// writeTar writes the archive of the directory to w. Entries are sorted and
+// carry no owner information, so that the same tree always produces the
+// same bytes.
+func (h *dirHandler) writeTar(w io.Writer) error {
+	tw := tar.NewWriter(w)
+	root := filepath.Base(h.dir)
+	err := ignore.Walk(h.dir, func(fn, rel string, fi os.FileInfo) error {
+		f, err := openReadOnly(fn)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		// The walk does not follow symbolic links, but the file could have
+		// been replaced by one since, pointing out of the directory.
+		opened, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if !os.SameFile(fi, opened) {
+			return fmt.Errorf("%s changed while being sent", rel)
+		}
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     path.Join(root, rel),
+			Mode:     int64(fi.Mode().Perm()),
+			Size:     fi.Size(),
+			ModTime:  fi.ModTime().Truncate(1e9),
+			Format:   tar.FormatPAX,
+		}
+		err = tw.WriteHeader(hdr)
+		if err != nil {
+			return err
+		}
+		_, err = io.CopyN(tw, f, fi.Size())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
@@ -0,0 +1,228 @@
+package pushcmd
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/transfer"
+	"github.com/yifu/pushpop/pkg/units"
+)
+
+// bandwidth shares the upload rate set by -limit between the downloads in
+// progress, in proportion to their weights, so that one fast receiver
+// cannot starve the others. Rate left over by a receiver that cannot keep
+// up goes to the others. Without -limit, downloads go as fast as the
+// network lets them.
+var bandwidth fairShare
+
+// fairTick is how often fairShare hands out the rate.
+const fairTick = 20 * time.Millisecond
+
+// fairChunk is the most a download writes at once under a limit.
+const fairChunk = 32 << 10
+
+// fairShare is a weighted fair scheduler of writes.
+type fairShare struct {
+	// rate is the total in bytes per second, 0 for no limit.
+	rate float64
+
+	mu    sync.Mutex
+	cond  *sync.Cond
+	flows map[*flow]bool
+}
+
+// flow is a download writing through fairShare.
+type flow struct {
+	weight float64
+	// credit is how many bytes the flow may write.
+	credit float64
+	// waiting is set while the flow waits for credit. Only waiting flows
+	// get credit, so that a flow blocked on the network leaves its share to
+	// the others.
+	waiting bool
+}
+
+// String and Set make fairShare the -limit flag, a rate in bytes per
+// second with an optional unit such as 10MB or 2MiB.
+func (s *fairShare) String() string {
+	if s == nil || s.rate == 0 {
+		return ""
+	}
+	return units.Bytes(int64(s.rate)) + "/s"
+}
+
+func (s *fairShare) Set(value string) error {
+	rate, err := units.ParseSize(value)
+	if err != nil {
+		return err
+	}
+	if rate < 0 {
+		return fmt.Errorf("Invalid limit %q", value)
+	}
+	s.rate = rate
+	return nil
+}
+
+// start starts handing out the rate, when limited.
+func (s *fairShare) start() {
+	s.cond = sync.NewCond(&s.mu)
+	s.flows = map[*flow]bool{}
+	if s.rate > 0 {
+		go s.run()
+	}
+}
+
+// run hands out the rate to waiting flows every fairTick.
+func (s *fairShare) run() {
+	ticker := time.NewTicker(fairTick)
+	defer ticker.Stop()
+	last := time.Now()
+	for now := range ticker.C {
+		budget := s.rate * now.Sub(last).Seconds()
+		last = now
+		s.mu.Lock()
+		var total float64
+		for f := range s.flows {
+			if f.waiting {
+				total += f.weight
+			}
+		}
+		for f := range s.flows {
+			if f.waiting {
+				f.credit += budget * f.weight / total
+			}
+		}
+		s.mu.Unlock()
+		s.cond.Broadcast()
+	}
+}
+
+// writer returns w, writing at the flow's share of the rate until done is
+// called. Without a limit, it returns w as is.
+func (s *fairShare) writer(w io.Writer, weight float64) (io.Writer, func()) {
+	if s.rate == 0 {
+		return w, func() {}
+	}
+	f := &flow{weight: weight}
+	s.mu.Lock()
+	s.flows[f] = true
+	s.mu.Unlock()
+	return &fairWriter{w: w, s: s, f: f}, func() {
+		s.mu.Lock()
+		delete(s.flows, f)
+		s.mu.Unlock()
+	}
+}
+
+type fairWriter struct {
+	w io.Writer
+	s *fairShare
+	f *flow
+}
+
+func (fw *fairWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := fw.take(len(p))
+		m, err := fw.w.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// take waits for credit and returns how many of want bytes may be written.
+func (fw *fairWriter) take(want int) int {
+	if want > fairChunk {
+		want = fairChunk
+	}
+	s, f := fw.s, fw.f
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for f.credit < 1 {
+		f.waiting = true
+		s.cond.Wait()
+	}
+	f.waiting = false
+	n := want
+	if float64(n) > f.credit {
+		n = int(f.credit)
+	}
+	f.credit -= float64(n)
+	return n
+}
+
+// weights is the -weight flag: how much of the rate receivers get relative
+// to others, 1 by default, by user, address or CIDR range, for instance
+// "alice=2,10.0.0.0/8=0.5".
+type weights []weightRule
+
+type weightRule struct {
+	match  accessList
+	weight float64
+}
+
+func (ws *weights) String() string {
+	if ws == nil {
+		return ""
+	}
+	var s []string
+	for _, w := range *ws {
+		s = append(s, w.match.String()+"="+strconv.FormatFloat(w.weight, 'g', -1, 64))
+	}
+	return strings.Join(s, ",")
+}
+
+func (ws *weights) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		i := strings.LastIndexByte(v, '=')
+		if i < 0 {
+			return fmt.Errorf("Invalid weight %q, expected who=weight", v)
+		}
+		weight, err := strconv.ParseFloat(v[i+1:], 64)
+		if err != nil || weight <= 0 {
+			return fmt.Errorf("Invalid weight %q", v[i+1:])
+		}
+		var rule weightRule
+		err = rule.match.Set(v[:i])
+		if err != nil {
+			return err
+		}
+		rule.weight = weight
+		*ws = append(*ws, rule)
+	}
+	return nil
+}
+
+// peerWeights is the -weight flag.
+var peerWeights weights
+
+// weightOf returns the weight of the receiver making r, from the first
+// matching rule.
+func weightOf(r *http.Request) float64 {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	user := r.Header.Get(transfer.UserHeader)
+	for _, w := range peerWeights {
+		if w.match.match(ip, user) {
+			return w.weight
+		}
+	}
+	return 1
+}
@@ -0,0 +1,203 @@
+package pushcmd
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/hashing"
+	"github.com/yifu/pushpop/pkg/transfer"
+)
+
+// followInterval is how often a followed file is checked for new data.
+const followInterval = 500 * time.Millisecond
+
+// followHandler serves a file that is still being written, like tail -f:
+// each receiver gets what is there, then whatever is appended, with chunked
+// encoding, until the file is marked complete. Its checksum is computed and
+// served once it is.
+type followHandler struct {
+	fn   string
+	name string
+	alg  hashing.Algorithm
+
+	// complete is closed once the file is marked complete.
+	complete chan struct{}
+	once     sync.Once
+
+	mu  sync.Mutex
+	sum string
+}
+
+func newFollowHandler(fn, name string, alg hashing.Algorithm) *followHandler {
+	return &followHandler{fn: fn, name: name, alg: alg, complete: make(chan struct{})}
+}
+
+func (h *followHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logRequest(r)
+	switch r.URL.Path {
+	case "/", downloadPath:
+		if wantsLanding(r) {
+			serveLanding(w, landing{Name: h.name, Size: -1, Alg: h.alg, Sum: h.checksum()})
+			return
+		}
+		h.serveFollow(w, r)
+	case transfer.HashPath(h.alg):
+		serveHash(w, h.checksum())
+	case transfer.SessionPath:
+		serveSession(w, transfer.NewManifest(h.name, transfer.Meta{Algorithm: h.alg, Size: -1, Sum: h.checksum()}), shareCapabilities)
+	case transfer.ProbePath:
+		transfer.ServeProbe(w, r)
+	case transfer.AckPath:
+		serveAck(w, r, h.checksum())
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// checksum returns the checksum of the file, "" until it is complete.
+func (h *followHandler) checksum() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sum
+}
+
+// finish marks the file complete: receivers get the rest of it and the end
+// of the response, then its checksum.
+func (h *followHandler) finish() {
+	h.once.Do(func() {
+		close(h.complete)
+		sum, err := hashes.SumFile(h.alg, h.fn, nil)
+		if err != nil {
+			log.Println("Unable to hash file: ", err)
+			return
+		}
+		h.mu.Lock()
+		h.sum = sum
+		h.mu.Unlock()
+		log.Println(h.fn, "is complete,", h.alg.Name(), sum)
+	})
+}
+
+// serveFollow sends the file from the start, and what is appended to it
+// until it is complete. Ranges are not served, since the size is not known
+// yet; a receiver that lost the connection starts over.
+func (h *followHandler) serveFollow(w http.ResponseWriter, r *http.Request) {
+	began := time.Now()
+	f, err := openReadOnly(h.fn)
+	if err != nil {
+		log.Println("Unable to open file: ", err)
+		http.Error(w, "unable to open file", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set(transfer.FollowHeader, "1")
+	setDisposition(w, h.name)
+	transfer.SetMeta(w.Header(), transfer.Meta{Algorithm: h.alg, Size: -1})
+	if r.Method == http.MethodHead {
+		return
+	}
+	out, leave := bandwidth.writer(w, weightOf(r))
+	defer leave()
+	flusher, _ := w.(http.Flusher)
+
+	var sent int64
+	for {
+		// Data written before the file was marked complete is sent too.
+		complete := h.isComplete()
+		n, err := bufferSize.Copy(out, f, 0)
+		sent += n
+		if err == nil && !complete {
+			err = h.checkTruncated(sent)
+		}
+		if err != nil {
+			log.Println("Unable to follow file: ", err)
+			recordSend(r, h.name, h.fn, sent, h.alg, "", began, err)
+			// End the response abruptly so that the receiver does not take it
+			// for the whole file.
+			panic(http.ErrAbortHandler)
+		}
+		if complete {
+			break
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case <-r.Context().Done():
+			recordSend(r, h.name, h.fn, sent, h.alg, "", began, r.Context().Err())
+			return
+		case <-h.complete:
+		case <-time.After(followInterval):
+		}
+	}
+	log.Println("Sent", sent, "bytes of the completed", h.fn, "to", r.RemoteAddr)
+	recordSend(r, h.name, h.fn, sent, h.alg, h.checksum(), began, nil)
+}
+
+func (h *followHandler) isComplete() bool {
+	select {
+	case <-h.complete:
+		return true
+	default:
+		return false
+	}
+}
+
+// checkTruncated fails when the file got shorter than what was sent, which
+// a receiver cannot make sense of.
+func (h *followHandler) checkTruncated(sent int64) error {
+	fi, err := os.Stat(h.fn)
+	if err != nil {
+		return err
+	}
+	if fi.Size() < sent {
+		return fmt.Errorf("%s was truncated while being followed", h.fn)
+	}
+	return nil
+}
+
+// await waits for the file to be complete: marked so with a first Ctrl-C,
+// or once it did not grow for idle when not 0. It returns false when push
+// should exit right away instead, on SIGTERM or once sh was closed.
+func (h *followHandler) await(sig <-chan os.Signal, idle time.Duration, sh *share) bool {
+	fmt.Println("Following", h.fn+", press Ctrl-C once it is complete.")
+	var last int64 = -1
+	grown := time.Now()
+	ticker := time.NewTicker(followInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case s := <-sig:
+			if s == syscall.SIGTERM {
+				return false
+			}
+			fmt.Println("Marked", h.fn, "complete, press Ctrl-C again to quit.")
+			h.finish()
+			return true
+		case <-sh.done:
+			return false
+		case <-ticker.C:
+		}
+		if idle == 0 {
+			continue
+		}
+		fi, err := os.Stat(h.fn)
+		if err != nil {
+			continue
+		}
+		if fi.Size() != last {
+			last, grown = fi.Size(), time.Now()
+		} else if time.Since(grown) >= idle {
+			fmt.Println(h.fn, "did not grow for", idle, "- marked complete.")
+			h.finish()
+			return true
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package pushcmd
+
+import (
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/hashing"
+	"github.com/yifu/pushpop/pkg/history"
+	"github.com/yifu/pushpop/pkg/notify"
+	"github.com/yifu/pushpop/pkg/transfer"
+	"github.com/yifu/pushpop/pkg/units"
+	"github.com/yifu/pushpop/pkg/version"
+)
+
+// notifier tells about the transfers that end, set by -notify and
+// -webhook.
+var notifier notify.Options
+
+// recordSend adds a download served to r to the history, and logs whole
+// downloads that completed with their rate. err is what ended the transfer,
+// nil when it completed.
+func recordSend(r *http.Request, name, path string, n int64, alg hashing.Algorithm, sum string, start time.Time, err error) {
+	addr, _, splitErr := net.SplitHostPort(r.RemoteAddr)
+	if splitErr != nil {
+		addr = r.RemoteAddr
+	}
+	result := history.ResultOK
+	if err != nil {
+		result = err.Error()
+	}
+	e := history.Entry{
+		Time:      start,
+		Direction: history.Send,
+		User:      r.Header.Get(transfer.UserHeader),
+		Addr:      addr,
+		Agent:     version.Peer(r.UserAgent()),
+		Name:      name,
+		Path:      path,
+		Size:      n,
+		Algorithm: alg.Name(),
+		Sum:       sum,
+		Duration:  time.Since(start),
+		Result:    result,
+	}
+	// Ranges are pieces of a download, or pop asking about the file.
+	whole := r.Header.Get("Range") == ""
+	if err == nil && whole {
+		rate := float64(n) / math.Max(e.Duration.Seconds(), 0.001)
+		log.Printf("Sent %s to %s in %v (%s/s)", units.Bytes(n), peerLabel(r), e.Duration.Round(time.Millisecond), units.Bytes(int64(rate)))
+	}
+	err = history.Append(e)
+	if err != nil {
+		log.Println("Unable to record history: ", err)
+	}
+	if whole {
+		notifier.Transfer(e)
+	}
+}
@@ -0,0 +1,74 @@
+package pushcmd
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/yifu/pushpop/pkg/hashing"
+	"github.com/yifu/pushpop/pkg/transfer"
+	"github.com/yifu/pushpop/pkg/units"
+)
+
+// downloadPath serves the file even to browsers, which get the landing page
+// at "/".
+const downloadPath = "/download"
+
+// wantsLanding reports whether r comes from a browser asking for a page
+// rather than from pop or curl asking for the file.
+func wantsLanding(r *http.Request) bool {
+	if r.Method != http.MethodGet || r.URL.Path != "/" || r.Header.Get("Range") != "" {
+		return false
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// landing describes the share on the landing page.
+type landing struct {
+	Name     string
+	Size     int64
+	Alg      hashing.Algorithm
+	Sum      string
+	HashPath string
+}
+
+func (l landing) HumanSize() string {
+	if l.Size < 0 {
+		return "unknown size"
+	}
+	return units.Bytes(l.Size)
+}
+
+var landingTemplate = template.Must(template.New("landing").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Name}} - pushpop</title>
+<style>
+body { font-family: sans-serif; max-width: 40em; margin: 2em auto; padding: 0 1em; }
+code { word-break: break-all; }
+a.button { display: inline-block; padding: .7em 1.5em; background: #2a6ad3; color: #fff; text-decoration: none; border-radius: .3em; }
+</style>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+<p>{{.HumanSize}}</p>
+<p><a class="button" href="/download" download="{{.Name}}">Download</a></p>
+{{if .Sum}}<p>{{.Alg.Name}}: <code>{{.Sum}}</code></p>
+{{else}}<p><a href="{{.HashPath}}">{{.Alg.Name}} checksum</a></p>
+{{end}}<p>Shared with <a href="https://github.com/yifu/pushpop">pushpop</a>.</p>
+</body>
+</html>
+`))
+
+func serveLanding(w http.ResponseWriter, l landing) {
+	l.HashPath = transfer.HashPath(l.Alg)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Vary", "Accept")
+	err := landingTemplate.Execute(w, l)
+	if err != nil {
+		log.Println("Unable to render landing page: ", err)
+	}
+}
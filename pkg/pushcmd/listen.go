@@ -0,0 +1,50 @@
+package pushcmd
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// listenPort is the -port flag: the port of the first share, 0 for a
+// random one. Each share has its own port, so the following shares take
+// the next ports.
+var listenPort int
+
+// bindIP is the -bind flag: the only address shares are served and
+// announced on, nil for all of them.
+var bindIP net.IP
+
+// portMu guards listenPort as shares are announced.
+var portMu sync.Mutex
+
+// parseBind checks the -bind flag.
+func parseBind(bind string) (net.IP, error) {
+	if bind == "" {
+		return nil, nil
+	}
+	ip := net.ParseIP(bind)
+	if ip == nil {
+		return nil, fmt.Errorf("Invalid -bind address %q, expected an IP address", bind)
+	}
+	return ip, nil
+}
+
+// listen returns a listener for a new share, on -bind and -port.
+func listen() (net.Listener, error) {
+	host := ""
+	if bindIP != nil {
+		host = bindIP.String()
+	}
+	portMu.Lock()
+	defer portMu.Unlock()
+	ln, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(listenPort)))
+	if err != nil {
+		return nil, err
+	}
+	if listenPort != 0 {
+		listenPort++
+	}
+	return ln, nil
+}
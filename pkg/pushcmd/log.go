@@ -0,0 +1,21 @@
+package pushcmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/yifu/pushpop/pkg/logging"
+)
+
+// fatal is log.Fatal, logging at the error level so that no -log-level
+// hides it.
+func fatal(v ...interface{}) {
+	logging.Error(fmt.Sprint(v...))
+	os.Exit(1)
+}
+
+// fatalf is log.Fatalf, logging at the error level.
+func fatalf(format string, v ...interface{}) {
+	logging.Error(fmt.Sprintf(format, v...))
+	os.Exit(1)
+}
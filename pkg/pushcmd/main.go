@@ -0,0 +1,429 @@
+// Package pushcmd is the push command, which the push binary runs, and
+// pushpop push.
+package pushcmd
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"flag"
+	"fmt"
+	"io"
+	"os/signal"
+	"log"
+	"log/slog"
+	"os"
+	"syscall"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"github.com/gosuri/uiprogress"
+	"github.com/yifu/pushpop/pkg/clipboard"
+	"github.com/yifu/pushpop/pkg/config"
+	"github.com/yifu/pushpop/pkg/debugserver"
+	"github.com/yifu/pushpop/pkg/hashing"
+	"github.com/yifu/pushpop/pkg/identity"
+	"github.com/yifu/pushpop/pkg/logging"
+	"github.com/yifu/pushpop/pkg/portmap"
+	"github.com/yifu/pushpop/pkg/tempfile"
+	"github.com/yifu/pushpop/pkg/transfer"
+	"golang.org/x/term"
+)
+
+// Main runs push with args, the command line arguments after the command.
+func Main(args []string) {
+	clip := flag.Bool("clipboard", false, "share the clipboard contents as a text snippet")
+	tmpdir := flag.String("tmpdir", "", "directory for temporary files (default $TMPDIR)")
+	name := flag.String("name", "", "name to announce instead of the file's base name")
+	origin := flag.String("relay", "", "re-serve the file shared at this URL by another push")
+	bytesMode := flag.Bool("bytes", false, "share stdin through a running push if there is one, e.g. from an editor")
+	hashName := flag.String("hash", hashing.Default.Name(), "checksum algorithm: "+strings.Join(hashing.Names(), ", "))
+	showQR := flag.Bool("qr", true, "print a QR code of the share URL")
+	copyShareURL := flag.Bool("copy-url", true, "put the share URL on the clipboard, with OSC 52 over SSH")
+	profile := flag.String("profile", "", "configuration profile to use (default $PUSHPOP_PROFILE)")
+	allowRoot := flag.Bool("allow-root", false, "run even as root")
+	noTUI := flag.Bool("no-tui", false, "print progress as plain lines, as when stdout is not a terminal")
+	to := flag.String("to", "", "only serve this user, and upload the file to them if they run pop -receive")
+	flag.Var(&allow, "allow", "only serve these CIDR ranges, addresses and users, comma-separated or repeated; user names are the unauthenticated ones receivers claim")
+	flag.Var(&deny, "deny", "never serve these CIDR ranges, addresses and users, comma-separated or repeated; user names are the unauthenticated ones receivers claim")
+	private := flag.Bool("private", false, "announce neither user nor file name, only serving receivers given the printed code")
+	outbox := flag.String("watch", "", "share every file of this directory, announcing new ones and closing the shares of removed ones")
+	flag.BoolVar(&notifier.Desktop, "notify", false, "show a desktop notification when a transfer ends")
+	flag.StringVar(&notifier.Webhook, "webhook", "", "post each transfer that ends to this URL, as JSON")
+	flag.Var(&bandwidth, "limit", "share at most this many bytes per second between receivers, fairly, e.g. 10MB")
+	flag.Var(&bufferSize, "buffer-size", "copy and hash files through buffers of this size, e.g. 1MiB, instead of sizes suiting the link and the disk")
+	flag.Var(&peerWeights, "weight", "give receivers more or less of -limit than others, by user, address or range, e.g. alice=2,10.0.0.0/8=0.5")
+	flag.StringVar(&preferFamily, "prefer", preferFamily, "address family receivers should use when both are announced: auto, v4 or v6")
+	follow := flag.Bool("follow", false, "share a file that is still being written, like tail -f, until it is marked complete with Ctrl-C")
+	followIdle := flag.Duration("follow-idle", 0, "with -follow, mark the file complete once it did not grow for this long")
+	swarmMode := flag.Bool("swarm", false, "let receivers download parts of the file from each other, sparing the upload")
+	signFiles := flag.Bool("sign", true, "sign shared files with your identity key, so receivers can tell you from someone announcing your name and keep a signature they can check later")
+	flag.IntVar(&limits.MaxConns, "max-conns", limits.MaxConns, "serve at most this many connections at once across shares, more waiting for one to close, 0 for no limit")
+	flag.IntVar(&limits.MaxConnsPerIP, "max-conns-per-ip", limits.MaxConnsPerIP, "close the connections of an address beyond this many open at once, 0 for no limit")
+	flag.DurationVar(&limits.ConnTimeout, "conn-timeout", 0, "close connections open for longer than this, e.g. 12h, 0 for never")
+	flag.DurationVar(&limits.HeaderTimeout, "header-timeout", limits.HeaderTimeout, "close connections that take longer than this to send the headers of a request, 0 for never")
+	flag.DurationVar(&limits.IdleTimeout, "idle-timeout", limits.IdleTimeout, "close kept-alive connections idle between requests for longer than this, 0 for never")
+	flag.DurationVar(&limits.WriteTimeout, "write-timeout", limits.WriteTimeout, "close connections whose receiver takes no data for this long, 0 for never")
+	flag.IntVar(&listenPort, "port", 0, "serve on this port, and the next ones for more shares, instead of random ones")
+	flag.BoolVar(&tailscale, "tailscale", false, "print the share's URL with this machine's Tailscale address, for receivers of the tailnet")
+	mapPort := flag.Bool("map-port", false, "ask the router to forward a port to the share, with NAT-PMP or UPnP, and print its URL outside the LAN")
+	bind := flag.String("bind", "", "serve and announce only this address of the machine, e.g. 192.168.1.10")
+	flag.BoolVar(&hashes.Xattr, "hash-xattr", false, "also cache checksums in an extended attribute of the shared files, which survives renames")
+	flag.BoolVar(&logging.Verbose, "verbose", false, "log debugging details too, same as -log-level debug")
+	flag.StringVar(&logging.Level, "log-level", logging.Level, "least severe messages to log: debug, info, warn or error")
+	flag.StringVar(&logging.File, "log-file", "", "append the log to this file instead of printing it on stderr")
+	debugListen := flag.String("debug-listen", "", "serve Go profiling and tracing endpoints on this address, e.g. 127.0.0.1:6060")
+	soakFor := flag.Duration("soak", 0, "")
+	flag.Usage = usage
+	flag.CommandLine.Parse(args)
+	err := config.Apply(flag.CommandLine, "push", *profile)
+	if err != nil {
+		fatal(err)
+	}
+	closeLog, err := logging.Setup()
+	if err != nil {
+		fatal(err)
+	}
+	defer closeLog()
+	notifier.Logger = slog.Default()
+	limits.Logger = slog.Default()
+	hashes.Hashing.BufferSize = int(bufferSize)
+
+	if *debugListen != "" {
+		err = debugserver.Start(*debugListen, slog.Default())
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	alg, err := hashing.Lookup(*hashName)
+	if err != nil {
+		fatal(err)
+	}
+
+	if os.Geteuid() == 0 && !*allowRoot {
+		fatal("Refusing to serve files to the network as root, use -allow-root to insist.")
+	}
+
+	tui = !*noTUI && term.IsTerminal(int(os.Stdout.Fd()))
+	if tui {
+		uiprogress.Start()
+		defer uiprogress.Stop()
+	}
+
+	if len(allow.users) > 0 || len(deny.users) > 0 {
+		log.Println("User names in -allow and -deny are the ones receivers claim, anyone can send any: use addresses or -private to keep others out.")
+	}
+	if *swarmMode && (*private || *to != "" || !allow.empty() || !deny.empty()) {
+		// Receivers serve the chunks they have to anyone who asks.
+		fatal("-swarm cannot be used with -private, -to, -allow or -deny: the receivers would hand the file to anyone.")
+	}
+	if *private {
+		privateCode, err = newCode()
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	bindIP, err = parseBind(*bind)
+	if err != nil {
+		fatal(err)
+	}
+	if tailscale {
+		_, err = tailnetIP()
+		if err != nil {
+			fatal(err)
+		}
+	}
+	if listenPort < 0 || listenPort > 65535 {
+		fatalf("Invalid -port %d", listenPort)
+	}
+
+	bandwidth.start()
+	err = validPrefer()
+	if err != nil {
+		fatal(err)
+	}
+
+	if *signFiles {
+		signingKey, err = identity.Load()
+		if err != nil {
+			log.Println("Unable to load the identity key, sharing unsigned: ", err)
+		} else {
+			fmt.Println("Signing with", identity.Fingerprint(signingKey.Public().(ed25519.PublicKey)))
+		}
+	}
+
+	if *soakFor > 0 {
+		soak(*soakFor, *tmpdir, alg)
+		return
+	}
+
+	if *outbox != "" {
+		if flag.NArg() != 0 {
+			fatal("USAGE: push -watch dir")
+		}
+		if !isDir(*outbox) {
+			fatal("Not a directory: ", *outbox)
+		}
+		if privateCode != "" {
+			// Every file would be announced under the one instance of the
+			// code, and pop -code only finds one of them.
+			fatal("-watch cannot be used with -private.")
+		}
+		stop := make(chan struct{})
+		go watchOutbox(*outbox, *to, alg, stop)
+		stopControl := serveControl(*tmpdir, alg)
+		defer stopControl()
+		defer closeShares()
+		go watchRevocations()
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		close(stop)
+		log.Println("Shutting down.")
+		return
+	}
+
+	var fn, basefn, sum string
+	switch {
+	case *clip:
+		if flag.NArg() != 0 {
+			fatal("USAGE: push -clipboard")
+		}
+		fn = spoolClipboard(*tmpdir)
+		defer os.Remove(fn)
+		basefn = "clipboard.txt"
+	case *origin != "":
+		if flag.NArg() != 0 {
+			fatal("USAGE: push -relay url")
+		}
+		fn, basefn, sum, alg = relay(*origin, *tmpdir)
+		defer os.Remove(fn)
+	case *bytesMode:
+		if flag.NArg() != 0 {
+			fatal("USAGE: push -bytes [-name name] < data")
+		}
+		basefn = "snippet.txt"
+		if *name != "" {
+			basefn = *name
+		}
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fatal(err)
+		}
+		err = sendBytes(data, basefn)
+		if err == nil {
+			return
+		}
+		log.Println("No running push to hand over to, sharing it ourselves: ", err)
+		fn, err = spool(bytes.NewReader(data), *tmpdir, "pushpop-bytes-*")
+		if err != nil {
+			fatal(err)
+		}
+		defer os.Remove(fn)
+	default:
+		if flag.NArg() != 1 {
+			fatal("USAGE: push file")
+		}
+		fn = flag.Arg(0)
+		basefn = filepath.Base(fn)
+	}
+	if fn == "-" {
+		basefn = "stdin"
+	}
+	if *name != "" {
+		basefn = *name
+	}
+
+	var handler http.Handler
+	var fh *fileHandler
+	var followed *followHandler
+	if *follow && (fn == "-" || isDir(fn) || *clip || *origin != "" || *bytesMode) {
+		fatal("-follow only shares a file")
+	}
+	if *follow {
+		tryOpenFile(fn)
+		followed = newFollowHandler(fn, basefn, alg)
+		handler = followed
+	} else if fn == "-" {
+		handler = &streamHandler{r: os.Stdin, name: basefn, alg: alg}
+	} else if isDir(fn) {
+		if *name == "" {
+			basefn += ".tar"
+		}
+		if within(tempfile.Dir(*tmpdir), fn) {
+			fatal("The temporary directory is inside the shared directory, set -tmpdir elsewhere.")
+		}
+		handler = &dirHandler{dir: filepath.Clean(fn), name: basefn, alg: alg}
+	} else {
+		tryOpenFile(fn)
+		fh = &fileHandler{fn: fn, name: basefn, alg: alg, signer: signingKey}
+		if sum != "" {
+			err := fh.setSum(sum)
+			if err != nil {
+				fatal(err)
+			}
+		}
+		handler = fh
+	}
+	if *to != "" {
+		handler = &recipientHandler{Handler: handler, user: *to}
+	}
+
+	sh, err := announce(basefn, alg, handler)
+	if err != nil {
+		fatal(err)
+	}
+	defer sh.close()
+	fmt.Println("Session:", sh.id)
+	if sh.gen > 1 {
+		fmt.Println("Generation:", sh.gen)
+	}
+	if privateCode != "" {
+		fmt.Printf("Private share, receive it with: pop -code %s\n", privateCode)
+	}
+	if fh != nil {
+		go fh.watch(sh)
+	} else if signingKey != nil {
+		slog.Debug("Only files are signed, not directories or streams")
+	}
+	if *swarmMode && fh != nil {
+		sh.pin(transfer.SwarmKey, "1")
+	} else if *swarmMode {
+		log.Println("Only files are swarmed, not directories or streams.")
+	}
+	if followed != nil && *to != "" {
+		log.Println("A followed file is only served, not uploaded to", *to+"'s receiver.")
+	}
+	if fh != nil && *to != "" {
+		// Whichever comes first: the recipient pops the share, or has a
+		// receiver waiting for it.
+		go func() {
+			err := pushTo(*to, fn, basefn, alg)
+			if err != nil {
+				log.Printf("Unable to send to %s's receiver: %v", *to, err)
+				return
+			}
+			sh.close()
+		}()
+	}
+
+	url, err := shareURL(sh.port)
+	if err == nil && privateCode != "" {
+		url += "?" + transfer.CodeParam + "=" + privateCode
+	}
+	// paste is the URL to put on the clipboard.
+	var paste string
+	if err != nil {
+		log.Println(err)
+	} else {
+		fmt.Println("URL:", url)
+		if fp := certFingerprint(); fp != "" {
+			fmt.Println("HTTPS URL:", httpsURL(url, fp))
+		}
+		paste = url
+		if line, ok := peersLine(sh.port); ok && privateCode == "" {
+			fmt.Println("For the peers file of receivers without mDNS:", line)
+		}
+		if *showQR && term.IsTerminal(int(os.Stdout.Fd())) {
+			err = printQR(url)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}
+
+	if *mapPort {
+		m, err := portmap.Map(sh.port, "pushpop "+basefn)
+		if err != nil {
+			log.Println("Unable to map a port on the router: ", err)
+		} else {
+			defer m.Close()
+			wan := m.URL()
+			if privateCode != "" {
+				wan += "?" + transfer.CodeParam + "=" + privateCode
+			}
+			fmt.Println("URL outside the LAN, mapped with "+m.Method+":", wan)
+			// The URL to paste is the one working from anywhere.
+			paste = wan
+		}
+	}
+	if *copyShareURL && paste != "" && term.IsTerminal(int(os.Stdout.Fd())) {
+		copyURL(paste)
+	}
+
+	stopControl := serveControl(*tmpdir, alg)
+	defer stopControl()
+	defer closeShares()
+	go watchRevocations()
+
+	// Clean exit.
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	if followed == nil || followed.await(sig, *followIdle, sh) {
+		waitForExit(sig)
+	}
+	
+	log.Println("Shutting down.")
+}
+
+// waitForExit returns on a signal, or once every share was revoked.
+func waitForExit(sig <-chan os.Signal) {
+	for {
+		open := openShares()
+		if len(open) == 0 {
+			log.Println("Every share was closed.")
+			return
+		}
+		select {
+		case <-sig:
+			return
+		case <-open[0].done:
+		}
+	}
+}
+
+// hiddenFlags are left out of the usage message.
+var hiddenFlags = map[string]bool{
+	// Stability testing, see soak.
+	"soak": true,
+}
+
+func usage() {
+	fmt.Fprintln(flag.CommandLine.Output(), "USAGE: push [flags] file")
+	visible := flag.NewFlagSet("push", flag.ContinueOnError)
+	visible.SetOutput(flag.CommandLine.Output())
+	flag.VisitAll(func(f *flag.Flag) {
+		if !hiddenFlags[f.Name] {
+			visible.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	visible.PrintDefaults()
+}
+
+func isDir(fn string) bool {
+	fi, err := os.Stat(fn)
+	return err == nil && fi.IsDir()
+}
+
+func tryOpenFile(fn string) {
+	f, err := openReadOnly(fn)
+	if err != nil {
+		fatal("Unable to open file: ", err)
+	}
+	f.Close()
+}
+
+// spoolClipboard saves the clipboard contents to a temporary file so they can
+// be served like any other file.
+func spoolClipboard(tmpdir string) string {
+	data, err := clipboard.Read()
+	if err != nil {
+		fatal("Unable to read clipboard: ", err)
+	}
+	fn, err := spool(bytes.NewReader(data), tmpdir, "pushpop-clipboard-*.txt")
+	if err != nil {
+		fatal(err)
+	}
+	return fn
+}
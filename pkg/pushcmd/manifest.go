@@ -0,0 +1,172 @@
+package pushcmd
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/hashing"
+	"github.com/yifu/pushpop/pkg/identity"
+	"github.com/yifu/pushpop/pkg/transfer"
+)
+
+// watchInterval is how often a shared file is checked for changes.
+const watchInterval = 2 * time.Second
+
+// watch pins the manifest of the file h serves in the TXT record of sh, and
+// builds and pins it again whenever the file changes, until sh is closed.
+// Building the manifest takes hashing the file, so the new checksum is
+// ready by the time receivers ask for it. The signer is announced once the
+// first signature is built, so that receivers checking it do not wait for
+// the hashing.
+func (h *fileHandler) watch(sh *share) {
+	var pinned fileVersion
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		v, err := h.currentVersion()
+		if err != nil {
+			log.Println(err)
+		} else if v != pinned {
+			if pinned != (fileVersion{}) {
+				log.Println(h.fn, "changed, hashing it again.")
+			}
+			h.announceSize(sh, v.size)
+			sum, err := h.buildManifest()
+			if err != nil {
+				log.Println("Unable to build the manifest: ", err)
+			} else {
+				sh.pin(transfer.ManifestKey, sum)
+				h.announceSum(sh)
+				h.announceSigner(sh)
+				pinned = v
+			}
+		}
+		select {
+		case <-sh.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// announceSize announces size as that of the file, dropping the checksum
+// of its previous version until the new one is known. Private shares
+// announce neither, which would tell what they share.
+func (h *fileHandler) announceSize(sh *share, size int64) {
+	if privateCode != "" {
+		return
+	}
+	sh.pin(transfer.SizeKey, strconv.FormatInt(size, 10))
+	sh.pin(transfer.SumKey, "")
+}
+
+// announceSum announces the prefix of the checksum of the file, once the
+// manifest is built.
+func (h *fileHandler) announceSum(sh *share) {
+	if privateCode != "" {
+		return
+	}
+	h.mu.Lock()
+	var sum string
+	if h.sumOf == h.manifestOf {
+		sum = h.sum
+	}
+	h.mu.Unlock()
+	sh.pin(transfer.SumKey, transfer.SumPrefix(sum))
+}
+
+// buildManifest computes the manifest of the file and returns its checksum.
+func (h *fileHandler) buildManifest() (string, error) {
+	sum, err := h.hash()
+	if err != nil {
+		return "", err
+	}
+	h.mu.Lock()
+	v := h.sumOf
+	h.mu.Unlock()
+	meta := transfer.Meta{Algorithm: h.alg, Sum: sum, Size: v.size, Mtime: v.mtime}
+	if fi, err := os.Stat(h.fn); err == nil {
+		meta.Mode = fi.Mode().Perm()
+	}
+	man := transfer.NewManifest(h.name, meta)
+	man.Version, man.Caps = transfer.ProtocolVersion, h.capabilities().String()
+	data, err := man.Encode()
+	if err != nil {
+		return "", err
+	}
+	var sig []byte
+	if h.signer != nil {
+		s, err := transfer.Sign(man, h.signer)
+		if err != nil {
+			return "", err
+		}
+		sig, err = s.Encode()
+		if err != nil {
+			return "", err
+		}
+	}
+	h.mu.Lock()
+	h.manifest, h.manifestOf, h.signature = data, v, sig
+	h.mu.Unlock()
+	return hashing.Sum(h.alg, bytes.NewReader(data))
+}
+
+// serveManifest answers the manifest endpoint, asking the client to come
+// back later while the manifest of the current version is not built yet.
+func (h *fileHandler) serveManifest(w http.ResponseWriter) {
+	v, err := h.currentVersion()
+	h.mu.Lock()
+	data := h.manifest
+	if err != nil || v != h.manifestOf {
+		data = nil
+	}
+	h.mu.Unlock()
+	if data == nil {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "manifest not ready", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// serveSignature answers the signature endpoint like serveManifest, the
+// signature being built along with the manifest.
+func (h *fileHandler) serveSignature(w http.ResponseWriter) {
+	if h.signer == nil {
+		http.Error(w, "the file is not signed", http.StatusNotFound)
+		return
+	}
+	v, err := h.currentVersion()
+	h.mu.Lock()
+	data := h.signature
+	if err != nil || v != h.manifestOf {
+		data = nil
+	}
+	h.mu.Unlock()
+	if data == nil {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "signature not ready", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// signingKey is the identity key files are signed with, set by -sign.
+var signingKey ed25519.PrivateKey
+
+// announceSigner announces the fingerprint of the key the file h serves is
+// signed with in the TXT record of sh, once the signature is built. Private
+// shares announce nothing that would tell who shares them, but still serve
+// the signature.
+func (h *fileHandler) announceSigner(sh *share) {
+	if h.signer != nil && privateCode == "" {
+		sh.pin(transfer.SignerKey, identity.Fingerprint(h.signer.Public().(ed25519.PublicKey)))
+	}
+}
@@ -0,0 +1,99 @@
+package pushcmd
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/hashing"
+	"github.com/yifu/pushpop/pkg/tempfile"
+)
+
+// outboxShare is a file of the outbox and its share once announced.
+type outboxShare struct {
+	seen fileVersion
+	sh   *share
+}
+
+// watchOutbox shares every file in dir, announcing new files as they
+// appear and closing the shares of the ones that disappear, until stop is
+// closed. A file is only announced once it stopped changing between two
+// checks, so that one still being copied in is not shared half written;
+// later changes are picked up by the share's own watch. Only regular files
+// directly in dir are shared, leaving out hidden ones and .part files.
+func watchOutbox(dir, to string, alg hashing.Algorithm, stop <-chan struct{}) {
+	files := map[string]*outboxShare{}
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		present := map[string]bool{}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			log.Println("Unable to read the outbox: ", err)
+		}
+		for _, e := range entries {
+			name := e.Name()
+			if !e.Type().IsRegular() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, tempfile.PartSuffix) {
+				continue
+			}
+			present[name] = true
+			fh := &fileHandler{fn: filepath.Join(dir, name), name: name, alg: alg, signer: signingKey}
+			v, err := fh.currentVersion()
+			if err != nil {
+				continue
+			}
+			f := files[name]
+			if f == nil {
+				files[name] = &outboxShare{seen: v}
+				continue
+			}
+			if f.sh != nil || v != f.seen {
+				f.seen = v
+				continue
+			}
+			f.sh = shareOutboxFile(fh, to)
+		}
+		for name, f := range files {
+			if present[name] {
+				continue
+			}
+			if f.sh != nil {
+				log.Println(name, "left the outbox, closing its share.")
+				f.sh.close()
+			}
+			delete(files, name)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// shareOutboxFile announces the file fh serves, or returns nil when it
+// cannot, to be tried again at the next check.
+func shareOutboxFile(fh *fileHandler, to string) *share {
+	f, err := openReadOnly(fh.fn)
+	if err != nil {
+		log.Println("Unable to open file: ", err)
+		return nil
+	}
+	f.Close()
+	var handler http.Handler = fh
+	if to != "" {
+		handler = &recipientHandler{Handler: handler, user: to}
+	}
+	sh, err := announce(fh.name, fh.alg, handler)
+	if err != nil {
+		log.Println("Unable to share", fh.name+": ", err)
+		return nil
+	}
+	log.Printf("Sharing %s on port %d, session %s.", fh.name, sh.port, sh.id)
+	go fh.watch(sh)
+	return sh
+}
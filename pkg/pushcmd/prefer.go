@@ -0,0 +1,37 @@
+package pushcmd
+
+import (
+	"fmt"
+
+	"github.com/yifu/pushpop/pkg/transfer"
+)
+
+// preferFamily is the -prefer flag: the address family receivers are told
+// to reach push with, or "auto".
+var preferFamily = "auto"
+
+// validPrefer checks the -prefer flag.
+func validPrefer() error {
+	switch preferFamily {
+	case "auto", transfer.PreferV4, transfer.PreferV6:
+		return nil
+	}
+	return fmt.Errorf("Invalid -prefer value %q, expected auto, v4 or v6", preferFamily)
+}
+
+// preferHint returns the address family announced with PreferKey. With
+// auto, it is IPv4 when a LAN interface has an IPv4 address and IPv6 when
+// only IPv6 ones do, the way the share URL is picked.
+func preferHint() string {
+	if preferFamily != "auto" {
+		return preferFamily
+	}
+	ip, err := localIP()
+	if err != nil {
+		return ""
+	}
+	if ip.To4() != nil {
+		return transfer.PreferV4
+	}
+	return transfer.PreferV6
+}
@@ -0,0 +1,69 @@
+package pushcmd
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net/http"
+
+	"github.com/yifu/pushpop/pkg/transfer"
+	"github.com/yifu/pushpop/pkg/version"
+)
+
+// privateCode is the code receivers need with -private, or "". Shares are
+// then announced under transfer.CodeInstance(privateCode) with neither user
+// nor file name.
+var privateCode string
+
+// codeCookie remembers the code of a browser that gave it in the URL, so
+// that the links of the landing page work.
+const codeCookie = "pushpop-code"
+
+// newCode returns a random code for a private share.
+func newCode() (string, error) {
+	b := make([]byte, 6)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// codeHandler serves a private share to the requests that know its code.
+type codeHandler struct {
+	http.Handler
+	code string
+}
+
+// withCode returns handler, requiring the code first with -private.
+func withCode(handler http.Handler) http.Handler {
+	if privateCode == "" {
+		return handler
+	}
+	return &codeHandler{handler, privateCode}
+}
+
+func (h *codeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.known(r.Header.Get(transfer.CodeHeader)) {
+		h.Handler.ServeHTTP(w, r)
+		return
+	}
+	if h.known(r.URL.Query().Get(transfer.CodeParam)) {
+		http.SetCookie(w, &http.Cookie{Name: codeCookie, Value: h.code, Path: "/", HttpOnly: true, SameSite: http.SameSiteStrictMode})
+		h.Handler.ServeHTTP(w, r)
+		return
+	}
+	if c, err := r.Cookie(codeCookie); err == nil && h.known(c.Value) {
+		h.Handler.ServeHTTP(w, r)
+		return
+	}
+	log.Printf("Rejected %s %s from %s (%s) without the code of the private share.",
+		r.Method, r.URL.Path, r.RemoteAddr, version.Peer(r.UserAgent()))
+	http.Error(w, "this share needs its code", http.StatusForbidden)
+}
+
+// known reports whether code is the code of the share, in constant time.
+func (h *codeHandler) known(code string) bool {
+	return subtle.ConstantTimeCompare([]byte(code), []byte(h.code)) == 1
+}
@@ -0,0 +1,221 @@
+package pushcmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gosuri/uiprogress"
+	"github.com/yifu/pushpop/pkg/transfer"
+	"github.com/yifu/pushpop/pkg/units"
+	"github.com/yifu/pushpop/pkg/version"
+)
+
+// tui is set when progress bars can be redrawn in place. Otherwise, such as
+// under cron or in CI, progress is printed as plain lines.
+var tui = true
+
+// renderInterval is how often the render loop looks at the counters.
+const renderInterval = 100 * time.Millisecond
+
+// lineInterval is the least time between two plain progress lines of a
+// transfer.
+const lineInterval = 5 * time.Second
+
+// transferProgress is a download in progress. The goroutine serving it only
+// adds to n; everything else belongs to the render loop, so that any number
+// of downloads never contend on a lock or interleave their output.
+type transferProgress struct {
+	n    int64 // atomic
+	done int32 // atomic
+
+	label    string
+	total    int64
+	started  time.Time
+	bar      *uiprogress.Bar
+	lastLine time.Time
+	// share, user and addr identify the share and receiver of a download,
+	// empty for other progress such as hashing.
+	share, user, addr string
+}
+
+// progress holds the downloads the render loop draws.
+var progress struct {
+	mu      sync.Mutex
+	list    []*transferProgress
+	started sync.Once
+}
+
+// trackProgress returns r, counting what is read from it to show the
+// progress of a download of total bytes under label. The returned function
+// must be called once the download is over, complete or not.
+func trackProgress(r io.Reader, label string, total int64) (io.Reader, func()) {
+	t, done := newProgress(label, total)
+	return &countingReader{r, t}, done
+}
+
+// newDownload shows the progress of the download of total bytes r asks for,
+// under the receiver's name, until the returned function is called.
+func newDownload(r *http.Request, total int64) (*transferProgress, func()) {
+	t := &transferProgress{
+		label:    peerLabel(r),
+		total:    total,
+		started:  time.Now(),
+		lastLine: time.Now(),
+		user:     r.Header.Get(transfer.UserHeader),
+		addr:     r.RemoteAddr,
+	}
+	if s := shareOf(r); s != nil {
+		t.share = s.id
+	}
+	return t, t.show()
+}
+
+// downloads returns the downloads in progress.
+func downloads() []*transferProgress {
+	progress.mu.Lock()
+	defer progress.mu.Unlock()
+	var list []*transferProgress
+	for _, t := range progress.list {
+		if t.addr != "" && atomic.LoadInt32(&t.done) == 0 {
+			list = append(list, t)
+		}
+	}
+	return list
+}
+
+// newProgress shows the progress of a download of total bytes under label,
+// as they are added to it, until the returned function is called.
+func newProgress(label string, total int64) (*transferProgress, func()) {
+	t := &transferProgress{label: label, total: total, started: time.Now(), lastLine: time.Now()}
+	return t, t.show()
+}
+
+// show adds t to the render loop, until the returned function is called.
+func (t *transferProgress) show() func() {
+	progress.mu.Lock()
+	progress.list = append(progress.list, t)
+	progress.mu.Unlock()
+	progress.started.Do(func() {
+		go renderProgress()
+	})
+	return func() {
+		atomic.StoreInt32(&t.done, 1)
+	}
+}
+
+// add counts n more bytes sent.
+func (t *transferProgress) add(n int64) {
+	atomic.AddInt64(&t.n, n)
+}
+
+// rate returns the average rate so far, in bytes per second.
+func (t *transferProgress) rate() float64 {
+	elapsed := time.Since(t.started).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(atomic.LoadInt64(&t.n)) / elapsed
+}
+
+// peerLabel names the receiver making r in progress and logs: its user when
+// it sent one, its address and its program.
+func peerLabel(r *http.Request) string {
+	label := r.RemoteAddr
+	if user := r.Header.Get(transfer.UserHeader); user != "" {
+		label = user + "@" + label
+	}
+	return fmt.Sprintf("%s (%s)", label, version.Peer(r.UserAgent()))
+}
+
+// countingReader adds what is read through it to a transfer's counter.
+type countingReader struct {
+	r io.Reader
+	t *transferProgress
+}
+
+func (c *countingReader) Read(buf []byte) (int, error) {
+	n, err := c.r.Read(buf)
+	c.t.add(int64(n))
+	return n, err
+}
+
+// renderProgress is the render loop: it brings the bars up to date with the
+// counters, or prints plain lines, and forgets finished downloads.
+func renderProgress() {
+	for range time.Tick(renderInterval) {
+		progress.mu.Lock()
+		list := progress.list
+		progress.mu.Unlock()
+
+		var lines bytes.Buffer
+		var finished []*transferProgress
+		for _, t := range list {
+			n := atomic.LoadInt64(&t.n)
+			done := atomic.LoadInt32(&t.done) == 1
+			if tui {
+				if t.bar == nil {
+					t.bar = newBar(t)
+				}
+				t.bar.Set(int(n))
+			} else if done || time.Since(t.lastLine) >= lineInterval {
+				t.lastLine = time.Now()
+				percent := 100.0
+				if t.total > 0 {
+					percent = float64(n) * 100 / float64(t.total)
+				}
+				fmt.Fprintf(&lines, "%s: %3.0f%% (%s of %s, %s/s)\n", t.label, percent, units.Bytes(n), units.Bytes(t.total), units.Bytes(int64(t.rate())))
+			}
+			if done {
+				finished = append(finished, t)
+			}
+		}
+		os.Stdout.Write(lines.Bytes())
+		if len(finished) > 0 {
+			forget(finished)
+		}
+	}
+}
+
+// forget removes finished downloads from the render loop.
+func forget(finished []*transferProgress) {
+	gone := map[*transferProgress]bool{}
+	for _, t := range finished {
+		gone[t] = true
+	}
+	progress.mu.Lock()
+	defer progress.mu.Unlock()
+	var kept []*transferProgress
+	for _, t := range progress.list {
+		if !gone[t] {
+			kept = append(kept, t)
+		}
+	}
+	progress.list = kept
+}
+
+// newBar adds a progress bar for t.
+func newBar(t *transferProgress) *uiprogress.Bar {
+	bar := uiprogress.AddBar(int(t.total))
+	label, width := fitLabel(t.label)
+	bar.Width = width
+	if width == 0 {
+		// uiprogress cannot draw a bar of no width: draw a blank one.
+		bar.Width = 1
+		bar.LeftEnd, bar.RightEnd = ' ', ' '
+	}
+	bar.AppendCompleted()
+	bar.AppendFunc(func(b *uiprogress.Bar) string {
+		return units.Bytes(int64(t.rate())) + "/s"
+	})
+	bar.PrependElapsed()
+	bar.PrependFunc(func(b *uiprogress.Bar) string {
+		return label
+	})
+	return bar
+}
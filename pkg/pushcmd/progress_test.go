@@ -0,0 +1,52 @@
+package pushcmd
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/gosuri/uiprogress"
+)
+
+func TestFitBar(t *testing.T) {
+	for _, tt := range []struct {
+		width, label, want int
+	}{
+		{20, 0, 0},
+		{40, 0, 0},
+		{40, 12, 0},
+		{42, 0, 10},
+		{80, 0, 48},
+		{80, 12, 36},
+		{80, 38, 10},
+		{80, 40, 0},
+		{200, 0, uiprogress.Width},
+		{200, 12, uiprogress.Width},
+		{200, 120, 48},
+	} {
+		label, got := fitBar(tt.width, strings.Repeat("x", tt.label))
+		if got != tt.want {
+			t.Errorf("fitBar(%d, %d) = %d, want %d", tt.width, tt.label, got, tt.want)
+		}
+		if got > 0 && utf8.RuneCountInString(label) != tt.label {
+			t.Errorf("fitBar(%d, %d) clamped the label to %q with room for a bar", tt.width, tt.label, label)
+		}
+	}
+}
+
+// TestFitBarFits checks that the whole line fits, whatever the width and
+// label, down to the narrowest line anything fits in.
+func TestFitBarFits(t *testing.T) {
+	for width := barDecorations + 1; width <= 200; width++ {
+		for _, n := range []int{0, 1, 12, 40, 80, 300} {
+			label, bar := fitBar(width, strings.Repeat("é", n))
+			if bar == 0 {
+				// newBar still draws a blank column.
+				bar = 1
+			}
+			if line := utf8.RuneCountInString(label) + barDecorations + bar; line > width {
+				t.Errorf("fitBar(%d, %d) makes a line of %d columns", width, n, line)
+			}
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package pushcmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// openReadOnly opens a file push serves. Shared files are only ever opened
+// read-only, so that no bug in a network-facing push can alter them.
+func openReadOnly(fn string) (*os.File, error) {
+	return os.OpenFile(fn, os.O_RDONLY, 0)
+}
+
+// within reports whether path is dir or lies under it.
+func within(path, dir string) bool {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,30 @@
+package pushcmd
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/yifu/pushpop/pkg/transfer"
+	"github.com/yifu/pushpop/pkg/version"
+)
+
+// recipientHandler serves a share meant for a single user, identified by
+// the user name pop sends with its requests. Anyone else gets 403.
+//
+// The user name is only what the receiver claims to be: this keeps a share
+// from landing on the wrong desk, it does not stop someone determined.
+type recipientHandler struct {
+	http.Handler
+	user string
+}
+
+func (h *recipientHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	got := r.Header.Get(transfer.UserHeader)
+	if got != h.user {
+		log.Printf("Rejected %s %s from %s (%s) as user %q, the share is for %s.",
+			r.Method, r.URL.Path, r.RemoteAddr, version.Peer(r.UserAgent()), got, h.user)
+		http.Error(w, "this share is for "+h.user, http.StatusForbidden)
+		return
+	}
+	h.Handler.ServeHTTP(w, r)
+}
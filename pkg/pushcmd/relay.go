@@ -0,0 +1,83 @@
+package pushcmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/yifu/pushpop/pkg/hashing"
+	"github.com/yifu/pushpop/pkg/tempfile"
+	"github.com/yifu/pushpop/pkg/transfer"
+	"github.com/yifu/pushpop/pkg/version"
+)
+
+// relay downloads the file shared at origin into a spool file and checks it
+// against the origin's checksum. It returns the spool file, the name the
+// origin announced, and the origin's checksum and its algorithm. Serving that
+// checksum as is lets the final receiver verify against the source, however
+// many relays sit in between.
+func relay(origin, tmpdir string) (string, string, string, hashing.Algorithm) {
+	c := &transfer.Client{UserAgent: version.UserAgent("push")}
+	req, err := c.NewRequest(origin)
+	if err != nil {
+		fatal(err)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fatal("Unexpected status from origin: ", resp.Status)
+	}
+
+	meta, err := transfer.ParseMeta(resp)
+	if err != nil {
+		fatal(err)
+	}
+	alg := meta.Algorithm
+
+	name := "relay"
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+	if err == nil && params["filename"] != "" {
+		name = filepath.Base(params["filename"])
+	}
+
+	f, err := tempfile.Create(tmpdir, "pushpop-relay-*")
+	if err != nil {
+		fatal(err)
+	}
+	defer f.Close()
+
+	fmt.Println("Relaying", name, "from", origin)
+	h := alg.New()
+	_, err = io.Copy(io.MultiWriter(f, h), resp.Body)
+	if err != nil {
+		os.Remove(f.Name())
+		fatal("Unable to fetch from origin: ", err)
+	}
+	local := hashing.Hex(h)
+
+	sum := meta.Sum
+	if sum == "" {
+		sum, err = c.FetchHash(origin, alg)
+	}
+	if err == transfer.ErrNoHash {
+		log.Println("Origin publishes no checksum, serving our own.")
+		return f.Name(), name, local, alg
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		fatal("Unable to fetch origin checksum: ", err)
+	}
+	if sum != local {
+		os.Remove(f.Name())
+		fatalf("Checksum mismatch with origin: expected %s, got %s", sum, local)
+	}
+	fmt.Println("Verified", alg.Name(), sum)
+	return f.Name(), name, sum, alg
+}
@@ -0,0 +1,104 @@
+package pushcmd
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// sendChunk is how much of a file sendWriter hands to the kernel at once
+// without a -limit, between progress updates.
+const sendChunk = 1 << 20
+
+// sendWriter is the ResponseWriter http.ServeContent writes a file to. It
+// counts what is sent, for progress and the history, and shares the rate
+// of -limit, while still letting the connection send the file with
+// sendfile: the file is handed to it a chunk at a time rather than read
+// through a wrapper.
+type sendWriter struct {
+	http.ResponseWriter
+	// out is the ResponseWriter as bandwidth shares it, fair its
+	// fairWriter when the rate is limited.
+	out  io.Writer
+	fair *fairWriter
+	// req is the request served, whose progress is shown from the headers
+	// on unless quiet.
+	req   *http.Request
+	quiet bool
+
+	status   int
+	n        int64
+	progress *transferProgress
+	done     func()
+}
+
+func (sw *sendWriter) WriteHeader(status int) {
+	sw.status = status
+	if !sw.quiet && sw.progress == nil && (status == http.StatusOK || status == http.StatusPartialContent) {
+		total, err := strconv.ParseInt(sw.Header().Get("Content-Length"), 10, 64)
+		if err == nil {
+			sw.progress, sw.done = newDownload(sw.req, total)
+		}
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *sendWriter) Write(p []byte) (int, error) {
+	n, err := sw.out.Write(p)
+	sw.count(int64(n))
+	return n, err
+}
+
+// ReadFrom sends what the file src limits to, in chunks as large as the
+// rate allows.
+func (sw *sendWriter) ReadFrom(src io.Reader) (int64, error) {
+	rf, ok := sw.ResponseWriter.(io.ReaderFrom)
+	lr, limited := src.(*io.LimitedReader)
+	if !ok || !limited {
+		return bufferSize.Copy(sw, src, 0)
+	}
+	var total int64
+	for lr.N > 0 {
+		want := int64(sendChunk)
+		if sw.fair != nil {
+			want = int64(sw.fair.take(int(min64(lr.N, sendChunk))))
+		}
+		if want > lr.N {
+			want = lr.N
+		}
+		// The file stays directly under the LimitedReader, which is what
+		// sendfile looks for.
+		n, err := rf.ReadFrom(&io.LimitedReader{R: lr.R, N: want})
+		lr.N -= n
+		total += n
+		sw.count(n)
+		if err != nil {
+			return total, err
+		}
+		if n < want {
+			break
+		}
+	}
+	return total, nil
+}
+
+func (sw *sendWriter) count(n int64) {
+	sw.n += n
+	if sw.progress != nil {
+		sw.progress.add(n)
+	}
+}
+
+// finish ends the progress of the download.
+func (sw *sendWriter) finish() {
+	if sw.done != nil {
+		sw.done()
+	}
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
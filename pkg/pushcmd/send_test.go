@@ -0,0 +1,64 @@
+package pushcmd
+
+import (
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/yifu/pushpop/pkg/hashing"
+)
+
+// BenchmarkServeFile compares serving a file with http.ServeContent, as
+// fileHandler does so that the kernel sends it with sendfile, with copying
+// it to the response through a buffer, as it did before.
+func BenchmarkServeFile(b *testing.B) {
+	const size = 64 << 20
+	fn := filepath.Join(b.TempDir(), "file")
+	f, err := os.Create(fn)
+	if err != nil {
+		b.Fatal(err)
+	}
+	_, err = io.CopyN(f, rand.Reader, size)
+	f.Close()
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, bb := range []struct {
+		name    string
+		handler http.Handler
+	}{
+		{"ServeContent", &fileHandler{fn: fn, name: "file", alg: hashing.Default, quiet: true}},
+		{"io.Copy", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			f, err := os.Open(fn)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			defer f.Close()
+			w.Header().Set("Content-Length", strconv.Itoa(size))
+			bufferSize.Copy(w, f, 0)
+		})},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			srv := httptest.NewServer(bb.handler)
+			defer srv.Close()
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				resp, err := http.Get(srv.URL + "/")
+				if err != nil {
+					b.Fatal(err)
+				}
+				n, err := io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				if err != nil || n != size {
+					b.Fatalf("got %d bytes: %v", n, err)
+				}
+			}
+		})
+	}
+}
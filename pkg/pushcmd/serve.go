@@ -0,0 +1,501 @@
+package pushcmd
+
+import (
+	"compress/gzip"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gosuri/uiprogress"
+	"github.com/yifu/pushpop/pkg/hashing"
+	"github.com/yifu/pushpop/pkg/prompt"
+	"github.com/yifu/pushpop/pkg/transfer"
+	"golang.org/x/term"
+)
+
+func serve(srv *http.Server, ln net.Listener) {
+	err := srv.Serve(ln)
+	if err != nil && !errors.Is(err, net.ErrClosed) && err != http.ErrServerClosed {
+		fatal(err)
+	}
+}
+
+func logRequest(r *http.Request) {
+	slog.Debug("Request", "method", r.Method, "path", r.URL.Path, "addr", r.RemoteAddr, "agent", r.UserAgent())
+}
+
+// serveAck records a receiver's acknowledgement that it got the file with
+// the given checksum.
+func serveAck(w http.ResponseWriter, r *http.Request, sum string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 256))
+	if err != nil {
+		http.Error(w, "unable to read body", http.StatusBadRequest)
+		return
+	}
+	got := strings.TrimSpace(string(body))
+	if sum != "" && got != sum {
+		log.Printf("%s acknowledged a different checksum: %s", r.RemoteAddr, got)
+	} else {
+		log.Printf("%s received and verified the file.", r.RemoteAddr)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func setDisposition(w http.ResponseWriter, name string) {
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+}
+
+// serveHash answers the hash endpoint. An empty sum means the hash is not
+// known yet, and the client is asked to come back later.
+func serveHash(w http.ResponseWriter, sum string) {
+	if sum == "" {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "hash not ready", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintln(w, sum)
+}
+
+// fileHandler serves a single file at "/", honoring byte ranges so that pop
+// can resume an interrupted download.
+type fileHandler struct {
+	fn   string
+	name string
+	alg  hashing.Algorithm
+	// quiet leaves out progress bars, which are never removed once added,
+	// and the history, for soak tests.
+	quiet bool
+
+	mu  sync.Mutex
+	sum string
+	// sumOf is the version of the file sum was computed for.
+	sumOf fileVersion
+	// job computes the checksum in the background, nil when it is not.
+	job      *hashJob
+	manifest []byte
+	// manifestOf is the version of the file manifest describes.
+	manifestOf fileVersion
+	// signer, when set, signs the manifest into signature, see sign.
+	signer    ed25519.PrivateKey
+	signature []byte
+
+	// blocksMu guards the block checksums, computed apart from the rest so
+	// that they hold up nothing else.
+	blocksMu sync.Mutex
+	blocks   []byte
+	// blocksOf is the version of the file blocks describe.
+	blocksOf fileVersion
+}
+
+// fileVersion tells versions of a file apart, as cheaply as a stat.
+type fileVersion struct {
+	size  int64
+	mtime time.Time
+}
+
+// currentVersion returns the version of the file on disk.
+func (h *fileHandler) currentVersion() (fileVersion, error) {
+	fi, err := os.Stat(h.fn)
+	if err != nil {
+		return fileVersion{}, err
+	}
+	return fileVersion{fi.Size(), fi.ModTime()}, nil
+}
+
+func (h *fileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logRequest(r)
+	switch r.URL.Path {
+	case "/", downloadPath:
+		if wantsLanding(r) {
+			h.serveLanding(w)
+			return
+		}
+		h.serveFile(w, r)
+	case transfer.HashPath(h.alg):
+		h.serveHash(w)
+	case transfer.SessionPath:
+		h.serveSession(w)
+	case transfer.ManifestPath:
+		h.serveManifest(w)
+	case transfer.SignaturePath:
+		h.serveSignature(w)
+	case transfer.BlocksPath:
+		h.serveBlocks(w)
+	case transfer.ProbePath:
+		transfer.ServeProbe(w, r)
+	case transfer.AckPath:
+		serveAck(w, r, h.cached())
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveHash answers the checksum once it is known, and asks receivers to
+// come back later while it is computed in the background, rather than hold
+// their request for as long as hashing a big file takes.
+func (h *fileHandler) serveHash(w http.ResponseWriter) {
+	j, err := h.startHash()
+	if err != nil {
+		log.Println("Unable to hash file: ", err)
+		http.Error(w, "unable to hash file", http.StatusInternalServerError)
+		return
+	}
+	select {
+	case <-j.done:
+	default:
+		w.Header().Set("Retry-After", strconv.Itoa(int(j.retryAfter()/time.Second)))
+		http.Error(w, "hash not ready", http.StatusServiceUnavailable)
+		return
+	}
+	if j.err != nil {
+		log.Println("Unable to hash file: ", j.err)
+		http.Error(w, "unable to hash file", http.StatusInternalServerError)
+		return
+	}
+	serveHash(w, j.sum)
+}
+
+// cached returns the checksum of the file if it was already computed for
+// its current version.
+func (h *fileHandler) cached() string {
+	v, err := h.currentVersion()
+	if err != nil {
+		return ""
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if v != h.sumOf {
+		return ""
+	}
+	return h.sum
+}
+
+// setSum records sum, known from elsewhere, as the checksum of the current
+// version of the file.
+func (h *fileHandler) setSum(sum string) error {
+	v, err := h.currentVersion()
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sum, h.sumOf = sum, v
+	return nil
+}
+
+// hash returns the checksum of the file, computing it again whenever the
+// file changed.
+func (h *fileHandler) hash() (string, error) {
+	j, err := h.startHash()
+	if err != nil {
+		return "", err
+	}
+	<-j.done
+	return j.sum, j.err
+}
+
+// hashJob computes the checksum of a version of the file in the background.
+type hashJob struct {
+	of      fileVersion
+	started time.Time
+	hashed  int64 // atomic
+	// done is closed once sum or err is set.
+	done chan struct{}
+	sum  string
+	err  error
+}
+
+// startHash starts computing the checksum of the current version of the
+// file in the background, unless it is known or already being computed,
+// and returns the job computing it.
+func (h *fileHandler) startHash() (*hashJob, error) {
+	v, err := h.currentVersion()
+	if err != nil {
+		return nil, err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.sum != "" && v == h.sumOf {
+		j := &hashJob{of: v, done: make(chan struct{}), sum: h.sum}
+		close(j.done)
+		return j, nil
+	}
+	if h.job != nil && h.job.of == v {
+		return h.job, nil
+	}
+	j := &hashJob{of: v, started: time.Now(), done: make(chan struct{})}
+	h.job = j
+	go h.run(j)
+	return j, nil
+}
+
+// run computes the checksum for j, showing its progress.
+func (h *fileHandler) run(j *hashJob) {
+	add := func(n int64) { atomic.AddInt64(&j.hashed, n) }
+	if !h.quiet {
+		t, finish := newProgress("Hashing "+h.name, j.of.size)
+		defer finish()
+		add = func(n int64) {
+			atomic.AddInt64(&j.hashed, n)
+			t.add(n)
+		}
+	}
+	j.sum, j.err = hashes.SumFile(h.alg, h.fn, add)
+	h.mu.Lock()
+	if h.job == j {
+		h.job = nil
+	}
+	// A change while hashing shows in the next version check.
+	if j.err == nil {
+		h.sum, h.sumOf = j.sum, j.of
+	}
+	h.mu.Unlock()
+	close(j.done)
+}
+
+// retryAfter is how long receivers should wait for j, from how fast it went
+// so far, between a second and maxRetryAfter.
+func (j *hashJob) retryAfter() time.Duration {
+	hashed := atomic.LoadInt64(&j.hashed)
+	elapsed := time.Since(j.started)
+	if hashed <= 0 || elapsed <= 0 {
+		return time.Second
+	}
+	left := time.Duration(float64(j.of.size-hashed) / float64(hashed) * float64(elapsed))
+	if left < time.Second {
+		return time.Second
+	}
+	if left > maxRetryAfter {
+		return maxRetryAfter
+	}
+	return left.Round(time.Second)
+}
+
+// maxRetryAfter bounds the wait asked of receivers, so that they notice a
+// faster finish soon enough.
+const maxRetryAfter = 10 * time.Second
+
+func (h *fileHandler) serveLanding(w http.ResponseWriter) {
+	fi, err := os.Stat(h.fn)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "unable to stat file", http.StatusInternalServerError)
+		return
+	}
+	serveLanding(w, landing{Name: h.name, Size: fi.Size(), Alg: h.alg, Sum: h.cached()})
+}
+
+func (h *fileHandler) serveFile(w http.ResponseWriter, r *http.Request) {
+	began := time.Now()
+
+	f, err := openReadOnly(h.fn)
+	if err != nil {
+		log.Println("Unable to open file: ", err)
+		http.Error(w, "unable to open file", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "unable to stat file", http.StatusInternalServerError)
+		return
+	}
+	size := fi.Size()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	setDisposition(w, h.name)
+	meta := transfer.Meta{Algorithm: h.alg, Sum: h.cached(), Size: size, Mtime: fi.ModTime(), Mode: fi.Mode().Perm()}
+	if meta.Sum == "" {
+		// Receivers without the checksum ask for it after downloading, by
+		// when it should be ready.
+		h.startHash()
+	}
+	transfer.SetMeta(w.Header(), meta)
+	w.Header().Set("Vary", "Accept-Encoding")
+
+	out, leave := bandwidth.writer(w, weightOf(r))
+	defer leave()
+	// Only whole files are compressed, so that ranges keep meaning offsets
+	// in the file.
+	if r.Header.Get("Range") == "" && r.Method == http.MethodGet && transfer.WantsGzip(r) && transfer.Compressible(h.name) {
+		h.serveGzip(w, r, f, out, size, began)
+		return
+	}
+	// ServeContent answers ranges and conditional requests, and lets the
+	// kernel send the file with sendfile.
+	fair, _ := out.(*fairWriter)
+	sw := &sendWriter{ResponseWriter: w, out: out, fair: fair, req: r, quiet: h.quiet || r.Method == http.MethodHead}
+	defer sw.finish()
+	http.ServeContent(sw, r, h.name, fi.ModTime(), f)
+	if !h.quiet && r.Method != http.MethodHead && (sw.status == http.StatusOK || sw.status == http.StatusPartialContent) {
+		// An interrupted download leaves fewer bytes sent than announced.
+		var err error
+		if want, parseErr := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64); parseErr == nil && sw.n < want {
+			err = fmt.Errorf("sent %d bytes out of %d", sw.n, want)
+			log.Println("Unable to send file: ", err)
+		}
+		recordSend(r, h.name, h.fn, sw.n, h.alg, h.cached(), began, err)
+	}
+}
+
+// serveGzip sends the whole file f, of size bytes, compressed to out.
+func (h *fileHandler) serveGzip(w http.ResponseWriter, r *http.Request, f *os.File, out io.Writer, size int64, began time.Time) {
+	w.Header().Set("Content-Encoding", "gzip")
+	var rd io.Reader = f
+	if !h.quiet {
+		t, done := newDownload(r, size)
+		defer done()
+		rd = &countingReader{rd, t}
+	}
+	zw, _ := gzip.NewWriterLevel(out, gzip.BestSpeed)
+	n, err := bufferSize.Copy(zw, rd, 0)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if !h.quiet {
+		recordSend(r, h.name, h.fn, n, h.alg, h.cached(), began, err)
+	}
+	if err != nil {
+		log.Println("Unable to copy file: ", err)
+	}
+}
+
+// streamHandler serves a stream that can only be read once, such as stdin.
+// The first receiver gets it with chunked encoding and no resume support;
+// its checksum is computed on the way out and served once the stream ends.
+type streamHandler struct {
+	r    io.Reader
+	name string
+	alg  hashing.Algorithm
+
+	mu      sync.Mutex
+	started bool
+	sum     string
+}
+
+func (h *streamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logRequest(r)
+	switch r.URL.Path {
+	case "/", downloadPath:
+		if wantsLanding(r) {
+			h.mu.Lock()
+			sum := h.sum
+			h.mu.Unlock()
+			serveLanding(w, landing{Name: h.name, Size: -1, Alg: h.alg, Sum: sum})
+			return
+		}
+		h.serveStream(w, r)
+	case transfer.HashPath(h.alg):
+		h.mu.Lock()
+		sum := h.sum
+		h.mu.Unlock()
+		serveHash(w, sum)
+	case transfer.SessionPath:
+		h.mu.Lock()
+		sum := h.sum
+		h.mu.Unlock()
+		serveSession(w, transfer.NewManifest(h.name, transfer.Meta{Algorithm: h.alg, Size: -1, Sum: sum}), shareCapabilities)
+	case transfer.ProbePath:
+		transfer.ServeProbe(w, r)
+	case transfer.AckPath:
+		h.mu.Lock()
+		sum := h.sum
+		h.mu.Unlock()
+		serveAck(w, r, sum)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *streamHandler) serveStream(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	setDisposition(w, h.name)
+	transfer.SetMeta(w.Header(), transfer.Meta{Algorithm: h.alg, Size: -1})
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	h.mu.Lock()
+	if h.started {
+		h.mu.Unlock()
+		http.Error(w, "stream already consumed", http.StatusGone)
+		return
+	}
+	h.started = true
+	h.mu.Unlock()
+
+	start := time.Now()
+	hasher := h.alg.New()
+	n, err := bufferSize.Copy(w, io.TeeReader(h.r, hasher), 0)
+	if err != nil {
+		recordSend(r, h.name, "", n, h.alg, "", start, err)
+		log.Println("Unable to stream: ", err)
+		return
+	}
+	log.Println("Streamed", n, "bytes to", r.RemoteAddr)
+
+	sum := hashing.Hex(hasher)
+	h.mu.Lock()
+	h.sum = sum
+	h.mu.Unlock()
+	recordSend(r, h.name, "", n, h.alg, sum, start, nil)
+}
+
+// fitLabel returns label and the width of a progress bar to show with it,
+// fitted to the terminal as fitBar does. Wrapped lines garble
+// uiprogress's redraws.
+func fitLabel(label string) (string, int) {
+	width, _, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		return label, uiprogress.Width
+	}
+	return fitBar(width, label)
+}
+
+// barDecorations is the width of what a progress bar line shows besides the
+// label and the bar: elapsed time, percentage, rate and the spaces between
+// them.
+const barDecorations = 32
+
+// minBar is the width of the narrowest bar worth showing.
+const minBar = 10
+
+// fitBar returns label and the width of a bar fitting along with it in a
+// line of width columns. When there is no room for a bar of minBar
+// columns, the bar is left out, 0, though newBar still gives it a blank
+// column, and label is clamped to what is left. Nothing fits in less than
+// barDecorations+1 columns.
+func fitBar(width int, label string) (string, int) {
+	w := width - utf8.RuneCountInString(label) - barDecorations
+	switch {
+	case w > uiprogress.Width:
+		return label, uiprogress.Width
+	case w >= minBar:
+		return label, w
+	}
+	room := width - barDecorations - 1
+	if room < 2 {
+		return "", 0
+	}
+	return prompt.Clamp(label, room), 0
+}
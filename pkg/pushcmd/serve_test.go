@@ -0,0 +1,135 @@
+package pushcmd
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yifu/pushpop/pkg/hashing"
+)
+
+// secret is the content of a file next to the shared ones, which no request
+// may get.
+const secret = "not shared"
+
+// traversals are request targets trying to get out of the share, sent as
+// they are, unlike a client would.
+var traversals = []string{
+	"/../secret",
+	"/../../../../etc/passwd",
+	"/download/../secret",
+	"/%2e%2e/secret",
+	"/%2e%2e%2fsecret",
+	"/..%2fsecret",
+	"/%2fsecret",
+	"//secret",
+	"/shared/../../secret",
+	"/download/%2e%2e%2f%2e%2e%2fsecret",
+	"/./secret",
+	"secret",
+	"/etc/passwd",
+	"http://localhost/../secret",
+	"http://localhost/%2e%2e/secret",
+}
+
+// get sends a GET request for target to addr, written out by hand so that
+// nothing cleans the path, and returns the status and body of the answer.
+func get(t *testing.T, addr, target string) (int, string) {
+	t.Helper()
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	_, err = io.WriteString(c, "GET "+target+" HTTP/1.1\r\nHost: localhost\r\nUser-Agent: pushpop-pop/0.0\r\nConnection: close\r\n\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestTraversal(t *testing.T) {
+	root := t.TempDir()
+	shared := filepath.Join(root, "shared")
+	err := os.Mkdir(shared, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for fn, content := range map[string]string{
+		filepath.Join(root, "secret"):       secret,
+		filepath.Join(shared, "shared.txt"): "shared",
+	} {
+		err := os.WriteFile(fn, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tt := range []struct {
+		name    string
+		handler http.Handler
+	}{
+		{"file", &fileHandler{fn: filepath.Join(shared, "shared.txt"), name: "shared.txt", alg: hashing.Default, quiet: true}},
+		{"dir", &dirHandler{dir: shared, name: "shared", alg: hashing.Default}},
+		{"stream", &streamHandler{r: strings.NewReader("stream"), name: "stream", alg: hashing.Default}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+			addr := srv.Listener.Addr().String()
+			for _, target := range traversals {
+				status, body := get(t, addr, target)
+				if status != http.StatusBadRequest && status != http.StatusNotFound {
+					t.Errorf("GET %s: got %d, want 400 or 404", target, status)
+				}
+				if strings.Contains(body, secret) || strings.Contains(body, "root:") {
+					t.Errorf("GET %s served something outside the share: %q", target, body)
+				}
+			}
+		})
+	}
+}
+
+// TestTraversalArchive checks that the archive of a directory holds none of
+// the files around it, whatever links the directory has.
+func TestTraversalArchive(t *testing.T) {
+	root := t.TempDir()
+	shared := filepath.Join(root, "shared")
+	err := os.Mkdir(shared, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(root, "secret"), []byte(secret), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Symlink(filepath.Join(root, "secret"), filepath.Join(shared, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Symlink(root, filepath.Join(shared, "parent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &dirHandler{dir: shared, name: "shared", alg: hashing.Default}
+	var buf bytes.Buffer
+	err = h.writeTar(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte(secret)) {
+		t.Error("the archive holds a file from outside the directory")
+	}
+}
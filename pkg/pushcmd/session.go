@@ -0,0 +1,87 @@
+package pushcmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/user"
+	"sync"
+
+	"github.com/yifu/pushpop/pkg/transfer"
+)
+
+// sender is who shares, from where, as told in the share descriptions.
+var sender struct {
+	once       sync.Once
+	user, host string
+}
+
+// shareCapabilities are those of every share: the port always speaks TLS
+// too, see serveTLS.
+var shareCapabilities = transfer.Capabilities{TLS: true}
+
+// capable is implemented by the handlers of shares supporting more than
+// shareCapabilities.
+type capable interface {
+	capabilities() transfer.Capabilities
+}
+
+// capabilitiesOf returns the capabilities of the share served by h, as
+// announced and described to receivers.
+func capabilitiesOf(h http.Handler) transfer.Capabilities {
+	if r, ok := h.(*recipientHandler); ok {
+		h = r.Handler
+	}
+	if c, ok := h.(capable); ok {
+		return c.capabilities()
+	}
+	return shareCapabilities
+}
+
+// serveSession answers transfer.SessionPath, describing a share of file
+// served with caps.
+func serveSession(w http.ResponseWriter, file transfer.Manifest, caps transfer.Capabilities) {
+	sender.once.Do(func() {
+		if usr, err := user.Current(); err == nil {
+			sender.user = usr.Username
+		}
+		sender.host, _ = os.Hostname()
+	})
+	s := transfer.Session{
+		Version:      transfer.ProtocolVersion,
+		User:         sender.user,
+		Host:         sender.host,
+		Files:        []transfer.Manifest{file},
+		Capabilities: caps,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(s)
+}
+
+// serveSession describes the file as it is now, with its checksum once it
+// is known, so that receivers need not wait for it.
+func (h *fileHandler) serveSession(w http.ResponseWriter) {
+	fi, err := os.Stat(h.fn)
+	if err != nil {
+		http.Error(w, "file not found", http.StatusNotFound)
+		return
+	}
+	v := fileVersion{fi.Size(), fi.ModTime()}
+	h.mu.Lock()
+	meta := transfer.Meta{Algorithm: h.alg, Size: v.size, Mtime: v.mtime, Mode: fi.Mode().Perm()}
+	if h.sumOf == v {
+		meta.Sum = h.sum
+	}
+	h.mu.Unlock()
+	serveSession(w, transfer.NewManifest(h.name, meta), h.capabilities())
+}
+
+// capabilities adds ranges, and compression when the file gains from it, to
+// shareCapabilities.
+func (h *fileHandler) capabilities() transfer.Capabilities {
+	caps := shareCapabilities
+	caps.Resume = true
+	caps.Compression = transfer.Compressible(h.name)
+	return caps
+}
@@ -0,0 +1,248 @@
+package pushcmd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/yifu/pushpop/pkg/control"
+	"github.com/yifu/pushpop/pkg/discovery"
+	"github.com/yifu/pushpop/pkg/hashcache"
+	"github.com/yifu/pushpop/pkg/hashing"
+	"github.com/yifu/pushpop/pkg/mux"
+	"github.com/yifu/pushpop/pkg/netlimit"
+	"github.com/yifu/pushpop/pkg/transfer"
+)
+
+// limits bounds the connections of receivers, across the shares of a push,
+// set by -max-conns, -max-conns-per-ip, -conn-timeout, -header-timeout,
+// -idle-timeout and -write-timeout.
+var limits = netlimit.New()
+
+// bufferSize is the -buffer-size flag, the size of the buffers shared files
+// are copied and hashed through.
+var bufferSize transfer.BufferSize
+
+// hashes is how shared files are hashed, set by -hash-xattr and
+// -buffer-size.
+var hashes hashcache.Options
+
+// share is a file being served on its own port and announced over mDNS.
+type share struct {
+	// id identifies the share to pushpop revoke.
+	id   string
+	name string
+	// gen is the generation of the share, 0 when it has none.
+	gen    int
+	port   int
+	srv    *http.Server
+	server discovery.Announcement
+	// stop ends the announcement.
+	stop context.CancelFunc
+	// textMu guards text, which pin rebuilds from goroutines of their own
+	// such as those announcing the checksum and the signer.
+	textMu sync.Mutex
+	// text is the TXT record of the share.
+	text []string
+	// cleanup, when set, runs once the share is closed.
+	cleanup func()
+
+	once sync.Once
+	// done is closed with the share.
+	done chan struct{}
+}
+
+// announce serves handler on a fresh port, subject to -allow, -deny and the
+// connection limits, and announces it as name, or under an opaque name
+// with -private.
+func announce(name string, alg hashing.Algorithm, handler http.Handler) (*share, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	ln, err := listen()
+	if err != nil {
+		return nil, err
+	}
+	addr := ln.Addr()
+	hostport := addr.String()
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	fmt.Println("host:", host, ", port:", port)
+	portn, err := strconv.Atoi(port)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	usr, err := user.Current()
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	kv := fmt.Sprintf("%s=%s", transfer.UserKey, usr.Username)
+	text := []string{kv, transfer.HashKey + "=" + alg.Name()}
+	instance := name
+	gen := 0
+	if privateCode != "" {
+		instance = transfer.CodeInstance(privateCode)
+		text = []string{transfer.HashKey + "=" + alg.Name()}
+	} else {
+		// Instance names must differ for an older push of the same name
+		// to stay visible; receivers go by the name and generation.
+		gen, err = control.NextGeneration(name)
+		if err != nil {
+			log.Println("Unable to number the share: ", err)
+		} else {
+			text = append(text, transfer.NameKey+"="+name, transfer.GenerationKey+"="+strconv.Itoa(gen))
+			if gen > 1 {
+				instance = fmt.Sprintf("%s (%d)", name, gen)
+			}
+		}
+	}
+
+	text = append(text, transfer.VersionKey+"="+strconv.Itoa(transfer.ProtocolVersion),
+		transfer.CapsKey+"="+capabilitiesOf(handler).String())
+	if fp := certFingerprint(); fp != "" {
+		text = append(text, transfer.CertKey+"="+fp)
+	} else {
+		log.Println("Unable to generate the TLS certificate: ", cert.err)
+	}
+	if hint := preferHint(); hint != "" {
+		text = append(text, transfer.PreferKey+"="+hint)
+	}
+
+	// HTTP and HTTPS share the announced port.
+	srv := limits.Server(withAccess(withCode(handler)))
+	mx := mux.New(limits.Listener(ln))
+	go serve(srv, mx.Match(mux.HTTP))
+	go serveTLS(srv, mx.Match(mux.TLS))
+	go mx.Serve()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	server, err := discovery.Announce(ctx, instance, bindIP, portn, text)
+	if err != nil {
+		cancel()
+		srv.Close()
+		ln.Close()
+		return nil, err
+	}
+	s := &share{id: id, name: name, gen: gen, port: portn, srv: srv, server: server, stop: cancel, text: text, done: make(chan struct{})}
+	addShare(s)
+	return s, nil
+}
+
+// close stops announcing the share and serving it, cutting downloads in
+// progress. Closing a share twice is harmless.
+func (s *share) close() {
+	s.once.Do(func() {
+		s.stop()
+		s.server.Shutdown()
+		s.srv.Close()
+		if s.cleanup != nil {
+			s.cleanup()
+		}
+		close(s.done)
+	})
+}
+
+// pin sets key=value in the TXT record of the share and announces it again.
+// An empty value removes key.
+func (s *share) pin(key, value string) {
+	s.textMu.Lock()
+	defer s.textMu.Unlock()
+	// The server keeps the slice it is given, so it always gets a new one.
+	var text []string
+	for _, kv := range s.text {
+		if !strings.HasPrefix(kv, key+"=") {
+			text = append(text, kv)
+		}
+	}
+	if value != "" {
+		text = append(text, key+"="+value)
+	}
+	s.text = text
+	s.server.SetText(s.text)
+}
+
+// newID returns a random share id.
+func newID() (string, error) {
+	b := make([]byte, 4)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// shares holds every open share, so they can be listed, revoked and closed
+// on exit.
+var shares struct {
+	mu   sync.Mutex
+	list []*share
+}
+
+func addShare(s *share) {
+	shares.mu.Lock()
+	defer shares.mu.Unlock()
+	shares.list = append(shares.list, s)
+}
+
+// openShares returns the shares not closed yet.
+func openShares() []*share {
+	shares.mu.Lock()
+	defer shares.mu.Unlock()
+	var open []*share
+	for _, s := range shares.list {
+		select {
+		case <-s.done:
+		default:
+			open = append(open, s)
+		}
+	}
+	return open
+}
+
+// findShare returns the open share with the given id, or nil.
+func findShare(id string) *share {
+	for _, s := range openShares() {
+		if s.id == id {
+			return s
+		}
+	}
+	return nil
+}
+
+// shareOf returns the open share r came in through, or nil.
+func shareOf(r *http.Request) *share {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(*net.TCPAddr)
+	if !ok {
+		return nil
+	}
+	for _, s := range openShares() {
+		if s.port == addr.Port {
+			return s
+		}
+	}
+	return nil
+}
+
+func closeShares() {
+	shares.mu.Lock()
+	defer shares.mu.Unlock()
+	for _, s := range shares.list {
+		s.close()
+	}
+	shares.list = nil
+}
@@ -0,0 +1,50 @@
+package pushcmd
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// fakeAnnouncement records the last TXT record it was given.
+type fakeAnnouncement struct {
+	mu   sync.Mutex
+	text []string
+}
+
+func (a *fakeAnnouncement) SetText(text []string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.text = text
+}
+
+func (a *fakeAnnouncement) Shutdown() {}
+
+// TestPinConcurrent pins keys from several goroutines, as the checksum and
+// signer announcements do; go test -race catches unguarded updates.
+func TestPinConcurrent(t *testing.T) {
+	a := &fakeAnnouncement{}
+	s := &share{server: a, text: []string{"hash=blake3"}}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s.pin("k"+strconv.Itoa(i), "v")
+		}(i)
+	}
+	wg.Wait()
+	s.pin("k0", "")
+	got := append([]string(nil), a.text...)
+	sort.Strings(got)
+	want := []string{"hash=blake3", "k1=v", "k2=v", "k3=v", "k4=v", "k5=v", "k6=v", "k7=v"}
+	if len(got) != len(want) {
+		t.Fatalf("text = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("text = %v, want %v", got, want)
+		}
+	}
+}
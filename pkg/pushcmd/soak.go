@@ -0,0 +1,157 @@
+package pushcmd
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log/slog"
+	mrand "math/rand"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/hashing"
+	"github.com/yifu/pushpop/pkg/tempfile"
+	"github.com/yifu/pushpop/pkg/transfer"
+)
+
+// soakMaxSize bounds the random size of the files served during a soak.
+const soakMaxSize = 64 << 20
+
+// soakStats accumulates what happened during a soak.
+type soakStats struct {
+	cycles, failures, disconnects int
+	bytes                         int64
+}
+
+// soak runs push/pop cycles against fileHandler for d: every cycle shares a
+// file of random size, downloads part of it, drops the connection, resumes
+// with a range request and checks the result against the served checksum.
+// Goroutine and file descriptor counts after the first cycle, which sets up
+// the network poller, are compared with the counts at the end, and soak
+// exits with an error when they grew.
+func soak(d time.Duration, tmpdir string, alg hashing.Algorithm) {
+	// The server side logs every dropped connection.
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	fmt.Printf("Soaking for %v.\n", d)
+
+	var stats soakStats
+	var goroutines, fds int
+	deadline := time.Now().Add(d)
+	for stats.cycles == 0 || time.Now().Before(deadline) {
+		err := soakCycle(tmpdir, alg, &stats)
+		stats.cycles++
+		if err != nil {
+			stats.failures++
+			fmt.Fprintf(os.Stderr, "Cycle %d failed: %v\n", stats.cycles, err)
+		}
+		if stats.cycles == 1 {
+			goroutines, fds = runtime.NumGoroutine(), openFDs()
+		}
+	}
+
+	// Give closed connections a moment to wind down before counting.
+	time.Sleep(100 * time.Millisecond)
+	runtime.GC()
+	endGoroutines, endFDs := runtime.NumGoroutine(), openFDs()
+
+	fmt.Printf("Soak summary: %d cycles, %d failures, %d disconnects, %d bytes.\n",
+		stats.cycles, stats.failures, stats.disconnects, stats.bytes)
+	fmt.Printf("Goroutines: %d -> %d, open files: %d -> %d.\n", goroutines, endGoroutines, fds, endFDs)
+	if stats.failures > 0 || endGoroutines > goroutines || endFDs > fds {
+		os.Exit(1)
+	}
+}
+
+func soakCycle(tmpdir string, alg hashing.Algorithm, stats *soakStats) error {
+	size := mrand.Int63n(soakMaxSize + 1)
+	f, err := tempfile.Create(tmpdir, "pushpop-soak-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = io.CopyN(f, rand.Reader, size)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: &fileHandler{fn: f.Name(), name: "soak", alg: alg, quiet: true}}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	url := fmt.Sprintf("http://%s/", ln.Addr())
+	client := &http.Client{Transport: &http.Transport{}}
+	defer client.CloseIdleConnections()
+
+	// Download a random prefix, then hang up.
+	cut := int64(0)
+	if size > 0 {
+		cut = mrand.Int63n(size)
+	}
+	h := alg.New()
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	n, err := io.CopyN(h, resp.Body, cut)
+	resp.Body.Close()
+	stats.bytes += n
+	stats.disconnects++
+	if err != nil {
+		return err
+	}
+
+	// Resume from the cut.
+	if cut < size {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", cut))
+		resp, err = client.Do(req)
+		if err != nil {
+			return err
+		}
+		n, err = io.Copy(h, resp.Body)
+		resp.Body.Close()
+		stats.bytes += n
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusPartialContent {
+			return fmt.Errorf("resume answered %s", resp.Status)
+		}
+	}
+
+	resp, err = client.Get(transfer.HashURL(url, alg))
+	if err != nil {
+		return err
+	}
+	remote, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if got, want := hashing.Hex(h), strings.TrimSpace(string(remote)); got != want {
+		return fmt.Errorf("checksum mismatch for %d bytes cut at %d: got %s, want %s", size, cut, got, want)
+	}
+	return nil
+}
+
+// openFDs counts the open file descriptors of the process, or returns -1
+// where /proc is not available.
+func openFDs() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(entries)
+}
@@ -0,0 +1,57 @@
+package pushcmd
+
+import (
+	"fmt"
+	"net"
+)
+
+// tailscale is the -tailscale flag: shares are printed with the tailnet
+// address of this machine, so that a receiver of the same tailnet, working
+// from elsewhere, can reach them with pop -url or the peers file. The
+// machine must run Tailscale; its tailnet address is the one in the range
+// Tailscale hands out. push does not join the tailnet itself with tsnet,
+// which would build the Tailscale client into it.
+var tailscale bool
+
+// tailnetRanges are the addresses Tailscale gives to the machines of a
+// tailnet.
+var tailnetRanges = []string{"100.64.0.0/10", "fd7a:115c:a1e0::/48"}
+
+// tailnetIP returns the tailnet address of this machine, IPv4 when it has
+// one.
+func tailnetIP() (net.IP, error) {
+	var nets []*net.IPNet
+	for _, cidr := range tailnetRanges {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	var v6 net.IP
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		for _, n := range nets {
+			if !n.Contains(ipnet.IP) {
+				continue
+			}
+			if ip4 := ipnet.IP.To4(); ip4 != nil {
+				return ip4, nil
+			}
+			if v6 == nil {
+				v6 = ipnet.IP
+			}
+		}
+	}
+	if v6 != nil {
+		return v6, nil
+	}
+	return nil, fmt.Errorf("No tailnet address found, is Tailscale running?")
+}
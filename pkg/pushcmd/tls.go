@@ -0,0 +1,95 @@
+package pushcmd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/transfer"
+)
+
+// cert is push's self-signed certificate, generated along with the first
+// share, and fingerprint its fingerprint, see transfer.CertFingerprint.
+var cert struct {
+	once        sync.Once
+	cert        tls.Certificate
+	fingerprint string
+	err         error
+}
+
+// certificate returns push's certificate, generating it the first time.
+func certificate() (*tls.Certificate, error) {
+	cert.once.Do(func() {
+		cert.cert, cert.err = selfSigned()
+		if cert.err == nil {
+			cert.fingerprint = transfer.CertFingerprint(cert.cert.Certificate[0])
+		}
+	})
+	return &cert.cert, cert.err
+}
+
+// certFingerprint returns the fingerprint of push's certificate, "" when
+// it could not be generated.
+func certFingerprint() string {
+	_, err := certificate()
+	if err != nil {
+		return ""
+	}
+	return cert.fingerprint
+}
+
+// serveTLS serves srv over TLS on ln, which shares the share's port with
+// plain HTTP. Clients pin the certificate fingerprint, announced with
+// transfer.CertKey and given in the HTTPS URL push prints, or skip
+// verification (curl -k).
+func serveTLS(srv *http.Server, ln net.Listener) {
+	config := &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return certificate()
+		},
+	}
+	serve(srv, tls.NewListener(ln, config))
+}
+
+// selfSigned generates a certificate for the host name, valid for a day.
+func selfSigned() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip, err := localIP(); err == nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
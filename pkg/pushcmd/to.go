@@ -0,0 +1,167 @@
+package pushcmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+	"github.com/yifu/pushpop/pkg/discovery"
+	"github.com/yifu/pushpop/pkg/hashing"
+	"github.com/yifu/pushpop/pkg/history"
+	"github.com/yifu/pushpop/pkg/transfer"
+	"github.com/yifu/pushpop/pkg/version"
+)
+
+// pushTo uploads fn as name to the receiver username announced with
+// pop -receive, waiting for one to show up.
+func pushTo(username, fn, name string, alg hashing.Algorithm) error {
+	addr, err := findReceiver(username)
+	if err != nil {
+		return err
+	}
+
+	f, err := openReadOnly(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	sum, err := hashing.Sum(alg, f)
+	if err != nil {
+		return err
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	began := time.Now()
+	meta := transfer.Meta{Algorithm: alg, Sum: sum, Size: fi.Size(), Mtime: fi.ModTime(), Mode: fi.Mode().Perm()}
+	got, err := uploadTo(addr, f, name, meta, username)
+	for retry, wait := 1, time.Second; err != nil && err != transfer.ErrRejected && retry <= uploadRetries; retry, wait = retry+1, wait*2 {
+		if wait > maxRetryWait {
+			wait = maxRetryWait
+		}
+		log.Printf("Upload interrupted: %v, resuming in %s (%d/%d)", err, wait, retry, uploadRetries)
+		time.Sleep(wait)
+		// The receiver may be back on another port.
+		addr, err = findReceiver(username)
+		if err != nil {
+			continue
+		}
+		got, err = uploadTo(addr, f, name, meta, username)
+	}
+
+	host, _, _ := net.SplitHostPort(addr)
+	result := history.ResultOK
+	if err != nil {
+		result = err.Error()
+	}
+	e := history.Entry{
+		Time:      began,
+		Direction: history.Send,
+		User:      username,
+		Addr:      host,
+		Name:      name,
+		Path:      fn,
+		Size:      fi.Size(),
+		Algorithm: alg.Name(),
+		Sum:       sum,
+		Duration:  time.Since(began),
+		Result:    result,
+	}
+	histErr := history.Append(e)
+	if histErr != nil {
+		log.Println("Unable to record history: ", histErr)
+	}
+	notifier.Transfer(e)
+	if err != nil {
+		return err
+	}
+	if got != sum {
+		return fmt.Errorf("%s received something else: expected %s, got %s", username, sum, got)
+	}
+	fmt.Println("Sent", name, "to", username+", verified", alg.Name(), sum)
+	return nil
+}
+
+// How often pushTo resumes an interrupted upload, waiting twice as long
+// each time up to maxRetryWait.
+const (
+	uploadRetries = 5
+	maxRetryWait  = 30 * time.Second
+)
+
+// uploadTo makes one attempt at uploading f, the file described by meta, as
+// name to the receiver at addr, starting from what it already has.
+// Receivers that do not resume get the whole file.
+func uploadTo(addr string, f *os.File, name string, meta transfer.Meta, username string) (string, error) {
+	u := "http://" + addr + "/" + url.PathEscape(name)
+	c := &transfer.Client{UserAgent: version.UserAgent("push")}
+	offset, err := c.UploadOffset(u, meta)
+	if err == transfer.ErrNoResume {
+		offset = 0
+	} else if err != nil {
+		return "", err
+	}
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+	if offset > 0 {
+		log.Printf("Resuming the upload at %d bytes out of %d.", offset, meta.Size)
+	}
+	rd, done := trackProgress(f, username, meta.Size-offset)
+	defer done()
+	if offset == 0 {
+		return c.Upload(u, rd, meta)
+	}
+	return c.UploadFrom(u, rd, offset, meta)
+}
+
+// findReceiver browses for the receiver announced by username and returns
+// its host:port.
+func findReceiver(username string) (string, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	entries, err := discovery.Browse(ctx)
+	if err != nil {
+		return "", err
+	}
+	for entry := range entries {
+		if txtValue(entry, "user") != username || txtValue(entry, transfer.RoleKey) != transfer.RoleReceive {
+			continue
+		}
+		var ip net.IP
+		if len(entry.AddrIPv4) > 0 {
+			ip = entry.AddrIPv4[0]
+		} else if len(entry.AddrIPv6) > 0 {
+			ip = entry.AddrIPv6[0]
+		} else {
+			continue
+		}
+		return net.JoinHostPort(ip.String(), strconv.Itoa(entry.Port)), nil
+	}
+	return "", fmt.Errorf("No receiver found for %s", username)
+}
+
+// txtValue returns the value of key in the TXT record of entry, or "".
+func txtValue(entry *zeroconf.ServiceEntry, key string) string {
+	for _, kv := range entry.Text {
+		if strings.HasPrefix(kv, key+"=") {
+			return kv[len(key)+1:]
+		}
+	}
+	return ""
+}
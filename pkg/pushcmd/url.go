@@ -0,0 +1,117 @@
+package pushcmd
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+	"github.com/yifu/pushpop/pkg/clipboard"
+	"github.com/yifu/pushpop/pkg/peers"
+	"github.com/yifu/pushpop/pkg/transfer"
+)
+
+// localIP returns an address of this machine that peers on the LAN can
+// reach, preferring IPv4, or the -bind address, or the tailnet one with
+// -tailscale.
+func localIP() (net.IP, error) {
+	if bindIP != nil && !bindIP.IsUnspecified() {
+		return bindIP, nil
+	}
+	if tailscale {
+		return tailnetIP()
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var v6 net.IP
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok || ipnet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			if ip4 := ipnet.IP.To4(); ip4 != nil {
+				return ip4, nil
+			}
+			if v6 == nil {
+				v6 = ipnet.IP
+			}
+		}
+	}
+	if v6 != nil {
+		return v6, nil
+	}
+	return nil, fmt.Errorf("No LAN address found")
+}
+
+// shareURL returns the URL of a share listening on port.
+func shareURL(port int) (string, error) {
+	ip, err := localIP()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("http://%s/", net.JoinHostPort(ip.String(), strconv.Itoa(port))), nil
+}
+
+// httpsURL returns the HTTPS version of the share URL url, giving the
+// fingerprint fp of the certificate for receivers to pin.
+func httpsURL(url, fp string) string {
+	url = "https" + strings.TrimPrefix(url, "http")
+	sep := "?"
+	if strings.Contains(url, "?") {
+		sep = "&"
+	}
+	return url + sep + transfer.CertParam + "=" + fp
+}
+
+// printQR renders url as a QR code made of half blocks, so that a phone
+// can open the landing page by scanning the terminal.
+func printQR(url string) error {
+	qr, err := qrcode.New(url, qrcode.Medium)
+	if err != nil {
+		return err
+	}
+	fmt.Print(qr.ToSmallString(false))
+	return nil
+}
+
+// copyURL puts url on the clipboard, saying so.
+func copyURL(url string) {
+	osc52, err := clipboard.Copy([]byte(url), os.Stdout)
+	if err != nil {
+		log.Println("Unable to copy the URL to the clipboard: ", err)
+		return
+	}
+	if osc52 {
+		fmt.Println("URL sent to the clipboard through the terminal (OSC 52).")
+	} else {
+		fmt.Println("URL copied to the clipboard.")
+	}
+}
+
+// peersLine returns the line of the peers file of receivers describing a
+// share listening on port.
+func peersLine(port int) (string, bool) {
+	usr, err := user.Current()
+	if err != nil {
+		return "", false
+	}
+	ip, err := localIP()
+	if err != nil {
+		return "", false
+	}
+	return peers.Line(usr.Username, ip.String(), port), true
+}
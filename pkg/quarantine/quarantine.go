@@ -0,0 +1,104 @@
+// Package quarantine keeps the partial downloads pop finds corrupted, along
+// with a report of what did not match, rather than deleting them, so that a
+// flaky network card or disk can be told apart from a bug. Quarantined
+// files live in $XDG_DATA_HOME/pushpop/quarantine until pushpop gc removes
+// them.
+package quarantine
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/history"
+)
+
+// ReportSuffix is appended to the name of a quarantined file for its
+// report.
+const ReportSuffix = ".json"
+
+// Report tells why a file was quarantined.
+type Report struct {
+	Time time.Time `json:"time"`
+	// Path is where the file was found corrupted.
+	Path   string `json:"path"`
+	URL    string `json:"url,omitempty"`
+	Reason string `json:"reason"`
+	Size   int64  `json:"size"`
+	// Offset and Length locate the bytes that were checked, and Mismatch
+	// the first one that differed, when known.
+	Offset   int64 `json:"offset,omitempty"`
+	Length   int64 `json:"length,omitempty"`
+	Mismatch int64 `json:"mismatch,omitempty"`
+	// Expected and Actual are the checksums, computed with Algorithm, of
+	// what should have been there and of what was.
+	Algorithm string `json:"algorithm,omitempty"`
+	Expected  string `json:"expected,omitempty"`
+	Actual    string `json:"actual,omitempty"`
+}
+
+// Dir returns the quarantine directory.
+func Dir() (string, error) {
+	dir, err := history.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "quarantine"), nil
+}
+
+// Keep moves fn to the quarantine directory with r next to it, filling in
+// the time, path and size of r, and returns where fn went.
+func Keep(fn string, r Report) (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", err
+	}
+	fi, err := os.Stat(fn)
+	if err != nil {
+		return "", err
+	}
+	r.Time = time.Now()
+	r.Path, _ = filepath.Abs(fn)
+	r.Size = fi.Size()
+	dest := filepath.Join(dir, r.Time.Format("20060102-150405.000")+"-"+filepath.Base(fn))
+	err = move(fn, dest)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return dest, err
+	}
+	return dest, os.WriteFile(dest+ReportSuffix, append(data, '\n'), 0600)
+}
+
+// move renames src to dest, copying it when they are on different volumes.
+func move(src, dest string) error {
+	if os.Rename(src, dest) == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dest)
+		return err
+	}
+	return os.Remove(src)
+}
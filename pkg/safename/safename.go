@@ -0,0 +1,43 @@
+// Package safename turns the file names senders announce, which anyone on
+// the network can choose, into names that are safe to create in the
+// destination directory: a single path element, never "." or "..", and
+// without control characters that could mislead a terminal.
+package safename
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"unicode"
+)
+
+// windowsReserved are the characters Windows does not allow in file names,
+// besides the separators.
+const windowsReserved = `<>:"|?*`
+
+// Name returns the safe file name for name: its last element, whichever of
+// / and \ separates them, with the characters the system does not allow
+// replaced by _. It fails when name has control characters or nothing
+// usable is left.
+func Name(name string) (string, error) {
+	if strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		return "", fmt.Errorf("Invalid file name %q: control characters", name)
+	}
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	if runtime.GOOS == "windows" {
+		name = strings.Map(func(r rune) rune {
+			if strings.ContainsRune(windowsReserved, r) {
+				return '_'
+			}
+			return r
+		}, name)
+		// Windows drops trailing dots and spaces, "..." would be "..".
+		name = strings.TrimRight(name, ". ")
+	}
+	if strings.TrimSpace(name) == "" || name == "." || name == ".." {
+		return "", fmt.Errorf("Invalid file name %q", name)
+	}
+	return name, nil
+}
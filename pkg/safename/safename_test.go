@@ -0,0 +1,69 @@
+package safename
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestName(t *testing.T) {
+	for _, tt := range []struct {
+		name, want string
+	}{
+		{"report.pdf", "report.pdf"},
+		{"résumé.txt", "résumé.txt"},
+		{"with spaces .txt", "with spaces .txt"},
+		{"../../etc/passwd", "passwd"},
+		{"/etc/passwd", "passwd"},
+		{`..\..\boot.ini`, "boot.ini"},
+		{`C:\Users\alice\notes.txt`, "notes.txt"},
+		{"dir/sub\\file", "file"},
+		{".hidden", ".hidden"},
+		{"...", "..."},
+		{"", ""},
+		{" ", ""},
+		{".", ""},
+		{"..", ""},
+		{"dir/", ""},
+		{"dir/..", ""},
+		{`dir\.`, ""},
+		{"evil\x1b[31m.txt", ""},
+		{"new\nline", ""},
+		{"nul\x00", ""},
+		{"del\x7f", ""},
+		{"c1\u009b31m", ""},
+	} {
+		if runtime.GOOS == "windows" && tt.name == "..." {
+			continue
+		}
+		got, err := Name(tt.name)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("Name(%q) = %q, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Name(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestNameWindows(t *testing.T) {
+	if runtime.GOOS != "windows" {
+		t.Skip("Windows only")
+	}
+	for _, tt := range []struct {
+		name, want string
+	}{
+		{`a<b>:c"d|e?f*.txt`, "a_b__c_d_e_f_.txt"},
+		{"trailing. ", "trailing"},
+	} {
+		got, err := Name(tt.name)
+		if err != nil || got != tt.want {
+			t.Errorf("Name(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+	if _, err := Name("..."); err == nil {
+		t.Error(`Name("...") succeeded`)
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+// +build !windows
+
+package tempfile
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// crossDevice tells whether err is that of a rename across filesystems.
+func crossDevice(err error) bool {
+	return errors.Is(err, syscall.EXDEV)
+}
+
+// syncDir flushes the entries of dir to the disk, a new name in it among
+// them.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,17 @@
+package tempfile
+
+import (
+	"errors"
+
+	"golang.org/x/sys/windows"
+)
+
+// crossDevice tells whether err is that of a rename across volumes.
+func crossDevice(err error) bool {
+	return errors.Is(err, windows.ERROR_NOT_SAME_DEVICE)
+}
+
+// syncDir does nothing: Windows has no way to flush a directory.
+func syncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,35 @@
+package tempfile
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Preallocate reserves the blocks of the first size bytes of f, without
+// changing its size, so that a .part file is written to blocks reserved in
+// one go and a disk too full for it fails now rather than near the end.
+// Contiguous blocks are asked for first.
+func Preallocate(f *os.File, size int64) error {
+	var st unix.Stat_t
+	err := unix.Fstat(int(f.Fd()), &st)
+	if err != nil {
+		return err
+	}
+	// F_PEOFPOSMODE allocates from the end of what is allocated already.
+	allocated := st.Blocks * 512
+	if size <= allocated {
+		return nil
+	}
+	store := unix.Fstore_t{
+		Flags:   unix.F_ALLOCATECONTIG | unix.F_ALLOCATEALL,
+		Posmode: unix.F_PEOFPOSMODE,
+		Length:  size - allocated,
+	}
+	err = unix.FcntlFstore(f.Fd(), unix.F_PREALLOCATE, &store)
+	if err != nil {
+		store.Flags = unix.F_ALLOCATEALL
+		err = unix.FcntlFstore(f.Fd(), unix.F_PREALLOCATE, &store)
+	}
+	return err
+}
@@ -0,0 +1,19 @@
+package tempfile
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Preallocate reserves the blocks of the first size bytes of f, without
+// changing its size, so that a .part file is written to blocks reserved in
+// one go and a disk too full for it fails now rather than near the end.
+func Preallocate(f *os.File, size int64) error {
+	for {
+		err := unix.Fallocate(int(f.Fd()), unix.FALLOC_FL_KEEP_SIZE, 0, size)
+		if err != unix.EINTR {
+			return err
+		}
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package tempfile
+
+import (
+	"errors"
+	"os"
+)
+
+// Preallocate returns errors.ErrUnsupported: without a way to reserve blocks
+// and keep the size of f, which tells how much of a .part file is there,
+// files are left to grow as they are written.
+func Preallocate(f *os.File, size int64) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,133 @@
+// Package tempfile decides where pushpop puts its temporary artifacts.
+//
+// Files that end up somewhere (a download's .part file) are created next to
+// their final destination, so the last step is an atomic rename on the same
+// volume; Finalize copies them next to it first when they are elsewhere.
+// Files that have no destination (spools, snapshots) go to the
+// --tmpdir override, or to $TMPDIR when no override is given.
+package tempfile
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// PartSuffix is appended to a destination path while it is being written.
+const PartSuffix = ".part"
+
+// Options are how Finalize saves files. The zero Options leave flushing
+// them to the system.
+type Options struct {
+	// Sync makes Finalize flush the file and its directory to the disk
+	// before returning, so that a file reported as saved survives a crash
+	// or a power loss. It is the -fsync flag.
+	Sync bool
+}
+
+// Dir returns the directory for artifacts without a final destination.
+func Dir(override string) string {
+	if override != "" {
+		return override
+	}
+	return os.TempDir()
+}
+
+// Part returns the in-progress path for dest. It always lives in the same
+// directory as dest so that Finalize never has to cross a filesystem.
+func Part(dest string) string {
+	return dest + PartSuffix
+}
+
+// Create creates a spool file in Dir(override).
+func Create(override, pattern string) (*os.File, error) {
+	return os.CreateTemp(Dir(override), pattern)
+}
+
+// CreateNear creates a temporary file in the directory of dest, falling back
+// to Dir(override) when that directory is not writable.
+func CreateNear(dest, override, pattern string) (*os.File, error) {
+	f, err := os.CreateTemp(filepath.Dir(dest), pattern)
+	if err == nil {
+		return f, nil
+	}
+	return Create(override, pattern)
+}
+
+// Finalize moves a completed temporary file to dest. When the two are on
+// different filesystems, tmp is copied next to dest and the copy renamed,
+// so that dest still appears at once, complete.
+func Finalize(tmp, dest string) error {
+	return Options{}.Finalize(tmp, dest)
+}
+
+// Finalize is the package's Finalize with o.
+func (o Options) Finalize(tmp, dest string) error {
+	if o.Sync {
+		err := syncFile(tmp)
+		if err != nil {
+			return err
+		}
+	}
+	err := os.Rename(tmp, dest)
+	if err != nil && crossDevice(err) {
+		err = copyRename(tmp, dest, o.Sync)
+	}
+	if err != nil {
+		return err
+	}
+	if o.Sync {
+		return syncDir(filepath.Dir(dest))
+	}
+	return nil
+}
+
+// copyRename copies tmp to a temporary file next to dest, flushing it to
+// the disk with sync, renames it to dest and removes tmp.
+func copyRename(tmp, dest string, sync bool) error {
+	in, err := os.Open(tmp)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".*"+PartSuffix)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Chmod(fi.Mode().Perm())
+	}
+	if err == nil && sync {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), dest)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	in.Close()
+	return os.Remove(tmp)
+}
+
+// syncFile flushes the file at path to the disk.
+func syncFile(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
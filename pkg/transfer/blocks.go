@@ -0,0 +1,49 @@
+package transfer
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/delta"
+)
+
+// BlocksPath is where a sender serves the checksums of the blocks of its
+// file, for receivers holding an older version to only download the blocks
+// that changed.
+const BlocksPath = "/blocks"
+
+// blocksLimit caps the size of the block checksums a receiver reads, enough
+// for files of a few terabytes.
+const blocksLimit = 64 << 20
+
+// FetchBlocks fetches the block checksums of the share at url, waiting while
+// the sender computes them.
+func (c *Client) FetchBlocks(url string) (delta.Blocks, error) {
+	req, err := c.NewRequest(strings.TrimSuffix(url, "/") + BlocksPath)
+	if err != nil {
+		return delta.Blocks{}, err
+	}
+	for i := 0; i < hashRetries; i++ {
+		resp, err := c.Do(req)
+		if err != nil {
+			return delta.Blocks{}, err
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, blocksLimit))
+		resp.Body.Close()
+		if err != nil {
+			return delta.Blocks{}, err
+		}
+		switch resp.StatusCode {
+		case http.StatusOK:
+			return delta.Decode(data)
+		case http.StatusServiceUnavailable:
+			time.Sleep(RetryAfter(resp))
+			continue
+		}
+		return delta.Blocks{}, fmt.Errorf("Unexpected status for the block checksums: %s", resp.Status)
+	}
+	return delta.Blocks{}, fmt.Errorf("Sender never finished its block checksums")
+}
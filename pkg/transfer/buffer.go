@@ -0,0 +1,72 @@
+package transfer
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/yifu/pushpop/pkg/units"
+)
+
+// Sizes of the buffers files are copied through, as measured by
+// BenchmarkBuffer: up to 64 KiB, larger buffers save system calls on any
+// link; up to 512 KiB, they still help a little on links fast enough for
+// copying to be the bottleneck; past that, they stop fitting in the CPU
+// caches and hashing what is copied slows down.
+const (
+	DefaultBufferSize = 64 << 10
+	FastBufferSize    = 256 << 10
+	// fastBufferRate is the throughput, in bytes per second, from which
+	// FastBufferSize is used.
+	fastBufferRate = 100 << 20
+)
+
+// BufferSize is the size of the buffers files are copied through, 0
+// letting Buffer pick one. It is the -buffer-size flag, a size with an
+// optional unit such as 256KiB.
+type BufferSize int
+
+// Buffer returns a buffer to copy a file through, of s bytes when set, of a
+// size suiting a link of rate bytes per second otherwise, rate being 0 when
+// unknown.
+func (s BufferSize) Buffer(rate float64) []byte {
+	switch {
+	case s > 0:
+		return make([]byte, s)
+	case rate >= fastBufferRate:
+		return make([]byte, FastBufferSize)
+	}
+	return make([]byte, DefaultBufferSize)
+}
+
+// Copy copies src to dst like io.Copy, through a buffer from
+// s.Buffer(rate). The buffer is used even when src is a file, whose
+// WriteTo method, or dst, whose ReadFrom method, would otherwise copy
+// through one of their own, short of sendfile.
+func (s BufferSize) Copy(dst io.Writer, src io.Reader, rate float64) (int64, error) {
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, s.Buffer(rate))
+}
+
+// Copy is the Copy of a zero BufferSize, through a buffer suiting a link
+// of rate bytes per second.
+func Copy(dst io.Writer, src io.Reader, rate float64) (int64, error) {
+	return BufferSize(0).Copy(dst, src, rate)
+}
+
+func (s *BufferSize) String() string {
+	if s == nil || *s == 0 {
+		return ""
+	}
+	return units.Bytes(int64(*s))
+}
+
+func (s *BufferSize) Set(value string) error {
+	size, err := units.ParseSize(value)
+	if err != nil {
+		return err
+	}
+	if size < 1 || size > 1<<30 {
+		return fmt.Errorf("Invalid buffer size %q", value)
+	}
+	*s = BufferSize(size)
+	return nil
+}
@@ -0,0 +1,63 @@
+package transfer
+
+import (
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yifu/pushpop/pkg/hashing"
+	"github.com/yifu/pushpop/pkg/units"
+)
+
+// benchSizes are the buffer sizes BenchmarkBuffer compares.
+var benchSizes = []int{16 << 10, 32 << 10, 64 << 10, 128 << 10, 256 << 10, 512 << 10, 1 << 20, 4 << 20}
+
+// BenchmarkBuffer compares buffer sizes on the path of a download over the
+// loopback interface, the fastest link there is: a file served with
+// ServeContent, copied into a .part file as pop does while hashing it.
+func BenchmarkBuffer(b *testing.B) {
+	const size = 64 << 20
+	dir := b.TempDir()
+	src := filepath.Join(dir, "file")
+	f, err := os.Create(src)
+	if err != nil {
+		b.Fatal(err)
+	}
+	_, err = io.CopyN(f, rand.Reader, size)
+	f.Close()
+	if err != nil {
+		b.Fatal(err)
+	}
+	part, err := os.Create(filepath.Join(dir, "file.part"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer part.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, src)
+	}))
+	defer srv.Close()
+
+	for _, n := range benchSizes {
+		b.Run(units.Bytes(int64(n)), func(b *testing.B) {
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				resp, err := http.Get(srv.URL)
+				if err != nil {
+					b.Fatal(err)
+				}
+				part.Truncate(0)
+				part.Seek(0, io.SeekStart)
+				got, err := BufferSize(n).Copy(io.MultiWriter(part, hashing.Default.New()), resp.Body, 0)
+				resp.Body.Close()
+				if err != nil || got != size {
+					b.Fatalf("Downloaded %d bytes out of %d: %v", got, size, err)
+				}
+			}
+		})
+	}
+}
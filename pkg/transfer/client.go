@@ -0,0 +1,52 @@
+package transfer
+
+import "net/http"
+
+// Client makes the requests of a receiver to senders, and of a sender to
+// receivers. The zero Client sends them with http.DefaultClient, telling
+// neither who is asking nor any code.
+type Client struct {
+	// HTTP sends the requests; nil means http.DefaultClient.
+	HTTP *http.Client
+	// UserAgent is sent with every request.
+	UserAgent string
+	// User, when set, is sent as UserHeader with every request.
+	User string
+	// Code, when set, is sent as CodeHeader with every request, for
+	// private shares.
+	Code string
+}
+
+// Do sends req with c.HTTP.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	if c.HTTP == nil {
+		return http.DefaultClient.Do(req)
+	}
+	return c.HTTP.Do(req)
+}
+
+// NewRequest returns a GET request for url carrying the headers of c. It
+// asks for the file as is; see AcceptGzip to have it compressed.
+func (c *Client) NewRequest(url string) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.identify(req)
+	// Otherwise net/http asks for gzip on its own and hides that it did.
+	req.Header.Set("Accept-Encoding", "identity")
+	return req, nil
+}
+
+// identify sets the headers telling the sender who is asking.
+func (c *Client) identify(req *http.Request) {
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+	if c.User != "" {
+		req.Header.Set(UserHeader, c.User)
+	}
+	if c.Code != "" {
+		req.Header.Set(CodeHeader, c.Code)
+	}
+}
@@ -0,0 +1,41 @@
+package transfer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientHeaders(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer srv.Close()
+	for _, tt := range []struct {
+		name   string
+		client *Client
+		want   map[string]string
+	}{
+		{"zero", &Client{}, map[string]string{UserHeader: "", CodeHeader: ""}},
+		{"user", &Client{UserAgent: "test/1.0", User: "alice"}, map[string]string{"User-Agent": "test/1.0", UserHeader: "alice", CodeHeader: ""}},
+		{"code", &Client{HTTP: srv.Client(), Code: "c0de"}, map[string]string{UserHeader: "", CodeHeader: "c0de"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := tt.client.NewRequest(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := tt.client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			for k, v := range tt.want {
+				if got.Get(k) != v {
+					t.Errorf("%s = %q, want %q", k, got.Get(k), v)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package transfer
+
+import (
+	"net/http"
+	"time"
+)
+
+// DefaultMaxSkew is how far apart the clocks of two peers may be before it
+// is worth a warning.
+const DefaultMaxSkew = time.Minute
+
+// Skew estimates how far the peer's clock is ahead of ours, from the Date
+// header of resp to a request sent at sent. The request is assumed to have
+// reached the peer halfway through the round trip; Date only has a
+// resolution of one second anyway. ok is false when there is no Date header.
+func Skew(resp *http.Response, sent time.Time) (skew time.Duration, ok bool) {
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, false
+	}
+	mid := sent.Add(time.Since(sent) / 2)
+	return date.Sub(mid), true
+}
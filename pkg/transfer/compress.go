@@ -0,0 +1,57 @@
+package transfer
+
+import (
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// compressibleExts are extensions worth compressing on the fly besides
+// text/* types: formats made of text, and archives that are not
+// compressed themselves.
+var compressibleExts = map[string]bool{
+	".csv":  true,
+	".json": true,
+	".log":  true,
+	".md":   true,
+	".sql":  true,
+	".svg":  true,
+	".tar":  true,
+	".txt":  true,
+	".xml":  true,
+	".yaml": true,
+	".yml":  true,
+}
+
+// Compressible reports whether the file called name is likely to shrink
+// with gzip, judging by its extension.
+func Compressible(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	if compressibleExts[ext] {
+		return true
+	}
+	return strings.HasPrefix(mime.TypeByExtension(ext), "text/")
+}
+
+// AcceptGzip asks for the response to req to be compressed with gzip. The
+// receiver then has to decompress it, see ContentGzip.
+func AcceptGzip(req *http.Request) {
+	req.Header.Set("Accept-Encoding", "gzip")
+}
+
+// WantsGzip reports whether r accepts a gzip-compressed response.
+func WantsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		enc = strings.TrimSpace(enc)
+		if enc == "gzip" || strings.HasPrefix(enc, "gzip;") && !strings.HasSuffix(enc, "q=0") {
+			return true
+		}
+	}
+	return false
+}
+
+// ContentGzip reports whether resp is compressed with gzip.
+func ContentGzip(resp *http.Response) bool {
+	return strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip")
+}
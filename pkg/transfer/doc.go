@@ -0,0 +1,26 @@
+// Package transfer holds the pushpop HTTP protocol: the paths and headers a
+// sender serves, and the client side shared by pop and by push when it
+// relays another sender.
+//
+// A share is announced over mDNS, see package discovery, with a TXT record
+// of key=value pairs: UserKey, HashKey, NameKey, GenerationKey, VersionKey
+// and CapsKey, and when the sender has them, ManifestKey, SignerKey,
+// PreferKey, SwarmKey, SizeKey, SumKey and CertKey. The sender then serves, on the announced port:
+//
+//	/                 the file, honoring single byte ranges, see Meta
+//	/file.<algorithm> its checksum, see Client.FetchHash
+//	SessionPath       the description of the share, see Session
+//	ManifestPath      its manifest, whose checksum ManifestKey pins
+//	SignaturePath     the signature of the manifest, with SignerKey
+//	BlocksPath        the checksums of its blocks, see package delta
+//	ProbePath         data to measure the link with, see Client.Probe
+//	AckPath           where receivers acknowledge the file
+//
+// A Client fetches these for receivers, telling the sender who is asking;
+// downloading a share and checking it takes a few calls, see the example.
+//
+// The package is part of the stable API of pushpop, with package discovery
+// and package hashing: from v1 on, its exported names only change in
+// backward compatible ways within a major version, and senders and
+// receivers of the same major version understand each other.
+package transfer
@@ -0,0 +1,69 @@
+package transfer_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/yifu/pushpop/pkg/hashing"
+	"github.com/yifu/pushpop/pkg/transfer"
+)
+
+// Downloading a share and checking it takes a few calls: its manifest,
+// checked against the checksum announced under ManifestKey, then the file,
+// checked against the manifest.
+func Example() {
+	srv, pinned := serve("hello.txt", "hello, world\n")
+	defer srv.Close()
+
+	c := &transfer.Client{UserAgent: "mytool/1.0"}
+	m, err := c.FetchManifest(srv.URL, pinned, hashing.BLAKE3)
+	if err != nil {
+		log.Fatal(err)
+	}
+	req, err := c.NewRequest(srv.URL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var out bytes.Buffer
+	sum, err := hashing.Sum(hashing.BLAKE3, io.TeeReader(resp.Body, &out))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if sum != m.Sum {
+		log.Fatal("Checksum mismatch")
+	}
+	fmt.Printf("%s: %q\n", m.Name, out.String())
+	// Output: hello.txt: "hello, world\n"
+}
+
+// serve starts a sender of a file called name holding content, with the
+// endpoints Example uses, and returns it with the checksum of its manifest.
+func serve(name, content string) (*httptest.Server, string) {
+	sum, _ := hashing.Sum(hashing.BLAKE3, bytes.NewReader([]byte(content)))
+	m := transfer.NewManifest(name, transfer.Meta{Algorithm: hashing.BLAKE3, Size: int64(len(content)), Sum: sum})
+	manifest, err := m.Encode()
+	if err != nil {
+		log.Fatal(err)
+	}
+	pinned, _ := hashing.Sum(hashing.BLAKE3, bytes.NewReader(manifest))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case transfer.ManifestPath:
+			w.Write(manifest)
+		case "/":
+			io.WriteString(w, content)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return srv, pinned
+}
@@ -0,0 +1,13 @@
+package transfer
+
+import "net/http"
+
+// FollowHeader is set by senders serving a file that is still being
+// written. The response goes on, with pauses, until the sender marks the
+// file complete, and the file's checksum is only known after that.
+const FollowHeader = "X-PushPop-Follow"
+
+// Following reports whether resp streams a file still being written.
+func Following(resp *http.Response) bool {
+	return resp.Header.Get(FollowHeader) != ""
+}
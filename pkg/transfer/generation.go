@@ -0,0 +1,10 @@
+package transfer
+
+// NameKey is the TXT record key of the file name of a share, which its
+// instance name only matches for its first generation.
+const NameKey = "name"
+
+// GenerationKey is the TXT record key of the generation of a share: pushes
+// of the same name by the same user count up, so that receivers can pick
+// the newest.
+const GenerationKey = "gen"
@@ -0,0 +1,130 @@
+package transfer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/hashing"
+)
+
+// ManifestPath is where a sender serves the manifest of its share.
+const ManifestPath = "/manifest.json"
+
+// ManifestKey is the TXT record key pinning the checksum of the manifest,
+// computed with the share's algorithm. A receiver that finds it trusts the
+// metadata of the share only once the manifest matches.
+const ManifestKey = "manifest"
+
+// manifestLimit caps the size of a manifest a receiver reads.
+const manifestLimit = 1 << 20
+
+// ErrManifestMismatch is returned by FetchManifest when the manifest does not
+// match the pinned checksum.
+var ErrManifestMismatch = errors.New("Manifest does not match the announced checksum")
+
+// Manifest describes the file of a share. Its checksum is announced over
+// mDNS, so it anchors the trust in everything else the sender tells.
+type Manifest struct {
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	Mtime     int64  `json:"mtime,omitempty"`
+	Mode      uint32 `json:"mode,omitempty"`
+	Algorithm string `json:"algorithm"`
+	Sum       string `json:"sum"`
+	// Version and Caps describe the sender rather than the file: its
+	// ProtocolVersion and its Capabilities as in CapsKey. Senders that
+	// predate them leave them out.
+	Version int    `json:"version,omitempty"`
+	Caps    string `json:"caps,omitempty"`
+}
+
+// NewManifest returns the manifest of a file called name, described by m.
+func NewManifest(name string, m Meta) Manifest {
+	man := Manifest{Name: name, Size: m.Size, Mode: uint32(m.Mode), Algorithm: m.Algorithm.Name(), Sum: m.Sum}
+	if !m.Mtime.IsZero() {
+		man.Mtime = m.Mtime.Unix()
+	}
+	return man
+}
+
+// Meta returns the metadata the manifest describes, the inverse of
+// NewManifest.
+func (m Manifest) Meta() (Meta, error) {
+	a, err := hashing.Lookup(m.Algorithm)
+	if err != nil {
+		return Meta{}, err
+	}
+	meta := Meta{Algorithm: a, Sum: m.Sum, Size: m.Size, Mode: os.FileMode(m.Mode).Perm()}
+	if m.Mtime != 0 {
+		meta.Mtime = time.Unix(m.Mtime, 0)
+	}
+	return meta, nil
+}
+
+// Encode returns the manifest as served, the bytes its checksum is computed
+// over.
+func (m Manifest) Encode() ([]byte, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// signed returns the bytes a signature of the manifest is computed over:
+// the manifest as served, less Version and Caps, which receivers that
+// predate them would drop before checking the signature.
+func (m Manifest) signed() ([]byte, error) {
+	m.Version, m.Caps = 0, ""
+	return m.Encode()
+}
+
+// FetchManifest fetches the manifest of the share at url and checks it
+// against pinned, its checksum computed with a.
+func (c *Client) FetchManifest(url, pinned string, a hashing.Algorithm) (Manifest, error) {
+	req, err := c.NewRequest(strings.TrimSuffix(url, "/") + ManifestPath)
+	if err != nil {
+		return Manifest{}, err
+	}
+	for i := 0; i < hashRetries; i++ {
+		resp, err := c.Do(req)
+		if err != nil {
+			return Manifest{}, err
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, manifestLimit))
+		resp.Body.Close()
+		if err != nil {
+			return Manifest{}, err
+		}
+		switch resp.StatusCode {
+		case http.StatusOK:
+		case http.StatusServiceUnavailable:
+			time.Sleep(RetryAfter(resp))
+			continue
+		default:
+			return Manifest{}, fmt.Errorf("Unexpected status for the manifest: %s", resp.Status)
+		}
+
+		sum, err := hashing.Sum(a, bytes.NewReader(data))
+		if err != nil {
+			return Manifest{}, err
+		}
+		if sum != pinned {
+			return Manifest{}, ErrManifestMismatch
+		}
+		var m Manifest
+		err = json.Unmarshal(data, &m)
+		if err != nil {
+			return Manifest{}, err
+		}
+		return m, nil
+	}
+	return Manifest{}, fmt.Errorf("Sender never finished its manifest")
+}
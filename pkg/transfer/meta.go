@@ -0,0 +1,106 @@
+package transfer
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/hashing"
+)
+
+// Headers carrying the metadata of the shared file, so that a single GET or
+// HEAD tells a receiver everything it needs to verify the download.
+const (
+	SizeHeader  = "X-PushPop-Size"
+	MtimeHeader = "X-PushPop-Mtime"
+	ModeHeader  = "X-PushPop-Mode"
+)
+
+// Meta describes the file a sender shares.
+type Meta struct {
+	Algorithm hashing.Algorithm
+	// Sum is the hex checksum of the whole file, empty when not known yet.
+	Sum string
+	// Size is the size of the whole file, -1 for streams.
+	Size int64
+	// Mtime is the modification time, zero when unknown.
+	Mtime time.Time
+	// Mode holds the permission bits, zero when unknown.
+	Mode os.FileMode
+}
+
+// HashHeader returns the header carrying a checksum computed with a, such as
+// X-PushPop-Blake3.
+func HashHeader(a hashing.Algorithm) string {
+	return http.CanonicalHeaderKey("X-PushPop-" + a.Name())
+}
+
+// reprDigestNames maps algorithms to their name in the HTTP Digest Algorithm
+// registry used by Repr-Digest (RFC 9530). BLAKE3 is not registered.
+var reprDigestNames = map[string]string{
+	"sha256": "sha-256",
+	"sha512": "sha-512",
+}
+
+// SetMeta describes m in h.
+func SetMeta(h http.Header, m Meta) {
+	h.Set(AlgorithmHeader, m.Algorithm.Name())
+	if m.Size >= 0 {
+		h.Set(SizeHeader, strconv.FormatInt(m.Size, 10))
+	}
+	if !m.Mtime.IsZero() {
+		h.Set(MtimeHeader, strconv.FormatInt(m.Mtime.Unix(), 10))
+		h.Set("Last-Modified", m.Mtime.UTC().Format(http.TimeFormat))
+	}
+	if m.Mode != 0 {
+		h.Set(ModeHeader, fmt.Sprintf("%#o", m.Mode.Perm()))
+	}
+	if m.Sum == "" {
+		return
+	}
+	h.Set(HashHeader(m.Algorithm), m.Sum)
+	if name, ok := reprDigestNames[m.Algorithm.Name()]; ok {
+		raw, err := hex.DecodeString(m.Sum)
+		if err == nil {
+			h.Set("Repr-Digest", name+"=:"+base64.StdEncoding.EncodeToString(raw)+":")
+		}
+	}
+}
+
+// ParseMeta reads the metadata a sender put in resp. Senders that predate
+// these headers yield the default algorithm and no checksum.
+func ParseMeta(resp *http.Response) (Meta, error) {
+	return ParseMetaHeader(resp.Header)
+}
+
+// ParseMetaHeader reads the metadata described in h, the headers of a
+// response or of an upload.
+func ParseMetaHeader(h http.Header) (Meta, error) {
+	m := Meta{Size: -1, Algorithm: hashing.Default}
+	if name := h.Get(AlgorithmHeader); name != "" {
+		a, err := hashing.Lookup(name)
+		if err != nil {
+			return m, err
+		}
+		m.Algorithm = a
+	}
+	m.Sum = strings.ToLower(strings.TrimSpace(h.Get(HashHeader(m.Algorithm))))
+	size, err := strconv.ParseInt(h.Get(SizeHeader), 10, 64)
+	if err == nil {
+		m.Size = size
+	}
+	mtime, err := strconv.ParseInt(h.Get(MtimeHeader), 10, 64)
+	if err == nil {
+		m.Mtime = time.Unix(mtime, 0)
+	}
+	mode, err := strconv.ParseUint(h.Get(ModeHeader), 0, 32)
+	if err == nil {
+		m.Mode = os.FileMode(mode).Perm()
+	}
+	return m, nil
+}
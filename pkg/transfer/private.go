@@ -0,0 +1,39 @@
+package transfer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"mime"
+	"net/http"
+
+	"github.com/yifu/pushpop/pkg/safename"
+)
+
+// CodeHeader carries the code of a private share. A private share is
+// announced under CodeInstance(code), without user or file name, and only
+// serves requests that know the code.
+const CodeHeader = "X-PushPop-Code"
+
+// CodeParam is the query parameter a browser can give the code in instead.
+const CodeParam = "code"
+
+// CodeInstance returns the mDNS instance name of the private share with
+// the given code. It tells nothing about the code, nor about the share.
+func CodeInstance(code string) string {
+	sum := sha256.Sum256([]byte("pushpop private share " + code))
+	return hex.EncodeToString(sum[:8])
+}
+
+// FileName returns the file name resp suggests in its Content-Disposition
+// header, reduced to its last element, or "" when there is none.
+func FileName(resp *http.Response) string {
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+	if err != nil {
+		return ""
+	}
+	name, err := safename.Name(params["filename"])
+	if err != nil {
+		return ""
+	}
+	return name
+}
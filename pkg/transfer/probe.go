@@ -0,0 +1,91 @@
+package transfer
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ProbePath serves throwaway data for a receiver to measure the throughput
+// to the sender before committing to a large download.
+const ProbePath = "/probe"
+
+// DefaultProbeTime is how long a probe downloads.
+const DefaultProbeTime = 1500 * time.Millisecond
+
+// probeLimit caps what the probe endpoint sends, in case a client does not
+// hang up.
+const probeLimit = 4 << 30
+
+// ErrNoProbe is returned by Probe when the sender has no probe endpoint.
+var ErrNoProbe = errors.New("Sender does not support probing")
+
+// probeBlock is repeated by ServeProbe. It is random, so that compression
+// along the way does not flatter the result.
+var probeBlock = func() []byte {
+	b := make([]byte, 64<<10)
+	rand.Read(b)
+	return b
+}()
+
+// ServeProbe answers the probe endpoint with data until the client hangs up.
+func ServeProbe(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method == http.MethodHead {
+		return
+	}
+	for sent := 0; sent < probeLimit; sent += len(probeBlock) {
+		_, err := w.Write(probeBlock)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Probe downloads from the probe endpoint of the sender at url for about d
+// and returns the throughput in bytes per second. The time to first byte is
+// left out, so that the result reflects bandwidth rather than latency.
+func (c *Client) Probe(url string, d time.Duration) (float64, error) {
+	req, err := c.NewRequest(strings.TrimSuffix(url, "/") + ProbePath)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return 0, ErrNoProbe
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.New("Unexpected status: " + resp.Status)
+	}
+
+	buf := make([]byte, 32<<10)
+	_, err = resp.Body.Read(buf)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	var total int64
+	for time.Since(start) < d {
+		n, err := resp.Body.Read(buf)
+		total += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	elapsed := time.Since(start)
+	if elapsed <= 0 || total == 0 {
+		return 0, errors.New("Probe received no data")
+	}
+	return float64(total) / elapsed.Seconds(), nil
+}
@@ -0,0 +1,127 @@
+package transfer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// SessionPath is where a sender serves the description of its share as a
+// whole, see Session.
+const SessionPath = "/meta.json"
+
+// ProtocolVersion is the version of the protocol this package speaks,
+// given in Session, the manifest and VersionKey. Senders that predate it
+// are version 0; a receiver goes by what they support as best it can, and
+// gets on with senders of later versions the same way, by what they tell
+// they support.
+const ProtocolVersion = 1
+
+// VersionKey is the TXT record key of the ProtocolVersion of the sender,
+// and CapsKey that of its Capabilities, see Capabilities.String. Receivers
+// know what they may ask of a sender from them before connecting.
+const (
+	VersionKey = "version"
+	CapsKey    = "caps"
+)
+
+// ErrNoSession is returned by FetchSession when the sender predates
+// SessionPath, its headers then being all there is to go by.
+var ErrNoSession = errors.New("Sender does not describe its share")
+
+// Session describes a share: the protocol version of its sender, who shares
+// it from where, its files and what the sender supports. A receiver goes by
+// it rather than by the headers of the download, which only tell about the
+// bytes they come with.
+type Session struct {
+	Version int    `json:"version"`
+	User    string `json:"user,omitempty"`
+	Host    string `json:"host,omitempty"`
+	// Files holds the shared file. Sum is empty while the sender hashes it,
+	// and Size is -1 for streams and files still being written.
+	Files        []Manifest   `json:"files"`
+	Capabilities Capabilities `json:"capabilities"`
+}
+
+// Capabilities tells what a sender supports besides downloading its files
+// whole.
+type Capabilities struct {
+	// Resume is set when the sender serves byte ranges.
+	Resume bool `json:"resume"`
+	// Compression is set when the sender compresses the file for receivers
+	// that accept gzip.
+	Compression bool `json:"compression"`
+	// TLS is set when the sender also speaks HTTPS on its port.
+	TLS bool `json:"tls"`
+}
+
+// Names of the capabilities in CapsKey and in the manifest.
+const (
+	capResume      = "resume"
+	capCompression = "compression"
+	capTLS         = "tls"
+)
+
+// String returns the names of the capabilities in c, comma separated, as
+// announced in CapsKey.
+func (c Capabilities) String() string {
+	var names []string
+	for _, k := range []struct {
+		name string
+		ok   bool
+	}{{capResume, c.Resume}, {capCompression, c.Compression}, {capTLS, c.TLS}} {
+		if k.ok {
+			names = append(names, k.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseCapabilities parses capabilities as String returns them. Names it
+// does not know, of capabilities of later versions, are left out.
+func ParseCapabilities(s string) Capabilities {
+	var c Capabilities
+	for _, name := range strings.Split(s, ",") {
+		switch strings.TrimSpace(name) {
+		case capResume:
+			c.Resume = true
+		case capCompression:
+			c.Compression = true
+		case capTLS:
+			c.TLS = true
+		}
+	}
+	return c
+}
+
+// FetchSession fetches the description of the share at url.
+func (c *Client) FetchSession(url string) (Session, error) {
+	req, err := c.NewRequest(strings.TrimSuffix(url, "/") + SessionPath)
+	if err != nil {
+		return Session{}, err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return Session{}, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return Session{}, ErrNoSession
+	default:
+		return Session{}, fmt.Errorf("Unexpected status for the share description: %s", resp.Status)
+	}
+	var s Session
+	err = json.NewDecoder(io.LimitReader(resp.Body, manifestLimit)).Decode(&s)
+	if err != nil {
+		return Session{}, err
+	}
+	if len(s.Files) == 0 {
+		return Session{}, errors.New("The share description lists no file")
+	}
+	return s, nil
+}
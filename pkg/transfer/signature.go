@@ -0,0 +1,119 @@
+package transfer
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/identity"
+)
+
+// SignaturePath is where a sender serves the signature of its share.
+const SignaturePath = "/signature"
+
+// SignerKey is the TXT record key announcing the fingerprint of the key a
+// share is signed with, see identity.Fingerprint.
+const SignerKey = "signer"
+
+// SignatureSuffix is added to the name of a downloaded file to store its
+// signature next to it.
+const SignatureSuffix = ".sig"
+
+// Signature is a detached signature of a file: its manifest, signed by the
+// sender's identity key. It lets a file be checked long after the share is
+// gone, with nothing but the signature file.
+type Signature struct {
+	Manifest Manifest `json:"manifest"`
+	// Key is the sender's ed25519 public key, Signature its signature of
+	// the encoded manifest. Both are base64 encoded in JSON.
+	Key       []byte `json:"key"`
+	Signature []byte `json:"signature"`
+}
+
+// Sign returns the signature of the file m describes by key.
+func Sign(m Manifest, key ed25519.PrivateKey) (Signature, error) {
+	data, err := m.signed()
+	if err != nil {
+		return Signature{}, err
+	}
+	return Signature{
+		Manifest:  m,
+		Key:       key.Public().(ed25519.PublicKey),
+		Signature: ed25519.Sign(key, data),
+	}, nil
+}
+
+// Verify checks that s is a valid signature of its manifest by its key.
+// Who the key belongs to is up to the caller, see Signer.
+func (s Signature) Verify() error {
+	if len(s.Key) != ed25519.PublicKeySize {
+		return fmt.Errorf("Invalid signature key")
+	}
+	data, err := s.Manifest.signed()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(ed25519.PublicKey(s.Key), data, s.Signature) {
+		return fmt.Errorf("Invalid signature of %s", s.Manifest.Name)
+	}
+	return nil
+}
+
+// Signer returns the fingerprint of the key s is signed with.
+func (s Signature) Signer() string {
+	return identity.Fingerprint(ed25519.PublicKey(s.Key))
+}
+
+// Encode returns the signature as served and stored.
+func (s Signature) Encode() ([]byte, error) {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// ParseSignature parses an encoded signature and verifies it.
+func ParseSignature(data []byte) (Signature, error) {
+	var s Signature
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return s, fmt.Errorf("Invalid signature: %v", err)
+	}
+	return s, s.Verify()
+}
+
+// FetchSignature fetches and verifies the signature of the share at url,
+// waiting for the sender to hash the file first. It also returns the
+// signature as served, to be stored.
+func (c *Client) FetchSignature(url string) (Signature, []byte, error) {
+	req, err := c.NewRequest(strings.TrimSuffix(url, "/") + SignaturePath)
+	if err != nil {
+		return Signature{}, nil, err
+	}
+	for i := 0; i < hashRetries; i++ {
+		resp, err := c.Do(req)
+		if err != nil {
+			return Signature{}, nil, err
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, manifestLimit))
+		resp.Body.Close()
+		if err != nil {
+			return Signature{}, nil, err
+		}
+		switch resp.StatusCode {
+		case http.StatusOK:
+			s, err := ParseSignature(data)
+			return s, data, err
+		case http.StatusServiceUnavailable:
+			time.Sleep(RetryAfter(resp))
+			continue
+		}
+		return Signature{}, nil, fmt.Errorf("Unexpected status for the signature: %s", resp.Status)
+	}
+	return Signature{}, nil, fmt.Errorf("Sender never finished its signature")
+}
@@ -0,0 +1,56 @@
+package transfer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// RoleSync is the role of an endpoint announced by pushpop sync -wait,
+// which serves a folder to sync with and takes uploads into it.
+const RoleSync = "sync"
+
+// FolderKey is the TXT record key of RoleSync endpoints naming the folder
+// they sync, so that one user can wait for syncs of several folders.
+const FolderKey = "folder"
+
+// FolderManifestPath is where a sync endpoint serves the manifest of its
+// folder, and FolderFilePath where it serves and takes the files, followed
+// by their slash separated path in the folder.
+const (
+	FolderManifestPath = "/sync/manifest.json"
+	FolderFilePath     = "/sync/files/"
+)
+
+// folderManifestLimit caps the size of a folder manifest a peer reads.
+const folderManifestLimit = 64 << 20
+
+// FolderManifest describes the files of a synced folder, each named by its
+// slash separated path in the folder.
+type FolderManifest struct {
+	Files []Manifest `json:"files"`
+}
+
+// FetchFolderManifest fetches the manifest of the folder served at url.
+func (c *Client) FetchFolderManifest(url string) (FolderManifest, error) {
+	req, err := c.NewRequest(strings.TrimSuffix(url, "/") + FolderManifestPath)
+	if err != nil {
+		return FolderManifest{}, err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return FolderManifest{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return FolderManifest{}, fmt.Errorf("Unexpected status for the folder manifest: %s", resp.Status)
+	}
+	var m FolderManifest
+	err = json.NewDecoder(io.LimitReader(resp.Body, folderManifestLimit)).Decode(&m)
+	if err != nil {
+		return FolderManifest{}, err
+	}
+	return m, nil
+}
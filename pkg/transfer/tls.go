@@ -0,0 +1,50 @@
+package transfer
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// CertKey is the TXT record key of the fingerprint of the self-signed
+// certificate the sender's port speaks TLS with, see CertFingerprint, and
+// CertParam the query parameter of share URLs giving it. Receivers pin it,
+// the certificate being signed by no authority.
+const (
+	CertKey   = "cert"
+	CertParam = "cert"
+)
+
+// certPrefix starts fingerprints, naming their algorithm.
+const certPrefix = "sha256:"
+
+// CertFingerprint returns the fingerprint of the DER encoded certificate
+// der: "sha256:" and the hex of its checksum.
+func CertFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return certPrefix + hex.EncodeToString(sum[:])
+}
+
+// PinnedTLS returns the TLS configuration of a client that accepts the
+// certificate with the given fingerprint, and no other, whoever signed it
+// and whatever name it bears.
+func PinnedTLS(fingerprint string) (*tls.Config, error) {
+	fingerprint = strings.ToLower(fingerprint)
+	if !strings.HasPrefix(fingerprint, certPrefix) || len(fingerprint) != len(certPrefix)+2*sha256.Size {
+		return nil, fmt.Errorf("Invalid certificate fingerprint %q, expected sha256: and 64 hex digits", fingerprint)
+	}
+	return &tls.Config{
+		// The fingerprint is checked instead of the chain and name.
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(certs [][]byte, _ [][]*x509.Certificate) error {
+			if len(certs) == 0 || CertFingerprint(certs[0]) != fingerprint {
+				return errors.New("The sender's certificate does not match the pinned fingerprint")
+			}
+			return nil
+		},
+	}, nil
+}
@@ -0,0 +1,165 @@
+package transfer
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/hashing"
+)
+
+// AlgorithmHeader names the checksum algorithm of the file in a response.
+const AlgorithmHeader = "X-PushPop-Hash-Algorithm"
+
+// hashRetries bounds how long FetchHash waits for a sender that is still
+// computing its hash.
+const hashRetries = 60
+
+// UserKey and HashKey are the TXT record keys of the user sharing a file
+// and of the name of the checksum algorithm of the share.
+const (
+	UserKey = "user"
+	HashKey = "hash"
+)
+
+// SizeKey is the TXT record key of the size of the shared file, and SumKey
+// that of the first SumPrefixLen hex digits of its checksum: receivers tell
+// what is shared, and whether they have it already, without connecting.
+// Senders update them as the file changes, and leave SumKey out until the
+// file is hashed.
+const (
+	SizeKey = "size"
+	SumKey  = "sum"
+)
+
+// SumPrefixLen is how many hex digits of the checksum SumKey carries, 64
+// bits: enough to tell versions of a file apart, short enough for a TXT
+// record.
+const SumPrefixLen = 16
+
+// SumPrefix returns the prefix of the checksum sum announced in SumKey.
+func SumPrefix(sum string) string {
+	if len(sum) > SumPrefixLen {
+		return sum[:SumPrefixLen]
+	}
+	return sum
+}
+
+// UserHeader carries the name of the user a receiver runs as, for shares
+// meant for a single recipient.
+const UserHeader = "X-PushPop-User"
+
+// ErrNoHash is returned by FetchHash when the sender does not publish one.
+var ErrNoHash = fmt.Errorf("Sender does not publish a checksum")
+
+// HashPath is where a sender serves the checksum of its file computed with
+// a, as lowercase hex.
+func HashPath(a hashing.Algorithm) string {
+	return "/file." + a.Name()
+}
+
+// HashURL returns the hash endpoint matching the file served at url.
+func HashURL(url string, a hashing.Algorithm) string {
+	return strings.TrimSuffix(url, "/") + HashPath(a)
+}
+
+// Algorithm returns the checksum algorithm advertised in resp, falling back
+// to hashing.Default for senders that do not advertise one.
+func Algorithm(resp *http.Response) (hashing.Algorithm, error) {
+	name := resp.Header.Get(AlgorithmHeader)
+	if name == "" {
+		return hashing.Default, nil
+	}
+	return hashing.Lookup(name)
+}
+
+// FetchHash returns the sender's checksum for the file at url, waiting while
+// the sender answers 503 Service Unavailable.
+func (c *Client) FetchHash(url string, a hashing.Algorithm) (string, error) {
+	for i := 0; i < hashRetries; i++ {
+		req, err := c.NewRequest(HashURL(url, a))
+		if err != nil {
+			return "", err
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			return "", err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return "", err
+		}
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			return strings.TrimSpace(string(body)), nil
+		case http.StatusNotFound:
+			return "", ErrNoHash
+		case http.StatusServiceUnavailable:
+			time.Sleep(RetryAfter(resp))
+		default:
+			return "", fmt.Errorf("Unexpected status: %s", resp.Status)
+		}
+	}
+	return "", fmt.Errorf("Sender never finished computing the checksum")
+}
+
+// RetryAfter returns the delay requested by resp, defaulting to one second.
+func RetryAfter(resp *http.Response) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	secs, err := strconv.Atoi(value)
+	if err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	// An HTTP date is measured against the peer's own Date header, so that
+	// the delay does not depend on how well the two clocks agree.
+	at, err := http.ParseTime(value)
+	if err != nil {
+		return time.Second
+	}
+	now, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil || !at.After(now) {
+		return time.Second
+	}
+	return at.Sub(now)
+}
+
+// AckPath is where a receiver tells the sender it got and verified the file.
+const AckPath = "/ack"
+
+// Ack tells the sender of the file at url that it was received with the
+// given checksum. Senders that predate acknowledgements answer 404, which
+// is not an error.
+func (c *Client) Ack(url, sum string) error {
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(url, "/")+AckPath, strings.NewReader(sum))
+	if err != nil {
+		return err
+	}
+	c.identify(req)
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("Unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+// PreferKey is the TXT record key telling which address family, PreferV4
+// or PreferV6, receivers should reach a sender with when it announces
+// addresses of both: on some networks one of them is announced but does
+// not work.
+const PreferKey = "prefer"
+
+// Address families of PreferKey.
+const (
+	PreferV4 = "v4"
+	PreferV6 = "v6"
+)
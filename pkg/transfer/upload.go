@@ -0,0 +1,131 @@
+package transfer
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// RoleKey is the TXT record key telling what an announced endpoint does.
+// Shares leave it out; receivers waiting for an upload set it to
+// RoleReceive.
+const RoleKey = "role"
+
+// RoleReceive is the role of an endpoint announced by pop -receive.
+const RoleReceive = "receive"
+
+// RolePeer is the role of a receiver serving the parts of a file it already
+// downloaded to others downloading it too, see SwarmKey.
+const RolePeer = "peer"
+
+// SwarmKey is the TXT record key of shares whose receivers may serve each
+// other, and of these receivers, RolePeer, announcing which file they
+// serve: the checksum of its manifest.
+const SwarmKey = "swarm"
+
+// ChunkPath is where a peer serves the parts of the file it has, followed
+// by the index of the part.
+const ChunkPath = "/chunk/"
+
+// ErrRejected is returned by Upload when the receiver turned the file down,
+// because it already has one by that name or does not want more.
+var ErrRejected = fmt.Errorf("Receiver rejected the file")
+
+// ErrNoResume is returned by UploadOffset when the receiver predates
+// resumable uploads and only takes whole files with Upload.
+var ErrNoResume = fmt.Errorf("Receiver does not resume uploads")
+
+// OffsetHeader carries how much of an upload the receiver has, in answers
+// to UploadOffset, and where the data of UploadFrom starts.
+const OffsetHeader = "Upload-Offset"
+
+// OffsetContentType is the type of the data UploadFrom appends.
+const OffsetContentType = "application/offset+octet-stream"
+
+// Upload sends r, the file described by m, to the receiver at url with a
+// PUT request and returns the checksum the receiver computed with
+// m.Algorithm. A receiver that got something else than m.Sum answers with
+// an error.
+func (c *Client) Upload(url string, r io.Reader, m Meta) (string, error) {
+	req, err := http.NewRequest(http.MethodPut, url, r)
+	if err != nil {
+		return "", err
+	}
+	c.identify(req)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	SetMeta(req.Header, m)
+	if m.Size >= 0 {
+		req.ContentLength = m.Size
+	}
+	return c.finishUpload(req, m)
+}
+
+// UploadOffset asks the receiver at url how much of the file described by
+// m it already has from an interrupted upload. Uploads are resumed the way
+// tus does: a HEAD request for the offset, then a PATCH request with the
+// rest, see UploadFrom.
+func (c *Client) UploadOffset(url string, m Meta) (int64, error) {
+	req, err := http.NewRequest(http.MethodHead, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	c.identify(req)
+	SetMeta(req.Header, m)
+	resp, err := c.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+	case http.StatusMethodNotAllowed:
+		return 0, ErrNoResume
+	case http.StatusConflict, http.StatusGone:
+		return 0, ErrRejected
+	default:
+		return 0, fmt.Errorf("Unexpected status: %s", resp.Status)
+	}
+	offset, err := strconv.ParseInt(resp.Header.Get(OffsetHeader), 10, 64)
+	if err != nil || offset < 0 || offset > m.Size {
+		return 0, fmt.Errorf("Invalid %s from the receiver: %q", OffsetHeader, resp.Header.Get(OffsetHeader))
+	}
+	return offset, nil
+}
+
+// UploadFrom sends r, the file described by m from offset to its end, to
+// the receiver at url with a PATCH request and returns the checksum the
+// receiver computed for the whole file, like Upload.
+func (c *Client) UploadFrom(url string, r io.Reader, offset int64, m Meta) (string, error) {
+	req, err := http.NewRequest(http.MethodPatch, url, r)
+	if err != nil {
+		return "", err
+	}
+	c.identify(req)
+	req.Header.Set("Content-Type", OffsetContentType)
+	req.Header.Set(OffsetHeader, strconv.FormatInt(offset, 10))
+	SetMeta(req.Header, m)
+	req.ContentLength = m.Size - offset
+	return c.finishUpload(req, m)
+}
+
+// finishUpload sends req and returns the checksum of the completed upload.
+func (c *Client) finishUpload(req *http.Request, m Meta) (string, error) {
+	resp, err := c.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusCreated:
+	case resp.StatusCode == http.StatusConflict && resp.Header.Get(OffsetHeader) != "":
+		return "", fmt.Errorf("The receiver has %s bytes, not %s", resp.Header.Get(OffsetHeader), req.Header.Get(OffsetHeader))
+	case resp.StatusCode == http.StatusConflict || resp.StatusCode == http.StatusGone:
+		return "", ErrRejected
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("Upload failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return strings.ToLower(strings.TrimSpace(resp.Header.Get(HashHeader(m.Algorithm)))), nil
+}
@@ -0,0 +1,50 @@
+// Package units formats quantities for people.
+package units
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Bytes formats n bytes with a binary unit, such as "1.3 GiB".
+func Bytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// multipliers are the size units ParseSize accepts, in upper case.
+var multipliers = map[string]float64{
+	"": 1, "B": 1,
+	"K": 1e3, "KB": 1e3, "KIB": 1 << 10,
+	"M": 1e6, "MB": 1e6, "MIB": 1 << 20,
+	"G": 1e9, "GB": 1e9, "GIB": 1 << 30,
+	"T": 1e12, "TB": 1e12, "TIB": 1 << 40,
+}
+
+// ParseSize parses a number, optionally followed by a size unit: 1.5GB is
+// 1.5e9 and 2KiB is 2048.
+func ParseSize(s string) (float64, error) {
+	i := strings.IndexFunc(s, unicode.IsLetter)
+	if i < 0 {
+		i = len(s)
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid size %q", s)
+	}
+	mul, ok := multipliers[strings.ToUpper(s[i:])]
+	if !ok {
+		return 0, fmt.Errorf("Unknown unit %q", s[i:])
+	}
+	return n * mul, nil
+}
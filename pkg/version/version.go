@@ -0,0 +1,36 @@
+// Package version identifies this build of pushpop to peers.
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// Version is the pushpop release, kept in sync with DEBIAN/control.
+const Version = "0.0"
+
+// UserAgent returns the User-Agent sent by program, e.g.
+// "pushpop-pop/0.0 (linux/amd64)".
+func UserAgent(program string) string {
+	return fmt.Sprintf("pushpop-%s/%s (%s/%s)", program, Version, runtime.GOOS, runtime.GOARCH)
+}
+
+// Peer returns a short description of the software behind a User-Agent, such
+// as "pop 0.0", "curl" or "browser", for logs and progress bars.
+func Peer(ua string) string {
+	switch {
+	case ua == "":
+		return "unknown"
+	case strings.HasPrefix(ua, "pushpop-"):
+		product := strings.Fields(ua)[0]
+		return strings.Replace(strings.TrimPrefix(product, "pushpop-"), "/", " ", 1)
+	case strings.HasPrefix(ua, "curl/"):
+		return "curl"
+	case strings.HasPrefix(ua, "Wget/"):
+		return "wget"
+	case strings.HasPrefix(ua, "Mozilla/"):
+		return "browser"
+	}
+	return strings.Fields(ua)[0]
+}
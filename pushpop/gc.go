@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/gc"
+	"github.com/yifu/pushpop/pkg/quarantine"
+	"github.com/yifu/pushpop/pkg/tempfile"
+)
+
+// stores lists every place where pushpop accumulates files.
+func stores(tmpdir string) []gc.Store {
+	s := []gc.Store{
+		// Spool files outlive push when it is killed.
+		{Name: "spool", Dir: tempfile.Dir(tmpdir), Pattern: "pushpop-*"},
+	}
+	// Corrupted downloads pop kept for inspection.
+	if dir, err := quarantine.Dir(); err == nil {
+		s = append(s, gc.Store{Name: "quarantine", Dir: dir, Pattern: "*"})
+	}
+	return s
+}
+
+func runGC(args []string) {
+	fs := flag.NewFlagSet("gc", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only report what would be removed")
+	maxAge := fs.Duration("max-age", 7*24*time.Hour, "remove files older than this, 0 to keep them")
+	maxSize := fs.Int64("max-size", 0, "maximum size of each store in bytes, 0 for no limit")
+	tmpdir := fs.String("tmpdir", "", "directory for temporary files (default $TMPDIR)")
+	fs.Parse(args)
+
+	policy := gc.Policy{MaxAge: *maxAge, MaxSize: *maxSize}
+	now := time.Now()
+	var count int
+	var freed int64
+	for _, s := range stores(*tmpdir) {
+		rs, err := gc.Plan(s, policy, now)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, r := range rs {
+			fmt.Printf("%s: %s (%d bytes, %s)\n", s.Name, r.Path, r.Size, r.Reason)
+			count++
+			freed += r.Size
+		}
+		if *dryRun {
+			continue
+		}
+		err = gc.Apply(rs)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	verb := "Removed"
+	if *dryRun {
+		verb = "Would remove"
+	}
+	fmt.Printf("%s %d files, %d bytes.\n", verb, count, freed)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/history"
+)
+
+func runHistory(args []string) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	last := fs.Int("n", 20, "show only the last n transfers, 0 for all")
+	direction := fs.String("direction", "", "show only transfers in this direction: send or receive")
+	peer := fs.String("peer", "", "show only transfers with a peer whose user or address contains this")
+	name := fs.String("name", "", "show only files whose name contains this")
+	since := fs.Duration("since", 0, "show only transfers started within this duration")
+	asJSON := fs.Bool("json", false, "print entries as JSON lines")
+	fs.Parse(args)
+
+	entries, err := history.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	now := time.Now()
+	var shown []history.Entry
+	for _, e := range entries {
+		if *direction != "" && e.Direction != *direction {
+			continue
+		}
+		if *peer != "" && !strings.Contains(e.User, *peer) && !strings.Contains(e.Addr, *peer) {
+			continue
+		}
+		if *name != "" && !strings.Contains(e.Name, *name) {
+			continue
+		}
+		if *since > 0 && now.Sub(e.Time) > *since {
+			continue
+		}
+		shown = append(shown, e)
+	}
+	if *last > 0 && len(shown) > *last {
+		shown = shown[len(shown)-*last:]
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, e := range shown {
+			enc.Encode(e)
+		}
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tDIRECTION\tPEER\tNAME\tSIZE\tDURATION\tRESULT")
+	for _, e := range shown {
+		who := e.Addr
+		if e.User != "" {
+			who = e.User + "@" + e.Addr
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			e.Time.Format("2006-01-02 15:04:05"), e.Direction, who, e.Name, e.Size,
+			e.Duration.Round(time.Millisecond), e.Result)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/discovery"
+)
+
+// rttTimeout is how long list waits for a peer to accept a connection.
+const rttTimeout = time.Second
+
+// listed is an endpoint found by list.
+type listed struct {
+	name, user, addr, details string
+	// rtt is how long connecting to addr took, 0 when it failed.
+	rtt time.Duration
+}
+
+func runList(args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	wait := fs.Duration("wait", 2*time.Second, "how long to browse the network for")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "USAGE: pushpop list [-wait duration]")
+		fmt.Fprintln(os.Stderr, "Lists the shares and receivers announced on the network, nearest first.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *wait)
+	defer cancel()
+	entries, err := discovery.Browse(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var found []*listed
+	var wg sync.WaitGroup
+	for entry := range entries {
+		l := &listed{name: discovery.Unescape(entry.Instance)}
+		var details []string
+		for _, kv := range entry.Text {
+			if strings.HasPrefix(kv, "user=") {
+				l.user = kv[len("user="):]
+			} else {
+				details = append(details, kv)
+			}
+		}
+		l.details = strings.Join(details, " ")
+		host := entry.HostName
+		if len(entry.AddrIPv4) > 0 {
+			host = entry.AddrIPv4[0].String()
+		} else if len(entry.AddrIPv6) > 0 {
+			host = entry.AddrIPv6[0].String()
+		}
+		l.addr = net.JoinHostPort(host, strconv.Itoa(entry.Port))
+		found = append(found, l)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.rtt = connectTime(l.addr)
+		}()
+	}
+	wg.Wait()
+
+	// Nearest first, unreachable ones last.
+	sort.SliceStable(found, func(i, j int) bool {
+		a, b := found[i].rtt, found[j].rtt
+		return a != 0 && (b == 0 || a < b)
+	})
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tUSER\tADDRESS\tRTT\tDETAILS")
+	for _, l := range found {
+		rtt := "-"
+		if l.rtt != 0 {
+			rtt = l.rtt.Round(10 * time.Microsecond).String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", l.name, l.user, l.addr, rtt, l.details)
+	}
+	tw.Flush()
+}
+
+// connectTime returns how long a TCP connection to addr takes to open, about
+// a round trip, or 0 when it cannot be opened. ICMP would need privileges.
+func connectTime(addr string) time.Duration {
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", addr, rttTimeout)
+	if err != nil {
+		return 0
+	}
+	rtt := time.Since(start)
+	conn.Close()
+	return rtt
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/yifu/pushpop/pkg/popcmd"
+	"github.com/yifu/pushpop/pkg/pushcmd"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "USAGE: pushpop <command> [arguments]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Commands:")
+	fmt.Fprintln(os.Stderr, "  push     share a file, as the push command")
+	fmt.Fprintln(os.Stderr, "  pop      download a file, as the pop command")
+	fmt.Fprintln(os.Stderr, "  list     list the shares announced on the network")
+	fmt.Fprintln(os.Stderr, "  gc       remove old state left by push and pop")
+	fmt.Fprintln(os.Stderr, "  history  list past transfers")
+	fmt.Fprintln(os.Stderr, "  revoke   stop a share of a running push")
+	fmt.Fprintln(os.Stderr, "  verify   check a file against its signature")
+	fmt.Fprintln(os.Stderr, "  sync     sync a folder both ways with another user's")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "push":
+		pushcmd.Main(os.Args[2:])
+	case "pop":
+		popcmd.Command = []string{os.Args[0], "pop"}
+		popcmd.Main(os.Args[2:])
+	case "list":
+		runList(os.Args[2:])
+	case "gc":
+		runGC(os.Args[2:])
+	case "history":
+		runHistory(os.Args[2:])
+	case "revoke":
+		runRevoke(os.Args[2:])
+	case "verify":
+		runVerify(os.Args[2:])
+	case "sync":
+		runSync(os.Args[2:])
+	default:
+		usage()
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/control"
+)
+
+// revokeWait is how long revoke waits for a push to honor a kill-switch
+// file.
+const revokeWait = 3 * time.Second
+
+func runRevoke(args []string) {
+	fs := flag.NewFlagSet("revoke", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "USAGE: pushpop revoke [session-id]")
+		fmt.Fprintln(os.Stderr, "Stops the share with the given session id, or lists the shares of the push owning the control socket.")
+	}
+	fs.Parse(args)
+	switch fs.NArg() {
+	case 0:
+		listShares()
+	case 1:
+		revoke(fs.Arg(0))
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+}
+
+func listShares() {
+	client, err := control.Client()
+	if err != nil {
+		log.Fatal(err)
+	}
+	resp, err := client.Get(control.BaseURL + "/shares")
+	if err != nil {
+		log.Fatal("No push is running: ", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(os.Stdout, resp.Body)
+}
+
+// revoke asks the push owning the control socket to stop the share with the
+// given id. Other pushes are reached through a kill-switch file.
+func revoke(id string) {
+	client, err := control.Client()
+	if err != nil {
+		log.Fatal(err)
+	}
+	resp, err := client.Post(control.BaseURL+"/revoke?id="+url.QueryEscape(id), "text/plain", nil)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNoContent {
+			fmt.Println("Revoked", id)
+			return
+		}
+	}
+
+	err = control.Revoke(id)
+	if err != nil {
+		log.Fatal(err)
+	}
+	deadline := time.Now().Add(revokeWait)
+	for time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+		if !control.Revoked(id) {
+			fmt.Println("Revoked", id)
+			return
+		}
+	}
+	control.Unrevoke(id)
+	log.Fatalf("No share with session id %s", id)
+}
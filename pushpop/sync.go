@@ -0,0 +1,603 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+	"github.com/yifu/pushpop/pkg/discovery"
+	"github.com/yifu/pushpop/pkg/hashcache"
+	"github.com/yifu/pushpop/pkg/hashing"
+	"github.com/yifu/pushpop/pkg/history"
+	"github.com/yifu/pushpop/pkg/ignore"
+	"github.com/yifu/pushpop/pkg/safename"
+	"github.com/yifu/pushpop/pkg/tempfile"
+	"github.com/yifu/pushpop/pkg/transfer"
+	"github.com/yifu/pushpop/pkg/units"
+	"github.com/yifu/pushpop/pkg/version"
+)
+
+// errMismatch is returned by store when the file received is not the one
+// announced.
+var errMismatch = errors.New("Checksum mismatch")
+
+// folder is a directory synced with another user's copy of it.
+type folder struct {
+	dir string
+	alg hashing.Algorithm
+	// peer is the user the folder is synced with.
+	peer string
+	// code is the code the peer must give while waiting. The user name
+	// alone would be no proof: anyone can send any name.
+	code string
+	// client makes the requests to the peer.
+	client *transfer.Client
+	// saving is how received files are saved, set by -fsync.
+	saving tempfile.Options
+
+	mu sync.Mutex
+	// files maps the paths of the last scan to the files found then, the
+	// only ones served.
+	files map[string]os.FileInfo
+}
+
+func runSync(args []string) {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only show what would be transferred")
+	wait := fs.Bool("wait", false, "serve the folder until interrupted, for the peer to sync with")
+	name := fs.String("name", "", "the name the folder is announced under, its base name by default")
+	code := fs.String("code", "", "the code printed by pushpop sync -wait; with -wait, the code to require instead of a random one")
+	fsync := fs.Bool("fsync", false, "flush each received file and its directory to the disk before going on")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "USAGE: pushpop sync [-dry-run] [-fsync] [-name folder] -code code dir user")
+		fmt.Fprintln(os.Stderr, "       pushpop sync -wait [-fsync] [-name folder] [-code code] dir user")
+		fmt.Fprintln(os.Stderr, "Syncs dir both ways with the folder user serves with pushpop sync -wait: files")
+		fmt.Fprintln(os.Stderr, "missing on one side are copied to it, and changed files are replaced by the")
+		fmt.Fprintln(os.Stderr, "most recently modified version. Deleted files are not deleted on the other side.")
+		fmt.Fprintln(os.Stderr, "The waiting side prints a code the other side must give with -code.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 || (*wait && *dryRun) || (!*wait && *code == "") {
+		fs.Usage()
+		os.Exit(2)
+	}
+	usr, err := user.Current()
+	if err != nil {
+		log.Fatal(err)
+	}
+	f := &folder{
+		dir:    fs.Arg(0),
+		alg:    hashing.Default,
+		peer:   fs.Arg(1),
+		client: &transfer.Client{UserAgent: version.UserAgent("sync"), User: usr.Username},
+		saving: tempfile.Options{Sync: *fsync},
+	}
+	fi, err := os.Stat(f.dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !fi.IsDir() {
+		log.Fatalf("%s is not a directory", f.dir)
+	}
+	if *name == "" {
+		abs, err := filepath.Abs(f.dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		*name = filepath.Base(abs)
+	}
+
+	if *wait {
+		f.code = *code
+		if f.code == "" {
+			f.code, err = newCode()
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		f.wait(*name, usr.Username)
+		return
+	}
+	f.client.Code = *code
+	fmt.Printf("Looking for %s's %s folder...\n", f.peer, *name)
+	addr, err := findFolder(f.peer, *name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	base := "http://" + addr
+	remote, err := f.client.FetchFolderManifest(base)
+	if err != nil {
+		log.Fatal(err)
+	}
+	local, err := f.scan()
+	if err != nil {
+		log.Fatal(err)
+	}
+	steps := f.plan(local, remote)
+	if len(steps) == 0 {
+		fmt.Printf("%s is in sync with %s.\n", *name, f.peer)
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, s := range steps {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.action, s.path, units.Bytes(s.size()), s.why)
+	}
+	tw.Flush()
+	if *dryRun {
+		return
+	}
+
+	failed := 0
+	for _, s := range steps {
+		var err error
+		switch s.action {
+		case actionGet:
+			err = f.get(base, addr, s)
+		case actionSend:
+			err = f.send(base, addr, s)
+		default:
+			continue
+		}
+		if err != nil {
+			log.Printf("Unable to %s %s: %v", s.action, s.path, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		log.Fatalf("%d of %d files failed to sync", failed, len(steps))
+	}
+	fmt.Printf("Synced %s with %s.\n", *name, f.peer)
+}
+
+// Actions of a sync plan.
+const (
+	actionGet  = "get"
+	actionSend = "send"
+	actionSkip = "skip"
+)
+
+// step is what a sync does with one file.
+type step struct {
+	action, path string
+	// why tells the reason for the action.
+	why           string
+	local, remote *transfer.Manifest
+}
+
+// size returns the size of what the step transfers.
+func (s step) size() int64 {
+	if s.action == actionSend {
+		return s.local.Size
+	}
+	return s.remote.Size
+}
+
+// plan returns what it takes to sync the folder, whose files are local, with
+// the peer's, whose files are remote: the files missing on one side are
+// copied there, and the files that differ replaced by the most recently
+// modified version. Files changed at the same second on both sides are
+// conflicts, and skipped.
+func (f *folder) plan(local, remote transfer.FolderManifest) []step {
+	m, err := ignore.Load(filepath.Join(f.dir, ignore.FileName))
+	if err != nil {
+		log.Println("Unable to load the ignore file: ", err)
+		m = &ignore.Matcher{}
+	}
+	mine := map[string]*transfer.Manifest{}
+	for i := range local.Files {
+		mine[local.Files[i].Name] = &local.Files[i]
+	}
+	var steps []step
+	for i := range remote.Files {
+		r := &remote.Files[i]
+		if ignored(m, r.Name) {
+			continue
+		}
+		l := mine[r.Name]
+		delete(mine, r.Name)
+		switch {
+		case l == nil:
+			steps = append(steps, step{actionGet, r.Name, "only on " + f.peer, nil, r})
+		case l.Algorithm == r.Algorithm && l.Sum == r.Sum:
+		case r.Mtime > l.Mtime:
+			steps = append(steps, step{actionGet, r.Name, "newer on " + f.peer, l, r})
+		case r.Mtime < l.Mtime:
+			steps = append(steps, step{actionSend, r.Name, "newer here", l, r})
+		default:
+			steps = append(steps, step{actionSkip, r.Name, "changed on both sides", l, r})
+		}
+	}
+	for name, l := range mine {
+		steps = append(steps, step{actionSend, name, "only here", l, nil})
+	}
+	sort.Slice(steps, func(i, j int) bool {
+		return steps[i].path < steps[j].path
+	})
+	return steps
+}
+
+// ignored reports whether m ignores the file at the slash separated path
+// rel, or one of its parent directories: "build/" must keep out
+// "build/out/a.o" as it does when walking the folder.
+func ignored(m *ignore.Matcher, rel string) bool {
+	for i := 0; i < len(rel); i++ {
+		if rel[i] == '/' && m.Match(rel[:i], true) {
+			return true
+		}
+	}
+	return m.Match(rel, false)
+}
+
+// newCode returns a random code for pushpop sync -wait.
+func newCode() (string, error) {
+	b := make([]byte, 6)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// get downloads the file of s from the peer at base.
+func (f *folder) get(base, addr string, s step) error {
+	start := time.Now()
+	meta, err := s.remote.Meta()
+	if err != nil {
+		return err
+	}
+	req, err := f.client.NewRequest(base + filePath(s.path))
+	if err != nil {
+		return err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected status: %s", resp.Status)
+	}
+	fn, err := f.path(s.path)
+	if err == nil {
+		_, err = f.store(fn, meta, resp.Body)
+	}
+	f.record(history.Receive, addr, s.path, fn, meta, start, err)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Got", s.path)
+	return nil
+}
+
+// send uploads the file of s to the peer at base.
+func (f *folder) send(base, addr string, s step) error {
+	start := time.Now()
+	meta, err := s.local.Meta()
+	if err != nil {
+		return err
+	}
+	fn := filepath.Join(f.dir, filepath.FromSlash(s.path))
+	file, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	sum, err := f.client.Upload(base+filePath(s.path), file, meta)
+	if err == nil && sum != meta.Sum {
+		err = fmt.Errorf("%s received something else: expected %s, got %s", f.peer, meta.Sum, sum)
+	}
+	f.record(history.Send, addr, s.path, fn, meta, start, err)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Sent", s.path)
+	return nil
+}
+
+// record appends the transfer of the file at rel to the history.
+func (f *folder) record(direction, addr, rel, fn string, meta transfer.Meta, start time.Time, err error) {
+	host, _, splitErr := net.SplitHostPort(addr)
+	if splitErr != nil {
+		host = addr
+	}
+	e := history.Entry{
+		Time:      start,
+		Direction: direction,
+		User:      f.peer,
+		Addr:      host,
+		Name:      rel,
+		Path:      fn,
+		Size:      meta.Size,
+		Algorithm: meta.Algorithm.Name(),
+		Sum:       meta.Sum,
+		Duration:  time.Since(start),
+		Result:    history.ResultOK,
+	}
+	if err != nil {
+		e.Result = err.Error()
+	}
+	err = history.Append(e)
+	if err != nil {
+		log.Println("Unable to record history: ", err)
+	}
+}
+
+// filePath returns the URL path of the file at the slash separated path rel
+// of a folder.
+func filePath(rel string) string {
+	parts := strings.Split(rel, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return transfer.FolderFilePath + strings.Join(parts, "/")
+}
+
+// scan returns the manifest of the folder, leaving out what its
+// .pushpopignore excludes and unfinished downloads. Checksums come from the
+// hash cache, so that only the files changed since the last sync are
+// hashed again.
+func (f *folder) scan() (transfer.FolderManifest, error) {
+	var m transfer.FolderManifest
+	files := map[string]os.FileInfo{}
+	err := ignore.Walk(f.dir, func(fn, rel string, fi os.FileInfo) error {
+		if strings.HasSuffix(rel, tempfile.PartSuffix) {
+			return nil
+		}
+		sum, err := hashcache.SumFile(f.alg, fn, nil)
+		if err != nil {
+			return err
+		}
+		meta := transfer.Meta{Algorithm: f.alg, Sum: sum, Size: fi.Size(), Mtime: fi.ModTime(), Mode: fi.Mode().Perm()}
+		m.Files = append(m.Files, transfer.NewManifest(rel, meta))
+		files[rel] = fi
+		return nil
+	})
+	if err != nil {
+		return transfer.FolderManifest{}, err
+	}
+	f.mu.Lock()
+	f.files = files
+	f.mu.Unlock()
+	return m, nil
+}
+
+// path returns the local path of the file at the slash separated path rel
+// of the folder, creating its parent directories. It refuses paths that
+// would lead out of the folder, with .. or through a symbolic link.
+func (f *folder) path(rel string) (string, error) {
+	parts := strings.Split(rel, "/")
+	dir := f.dir
+	for i, p := range parts {
+		name, err := safename.Name(p)
+		if err != nil || name != p {
+			return "", fmt.Errorf("Invalid path %q", rel)
+		}
+		if i == len(parts)-1 {
+			break
+		}
+		dir = filepath.Join(dir, name)
+		fi, err := os.Lstat(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			err = os.Mkdir(dir, 0755)
+			if err != nil {
+				return "", err
+			}
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if !fi.IsDir() {
+			return "", fmt.Errorf("%s is not a directory", dir)
+		}
+	}
+	return filepath.Join(f.dir, filepath.FromSlash(rel)), nil
+}
+
+// store writes body, the file described by meta, to fn through its .part
+// file, then gives it the modification time and mode of meta, and returns
+// its checksum.
+func (f *folder) store(fn string, meta transfer.Meta, body io.Reader) (string, error) {
+	part := tempfile.Part(fn)
+	out, err := os.OpenFile(part, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return "", err
+	}
+	h := meta.Algorithm.New()
+	n, err := transfer.Copy(io.MultiWriter(out, h), body, 0)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && meta.Size >= 0 && n != meta.Size {
+		err = fmt.Errorf("Received %d bytes out of %d", n, meta.Size)
+	}
+	sum := hashing.Hex(h)
+	if err == nil && meta.Sum != "" && sum != meta.Sum {
+		err = errMismatch
+	}
+	if err == nil {
+		err = f.saving.Finalize(part, fn)
+	}
+	if err != nil {
+		os.Remove(part)
+		return "", err
+	}
+	if !meta.Mtime.IsZero() {
+		os.Chtimes(fn, meta.Mtime, meta.Mtime)
+	}
+	if meta.Mode != 0 {
+		os.Chmod(fn, meta.Mode)
+	}
+	return sum, nil
+}
+
+// wait announces the folder as name and serves it to the peer until
+// interrupted.
+func (f *folder) wait(name, username string) {
+	host, err := os.Hostname()
+	if err != nil {
+		log.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", ":0")
+	if err != nil {
+		log.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	instance := fmt.Sprintf("%s sync %s", host, name)
+	text := []string{"user=" + username, transfer.RoleKey + "=" + transfer.RoleSync, transfer.FolderKey + "=" + name}
+	_, err = discovery.Announce(context.Background(), instance, nil, port, text)
+	if err != nil {
+		log.Fatal("Failed to announce: ", err)
+	}
+	fmt.Printf("Waiting for %s to sync %s on port %d.\n", f.peer, name, port)
+	fmt.Printf("Give them the code %s: pushpop sync -code %s dir %s\n", f.code, f.code, username)
+	log.Fatal(http.Serve(ln, f))
+}
+
+func (f *folder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Println(r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent())
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(transfer.CodeHeader)), []byte(f.code)) != 1 {
+		http.Error(w, "wrong or missing sync code", http.StatusForbidden)
+		return
+	}
+	// The code is the proof; the name only catches a code given to the
+	// wrong user.
+	if r.Header.Get(transfer.UserHeader) != f.peer {
+		http.Error(w, "this folder is synced with "+f.peer, http.StatusForbidden)
+		return
+	}
+	switch {
+	case r.URL.Path == transfer.FolderManifestPath && r.Method == http.MethodGet:
+		m, err := f.scan()
+		if err != nil {
+			log.Println("Unable to scan the folder: ", err)
+			http.Error(w, "unable to scan the folder", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m)
+	case strings.HasPrefix(r.URL.Path, transfer.FolderFilePath) && r.Method == http.MethodGet:
+		f.serveFile(w, r, strings.TrimPrefix(r.URL.Path, transfer.FolderFilePath))
+	case strings.HasPrefix(r.URL.Path, transfer.FolderFilePath) && r.Method == http.MethodPut:
+		f.receive(w, r, strings.TrimPrefix(r.URL.Path, transfer.FolderFilePath))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveFile sends the file at rel, if the last scan found it.
+func (f *folder) serveFile(w http.ResponseWriter, r *http.Request, rel string) {
+	f.mu.Lock()
+	fi, ok := f.files[rel]
+	f.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	file, err := os.Open(filepath.Join(f.dir, filepath.FromSlash(rel)))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+	// The file could have been replaced by a symbolic link since the scan,
+	// pointing out of the folder.
+	opened, err := file.Stat()
+	if err != nil || !os.SameFile(fi, opened) {
+		http.Error(w, "file changed since the manifest", http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, "", opened.ModTime(), file)
+}
+
+// receive saves the file at rel the peer uploads.
+func (f *folder) receive(w http.ResponseWriter, r *http.Request, rel string) {
+	start := time.Now()
+	meta, err := transfer.ParseMetaHeader(r.Header)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m, err := ignore.Load(filepath.Join(f.dir, ignore.FileName))
+	if err == nil && ignored(m, rel) {
+		http.Error(w, "the folder ignores "+rel, http.StatusForbidden)
+		return
+	}
+	fn, err := f.path(rel)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sum, err := f.store(fn, meta, r.Body)
+	f.record(history.Receive, r.RemoteAddr, rel, fn, meta, start, err)
+	switch {
+	case err == errMismatch:
+		http.Error(w, "checksum mismatch", http.StatusUnprocessableEntity)
+		return
+	case err != nil:
+		log.Println("Upload interrupted: ", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Println("Got", rel)
+	w.Header().Set(transfer.HashHeader(meta.Algorithm), sum)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// findFolder browses for the folder name announced by username and returns
+// its host:port.
+func findFolder(username, name string) (string, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	entries, err := discovery.Browse(ctx)
+	if err != nil {
+		return "", err
+	}
+	for entry := range entries {
+		if txtValue(entry, "user") != username || txtValue(entry, transfer.RoleKey) != transfer.RoleSync || txtValue(entry, transfer.FolderKey) != name {
+			continue
+		}
+		var ip net.IP
+		if len(entry.AddrIPv4) > 0 {
+			ip = entry.AddrIPv4[0]
+		} else if len(entry.AddrIPv6) > 0 {
+			ip = entry.AddrIPv6[0]
+		} else {
+			continue
+		}
+		return net.JoinHostPort(ip.String(), strconv.Itoa(entry.Port)), nil
+	}
+	return "", fmt.Errorf("No %s folder found for %s", name, username)
+}
+
+// txtValue returns the value of key in the TXT record of entry, or "".
+func txtValue(entry *zeroconf.ServiceEntry, key string) string {
+	for _, kv := range entry.Text {
+		if strings.HasPrefix(kv, key+"=") {
+			return kv[len(key)+1:]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/yifu/pushpop/pkg/ignore"
+)
+
+func TestIgnored(t *testing.T) {
+	m, err := ignore.Parse(strings.NewReader("build/\n*.log\n!keep.log\n/top/\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		rel  string
+		want bool
+	}{
+		{"main.go", false},
+		{"build", false},
+		{"build/out/a.o", true},
+		{"src/build/a.o", true},
+		{"a.log", true},
+		{"logs/keep.log", false},
+		{"top/a", true},
+		{"src/top/a", false},
+	} {
+		if got := ignored(m, tt.rel); got != tt.want {
+			t.Errorf("ignored(%q) = %v, want %v", tt.rel, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/yifu/pushpop/pkg/hashing"
+	"github.com/yifu/pushpop/pkg/transfer"
+)
+
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "USAGE: pushpop verify file [signature]")
+		fmt.Fprintln(os.Stderr, "Checks a downloaded file against the signature pop saved next to it, file"+transfer.SignatureSuffix+" by default.")
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	fn := fs.Arg(0)
+	sigPath := fn + transfer.SignatureSuffix
+	if fs.NArg() == 2 {
+		sigPath = fs.Arg(1)
+	}
+
+	data, err := os.ReadFile(sigPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s, err := transfer.ParseSignature(data)
+	if err != nil {
+		log.Fatal(err)
+	}
+	m := s.Manifest
+	alg, err := hashing.Lookup(m.Algorithm)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fi, err := os.Stat(fn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sum, err := hashing.SumFile(alg, fn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if fi.Size() != m.Size || sum != m.Sum {
+		log.Fatalf("%s does not match its signature: expected %s %s, got %s", fn, m.Algorithm, m.Sum, sum)
+	}
+	fmt.Printf("%s: %s signed by %s\n", fn, m.Name, s.Signer())
+}
@@ -0,0 +1,127 @@
+// pushpopd keeps the pushpop endpoints announced on the network at hand, so
+// that pop finds a share at once instead of browsing from scratch.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/yifu/pushpop/pkg/discovery"
+)
+
+// zeroconf reports an entry once per browse, so pushpopd browses again
+// every round to notice the entries still there. An entry not seen for
+// keep is dropped.
+const (
+	round = 10 * time.Second
+	keep  = 3 * round
+)
+
+// cache holds the entries seen recently.
+type cache struct {
+	mu      sync.Mutex
+	records map[string]discovery.Record
+}
+
+func (c *cache) add(r discovery.Record) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.records[r.Key()]; !ok {
+		log.Printf("Found %s on %s:%d", r.Instance, r.HostName, r.Port)
+	}
+	c.records[r.Key()] = r
+}
+
+// expire drops the entries not seen for keep.
+func (c *cache) expire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, r := range c.records {
+		if time.Since(r.Seen) > keep {
+			log.Printf("Lost %s on %s:%d", r.Instance, r.HostName, r.Port)
+			delete(c.records, k)
+		}
+	}
+}
+
+// list returns the entries, the most recently seen first.
+func (c *cache) list() []discovery.Record {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	list := make([]discovery.Record, 0, len(c.records))
+	for _, r := range c.records {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Seen.After(list[j].Seen)
+	})
+	return list
+}
+
+func (c *cache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != discovery.EntriesPath {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.list())
+}
+
+// watch browses the network round after round until ctx is done.
+func (c *cache) watch(ctx context.Context) {
+	for ctx.Err() == nil {
+		browse, cancel := context.WithTimeout(ctx, round)
+		entries, err := discovery.BrowseNetwork(browse)
+		if err != nil {
+			log.Println("Failed to browse: ", err)
+		} else {
+			for e := range entries {
+				c.add(discovery.NewRecord(e))
+			}
+		}
+		<-browse.Done()
+		cancel()
+		c.expire()
+	}
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "USAGE: pushpopd")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ln, err := discovery.Listen()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Serving on", ln.Addr())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &cache{records: map[string]discovery.Record{}}
+	srv := &http.Server{Handler: c}
+	go srv.Serve(ln)
+	go c.watch(ctx)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+	cancel()
+	srv.Close()
+	log.Println("Shutting down.")
+}
@@ -0,0 +1,5 @@
+@echo off
+rem Copy to shell:sendto to get "Share via pushpop" in the Send To menu.
+title pushpop: %~nx1
+push.exe "%~1"
+pause